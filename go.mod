@@ -15,6 +15,7 @@ require (
 	github.com/maypok86/otter v1.2.4
 	github.com/opencontainers/go-digest v1.0.1-0.20231212064514-429d0316a3dd
 	github.com/opencontainers/image-spec v1.1.1
+	github.com/pelletier/go-toml/v2 v2.2.2
 	github.com/puzpuzpuz/xsync/v3 v3.5.1
 	github.com/samber/lo v1.49.1
 	github.com/smallnest/deepcopy v1.0.0
@@ -24,6 +25,7 @@ require (
 	github.com/therootcompany/xz v1.0.1
 	github.com/ulikunitz/xz v0.5.12
 	github.com/urfave/cli/v3 v3.0.0-beta1
+	go.etcd.io/bbolt v1.4.3
 	go.uber.org/mock v0.5.0
 	golang.org/x/sync v0.12.0
 	golang.org/x/sys v0.31.0
@@ -64,7 +66,6 @@ require (
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/morikuni/aec v1.0.0 // indirect
-	github.com/pelletier/go-toml/v2 v2.2.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
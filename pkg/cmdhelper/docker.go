@@ -6,6 +6,8 @@
 	"github.com/urfave/cli/v3"
 
 	"github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xdocker"
+	"github.com/wuxler/ruasec/pkg/xlog"
 )
 
 // ElectDockerServerAddress returns the default registry to use when address is not specified.
@@ -19,3 +21,40 @@ func ElectDockerServerAddress(ctx context.Context, cmd *cli.Command, address str
 	}
 	return name.DockerIndexServer, true
 }
+
+// ElectDockerDataRoot returns the docker data root to use. When dataRoot is not
+// specified, the "data-root" in the docker daemon config file takes precedence
+// over the one discovered from the current user. The "data-root" in the rootful
+// docker daemon config file is used when the rootful data root is discovered.
+func ElectDockerDataRoot(ctx context.Context, dataRoot string) string {
+	return electDockerDataRoot(ctx, dataRoot, xdocker.DaemonConfigFile(), xdocker.DefaultDaemonConfigFile, xdocker.DiscoverDataRoot())
+}
+
+func electDockerDataRoot(ctx context.Context, dataRoot string, file string, rootfulFile string, discovered string) string {
+	if dataRoot != "" {
+		return dataRoot
+	}
+	if configured := configuredDataRoot(ctx, file); configured != "" {
+		xlog.C(ctx).Debugf("no docker data root specified, using %s from docker daemon config file %s", configured, file)
+		return configured
+	}
+	if discovered == xdocker.DefaultDataRoot && file != rootfulFile {
+		if configured := configuredDataRoot(ctx, rootfulFile); configured != "" {
+			xlog.C(ctx).Debugf("no docker data root specified, using %s from rootful docker daemon config file %s", configured, rootfulFile)
+			return configured
+		}
+	}
+	xlog.C(ctx).Debugf("no docker data root specified, using discovered %s", discovered)
+	return discovered
+}
+
+// configuredDataRoot returns the "data-root" in the docker daemon config file,
+// or empty if not configured or unable to load.
+func configuredDataRoot(ctx context.Context, file string) string {
+	config, err := xdocker.LoadDaemonConfig(file)
+	if err != nil {
+		xlog.C(ctx).Warnf("skip, unable to load docker daemon config file %s: %s", file, err)
+		return ""
+	}
+	return config.DataRoot
+}
@@ -0,0 +1,102 @@
+package cmdhelper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker"
+)
+
+func TestElectDockerDataRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeDaemonConfig := func(content string) string {
+		file := filepath.Join(t.TempDir(), "daemon.json")
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		return file
+	}
+	configured := writeDaemonConfig(`{"data-root": "` + filepath.Join(dir, "configured") + `"}`)
+	rootful := writeDaemonConfig(`{"data-root": "` + filepath.Join(dir, "rootful") + `"}`)
+	rootless := filepath.Join(dir, "rootless")
+
+	testcases := []struct {
+		name       string
+		dataRoot   string
+		file       string
+		rootful    string
+		discovered string
+		want       string
+	}{
+		{
+			name:     "flag takes precedence",
+			dataRoot: filepath.Join(dir, "flag"),
+			file:     configured,
+			want:     filepath.Join(dir, "flag"),
+		},
+		{
+			name: "daemon config",
+			file: configured,
+			want: filepath.Join(dir, "configured"),
+		},
+		{
+			name: "daemon config without data root",
+			file: writeDaemonConfig(`{"storage-driver": "overlay2"}`),
+			want: rootless,
+		},
+		{
+			name: "invalid daemon config",
+			file: writeDaemonConfig(`{"data-root": [`),
+			want: rootless,
+		},
+		{
+			name: "missing daemon config",
+			file: filepath.Join(dir, "missing.json"),
+			want: rootless,
+		},
+		{
+			name:       "rootful daemon config of the discovered rootful data root",
+			file:       writeDaemonConfig(`{"storage-driver": "overlay2"}`),
+			rootful:    rootful,
+			discovered: xdocker.DefaultDataRoot,
+			want:       filepath.Join(dir, "rootful"),
+		},
+		{
+			name:    "rootful daemon config skipped for the rootless data root",
+			file:    writeDaemonConfig(`{"storage-driver": "overlay2"}`),
+			rootful: rootful,
+			want:    rootless,
+		},
+		{
+			name:       "daemon config takes precedence over the rootful one",
+			file:       configured,
+			rootful:    rootful,
+			discovered: xdocker.DefaultDataRoot,
+			want:       filepath.Join(dir, "configured"),
+		},
+		{
+			name:       "rootful daemon config without data root",
+			file:       filepath.Join(dir, "missing.json"),
+			rootful:    writeDaemonConfig(`{"storage-driver": "overlay2"}`),
+			discovered: xdocker.DefaultDataRoot,
+			want:       xdocker.DefaultDataRoot,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootfulFile := tc.rootful
+			if rootfulFile == "" {
+				rootfulFile = filepath.Join(dir, "missing-rootful.json")
+			}
+			// discovered the rootless data root by default
+			discovered := tc.discovered
+			if discovered == "" {
+				discovered = rootless
+			}
+			assert.Equal(t, tc.want, electDockerDataRoot(context.Background(), tc.dataRoot, tc.file, rootfulFile, discovered))
+		})
+	}
+}
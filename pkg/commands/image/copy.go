@@ -0,0 +1,151 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/wuxler/ruasec/pkg/appinfo"
+	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/commands/internal/options"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/writer"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+// NewCopyCommand returns a command with default values.
+func NewCopyCommand() *CopyCommand {
+	return &CopyCommand{
+		Image: options.NewImageOptions(),
+	}
+}
+
+// CopyCommand is used to copy an image from any storage to a remote registry.
+type CopyCommand struct {
+	Image              *options.ImageOptions
+	Compression        string   `json:"compression,omitempty" yaml:"compression,omitempty"`
+	ChunkSize          int64    `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty"`
+	MountFrom          []string `json:"mount_from,omitempty" yaml:"mount_from,omitempty"`
+	AllowImageIDChange bool     `json:"allow_image_id_change,omitempty" yaml:"allow_image_id_change,omitempty"`
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *CopyCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:    "copy",
+		Aliases: []string{"cp"},
+		Usage:   "Copy an image from any storage to a remote registry",
+		UsageText: `ruasec image copy [OPTIONS] [SCHEME://]SRC NAME[:TAG]
+
+# Push the image from the docker root directory to the registry without the docker daemon
+$ ruasec image copy docker-rootfs://nginx:latest registry.example.com/library/nginx:latest
+
+# Push the image saved by "docker save" to the registry
+$ ruasec image copy --docker-archive-file nginx.tar docker-archive://nginx:latest registry.example.com/library/nginx:latest
+
+# Push the image with the layers recompressed by zstd and uploaded in 16MiB chunks
+$ ruasec image copy --compression zstd --chunk-size 16777216 oci-layout://latest registry.example.com/library/nginx:latest
+`,
+		ArgsUsage: "SRC DST",
+		Flags:     c.Flags(),
+		Before: cmdhelper.BeforeFunc(cmdhelper.ActionFuncChain(
+			cmdhelper.ExactArgs(2), //nolint:mnd // source and target
+			c.Image.Common.Init,
+		)),
+		Action: c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *CopyCommand) Flags() []cli.Flag {
+	local := []cli.Flag{
+		&cli.StringFlag{
+			Name: "compression",
+			Usage: fmt.Sprintf(`compression format of the layers like "gzip" and "zstd", or %q to push uncompressed, `+
+				`default to keep the original compressed layers`, writer.CompressionNone),
+			Destination: &c.Compression,
+			Value:       c.Compression,
+		},
+		&cli.IntFlag{
+			Name:        "chunk-size",
+			Usage:       "upload the blobs larger than the size in bytes in chunks, upload monolithically if not positive",
+			Destination: &c.ChunkSize,
+			Value:       c.ChunkSize,
+		},
+		&cli.StringSliceFlag{
+			Name:        "mount-from",
+			Usage:       "full names of the repositories in the target registry to mount the blobs from",
+			Destination: &c.MountFrom,
+			Value:       c.MountFrom,
+		},
+		&cli.BoolFlag{
+			Name: "allow-image-id-change",
+			Usage: "accept the layers reproduced from the filesystems with different DiffIDs, " +
+				"which rewrites the diff_ids of the image config and changes the image id",
+			Destination: &c.AllowImageIDChange,
+			Value:       c.AllowImageIDChange,
+		},
+	}
+	return append(c.Image.Flags(), local...)
+}
+
+// Run is the main function for the current command
+func (c *CopyCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	src, dst := cmd.Args().Get(0), cmd.Args().Get(1)
+	target, err := ocispecname.NewReference(dst)
+	if err != nil {
+		return err
+	}
+	if _, ok := ocispecname.IsDigested(target); ok {
+		return fmt.Errorf("target must be formatted as NAME[:TAG] but got %q, the manifest digest may be changed", dst)
+	}
+
+	scheme, _ := ocispecname.SplitScheme(src)
+	storage, err := c.Image.NewImageStorage(ctx, cmd.Writer, scheme)
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(storage)
+
+	cacheDir := appinfo.GetWorkspace().TempDir()
+	img, err := storage.GetImage(ctx, src, image.WithCacheDir(cacheDir))
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(img)
+
+	client, err := c.Image.Remote.NewClient(cmd.Writer)
+	if err != nil {
+		return err
+	}
+	repository, err := client.NewRepository(ctx, target.Repository())
+	if err != nil {
+		return err
+	}
+
+	opts := []writer.Option{
+		writer.WithCompression(c.Compression),
+		writer.WithCacheDir(cacheDir),
+		writer.WithChunkSize(c.ChunkSize),
+		writer.WithMountFrom(c.MountFrom...),
+		writer.WithImageIDChangeAllowed(c.AllowImageIDChange),
+	}
+	if tagged, ok := ocispecname.IsTagged(target); ok {
+		opts = append(opts, writer.WithTags(tagged.Tag()))
+	}
+	if storage.Type() == image.StorageTypeRemote {
+		// the blobs can be mounted when the source is in the same registry
+		if named, err := ocispecname.NewReference(src); err == nil {
+			opts = append(opts, writer.WithMountFrom(named.Repository().String()))
+		}
+	}
+
+	desc, err := writer.PushImage(ctx, img, repository, opts...)
+	if err != nil {
+		return err
+	}
+	cmdhelper.Fprintf(cmd.Writer, "Copied %s to %s@%s", src, target.Repository(), desc.Digest)
+	return nil
+}
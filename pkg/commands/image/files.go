@@ -0,0 +1,172 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+	"gopkg.in/yaml.v3"
+
+	"github.com/wuxler/ruasec/pkg/appinfo"
+	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/commands/internal/options"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/squashfs"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+// NewFilesCommand returns a command with default values.
+func NewFilesCommand() *FilesCommand {
+	return &FilesCommand{
+		Image:  options.NewImageOptions(),
+		Format: "text",
+	}
+}
+
+// FilesCommand is used to list the files of an image with the layers they come from.
+type FilesCommand struct {
+	Image    *options.ImageOptions
+	Deleted  bool   `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+	Shadowed bool   `json:"shadowed,omitempty" yaml:"shadowed,omitempty"`
+	Format   string `json:"format,omitempty" yaml:"format,omitempty"`
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *FilesCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:  "files",
+		Usage: "List the files of an image with the layers introducing them",
+		UsageText: `ruasec image files [OPTIONS] [SCHEME://]IMAGE [PATH...]
+
+# List all the files of the image and the layers last adding or modifying them
+$ ruasec image files hello-world:latest
+
+# Show which layer introduces the specified files, including the deleted ones
+$ ruasec image files --deleted docker-rootfs://nginx:latest etc/nginx/nginx.conf /etc/passwd
+
+# Show the earlier layers shadowed by the current versions in json format
+$ ruasec image files --shadowed --format json oci-layout://latest
+`,
+		ArgsUsage: "IMAGE [PATH...]",
+		Flags:     c.Flags(),
+		Before: cmdhelper.BeforeFunc(cmdhelper.ActionFuncChain(
+			cmdhelper.MinimumNArgs(1),
+			c.Image.Common.Init,
+		)),
+		Action: c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *FilesCommand) Flags() []cli.Flag {
+	local := []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "deleted",
+			Usage:       "include the files deleted by the upper layers",
+			Destination: &c.Deleted,
+			Value:       c.Deleted,
+		},
+		&cli.BoolFlag{
+			Name:        "shadowed",
+			Usage:       "show the lower layers holding the shadowed versions in text format",
+			Destination: &c.Shadowed,
+			Value:       c.Shadowed,
+		},
+		&cli.StringFlag{
+			Name:        "format",
+			Aliases:     []string{"f"},
+			Usage:       `output format, oneof ["text", "json", "yaml"]`,
+			Destination: &c.Format,
+			Value:       c.Format,
+		},
+	}
+	return append(c.Image.Flags(), local...)
+}
+
+// Run is the main function for the current command
+func (c *FilesCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	name := cmd.Args().First()
+	scheme, _ := ocispecname.SplitScheme(name)
+
+	storage, err := c.Image.NewImageStorage(ctx, cmd.Writer, scheme)
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(storage)
+
+	cacheDir := appinfo.GetWorkspace().TempDir()
+	img, err := storage.GetImage(ctx, name, image.WithCacheDir(cacheDir))
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(img)
+
+	index, err := squashfs.NewIndex(ctx, img, squashfs.WithCacheDir(cacheDir))
+	if err != nil {
+		return err
+	}
+
+	records := []squashfs.FileRecord{}
+	if paths := cmd.Args().Tail(); len(paths) > 0 {
+		for _, path := range paths {
+			record, ok := index.Lookup(path)
+			if !ok {
+				return fmt.Errorf("file %q not found in image %s", path, name)
+			}
+			records = append(records, record)
+		}
+	} else {
+		for _, record := range index.Records() {
+			if record.Deleted() && !c.Deleted {
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+	return c.write(cmd.Writer, records)
+}
+
+func (c *FilesCommand) write(w io.Writer, records []squashfs.FileRecord) error {
+	switch strings.ToLower(c.Format) {
+	case "json":
+		content, err := cmdhelper.PrettifyJSON(records)
+		if err != nil {
+			return err
+		}
+		cmdhelper.Fprintf(w, "%s", string(content))
+		return nil
+	case "yaml", "yml":
+		return yaml.NewEncoder(w).Encode(records)
+	case "text", "":
+	default:
+		return fmt.Errorf("unsupported output format %q", c.Format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd // padding between columns
+	cmdhelper.Fprintf(tw, "PATH\tCHANGE\tLAYER\tDIFF ID\tCREATED BY")
+	for _, record := range records {
+		writeFileRecordRow(tw, record.Path, string(record.Change), record.Layer)
+		if c.Shadowed {
+			for _, shadowed := range record.Shadowed {
+				writeFileRecordRow(tw, "", "shadowed", shadowed)
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func writeFileRecordRow(w io.Writer, path string, change string, layer squashfs.LayerRef) {
+	diffID := layer.DiffID.String()
+	if err := layer.DiffID.Validate(); err == nil {
+		diffID = layer.DiffID.Encoded()
+		if len(diffID) > 12 { //nolint:mnd // short digest length
+			diffID = diffID[:12]
+		}
+	}
+	createdBy := strings.Join(strings.Fields(layer.CreatedBy), " ")
+	cmdhelper.Fprintf(w, "%s\t%s\t%d\t%s\t%s", path, change, layer.Index, diffID, createdBy)
+}
@@ -29,6 +29,9 @@ func (c *ImageCommand) ToCLI() *cli.Command {
 		Usage:   "Container image operations",
 		Commands: []*cli.Command{
 			NewConfigFetchCommand().ToCLI(),
+			NewFilesCommand().ToCLI(),
+			NewSaveCommand().ToCLI(),
+			NewCopyCommand().ToCLI(),
 		},
 	}
 }
@@ -75,6 +78,21 @@ func (c *ConfigFetchCommand) ToCLI() *cli.Command {
 # Fetch the image config from docker-daemon storage type specified
 $ ruasec image config --storage-type docker-daemon hello-world:latest
 $ ruasec image config docker-daemon://hello-world:latest
+
+# Fetch the image config from oci-layout storage type specified
+$ skopeo copy docker://hello-world:latest oci:hello-world:latest
+$ ruasec image config --oci-layout-dir hello-world oci-layout://latest
+
+# Fetch the image config from oci-archive storage type specified
+$ buildah push hello-world:latest oci-archive:hello-world.tar:latest
+$ ruasec image config --oci-archive-file hello-world.tar oci-archive://latest
+
+# Fetch the image config from containerd-rootfs storage type specified
+$ ruasec image config --containerd-namespace k8s.io containerd-rootfs://hello-world:latest
+
+# Fetch the image config from containers-storage storage type specified
+$ ruasec image config containers-storage://hello-world:latest
+$ ruasec image config --containers-root ~/.local/share/containers/storage containers-storage://hello-world:latest
 `,
 		ArgsUsage: "IMAGE",
 		Flags:     c.Flags(),
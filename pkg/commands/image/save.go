@@ -0,0 +1,158 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/wuxler/ruasec/pkg/appinfo"
+	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/commands/internal/options"
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/writer"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+// NewSaveCommand returns a command with default values.
+func NewSaveCommand() *SaveCommand {
+	return &SaveCommand{
+		Image:  options.NewImageOptions(),
+		Format: image.StorageTypeDockerArchive,
+	}
+}
+
+// SaveCommand is used to save images into the docker archive or OCI image layout.
+type SaveCommand struct {
+	Image              *options.ImageOptions
+	Output             string `json:"output,omitempty" yaml:"output,omitempty"`
+	Format             string `json:"format,omitempty" yaml:"format,omitempty"`
+	Compression        string `json:"compression,omitempty" yaml:"compression,omitempty"`
+	AllowImageIDChange bool   `json:"allow_image_id_change,omitempty" yaml:"allow_image_id_change,omitempty"`
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *SaveCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:  "save",
+		Usage: "Save one or more images to a docker archive or an OCI image layout",
+		UsageText: `ruasec image save [OPTIONS] [SCHEME://]IMAGE [[SCHEME://]IMAGE...]
+
+# Save the images as a "docker save" compatible tarball, which can be loaded by "docker load"
+$ ruasec image save -o images.tar hello-world:latest docker-rootfs://nginx:latest
+
+# Save the image into the OCI image layout directory with the original compressed layers
+$ ruasec image save --format oci-layout -o hello-world containers-storage://hello-world:latest
+
+# Save the image as the OCI image layout tarball with the zstd compressed layers
+$ ruasec image save --format oci-archive --compression zstd -o hello-world.tar hello-world:latest
+`,
+		ArgsUsage: "IMAGE [IMAGE...]",
+		Flags:     c.Flags(),
+		Before: cmdhelper.BeforeFunc(cmdhelper.ActionFuncChain(
+			cmdhelper.MinimumNArgs(1),
+			c.Image.Common.Init,
+		)),
+		Action: c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *SaveCommand) Flags() []cli.Flag {
+	local := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"o"},
+			Usage:       "write to the file, or the directory for oci-layout format",
+			Destination: &c.Output,
+			Value:       c.Output,
+		},
+		&cli.StringFlag{
+			Name: "format",
+			Usage: fmt.Sprintf("output format, oneof [%q, %q, %q]",
+				image.StorageTypeDockerArchive, image.StorageTypeOCILayout, image.StorageTypeOCIArchive),
+			Destination: &c.Format,
+			Value:       c.Format,
+		},
+		&cli.StringFlag{
+			Name: "compression",
+			Usage: fmt.Sprintf(`compression format of the OCI layers like "gzip" and "zstd", or %q to write uncompressed, `+
+				`default to keep the original compressed layers`, writer.CompressionNone),
+			Destination: &c.Compression,
+			Value:       c.Compression,
+		},
+		&cli.BoolFlag{
+			Name: "allow-image-id-change",
+			Usage: "accept the layers reproduced from the filesystems with different DiffIDs, " +
+				"which rewrites the diff_ids of the image config and changes the image id",
+			Destination: &c.AllowImageIDChange,
+			Value:       c.AllowImageIDChange,
+		},
+	}
+	return append(c.Image.Flags(), local...)
+}
+
+// Run is the main function for the current command
+func (c *SaveCommand) Run(ctx context.Context, cmd *cli.Command) (err error) {
+	if c.Output == "" {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "output is required")
+	}
+	switch c.Format {
+	case image.StorageTypeDockerArchive, image.StorageTypeOCILayout, image.StorageTypeOCIArchive:
+	default:
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "unsupported output format %q", c.Format)
+	}
+
+	cacheDir := appinfo.GetWorkspace().TempDir()
+	imgs := []ocispec.Image{}
+	closers := []io.Closer{}
+	defer func() {
+		for _, closer := range closers {
+			xio.CloseAndSkipError(closer)
+		}
+	}()
+	for _, name := range cmd.Args().Slice() {
+		scheme, _ := ocispecname.SplitScheme(name)
+		storage, err := c.Image.NewImageStorage(ctx, cmd.Writer, scheme)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, storage)
+
+		img, err := storage.GetImage(ctx, name, image.WithCacheDir(cacheDir))
+		if err != nil {
+			return err
+		}
+		// the image must be closed before the storage
+		closers = slices.Insert(closers, 0, io.Closer(img))
+		imgs = append(imgs, img)
+	}
+
+	opts := []writer.Option{
+		writer.WithCompression(c.Compression),
+		writer.WithCacheDir(cacheDir),
+		writer.WithImageIDChangeAllowed(c.AllowImageIDChange),
+	}
+	if c.Format == image.StorageTypeOCILayout {
+		return writer.WriteOCILayout(ctx, c.Output, imgs, opts...)
+	}
+
+	file, err := xos.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, file.Close())
+	}()
+	if c.Format == image.StorageTypeOCIArchive {
+		return writer.WriteOCIArchive(ctx, file, imgs, opts...)
+	}
+	return writer.WriteDockerArchive(ctx, file, imgs, opts...)
+}
@@ -7,11 +7,13 @@
 	"io"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/urfave/cli/v3"
 
 	"github.com/wuxler/ruasec/pkg/appinfo"
 	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
 	"github.com/wuxler/ruasec/pkg/util/xhttp"
 	"github.com/wuxler/ruasec/pkg/xlog"
 )
@@ -60,14 +62,23 @@ func (o *Common) Init(_ context.Context, _ *cli.Command) error {
 
 // NewRemote returns the options with default values.
 func NewRemote() *Remote {
-	return &Remote{}
+	return &Remote{
+		Retry:           remote.DefaultRetryMaxAttempts - 1,
+		RetryMinBackoff: remote.DefaultRetryMinBackoff,
+		RetryMaxBackoff: remote.DefaultRetryMaxBackoff,
+		RetryMaxWait:    remote.DefaultRetryMaxWait,
+	}
 }
 
 // Remote defines the options for remote access.
 type Remote struct {
-	Insecure   bool     `json:"insecure,omitempty" yaml:"insecure,omitempty"`
-	TLSCAFiles []string `json:"tls_ca_files,omitempty" yaml:"tls_ca_files,omitempty"`
-	DumpEnable bool     `json:"dump_enable,omitempty" yaml:"dump_enable,omitempty"`
+	Insecure        bool          `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	TLSCAFiles      []string      `json:"tls_ca_files,omitempty" yaml:"tls_ca_files,omitempty"`
+	DumpEnable      bool          `json:"dump_enable,omitempty" yaml:"dump_enable,omitempty"`
+	Retry           int64         `json:"retry,omitempty" yaml:"retry,omitempty"`
+	RetryMinBackoff time.Duration `json:"retry_min_backoff,omitempty" yaml:"retry_min_backoff,omitempty"`
+	RetryMaxBackoff time.Duration `json:"retry_max_backoff,omitempty" yaml:"retry_max_backoff,omitempty"`
+	RetryMaxWait    time.Duration `json:"retry_max_wait,omitempty" yaml:"retry_max_wait,omitempty"`
 }
 
 // Flags returns the cli flags related to current options.
@@ -102,7 +113,48 @@ func (o *Remote) Flags() []cli.Flag {
 			Destination: &o.DumpEnable,
 			Value:       o.DumpEnable,
 		},
+		&cli.IntFlag{
+			Name:        "retry",
+			Usage:       "maximum number of retries for the requests failed with transient errors, 0 to disable",
+			Sources:     cli.EnvVars("RUASEC_REMOTE_RETRY"),
+			Destination: &o.Retry,
+			Value:       o.Retry,
+		},
+		&cli.DurationFlag{
+			Name:        "retry-min-backoff",
+			Usage:       "backoff before the first retry, doubled for each of the following retries",
+			Sources:     cli.EnvVars("RUASEC_REMOTE_RETRY_MIN_BACKOFF"),
+			Destination: &o.RetryMinBackoff,
+			Value:       o.RetryMinBackoff,
+		},
+		&cli.DurationFlag{
+			Name:        "retry-max-backoff",
+			Usage:       "upper bound of the backoff between the retries",
+			Sources:     cli.EnvVars("RUASEC_REMOTE_RETRY_MAX_BACKOFF"),
+			Destination: &o.RetryMaxBackoff,
+			Value:       o.RetryMaxBackoff,
+		},
+		&cli.DurationFlag{
+			Name:        "retry-max-wait",
+			Usage:       `upper bound of the wait required by the registry with "Retry-After" or "RateLimit-*" headers, give up if longer`,
+			Sources:     cli.EnvVars("RUASEC_REMOTE_RETRY_MAX_WAIT"),
+			Destination: &o.RetryMaxWait,
+			Value:       o.RetryMaxWait,
+		},
+	}
+}
+
+// NewRetryPolicy returns the retry policy with options, or nil if retry is disabled.
+func (o *Remote) NewRetryPolicy() *remote.RetryPolicy {
+	if o.Retry <= 0 {
+		return nil
 	}
+	policy := remote.DefaultRetryPolicy()
+	policy.MaxAttempts = int(o.Retry) + 1
+	policy.MinBackoff = o.RetryMinBackoff
+	policy.MaxBackoff = o.RetryMaxBackoff
+	policy.MaxWait = o.RetryMaxWait
+	return policy
 }
 
 // NewHTTPTransport returns a new http transport with options.
@@ -1,12 +1,17 @@
 package options
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/urfave/cli/v3"
 
 	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/ocispec/authn/authfile"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/registries"
 	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
 	"github.com/wuxler/ruasec/pkg/util/xdocker"
 )
@@ -19,15 +24,20 @@
 // NewContainerRegistry returns the options with default values.
 func NewContainerRegistry() *ContainerRegistry {
 	return &ContainerRegistry{
-		Remote:   NewRemote(),
-		AuthFile: xdocker.ConfigFile(),
+		Remote:             NewRemote(),
+		RegistriesConf:     registries.ConfigFile(),
+		DockerDaemonConfig: xdocker.DaemonConfigFile(),
 	}
 }
 
 // ContainerRegistry defines the remote registry client options.
 type ContainerRegistry struct {
-	*Remote  `json:",inline" yaml:",inline"`
-	AuthFile string `json:"auth_file,omitempty" yaml:"auth_file,omitempty"`
+	*Remote            `json:",inline" yaml:",inline"`
+	AuthFile           string   `json:"auth_file,omitempty" yaml:"auth_file,omitempty"`
+	RegistriesConf     string   `json:"registries_conf,omitempty" yaml:"registries_conf,omitempty"`
+	RegistryMirrors    []string `json:"registry_mirrors,omitempty" yaml:"registry_mirrors,omitempty"`
+	ShortNameMode      string   `json:"short_name_mode,omitempty" yaml:"short_name_mode,omitempty"`
+	DockerDaemonConfig string   `json:"docker_daemon_config,omitempty" yaml:"docker_daemon_config,omitempty"`
 }
 
 // Flags returns the cli flags related to current options.
@@ -35,12 +45,41 @@ func (o *ContainerRegistry) Flags() []cli.Flag {
 	flags := []cli.Flag{
 		&cli.StringFlag{
 			Name:        "auth-file",
-			Usage:       "registry auth file path",
+			Usage:       `registry auth file path, merge "$REGISTRY_AUTH_FILE", podman and docker auth files if not set`,
 			Sources:     cli.EnvVars("RUA_REGISTRY_AUTH_FILE"),
 			Destination: &o.AuthFile,
 			Value:       o.AuthFile,
 			Category:    FlagCategoryContainerRegistry,
 		},
+		&cli.StringFlag{
+			Name:        "registries-conf",
+			Usage:       `registries config file compatible with containers "registries.conf" v2 to configure mirrors, location rewrites and blocked registries`,
+			Sources:     cli.EnvVars("RUA_REGISTRIES_CONF"),
+			Destination: &o.RegistriesConf,
+			Value:       o.RegistriesConf,
+		},
+		&cli.StringSliceFlag{
+			Name:        "registry-mirror",
+			Usage:       `mirror of Docker Hub tried before the origin like "registry-mirrors" in docker daemon config, e.g. "https://mirror.gcr.io"`,
+			Sources:     cli.EnvVars("RUA_REGISTRY_MIRRORS"),
+			Destination: &o.RegistryMirrors,
+			Value:       o.RegistryMirrors,
+		},
+		&cli.StringFlag{
+			Name: "short-name-mode",
+			Usage: fmt.Sprintf("mode to resolve short names like \"myapp:1.2\" with aliases and unqualified search registries, overrides the registries config, must be one of [%s]",
+				strings.Join([]string{registries.ShortNameModeEnforcing, registries.ShortNameModePermissive, registries.ShortNameModeDisabled}, ", ")),
+			Sources:     cli.EnvVars("RUA_SHORT_NAME_MODE"),
+			Destination: &o.ShortNameMode,
+			Value:       o.ShortNameMode,
+		},
+		&cli.StringFlag{
+			Name:        "docker-daemon-config",
+			Usage:       `docker daemon config file to read the "registry-mirrors" and "insecure-registries" from, skipped if empty`,
+			Sources:     cli.EnvVars("RUA_DOCKER_DAEMON_CONFIG"),
+			Destination: &o.DockerDaemonConfig,
+			Value:       o.DockerDaemonConfig,
+		},
 	}
 	flags = append(flags, o.Remote.Flags()...)
 	cmdhelper.SetFlagsCategory(FlagCategoryContainerRegistry, flags...)
@@ -53,15 +92,71 @@ func (o *ContainerRegistry) NewClient(w io.Writer) (*remote.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	var authProvider remote.AuthProvider
-	if o.AuthFile != "" {
-		authProvider, err = remote.NewAuthProviderFromAuthFilePath(o.AuthFile)
-		if err != nil {
-			return nil, err
-		}
+	authProvider, err := remote.NewAuthProviderFromAuthFilePaths(o.AuthFilePaths()...)
+	if err != nil {
+		return nil, err
 	}
 	client := remote.NewClient()
 	client.Client = &http.Client{Transport: tr}
 	client.AuthProvider = authProvider
+	client.RetryPolicy = o.Remote.NewRetryPolicy()
 	return client, nil
 }
+
+// AuthFilePaths returns the auth files to read the credentials from, in the
+// order of precedence. Only the auth file specified is used if set.
+func (o *ContainerRegistry) AuthFilePaths() []string {
+	if o.AuthFile != "" {
+		return []string{o.AuthFile}
+	}
+	return authfile.DefaultPaths()
+}
+
+// WritableAuthFile returns the auth file to store the credentials, which is the
+// auth file specified, or "$REGISTRY_AUTH_FILE", or the docker config file.
+func (o *ContainerRegistry) WritableAuthFile() string {
+	if o.AuthFile != "" {
+		return o.AuthFile
+	}
+	if path := os.Getenv(authfile.EnvRegistryAuthFile); path != "" {
+		return path
+	}
+	return xdocker.ConfigFile()
+}
+
+// NewRegistriesConfig returns the registries config loaded from the config file
+// and merged with the mirrors of Docker Hub and the short name mode. The mirrors
+// and the insecure registries in the docker daemon config file are merged after
+// the ones specified.
+func (o *ContainerRegistry) NewRegistriesConfig() (*registries.Config, error) {
+	config := &registries.Config{}
+	if o.RegistriesConf != "" {
+		loaded, err := registries.Load(o.RegistriesConf)
+		if err != nil {
+			return nil, err
+		}
+		config = loaded
+	}
+	if err := config.AddDockerMirrors(o.RegistryMirrors...); err != nil {
+		return nil, err
+	}
+	if o.DockerDaemonConfig != "" {
+		daemonConfig, err := xdocker.LoadDaemonConfig(o.DockerDaemonConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load docker daemon config file %s: %w", o.DockerDaemonConfig, err)
+		}
+		if err := config.AddDockerMirrors(daemonConfig.RegistryMirrors...); err != nil {
+			return nil, err
+		}
+		if err := config.AddInsecureRegistries(daemonConfig.InsecureRegistries...); err != nil {
+			return nil, err
+		}
+	}
+	if o.ShortNameMode != "" {
+		config.ShortNameMode = o.ShortNameMode
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
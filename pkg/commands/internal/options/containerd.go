@@ -0,0 +1,60 @@
+package options
+
+import (
+	"github.com/urfave/cli/v3"
+
+	containerdrootfs "github.com/wuxler/ruasec/pkg/image/containerd/rootfs"
+)
+
+const (
+	// ContainerdFlagCategory is the category of the containerd flags.
+	ContainerdFlagCategory = "[Containerd]"
+)
+
+// NewContainerdOptions returns a new *ContainerdOptions with default values.
+func NewContainerdOptions() *ContainerdOptions {
+	return &ContainerdOptions{
+		Root:        containerdrootfs.DefaultRoot,
+		Snapshotter: containerdrootfs.DefaultSnapshotter,
+	}
+}
+
+// ContainerdOptions defines the options for the containerd.
+type ContainerdOptions struct {
+	// Root is the path to the containerd root directory.
+	Root string
+	// Namespace is the containerd namespace to lookup images.
+	Namespace string
+	// Snapshotter is the name of the snapshotter unpacking the image layers.
+	Snapshotter string
+}
+
+// Flags returns the []cli.Flag related to current options.
+func (o *ContainerdOptions) Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "containerd-root",
+			Usage:       "path to the containerd root directory",
+			Sources:     cli.EnvVars("RUA_CONTAINERD_ROOT"),
+			Value:       o.Root,
+			Destination: &o.Root,
+			Category:    ContainerdFlagCategory,
+		},
+		&cli.StringFlag{
+			Name:        "containerd-namespace",
+			Usage:       "containerd namespace to lookup images, all namespaces are looked up if not set",
+			Sources:     cli.EnvVars("RUA_CONTAINERD_NAMESPACE", "CONTAINERD_NAMESPACE"),
+			Value:       o.Namespace,
+			Destination: &o.Namespace,
+			Category:    ContainerdFlagCategory,
+		},
+		&cli.StringFlag{
+			Name:        "containerd-snapshotter",
+			Usage:       "name of the containerd snapshotter unpacking the image layers",
+			Sources:     cli.EnvVars("RUA_CONTAINERD_SNAPSHOTTER", "CONTAINERD_SNAPSHOTTER"),
+			Value:       o.Snapshotter,
+			Destination: &o.Snapshotter,
+			Category:    ContainerdFlagCategory,
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package options
+
+import (
+	"github.com/urfave/cli/v3"
+
+	containersrootfs "github.com/wuxler/ruasec/pkg/image/containers/rootfs"
+)
+
+const (
+	// ContainersFlagCategory is the category of the containers storage flags.
+	ContainersFlagCategory = "[Containers Storage]"
+)
+
+// NewContainersOptions returns a new *ContainersOptions with default values.
+func NewContainersOptions() *ContainersOptions {
+	config := containersrootfs.DefaultConfig()
+	return &ContainersOptions{
+		Root:   config.Root,
+		Driver: config.Driver,
+	}
+}
+
+// ContainersOptions defines the options for the containers storage used by
+// podman, buildah and CRI-O.
+type ContainersOptions struct {
+	// Root is the path to the graph root directory of the containers storage.
+	Root string
+	// Driver is the name of the storage driver.
+	Driver string
+}
+
+// Flags returns the []cli.Flag related to current options.
+func (o *ContainersOptions) Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "containers-root",
+			Usage:       "path to the graph root directory of the containers storage, default to the rootless one for non-root user",
+			Sources:     cli.EnvVars("RUA_CONTAINERS_ROOT"),
+			Value:       o.Root,
+			Destination: &o.Root,
+			Category:    ContainersFlagCategory,
+		},
+		&cli.StringFlag{
+			Name:        "containers-driver",
+			Usage:       "name of the containers storage driver",
+			Sources:     cli.EnvVars("RUA_CONTAINERS_DRIVER", "STORAGE_DRIVER"),
+			Value:       o.Driver,
+			Destination: &o.Driver,
+			Category:    ContainersFlagCategory,
+		},
+	}
+}
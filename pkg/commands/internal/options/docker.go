@@ -1,6 +1,8 @@
 package options
 
 import (
+	"fmt"
+
 	"github.com/urfave/cli/v3"
 
 	"github.com/wuxler/ruasec/pkg/util/xdocker"
@@ -14,14 +16,14 @@
 // NewDockerOptions returns a new *DockerOptions with default values.
 func NewDockerOptions() *DockerOptions {
 	return &DockerOptions{
-		DataRoot:   xdocker.DefaultDataRoot,
 		DaemonHost: xdocker.DefaultDaemonHost,
 	}
 }
 
 // DockerOptions defines the options for the docker options.
 type DockerOptions struct {
-	// DataRoot is the path to the docker data root.
+	// DataRoot is the path to the docker data root, loaded from the docker daemon
+	// config file or discovered from the current user when empty.
 	DataRoot string
 	// ArchiveFile is the path to the docker archive file by `docker save`.
 	ArchiveFile string
@@ -34,7 +36,7 @@ func (o *DockerOptions) Flags() []cli.Flag {
 	return []cli.Flag{
 		&cli.StringFlag{
 			Name:        "docker-data-root",
-			Usage:       "path to the docker data root",
+			Usage:       fmt.Sprintf("path to the docker data root, default to the \"data-root\" in %s, or %s for root user and %s for rootless if exists", xdocker.DaemonConfigFile(), xdocker.DefaultDataRoot, xdocker.RootlessDataRoot()),
 			Sources:     cli.EnvVars("RUA_DOCKER_DATA_ROOT"),
 			Value:       o.DataRoot,
 			Destination: &o.DataRoot,
@@ -10,10 +10,15 @@
 	"github.com/urfave/cli/v3"
 
 	"github.com/wuxler/ruasec/pkg/appinfo"
+	"github.com/wuxler/ruasec/pkg/cmdhelper"
 	"github.com/wuxler/ruasec/pkg/image"
+	containerdrootfs "github.com/wuxler/ruasec/pkg/image/containerd/rootfs"
+	containersrootfs "github.com/wuxler/ruasec/pkg/image/containers/rootfs"
 	"github.com/wuxler/ruasec/pkg/image/docker/archive"
 	"github.com/wuxler/ruasec/pkg/image/docker/daemon"
 	"github.com/wuxler/ruasec/pkg/image/docker/rootfs"
+	ociarchive "github.com/wuxler/ruasec/pkg/image/oci/archive"
+	"github.com/wuxler/ruasec/pkg/image/oci/layout"
 	remoteimage "github.com/wuxler/ruasec/pkg/image/remote"
 )
 
@@ -23,15 +28,21 @@ func NewImageOptions() *ImageOptions {
 		Common:      NewCommon(),
 		Remote:      NewContainerRegistry(),
 		Docker:      NewDockerOptions(),
+		OCI:         NewOCIOptions(),
+		Containerd:  NewContainerdOptions(),
+		Containers:  NewContainersOptions(),
 		StorageType: "auto",
 	}
 }
 
 // ImageOptions contains the options for the image command
 type ImageOptions struct {
-	Common *Common
-	Remote *ContainerRegistry
-	Docker *DockerOptions
+	Common     *Common
+	Remote     *ContainerRegistry
+	Docker     *DockerOptions
+	OCI        *OCIOptions
+	Containerd *ContainerdOptions
+	Containers *ContainersOptions
 	// StorageType is the type of storage to use for the image
 	StorageType string
 }
@@ -42,6 +53,9 @@ func (o *ImageOptions) Flags() []cli.Flag {
 	flags = append(flags, o.Common.Flags()...)
 	flags = append(flags, o.Remote.Flags()...)
 	flags = append(flags, o.Docker.Flags()...)
+	flags = append(flags, o.OCI.Flags()...)
+	flags = append(flags, o.Containerd.Flags()...)
+	flags = append(flags, o.Containers.Flags()...)
 	flags = append(flags, &cli.StringFlag{
 		Name:        "storage-type",
 		Aliases:     []string{"t"},
@@ -75,7 +89,7 @@ func (o *ImageOptions) NewImageStorage(ctx context.Context, w io.Writer, scheme
 	}
 	switch scheme {
 	case image.StorageTypeDockerFS:
-		return rootfs.NewStorage(ctx, o.Docker.DataRoot)
+		return rootfs.NewStorage(ctx, cmdhelper.ElectDockerDataRoot(ctx, o.Docker.DataRoot))
 	case image.StorageTypeDockerArchive:
 		return archive.NewStorageFromFile(ctx, o.Docker.ArchiveFile)
 	case image.StorageTypeDockerDaemon:
@@ -83,11 +97,34 @@ func (o *ImageOptions) NewImageStorage(ctx context.Context, w io.Writer, scheme
 		config.CacheDir = appinfo.GetWorkspace().TempDir()
 		config.Host = o.Docker.DaemonHost
 		return daemon.NewStorageWithConfig(ctx, config)
+	case image.StorageTypeOCILayout:
+		return layout.NewStorageFromDir(ctx, o.OCI.LayoutDir)
+	case image.StorageTypeOCIArchive:
+		config := ociarchive.DefaultConfig()
+		config.CacheDir = appinfo.GetWorkspace().TempDir()
+		return ociarchive.NewStorageFromFileWithConfig(ctx, o.OCI.ArchiveFile, config)
+	case image.StorageTypeContainerdFS:
+		config := containerdrootfs.DefaultConfig()
+		config.Root = o.Containerd.Root
+		config.Namespace = o.Containerd.Namespace
+		config.Snapshotter = o.Containerd.Snapshotter
+		config.CacheDir = appinfo.GetWorkspace().TempDir()
+		return containerdrootfs.NewStorageWithConfig(ctx, config)
+	case image.StorageTypeContainersFS:
+		config := containersrootfs.DefaultConfig()
+		config.Root = o.Containers.Root
+		config.Driver = o.Containers.Driver
+		return containersrootfs.NewStorageWithConfig(ctx, config)
 	default:
 		client, err := o.Remote.NewClient(w)
 		if err != nil {
 			return nil, err
 		}
-		return remoteimage.NewStorage(client), nil
+		config := remoteimage.DefaultConfig()
+		config.Registries, err = o.Remote.NewRegistriesConfig()
+		if err != nil {
+			return nil, err
+		}
+		return remoteimage.NewStorageWithConfig(client, config), nil
 	}
 }
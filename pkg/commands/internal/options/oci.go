@@ -0,0 +1,45 @@
+package options
+
+import (
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	// OCIFlagCategory is the category of the OCI flags.
+	OCIFlagCategory = "[OCI]"
+)
+
+// NewOCIOptions returns a new *OCIOptions with default values.
+func NewOCIOptions() *OCIOptions {
+	return &OCIOptions{}
+}
+
+// OCIOptions defines the options for the OCI image layout.
+type OCIOptions struct {
+	// LayoutDir is the path to the OCI image layout directory.
+	LayoutDir string
+	// ArchiveFile is the path to the OCI image layout tarball file, may be compressed.
+	ArchiveFile string
+}
+
+// Flags returns the []cli.Flag related to current options.
+func (o *OCIOptions) Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "oci-layout-dir",
+			Usage:       "path to the OCI image layout directory",
+			Sources:     cli.EnvVars("RUA_OCI_LAYOUT_DIR"),
+			Value:       o.LayoutDir,
+			Destination: &o.LayoutDir,
+			Category:    OCIFlagCategory,
+		},
+		&cli.StringFlag{
+			Name:        "oci-archive-file",
+			Usage:       "path to the OCI image layout tarball file, gzip and zstd compressed tarballs are supported",
+			Sources:     cli.EnvVars("RUA_OCI_ARCHIVE_FILE"),
+			Value:       o.ArchiveFile,
+			Destination: &o.ArchiveFile,
+			Category:    OCIFlagCategory,
+		},
+	}
+}
@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/platforms"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v3"
+
+	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/commands/internal/options"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+// NewCopyCommand returns a CopyCommand with default values.
+func NewCopyCommand() *CopyCommand {
+	return &CopyCommand{
+		Common:      options.NewCommon(),
+		Remote:      options.NewContainerRegistry(),
+		Concurrency: remote.DefaultCopyConcurrency,
+	}
+}
+
+// CopyCommand is used to copy the image or index with all the contents it refers to
+// between the remote repositories.
+type CopyCommand struct {
+	Common      *options.Common
+	Remote      *options.ContainerRegistry
+	Platforms   []string `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+	Referrers   bool     `json:"referrers,omitempty" yaml:"referrers,omitempty"`
+	Concurrency int64    `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *CopyCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:    "copy",
+		Aliases: []string{"cp"},
+		Usage:   "Copy the image or index with all the manifests and blobs between the remote repositories",
+		UsageText: `ruasec registry copy [OPTIONS] SRC[:TAG|@DIGEST] DST[:TAG]
+
+# Mirror the multi-arch image to another registry
+$ ruasec registry copy nginx:latest registry.example.com/library/nginx:latest
+
+# Copy the linux/amd64 and linux/arm64 images only, the index is rewritten with the matched manifests
+$ ruasec registry copy --platform linux/amd64 --platform linux/arm64 nginx:latest registry.example.com/library/nginx:latest
+
+# Copy the image with the signatures and SBOMs referring to it
+$ ruasec registry copy --referrers registry.example.com/app:v1 registry.example.com/release/app:v1
+`,
+		ArgsUsage: "SRC DST",
+		Flags:     c.Flags(),
+		Before:    cmdhelper.BeforeFunc(cmdhelper.ExactArgs(2)), //nolint:mnd // source and target
+		Action:    c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *CopyCommand) Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "platform",
+			Usage:       `copy the manifests matched the platforms formatted as "os[/arch[/variant]]" in the index only`,
+			Destination: &c.Platforms,
+			Value:       c.Platforms,
+		},
+		&cli.BoolFlag{
+			Name:        "referrers",
+			Usage:       "copy the referrers like signatures and SBOMs recursively",
+			Destination: &c.Referrers,
+			Value:       c.Referrers,
+		},
+		&cli.IntFlag{
+			Name:        "concurrency",
+			Usage:       "number of the manifests and blobs transferred in parallel",
+			Destination: &c.Concurrency,
+			Value:       c.Concurrency,
+		},
+	}
+	flags = append(flags, c.Common.Flags()...)
+	flags = append(flags, c.Remote.Flags()...)
+	return flags
+}
+
+// Run is the main function for the current command
+func (c *CopyCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	rawSrc, rawDst := cmd.Args().Get(0), cmd.Args().Get(1)
+	source, err := name.NewReference(rawSrc)
+	if err != nil {
+		return err
+	}
+	reference, err := name.Identify(source)
+	if err != nil {
+		return err
+	}
+	target, err := name.NewReference(rawDst)
+	if err != nil {
+		return err
+	}
+	if _, ok := name.IsDigested(target); ok {
+		return fmt.Errorf("target must be formatted as NAME[:TAG] but got %q, the manifest digest may be changed", rawDst)
+	}
+
+	opts := []remote.CopyOption{
+		remote.WithCopyReferrers(c.Referrers),
+		remote.WithCopyConcurrency(int(c.Concurrency)),
+	}
+	for _, raw := range c.Platforms {
+		p, err := platforms.Parse(raw)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, remote.WithCopyPlatforms(imgspecv1.Platform(p)))
+	}
+	if tagged, ok := name.IsTagged(target); ok {
+		opts = append(opts, remote.WithCopyTags(tagged.Tag()))
+	} else if tagged, ok := name.IsTagged(source); ok {
+		opts = append(opts, remote.WithCopyTags(tagged.Tag()))
+	}
+
+	client, err := c.Remote.NewClient(cmd.Writer)
+	if err != nil {
+		return err
+	}
+	src, err := client.NewRepository(ctx, source.Repository())
+	if err != nil {
+		return err
+	}
+	dst, err := client.NewRepository(ctx, target.Repository())
+	if err != nil {
+		return err
+	}
+
+	desc, err := remote.Copy(ctx, src, reference, dst, opts...)
+	if err != nil {
+		return err
+	}
+	cmdhelper.Fprintf(cmd.Writer, "Copied %s to %s@%s", source, target.Repository(), desc.Digest)
+	return nil
+}
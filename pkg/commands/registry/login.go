@@ -6,6 +6,7 @@
 	"io"
 	"os"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/manifoldco/promptui"
 	"github.com/urfave/cli/v3"
@@ -34,6 +35,7 @@ type LoginCommand struct {
 	Username      string `json:"username,omitempty" yaml:"username,omitempty"`
 	Password      string `json:"password,omitempty" yaml:"password,omitempty"`
 	PasswordStdin bool   `json:"password_stdin,omitempty" yaml:"password_stdin,omitempty"`
+	Show          bool   `json:"show,omitempty" yaml:"show,omitempty"`
 }
 
 // ToCLI tranforms to a *cli.Command.
@@ -54,6 +56,12 @@ func (c *LoginCommand) ToCLI() *cli.Command {
 
 # Log in the private registry deployed with self-signed ssl certificate:
 $ ruasec registry login --insecure registry.example.com
+
+# Show the credential used for the repository and the auth file supplying it:
+$ ruasec registry login --show registry.example.com/team/repo
+
+# Show all the credentials found in the auth files:
+$ ruasec registry login --show
 `,
 		ArgsUsage: "REGISTRY",
 		Flags:     c.Flags(),
@@ -88,6 +96,12 @@ func (c *LoginCommand) Flags() []cli.Flag {
 			Destination: &c.PasswordStdin,
 			Value:       c.PasswordStdin,
 		},
+		&cli.BoolFlag{
+			Name:        "show",
+			Usage:       "show the credentials and the auth files supplying them instead of logging in",
+			Destination: &c.Show,
+			Value:       c.Show,
+		},
 	}
 	flags = append(flags, c.Remote.Flags()...)
 	flags = append(flags, c.Common.Flags()...)
@@ -117,6 +131,9 @@ func (c *LoginCommand) Validate(ctx context.Context, cmd *cli.Command) error {
 
 // Run is the main function for the current command
 func (c *LoginCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	if c.Show {
+		return c.show(ctx, cmd)
+	}
 	if err := c.run(ctx, cmd); err != nil {
 		return err
 	}
@@ -131,7 +148,8 @@ func (c *LoginCommand) run(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	authFile := authfile.NewAuthFile(c.Remote.AuthFile)
+	authFilePath := c.Remote.WritableAuthFile()
+	authFile := authfile.NewAuthFile(authFilePath)
 	if err := authFile.Load(); err != nil {
 		cmdhelper.Fprintf(cmd.Writer, "Warning: Failed to load auth file: %s", err)
 	}
@@ -142,9 +160,10 @@ func (c *LoginCommand) run(ctx context.Context, cmd *cli.Command) error {
 
 	if c.Password == "" && c.Username == "" {
 		// try to login with the crendetial found in default auth files
+		provider := client.AuthProvider
 		client.AuthProvider = func(ctx context.Context, host string) authn.AuthConfig {
-			authConfig, err := authFile.Get(ctx, host)
-			if err == nil && authConfig != authn.EmptyAuthConfig {
+			authConfig := provider(ctx, host)
+			if authConfig != authn.EmptyAuthConfig {
 				cmdhelper.Fprintf(cmd.Writer, "Authenticating with existing credentials ...")
 			}
 			return authConfig
@@ -180,14 +199,60 @@ func (c *LoginCommand) run(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 	// store the validate credential
-	cmdhelper.Fprintf(cmd.Writer, "Warning: Your password will be stored unencryped in %s", c.Remote.AuthFile)
-	store := credentials.NewFileStore(authFile)
+	if helper := authFile.CredentialHelper(serverAddress); helper != "" {
+		cmdhelper.Fprintf(cmd.Writer, "Storing the credential with %s%s", credentials.HelperPrefix, helper)
+	} else {
+		cmdhelper.Fprintf(cmd.Writer, "Warning: Your password will be stored unencryped in %s", authFilePath)
+	}
+	store := authFile.GetCredentialsStore()
 	if err := store.Store(ctx, serverAddress, authConfig); err != nil {
 		return err
 	}
 	return nil
 }
 
+// show prints the credential used for the registry or repository specified, or
+// all the credentials found in the auth files, with the auth files supplying them.
+func (c *LoginCommand) show(ctx context.Context, cmd *cli.Command) error {
+	chain, err := authfile.LoadChain(c.Remote.AuthFilePaths()...)
+	if err != nil {
+		return err
+	}
+	var sources []authfile.Source
+	if target := cmd.Args().First(); target != "" {
+		_, remainder := ocispecname.SplitScheme(target)
+		host, repository, _ := strings.Cut(strings.TrimSuffix(remainder, "/"), "/")
+		var repositories []string
+		if repository != "" {
+			repositories = append(repositories, repository)
+		}
+		source, err := chain.Lookup(ctx, host, repositories...)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	} else {
+		sources, err = chain.List(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	tw := tabwriter.NewWriter(cmd.Writer, 0, 0, 2, ' ', 0) //nolint:mnd // padding between columns
+	cmdhelper.Fprintf(tw, "KEY\tUSERNAME\tHELPER\tAUTH FILE\tSTATUS")
+	for _, source := range sources {
+		helper, status := "-", "active"
+		if source.Helper != "" {
+			helper = credentials.HelperPrefix + source.Helper
+		}
+		if source.Shadowed {
+			status = "shadowed"
+		}
+		cmdhelper.Fprintf(tw, "%s\t%s\t%s\t%s\t%s", source.Key, source.Username(), helper, source.Path, status)
+	}
+	return tw.Flush()
+}
+
 func (c *LoginCommand) promptUserInput() error {
 	if c.Username == "" {
 		prompt := promptui.Prompt{
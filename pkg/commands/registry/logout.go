@@ -11,7 +11,6 @@
 	"github.com/wuxler/ruasec/pkg/cmdhelper"
 	"github.com/wuxler/ruasec/pkg/commands/internal/options"
 	"github.com/wuxler/ruasec/pkg/ocispec/authn/authfile"
-	"github.com/wuxler/ruasec/pkg/ocispec/authn/credentials"
 	"github.com/wuxler/ruasec/pkg/ocispec/name"
 )
 
@@ -50,11 +49,11 @@ func (c *LogoutCommand) Flags() []cli.Flag {
 
 // Run is the main function for the current command
 func (c *LogoutCommand) Run(ctx context.Context, cmd *cli.Command) error {
-	authFile := authfile.NewAuthFile(c.ContainerRegistry.AuthFile)
+	authFile := authfile.NewAuthFile(c.ContainerRegistry.WritableAuthFile())
 	if err := authFile.Load(); err != nil {
 		cmdhelper.Fprintf(cmd.Writer, "Warning: Failed to load auth file: %s", err)
 	}
-	store := credentials.NewFileStore(authFile)
+	store := authFile.GetCredentialsStore()
 
 	serverAddress, isDefaultServer := cmdhelper.ElectDockerServerAddress(ctx, cmd, cmd.Args().First())
 	serversToLogout := []string{serverAddress}
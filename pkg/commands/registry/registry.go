@@ -25,8 +25,11 @@ func (c *RegistryCommand) ToCLI() *cli.Command {
 			NewLogoutCommand().ToCLI(),
 			NewManifestCommand().ToCLI(),
 			NewRepositoryCommand().ToCLI(),
+			NewTagCommand().ToCLI(),
 			NewCatalogCommand().ToCLI(),
 			NewBlobCommand().ToCLI(),
+			NewCopyCommand().ToCLI(),
+			NewSyncCommand().ToCLI(),
 		},
 	}
 }
@@ -0,0 +1,485 @@
+package registry
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/containerd/platforms"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
+
+	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/commands/internal/options"
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+	"github.com/wuxler/ruasec/pkg/util/xsemver"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// SyncSpec is the specification of the images to sync, which is loaded from the
+// YAML file like below:
+//
+//	targets:
+//	  - registry.example.com/mirror
+//	platforms:
+//	  - linux/amd64
+//	images:
+//	  - source: docker.io/library/nginx
+//	    tags: ["latest"]
+//	    semver: ">= 1.26, < 2"
+//	  - source: quay.io/prometheus/prometheus
+//	    tag_regex: '^v3\.\d+\.\d+$'
+//	    targets:
+//	      - registry.example.com/monitoring
+//	    referrers: true
+type SyncSpec struct {
+	// Targets are the default prefixes of the target repositories, which are joined
+	// with the repository paths of the sources.
+	Targets []string `json:"targets,omitempty" yaml:"targets,omitempty"`
+	// Platforms are the default platforms to filter the indexes.
+	Platforms []string `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+	// Referrers copies the referrers of the images by default if true.
+	Referrers bool `json:"referrers,omitempty" yaml:"referrers,omitempty"`
+	// Images are the source repositories to sync.
+	Images []SyncImage `json:"images,omitempty" yaml:"images,omitempty"`
+}
+
+// SyncImage is the source repository with the tags to sync.
+type SyncImage struct {
+	// Source is the name of the source repository.
+	Source string `json:"source" yaml:"source"`
+	// Tags are the tags to sync.
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// TagRegex selects the tags listed in the source repository matched the regexp.
+	TagRegex string `json:"tag_regex,omitempty" yaml:"tag_regex,omitempty"`
+	// Semver selects the tags listed in the source repository satisfied the semantic
+	// version constraint like ">= 1.2, < 2".
+	Semver string `json:"semver,omitempty" yaml:"semver,omitempty"`
+	// Targets overrides the default target prefixes.
+	Targets []string `json:"targets,omitempty" yaml:"targets,omitempty"`
+	// Platforms overrides the default platforms.
+	Platforms []string `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+	// Referrers overrides the default referrers option.
+	Referrers *bool `json:"referrers,omitempty" yaml:"referrers,omitempty"`
+}
+
+// SyncSummary is the result of the sync.
+type SyncSummary struct {
+	Copied  []SyncResult `json:"copied"`
+	Skipped []SyncResult `json:"skipped"`
+	Failed  []SyncResult `json:"failed"`
+}
+
+// SyncResult is the result of an image synced.
+type SyncResult struct {
+	Source string `json:"source"`
+	Target string `json:"target,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewSyncCommand returns a SyncCommand with default values.
+func NewSyncCommand() *SyncCommand {
+	return &SyncCommand{
+		Common:      options.NewCommon(),
+		Remote:      options.NewContainerRegistry(),
+		Jobs:        1,
+		Concurrency: remote.DefaultCopyConcurrency,
+	}
+}
+
+// SyncCommand is used to mirror the images listed in the specification file.
+type SyncCommand struct {
+	Common      *options.Common
+	Remote      *options.ContainerRegistry
+	File        string `json:"file,omitempty" yaml:"file,omitempty"`
+	Summary     string `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Jobs        int64  `json:"jobs,omitempty" yaml:"jobs,omitempty"`
+	Concurrency int64  `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *SyncCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:  "sync",
+		Usage: "Mirror the images listed in the specification file, only the missing or changed ones are copied",
+		UsageText: `ruasec registry sync [OPTIONS] -f FILE
+
+# Sync the images listed in sync.yaml and print the summary in json format
+$ ruasec registry sync -f sync.yaml
+
+# Sync 4 images in parallel and write the summary into the file
+$ ruasec registry sync -f sync.yaml --jobs 4 --summary summary.json
+`,
+		Flags: c.Flags(),
+		Before: cmdhelper.BeforeFunc(cmdhelper.ActionFuncChain(
+			cmdhelper.NoArgs(),
+			c.Common.Init,
+		)),
+		Action: c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *SyncCommand) Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Aliases:     []string{"f"},
+			Usage:       "specification file in yaml format of the images to sync",
+			Required:    true,
+			Destination: &c.File,
+			Value:       c.File,
+		},
+		&cli.StringFlag{
+			Name:        "summary",
+			Usage:       "write the summary in json format to the file instead of stdout",
+			Destination: &c.Summary,
+			Value:       c.Summary,
+		},
+		&cli.IntFlag{
+			Name:        "jobs",
+			Aliases:     []string{"j"},
+			Usage:       "number of the images synced in parallel",
+			Destination: &c.Jobs,
+			Value:       c.Jobs,
+		},
+		&cli.IntFlag{
+			Name:        "concurrency",
+			Usage:       "number of the manifests and blobs transferred in parallel for each image",
+			Destination: &c.Concurrency,
+			Value:       c.Concurrency,
+		},
+	}
+	flags = append(flags, c.Common.Flags()...)
+	flags = append(flags, c.Remote.Flags()...)
+	return flags
+}
+
+// Run is the main function for the current command
+func (c *SyncCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	spec, err := LoadSyncSpec(c.File)
+	if err != nil {
+		return err
+	}
+	client, err := c.Remote.NewClient(cmd.Writer)
+	if err != nil {
+		return err
+	}
+	s := &syncer{
+		client:      client,
+		concurrency: int(c.Concurrency),
+		registries:  map[string]*remote.Registry{},
+	}
+
+	// resolve the tags of all the sources first to know the jobs
+	jobs := []syncJob{}
+	for _, img := range spec.Images {
+		resolved, err := s.resolve(ctx, spec, img)
+		if err != nil {
+			xlog.C(ctx).Warnf("unable to resolve %s: %s", img.Source, err)
+			s.record(&s.summary.Failed, SyncResult{Source: img.Source, Error: err.Error()})
+			continue
+		}
+		jobs = append(jobs, resolved...)
+	}
+
+	var g errgroup.Group
+	g.SetLimit(int(max(c.Jobs, 1)))
+	for _, job := range jobs {
+		g.Go(func() error {
+			s.sync(ctx, job)
+			// failures are recorded in the summary and do not stop the others
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	if err := c.writeSummary(cmd.Writer, s.sorted()); err != nil {
+		return err
+	}
+	if n := len(s.summary.Failed); n > 0 {
+		return fmt.Errorf("%d images failed to sync", n)
+	}
+	return nil
+}
+
+func (c *SyncCommand) writeSummary(stdout io.Writer, summary *SyncSummary) (err error) {
+	w := stdout
+	if c.Summary != "" {
+		file, err := xos.Create(c.Summary)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errors.Join(err, file.Close())
+		}()
+		w = file
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(summary)
+}
+
+// LoadSyncSpec loads the sync specification from the YAML file and validates it.
+func LoadSyncSpec(path string) (*SyncSpec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &SyncSpec{}
+	if err := yaml.Unmarshal(content, spec); err != nil {
+		return nil, fmt.Errorf("unable to parse sync spec %s: %w", path, err)
+	}
+	for i, img := range spec.Images {
+		if img.Source == "" {
+			return nil, errdefs.Newf(errdefs.ErrInvalidParameter, "images[%d]: source is required", i)
+		}
+		if len(img.Tags) == 0 && img.TagRegex == "" && img.Semver == "" {
+			return nil, errdefs.Newf(errdefs.ErrInvalidParameter, "images[%d]: one of tags, tag_regex and semver is required", i)
+		}
+		for _, tag := range img.Tags {
+			if err := name.ValidateTag(tag); err != nil {
+				return nil, errdefs.Newf(errdefs.ErrInvalidParameter, "images[%d]: invalid tag %q: %s", i, tag, err)
+			}
+		}
+		if len(img.Targets) == 0 && len(spec.Targets) == 0 {
+			return nil, errdefs.Newf(errdefs.ErrInvalidParameter, "images[%d]: targets is required", i)
+		}
+	}
+	return spec, nil
+}
+
+// syncJob is an image to sync from the source to the target.
+type syncJob struct {
+	source    *remote.Repository
+	target    *remote.Repository
+	tag       string
+	platforms []imgspecv1.Platform
+	referrers bool
+}
+
+// syncer syncs the images and records the results.
+type syncer struct {
+	client      *remote.Client
+	concurrency int
+
+	mu         sync.Mutex
+	registries map[string]*remote.Registry
+	summary    SyncSummary
+}
+
+// resolve returns the jobs of the tags selected in the source repository for
+// each target.
+func (s *syncer) resolve(ctx context.Context, spec *SyncSpec, img SyncImage) ([]syncJob, error) {
+	source, err := s.repository(ctx, img.Source)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.selectTags(ctx, source, img)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := []imgspecv1.Platform{}
+	for _, raw := range orDefault(img.Platforms, spec.Platforms) {
+		p, err := platforms.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, imgspecv1.Platform(p))
+	}
+	referrers := spec.Referrers
+	if img.Referrers != nil {
+		referrers = *img.Referrers
+	}
+
+	jobs := []syncJob{}
+	for _, prefix := range orDefault(img.Targets, spec.Targets) {
+		target, err := s.repository(ctx, strings.TrimSuffix(prefix, "/")+"/"+source.Name().Path())
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			jobs = append(jobs, syncJob{source: source, target: target, tag: tag, platforms: ps, referrers: referrers})
+		}
+	}
+	return jobs, nil
+}
+
+// orDefault returns values if not empty, or the defaults.
+func orDefault(values, defaults []string) []string {
+	if len(values) > 0 {
+		return values
+	}
+	return defaults
+}
+
+// selectTags returns the tags listed explicitly and the ones in the source
+// repository matched the filters.
+func (s *syncer) selectTags(ctx context.Context, source *remote.Repository, img SyncImage) ([]string, error) {
+	tags := slices.Clone(img.Tags)
+	if img.TagRegex == "" && img.Semver == "" {
+		return tags, nil
+	}
+
+	var re *regexp.Regexp
+	if img.TagRegex != "" {
+		compiled, err := regexp.Compile(img.TagRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag_regex: %w", err)
+		}
+		re = compiled
+	}
+	var constraint *xsemver.Constraint
+	if img.Semver != "" {
+		parsed, err := xsemver.ParseConstraint(img.Semver)
+		if err != nil {
+			return nil, err
+		}
+		constraint = parsed
+	}
+
+	iterator := source.Tags().List()
+	for {
+		page, err := iterator.Next(ctx)
+		if err != nil {
+			if errors.Is(err, iter.ErrIteratorDone) {
+				break
+			}
+			return nil, fmt.Errorf("unable to list tags: %w", err)
+		}
+		for _, tag := range page {
+			if re != nil && !re.MatchString(tag) {
+				continue
+			}
+			if constraint != nil {
+				v, err := xsemver.Parse(tag)
+				if err != nil || !constraint.Check(v) {
+					continue
+				}
+			}
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), nil
+}
+
+// sync copies the image if the target tag is missing or refers to a different
+// manifest. The images filtered by the platforms are always copied since their
+// indexes are rewritten, but the existing contents are still skipped.
+func (s *syncer) sync(ctx context.Context, job syncJob) {
+	result := SyncResult{
+		Source: job.source.Name().String() + ":" + job.tag,
+		Target: job.target.Name().String() + ":" + job.tag,
+	}
+	fail := func(err error) {
+		xlog.C(ctx).Warnf("unable to sync %s to %s: %s", result.Source, result.Target, err)
+		result.Error = err.Error()
+		s.record(&s.summary.Failed, result)
+	}
+	if _, err := name.WithTag(job.source.Name(), job.tag); err != nil {
+		fail(err)
+		return
+	}
+	if _, err := name.WithTag(job.target.Name(), job.tag); err != nil {
+		fail(err)
+		return
+	}
+
+	srcDesc, err := job.source.Manifests().Stat(ctx, job.tag)
+	if err != nil {
+		fail(err)
+		return
+	}
+	dstDesc, err := job.target.Manifests().Stat(ctx, job.tag)
+	if err != nil && !errors.Is(err, errdefs.ErrNotFound) {
+		fail(err)
+		return
+	}
+	if err == nil && dstDesc.Digest == srcDesc.Digest {
+		xlog.C(ctx).Debugf("skip %s which is up to date", result.Target)
+		result.Digest = dstDesc.Digest.String()
+		s.record(&s.summary.Skipped, result)
+		return
+	}
+
+	// copy by the digest in case the source tag is updated meanwhile
+	desc, err := remote.Copy(ctx, job.source, srcDesc.Digest.String(), job.target,
+		remote.WithCopyTags(job.tag),
+		remote.WithCopyPlatforms(job.platforms...),
+		remote.WithCopyReferrers(job.referrers),
+		remote.WithCopyConcurrency(s.concurrency),
+	)
+	if err != nil {
+		fail(err)
+		return
+	}
+	result.Digest = desc.Digest.String()
+	if desc.Digest == dstDesc.Digest {
+		s.record(&s.summary.Skipped, result)
+		return
+	}
+	xlog.C(ctx).Infof("synced %s to %s@%s", result.Source, result.Target, desc.Digest)
+	s.record(&s.summary.Copied, result)
+}
+
+// repository returns the remote repository, and the registries are shared to
+// detect the schemes once.
+func (s *syncer) repository(ctx context.Context, raw string) (*remote.Repository, error) {
+	repo, err := name.NewRepository(raw)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := repo.Domain().String()
+	registry, ok := s.registries[key]
+	if !ok {
+		if registry, err = s.client.NewRegistry(ctx, repo.Domain()); err != nil {
+			return nil, err
+		}
+		s.registries[key] = registry
+	}
+	return registry.RepositoryE(repo.Path())
+}
+
+func (s *syncer) record(results *[]SyncResult, result SyncResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*results = append(*results, result)
+}
+
+// sorted returns the summary with the results sorted.
+func (s *syncer) sorted() *SyncSummary {
+	compare := func(a, b SyncResult) int {
+		return cmp.Or(strings.Compare(a.Source, b.Source), strings.Compare(a.Target, b.Target))
+	}
+	summary := &SyncSummary{
+		Copied:  slices.SortedFunc(slices.Values(s.summary.Copied), compare),
+		Skipped: slices.SortedFunc(slices.Values(s.summary.Skipped), compare),
+		Failed:  slices.SortedFunc(slices.Values(s.summary.Failed), compare),
+	}
+	// keep the empty lists in json instead of null
+	for _, results := range []*[]SyncResult{&summary.Copied, &summary.Skipped, &summary.Failed} {
+		if *results == nil {
+			*results = []SyncResult{}
+		}
+	}
+	return summary
+}
@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/server"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+// newTestRegistry serves the in-memory registry, and returns the host of it.
+func newTestRegistry(t *testing.T) (*memory.Spec, string) {
+	t.Helper()
+	spec := memory.New()
+	srv := httptest.NewServer(server.NewHandler(spec))
+	t.Cleanup(srv.Close)
+	return spec, strings.TrimPrefix(srv.URL, "http://")
+}
+
+func pushBlob(t *testing.T, spec *memory.Spec, repo string, mediaType string, content []byte) imgspecv1.Descriptor {
+	t.Helper()
+	desc := ocispec.NewDescriptorFromBytes(mediaType, content)
+	err := spec.PushBlob(context.Background(), repo, func(context.Context) (cas.ReadCloser, error) {
+		return cas.NewReadCloser(xio.NopReader(bytes.NewReader(content)), desc), nil
+	})
+	require.NoError(t, err)
+	return desc
+}
+
+// pushImage pushes the image with the config content and tags it.
+func pushImage(t *testing.T, spec *memory.Spec, repo string, config string, tags ...string) imgspecv1.Descriptor {
+	t.Helper()
+	mf := imgspecv1.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    pushBlob(t, spec, repo, ocispec.MediaTypeImageConfig, []byte(config)),
+		Layers:    []imgspecv1.Descriptor{pushBlob(t, spec, repo, ocispec.MediaTypeImageLayerGzip, []byte("layer of "+config))},
+	}
+	mf.SchemaVersion = 2
+	content, err := json.Marshal(mf)
+	require.NoError(t, err)
+	require.NoError(t, spec.PushManifest(context.Background(), repo, cas.NewReaderFromBytes(mf.MediaType, content), tags...))
+	return ocispec.NewDescriptorFromBytes(mf.MediaType, content)
+}
+
+func newTestSyncer() *syncer {
+	return &syncer{
+		client:      remote.NewClient(),
+		concurrency: 1,
+		registries:  map[string]*remote.Registry{},
+	}
+}
+
+func TestLoadSyncSpec(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			content: `
+targets: [registry.example.com/mirror]
+images:
+  - source: docker.io/library/nginx
+    tags: [latest, "1.27"]
+    semver: ">= 1.26"
+  - source: quay.io/prometheus/prometheus
+    tag_regex: '^v3\.'
+    targets: [registry.example.com/monitoring]
+`,
+		},
+		{
+			name:    "image targets only",
+			content: "images: [{source: nginx, tags: [latest], targets: [registry.example.com/mirror]}]",
+		},
+		{
+			name:    "source required",
+			content: "targets: [registry.example.com/mirror]\nimages: [{tags: [latest]}]",
+			wantErr: "images[0]: source is required",
+		},
+		{
+			name:    "tags required",
+			content: "targets: [registry.example.com/mirror]\nimages: [{source: nginx}]",
+			wantErr: "images[0]: one of tags, tag_regex and semver is required",
+		},
+		{
+			name:    "invalid tag",
+			content: "targets: [registry.example.com/mirror]\nimages: [{source: nginx, tags: [latest, v1.0+build]}]",
+			wantErr: `images[0]: invalid tag "v1.0+build"`,
+		},
+		{
+			name:    "targets required",
+			content: "images: [{source: nginx, tags: [latest]}]",
+			wantErr: "images[0]: targets is required",
+		},
+		{
+			name:    "invalid yaml",
+			content: "images: {",
+			wantErr: "unable to parse sync spec",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sync.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+			spec, err := LoadSyncSpec(path)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, spec.Images)
+		})
+	}
+}
+
+func TestSyncer_selectTags(t *testing.T) {
+	ctx := context.Background()
+	spec, host := newTestRegistry(t)
+	pushImage(t, spec, "library/app", `{"os":"linux"}`, "v1.0.0", "v1.1.0", "v2.0.0", "latest", "dev")
+
+	s := newTestSyncer()
+	source, err := s.repository(ctx, host+"/library/app")
+	require.NoError(t, err)
+
+	testcases := []struct {
+		name    string
+		img     SyncImage
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "explicit tags only",
+			img:  SyncImage{Tags: []string{"missing", "latest"}},
+			want: []string{"missing", "latest"},
+		},
+		{
+			name: "regex merged with explicit tags",
+			img:  SyncImage{Tags: []string{"v1.0.0", "latest"}, TagRegex: `^v1\.`},
+			want: []string{"latest", "v1.0.0", "v1.1.0"},
+		},
+		{
+			name: "semver",
+			img:  SyncImage{Semver: ">= 1.1, < 3"},
+			want: []string{"v1.1.0", "v2.0.0"},
+		},
+		{
+			name: "regex and semver",
+			img:  SyncImage{Tags: []string{"dev"}, TagRegex: `^v\d+\.0\.`, Semver: ">= 1"},
+			want: []string{"dev", "v1.0.0", "v2.0.0"},
+		},
+		{
+			name:    "invalid regex",
+			img:     SyncImage{TagRegex: `(`},
+			wantErr: true,
+		},
+		{
+			name:    "invalid semver",
+			img:     SyncImage{Semver: "invalid"},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.selectTags(ctx, source, tc.img)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestSyncer_sync(t *testing.T) {
+	ctx := context.Background()
+	spec, host := newTestRegistry(t)
+	first := pushImage(t, spec, "library/app", `{"os":"linux","variant":"first"}`, "v1")
+
+	s := newTestSyncer()
+	source, err := s.repository(ctx, host+"/library/app")
+	require.NoError(t, err)
+	target, err := s.repository(ctx, host+"/mirror/library/app")
+	require.NoError(t, err)
+	job := syncJob{source: source, target: target, tag: "v1"}
+
+	// copied when the target is missing
+	s.sync(ctx, job)
+	require.Len(t, s.summary.Copied, 1)
+	assert.Equal(t, SyncResult{
+		Source: host + "/library/app:v1",
+		Target: host + "/mirror/library/app:v1",
+		Digest: first.Digest.String(),
+	}, s.summary.Copied[0])
+	desc, err := spec.StatManifest(ctx, "mirror/library/app", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, first.Digest, desc.Digest)
+
+	// skipped when the target is up to date
+	s.sync(ctx, job)
+	require.Len(t, s.summary.Skipped, 1)
+	assert.Equal(t, first.Digest.String(), s.summary.Skipped[0].Digest)
+	assert.Len(t, s.summary.Copied, 1)
+
+	// copied again when the source tag is updated
+	second := pushImage(t, spec, "library/app", `{"os":"linux","variant":"second"}`, "v1")
+	s.sync(ctx, job)
+	require.Len(t, s.summary.Copied, 2)
+	assert.Equal(t, second.Digest.String(), s.summary.Copied[1].Digest)
+	desc, err = spec.StatManifest(ctx, "mirror/library/app", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, second.Digest, desc.Digest)
+
+	// failed when the source tag is missing or invalid
+	s.sync(ctx, syncJob{source: source, target: target, tag: "missing"})
+	s.sync(ctx, syncJob{source: source, target: target, tag: "v1.0+build"})
+	require.Len(t, s.summary.Failed, 2)
+	assert.Contains(t, s.summary.Failed[0].Error, errdefs.ErrNotFound.Error())
+	assert.Equal(t, host+"/library/app:v1.0+build", s.summary.Failed[1].Source)
+	assert.NotEmpty(t, s.summary.Failed[1].Error)
+}
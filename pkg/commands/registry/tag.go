@@ -1,3 +1,525 @@
 package registry
 
-// TODO: implement tag operations commands.
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/wuxler/ruasec/pkg/cmdhelper"
+	"github.com/wuxler/ruasec/pkg/commands/internal/options"
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xsemver"
+)
+
+const (
+	// TagSortNone keeps the order returned by the registry.
+	TagSortNone = "none"
+	// TagSortLexical sorts the tags in lexical order.
+	TagSortLexical = "lexical"
+	// TagSortSemver sorts the tags by the semantic versions, and the tags which
+	// are not semantic versions are put at last in lexical order.
+	TagSortSemver = "semver"
+	// TagSortCreated sorts the tags by the "created" time in the image config.
+	TagSortCreated = "created"
+
+	// tagStatConcurrency limits the images inspected in parallel when sorting by
+	// the creation time.
+	tagStatConcurrency = 8
+)
+
+// NewTagCommand returns a TagCommand with default values.
+func NewTagCommand() *TagCommand {
+	return &TagCommand{}
+}
+
+// TagCommand defines tag operations.
+type TagCommand struct{}
+
+// ToCLI transforms to a *cli.Command.
+func (c *TagCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:            "tag",
+		Usage:           "Tag operations",
+		HideHelpCommand: true,
+		Commands: []*cli.Command{
+			NewTagAddCommand().ToCLI(),
+			NewTagRemoveCommand().ToCLI(),
+			NewTagListCommand().ToCLI(),
+		},
+	}
+}
+
+// NewTagAddCommand returns a tag add command with default values.
+func NewTagAddCommand() *TagAddCommand {
+	return &TagAddCommand{
+		Common: options.NewCommon(),
+		Remote: options.NewContainerRegistry(),
+	}
+}
+
+// TagAddCommand is used to add tags to the manifest.
+type TagAddCommand struct {
+	Common *options.Common
+	Remote *options.ContainerRegistry
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *TagAddCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:    "add",
+		Aliases: []string{"create"},
+		Usage:   "Add tags to the manifest, or retag it into another repository in the same registry",
+		UsageText: `ruasec registry tag add [OPTIONS] SRC[:TAG|@DIGEST] NEWTAG|NAME:NEWTAG [...]
+
+# Add tags to the manifest in the same repository
+$ ruasec registry tag add registry.example.com/app:v1.2.3 v1.2 v1 latest
+
+# Retag into another repository in the same registry, the blobs are mounted without uploading
+$ ruasec registry tag add registry.example.com/staging/app:v1 registry.example.com/release/app:v1
+`,
+		ArgsUsage: "SRC NEWTAG [NEWTAG...]",
+		Flags:     c.Flags(),
+		Before:    cmdhelper.BeforeFunc(cmdhelper.MinimumNArgs(2)), //nolint:mnd // source and tags
+		Action:    c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *TagAddCommand) Flags() []cli.Flag {
+	flags := []cli.Flag{}
+	flags = append(flags, c.Common.Flags()...)
+	flags = append(flags, c.Remote.Flags()...)
+	return flags
+}
+
+// Run is the main function for the current command
+func (c *TagAddCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	source, err := name.NewReference(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	reference, err := name.Identify(source)
+	if err != nil {
+		return err
+	}
+	// the new tags are either the bare tags in the source repository or the
+	// tagged references in other repositories
+	targets := []name.Tagged{}
+	for _, raw := range cmd.Args().Tail() {
+		if tagged, err := name.WithTag(source.Repository(), raw); err == nil {
+			targets = append(targets, tagged)
+			continue
+		}
+		ref, err := name.NewReference(raw)
+		if err != nil {
+			return err
+		}
+		tagged, ok := name.IsTagged(ref)
+		if !ok {
+			return fmt.Errorf("new tag must be formatted as TAG or NAME:TAG but got %q", raw)
+		}
+		if ref.Repository().Domain().Hostname() != source.Repository().Domain().Hostname() {
+			return errdefs.Newf(errdefs.ErrUnsupported,
+				"retag %q across registries, use \"ruasec registry copy\" instead", raw)
+		}
+		targets = append(targets, tagged)
+	}
+
+	client, err := c.Remote.NewClient(cmd.Writer)
+	if err != nil {
+		return err
+	}
+	src, err := client.NewRepository(ctx, source.Repository())
+	if err != nil {
+		return err
+	}
+	rc, err := src.Manifests().FetchTagOrDigest(ctx, reference)
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(rc)
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	desc := rc.Descriptor()
+
+	for _, target := range targets {
+		if target.Repository().String() == source.Repository().String() {
+			if err := src.Tags().Tag(ctx, cas.NewReader(bytes.NewReader(content), desc), target.Tag()); err != nil {
+				return err
+			}
+		} else {
+			dst := src.Registry().Repository(target.Repository().Path())
+			// the blobs are mounted from the source repository by the copy
+			if _, err := remote.Copy(ctx, src, desc.Digest.String(), dst, remote.WithCopyTags(target.Tag())); err != nil {
+				return err
+			}
+		}
+		cmdhelper.Fprintf(cmd.Writer, "Tagged %s as %s", source, target)
+	}
+	return nil
+}
+
+// NewTagRemoveCommand returns a tag remove command with default values.
+func NewTagRemoveCommand() *TagRemoveCommand {
+	return &TagRemoveCommand{
+		Common: options.NewCommon(),
+		Remote: options.NewContainerRegistry(),
+	}
+}
+
+// TagRemoveCommand is used to remove the tags without deleting the manifests.
+type TagRemoveCommand struct {
+	Common *options.Common
+	Remote *options.ContainerRegistry
+	Force  bool `json:"force,omitempty" yaml:"force,omitempty"`
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *TagRemoveCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:    "remove",
+		Aliases: []string{"rm", "delete", "del"},
+		Usage:   "Remove the tags from the remote repository",
+		UsageText: `ruasec registry tag remove [OPTIONS] NAME:TAG [NAME:TAG...]
+
+# Remove the tags, the manifests referred are kept if the registry supports deleting tags
+$ ruasec registry tag rm registry.example.com/app:dev registry.example.com/app:test
+`,
+		ArgsUsage: "NAME:TAG [NAME:TAG...]",
+		Flags:     c.Flags(),
+		Before:    cmdhelper.BeforeFunc(cmdhelper.MinimumNArgs(1)),
+		Action:    c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *TagRemoveCommand) Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "force",
+			Aliases:     []string{"f"},
+			Usage:       "ignore the tags not found",
+			Destination: &c.Force,
+			Value:       c.Force,
+		},
+	}
+	flags = append(flags, c.Common.Flags()...)
+	flags = append(flags, c.Remote.Flags()...)
+	return flags
+}
+
+// Run is the main function for the current command
+func (c *TagRemoveCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	targets := []name.Tagged{}
+	for _, raw := range cmd.Args().Slice() {
+		ref, err := name.NewReference(raw)
+		if err != nil {
+			return err
+		}
+		tagged, ok := name.IsTagged(ref)
+		if !ok {
+			return fmt.Errorf("target must be a tag reference formatted as NAME:TAG but got %q", raw)
+		}
+		targets = append(targets, tagged)
+	}
+
+	client, err := c.Remote.NewClient(cmd.Writer)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		repository, err := client.NewRepository(ctx, target.Repository())
+		if err != nil {
+			return err
+		}
+		if err := repository.Tags().Untag(ctx, target.Tag()); err != nil {
+			if c.Force && errors.Is(err, errdefs.ErrNotFound) {
+				cmdhelper.Fprintf(cmd.Writer, "Skip, missing %q which is not found", target)
+				continue
+			}
+			return fmt.Errorf("%s: %w", target, err)
+		}
+		cmdhelper.Fprintf(cmd.Writer, "Untagged %s", target)
+	}
+	return nil
+}
+
+// NewTagListCommand returns a tag list command with default values.
+func NewTagListCommand() *TagListCommand {
+	return &TagListCommand{
+		Common: options.NewCommon(),
+		Remote: options.NewContainerRegistry(),
+		Sort:   TagSortNone,
+	}
+}
+
+// TagListCommand is used to list the tags in the remote repository.
+type TagListCommand struct {
+	Common   *options.Common
+	Remote   *options.ContainerRegistry
+	Sort     string `json:"sort,omitempty" yaml:"sort,omitempty"`
+	Reverse  bool   `json:"reverse,omitempty" yaml:"reverse,omitempty"`
+	Filter   string `json:"filter,omitempty" yaml:"filter,omitempty"`
+	PageSize int64  `json:"page_size,omitempty" yaml:"page_size,omitempty"`
+	Offset   string `json:"offset,omitempty" yaml:"offset,omitempty"`
+}
+
+// ToCLI transforms to a *cli.Command.
+func (c *TagListCommand) ToCLI() *cli.Command {
+	return &cli.Command{
+		Name:    "list",
+		Aliases: []string{"ls"},
+		Usage:   "List the tags in the remote repository",
+		UsageText: `ruasec registry tag list [OPTIONS] REPOSITORY
+
+# List the release tags from the newest version
+$ ruasec registry tag ls --filter '^v\d+\.\d+\.\d+$' --sort semver --reverse registry.example.com/app
+
+# List the tags by the image creation time
+$ ruasec registry tag ls --sort created registry.example.com/app
+
+# List a page of 100 tags after "v1.0.0"
+$ ruasec registry tag ls --page-size 100 --offset v1.0.0 registry.example.com/app
+`,
+		ArgsUsage: "REPOSITORY",
+		Flags:     c.Flags(),
+		Before:    cmdhelper.BeforeFunc(cmdhelper.ExactArgs(1)),
+		Action:    c.Run,
+	}
+}
+
+// Flags defines the flags related to the current command.
+func (c *TagListCommand) Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name: "sort",
+			Usage: fmt.Sprintf("sort mode, oneof [%q, %q, %q, %q]",
+				TagSortNone, TagSortLexical, TagSortSemver, TagSortCreated),
+			Destination: &c.Sort,
+			Value:       c.Sort,
+		},
+		&cli.BoolFlag{
+			Name:        "reverse",
+			Aliases:     []string{"r"},
+			Usage:       "reverse the sorted tags",
+			Destination: &c.Reverse,
+			Value:       c.Reverse,
+		},
+		&cli.StringFlag{
+			Name:        "filter",
+			Usage:       "list the tags matched the regexp only",
+			Destination: &c.Filter,
+			Value:       c.Filter,
+		},
+		&cli.IntFlag{
+			Name:        "page-size",
+			Usage:       "list a single page with the number of tags at most, list all the tags if not positive",
+			Destination: &c.PageSize,
+			Value:       c.PageSize,
+		},
+		&cli.StringFlag{
+			Name:        "offset",
+			Usage:       "list the tags after the tag in the registry order",
+			Destination: &c.Offset,
+			Value:       c.Offset,
+		},
+	}
+	flags = append(flags, c.Common.Flags()...)
+	flags = append(flags, c.Remote.Flags()...)
+	return flags
+}
+
+// Run is the main function for the current command
+func (c *TagListCommand) Run(ctx context.Context, cmd *cli.Command) error {
+	if !slices.Contains([]string{TagSortNone, TagSortLexical, TagSortSemver, TagSortCreated}, c.Sort) {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "unsupported sort mode %q", c.Sort)
+	}
+	var re *regexp.Regexp
+	if c.Filter != "" {
+		compiled, err := regexp.Compile(c.Filter)
+		if err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		re = compiled
+	}
+	target, err := name.NewRepository(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+
+	client, err := c.Remote.NewClient(cmd.Writer)
+	if err != nil {
+		return err
+	}
+	repository, err := client.NewRepository(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	tags, err := c.list(ctx, repository.Tags())
+	if err != nil {
+		return err
+	}
+	if re != nil {
+		tags = slices.DeleteFunc(tags, func(tag string) bool {
+			return !re.MatchString(tag)
+		})
+	}
+	switch c.Sort {
+	case TagSortLexical:
+		slices.Sort(tags)
+	case TagSortSemver:
+		sortTagsBySemver(tags)
+	case TagSortCreated:
+		if err := sortTagsByCreated(ctx, repository, tags); err != nil {
+			return err
+		}
+	}
+	if c.Reverse {
+		slices.Reverse(tags)
+	}
+	for _, tag := range tags {
+		cmdhelper.Fprintf(cmd.Writer, tag)
+	}
+	return nil
+}
+
+// list returns all the tags, or a single page when the page size is set.
+func (c *TagListCommand) list(ctx context.Context, store distribution.TagStore) ([]string, error) {
+	opts := []distribution.ListOption{distribution.WithOffset(c.Offset)}
+	if c.PageSize > 0 {
+		opts = append(opts, distribution.WithPageSize(int(c.PageSize)))
+	}
+	iterator := store.List(opts...)
+	tags := []string{}
+	for {
+		page, err := iterator.Next(ctx)
+		if err != nil {
+			if errors.Is(err, iter.ErrIteratorDone) {
+				break
+			}
+			return nil, err
+		}
+		tags = append(tags, page...)
+		if c.PageSize > 0 {
+			break
+		}
+	}
+	return tags, nil
+}
+
+// sortTagsBySemver sorts the semantic version tags in ascending order, and the
+// others are put at last in lexical order.
+func sortTagsBySemver(tags []string) {
+	slices.SortStableFunc(tags, func(a, b string) int {
+		va, erra := xsemver.Parse(a)
+		vb, errb := xsemver.Parse(b)
+		switch {
+		case erra == nil && errb == nil:
+			if n := va.Compare(vb); n != 0 {
+				return n
+			}
+			return strings.Compare(a, b)
+		case erra == nil:
+			return -1
+		case errb == nil:
+			return 1
+		default:
+			return strings.Compare(a, b)
+		}
+	})
+}
+
+// sortTagsByCreated sorts the tags by the "created" time in the image configs
+// from the oldest, and the tags without the time are put at first. The config
+// of the first image manifest is used for the indexes.
+func sortTagsByCreated(ctx context.Context, repository *remote.Repository, tags []string) error {
+	created := make([]time.Time, len(tags))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(tagStatConcurrency)
+	for i, tag := range tags {
+		g.Go(func() error {
+			t, err := imageCreated(gctx, repository, tag)
+			if err != nil {
+				return fmt.Errorf("unable to get the creation time of tag %s: %w", tag, err)
+			}
+			created[i] = t
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	indexes := make([]int, len(tags))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	slices.SortStableFunc(indexes, func(a, b int) int {
+		return created[a].Compare(created[b])
+	})
+	sorted := make([]string, 0, len(tags))
+	for _, i := range indexes {
+		sorted = append(sorted, tags[i])
+	}
+	copy(tags, sorted)
+	return nil
+}
+
+// imageCreated returns the "created" time in the image config of the tag.
+func imageCreated(ctx context.Context, repository *remote.Repository, tag string) (time.Time, error) {
+	rc, err := repository.Manifests().FetchTagOrDigest(ctx, tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer xio.CloseAndSkipError(rc)
+	parsed, desc, err := manifest.ParseCASReader(rc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	first := func(descs ...imgspecv1.Descriptor) (imgspecv1.Descriptor, bool) {
+		if len(descs) == 0 {
+			return imgspecv1.Descriptor{}, false
+		}
+		return descs[0], true
+	}
+	img, _, err := manifest.SelectImageManifest(ctx, repository.Manifests(), parsed, desc,
+		manifest.DescriptorMatcherByPlatform(nil), first)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	configRC, err := repository.Blobs().Fetch(ctx, img.Config())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer xio.CloseAndSkipError(configRC)
+	config := imgspecv1.Image{}
+	if err := json.NewDecoder(configRC).Decode(&config); err != nil {
+		return time.Time{}, err
+	}
+	if config.Created == nil {
+		return time.Time{}, nil
+	}
+	return *config.Created, nil
+}
@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+)
+
+// runCommand runs the command as the root with the args, and returns the lines
+// written to the output. The auth file is isolated from the user's one.
+func runCommand(t *testing.T, command *cli.Command, args ...string) ([]string, error) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	command.Writer = buf
+	command.ErrWriter = io.Discard
+	args = append([]string{command.Name, "--auth-file", filepath.Join(t.TempDir(), "auth.json")}, args...)
+	err := command.Run(context.Background(), args)
+	return strings.Split(strings.TrimSpace(buf.String()), "\n"), err
+}
+
+func TestTagListCommand_list(t *testing.T) {
+	ctx := context.Background()
+	spec, host := newTestRegistry(t)
+	pushImage(t, spec, "library/app", `{"os":"linux"}`, "a", "b", "c", "d", "e")
+
+	repository, err := newTestSyncer().repository(ctx, host+"/library/app")
+	require.NoError(t, err)
+
+	testcases := []struct {
+		name     string
+		pageSize int64
+		offset   string
+		want     []string
+	}{
+		{name: "all", want: []string{"a", "b", "c", "d", "e"}},
+		{name: "offset", offset: "b", want: []string{"c", "d", "e"}},
+		{name: "single page", pageSize: 2, want: []string{"a", "b"}},
+		{name: "single page after offset", pageSize: 2, offset: "c", want: []string{"d", "e"}},
+		{name: "offset after all", offset: "e", want: []string{}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &TagListCommand{PageSize: tc.pageSize, Offset: tc.offset}
+			got, err := c.list(ctx, repository.Tags())
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestSortTagsBySemver(t *testing.T) {
+	tags := []string{"latest", "v1.10.0", "1.2.0", "dev", "v1.2.0", "v1.9.1", "v2.0.0-rc.1", "v2.0.0"}
+	sortTagsBySemver(tags)
+	assert.Equal(t, []string{"1.2.0", "v1.2.0", "v1.9.1", "v1.10.0", "v2.0.0-rc.1", "v2.0.0", "dev", "latest"}, tags)
+}
+
+func TestSortTagsByCreated(t *testing.T) {
+	ctx := context.Background()
+	spec, host := newTestRegistry(t)
+	pushImage(t, spec, "library/app", `{"created":"2024-03-01T00:00:00Z","os":"linux"}`, "march")
+	pushImage(t, spec, "library/app", `{"created":"2024-01-01T00:00:00Z","os":"linux"}`, "january")
+	pushImage(t, spec, "library/app", `{"os":"linux"}`, "unknown")
+	pushImage(t, spec, "library/app", `{"created":"2024-02-01T00:00:00Z","os":"linux"}`, "february")
+
+	repository, err := newTestSyncer().repository(ctx, host+"/library/app")
+	require.NoError(t, err)
+
+	tags := []string{"march", "january", "unknown", "february"}
+	require.NoError(t, sortTagsByCreated(ctx, repository, tags))
+	assert.Equal(t, []string{"unknown", "january", "february", "march"}, tags)
+
+	err = sortTagsByCreated(ctx, repository, []string{"march", "missing"})
+	assert.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestTagCommands(t *testing.T) {
+	ctx := context.Background()
+	spec, host := newTestRegistry(t)
+	desc := pushImage(t, spec, "library/app", `{"created":"2024-01-01T00:00:00Z","os":"linux"}`, "v1.2.3")
+	pushImage(t, spec, "library/app", `{"created":"2024-02-01T00:00:00Z","os":"linux"}`, "dev")
+	repo := host + "/library/app"
+
+	t.Run("add", func(t *testing.T) {
+		out, err := runCommand(t, NewTagAddCommand().ToCLI(), repo+":v1.2.3", "v1.2", "latest", host+"/release/app:v1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"Tagged " + repo + ":v1.2.3 as " + repo + ":v1.2",
+			"Tagged " + repo + ":v1.2.3 as " + repo + ":latest",
+			"Tagged " + repo + ":v1.2.3 as " + host + "/release/app:v1",
+		}, out)
+		for _, target := range []struct{ repo, tag string }{
+			{"library/app", "v1.2"},
+			{"library/app", "latest"},
+			{"release/app", "v1"},
+		} {
+			got, err := spec.StatManifest(ctx, target.repo, target.tag)
+			require.NoError(t, err, target)
+			assert.Equal(t, desc.Digest, got.Digest, target)
+		}
+
+		_, err = runCommand(t, NewTagAddCommand().ToCLI(), repo+":v1.2.3", "registry.example.com/app:v1")
+		assert.ErrorIs(t, err, errdefs.ErrUnsupported)
+		_, err = runCommand(t, NewTagAddCommand().ToCLI(), repo+":missing", "v1")
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+	})
+
+	t.Run("ls", func(t *testing.T) {
+		out, err := runCommand(t, NewTagListCommand().ToCLI(), repo)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"dev", "latest", "v1.2", "v1.2.3"}, out)
+
+		out, err = runCommand(t, NewTagListCommand().ToCLI(), "--filter", `^v\d`, "--sort", TagSortSemver, "--reverse", repo)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"v1.2.3", "v1.2"}, out)
+
+		out, err = runCommand(t, NewTagListCommand().ToCLI(), "--sort", TagSortCreated, "--filter", "^(dev|latest)$", repo)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"latest", "dev"}, out)
+
+		out, err = runCommand(t, NewTagListCommand().ToCLI(), "--page-size", "1", "--offset", "dev", repo)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"latest"}, out)
+
+		_, err = runCommand(t, NewTagListCommand().ToCLI(), "--sort", "unknown", repo)
+		assert.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+	})
+
+	t.Run("rm", func(t *testing.T) {
+		_, err := runCommand(t, NewTagRemoveCommand().ToCLI(), repo+":latest", repo+":v1.2")
+		require.NoError(t, err)
+		_, err = spec.StatManifest(ctx, "library/app", "latest")
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+		// the manifest is kept for the remaining tag
+		got, err := spec.StatManifest(ctx, "library/app", "v1.2.3")
+		require.NoError(t, err)
+		assert.Equal(t, desc.Digest, got.Digest)
+
+		_, err = runCommand(t, NewTagRemoveCommand().ToCLI(), repo+":latest")
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+		out, err := runCommand(t, NewTagRemoveCommand().ToCLI(), "--force", repo+":latest")
+		require.NoError(t, err)
+		assert.Equal(t, []string{`Skip, missing "` + repo + `:latest" which is not found`}, out)
+
+		_, err = runCommand(t, NewTagRemoveCommand().ToCLI(), repo+"@"+desc.Digest.String())
+		assert.ErrorContains(t, err, "must be a tag reference")
+	})
+}
@@ -3,13 +3,21 @@
 import (
 	"context"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/urfave/cli/v3"
 
+	"github.com/wuxler/ruasec/pkg/appinfo"
 	"github.com/wuxler/ruasec/pkg/cmdhelper"
 	"github.com/wuxler/ruasec/pkg/commands/internal/options"
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/ocilayout"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/proxy"
+	distributionserver "github.com/wuxler/ruasec/pkg/ocispec/distribution/server"
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
 	"github.com/wuxler/ruasec/pkg/xlog"
 )
 
@@ -22,12 +30,23 @@ func New() *Command {
 func NewCommand() *Command {
 	return &Command{
 		ServerOptions: options.NewServerOptions(),
+		Remote:        options.NewContainerRegistry(),
+		ProxyTagTTL:   proxy.DefaultTagTTL,
 	}
 }
 
 // Command is a command to start the server.
 type Command struct {
 	ServerOptions *options.ServerOptions
+	// RegistryDir is the directory to store the repositories as OCI image layouts,
+	// and the OCI distribution API is served at "/v2/" if set.
+	RegistryDir string `json:"registry_dir,omitempty" yaml:"registry_dir,omitempty"`
+	// Proxy is the upstream registry to pull through, and the OCI distribution API
+	// is served at "/v2/" with the contents cached in ProxyCacheDir if set.
+	Proxy         string                     `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	ProxyTagTTL   time.Duration              `json:"proxy_tag_ttl,omitempty" yaml:"proxy_tag_ttl,omitempty"`
+	ProxyCacheDir string                     `json:"proxy_cache_dir,omitempty" yaml:"proxy_cache_dir,omitempty"`
+	Remote        *options.ContainerRegistry `json:"remote,omitempty" yaml:"remote,omitempty"`
 }
 
 // ToCLI transforms to a *cli.Command.
@@ -43,6 +62,14 @@ func (c *Command) ToCLI() *cli.Command {
 
 # Start the server with custom port
 $ ruasec server --port 9000
+
+# Serve a local registry storing the repositories in the directory
+$ ruasec server --registry-dir /var/lib/ruasec/registry
+$ ruasec registry copy nginx:latest 127.0.0.1:8080/library/nginx:latest
+
+# Serve a pull-through caching proxy of Docker Hub
+$ ruasec server --proxy docker.io --proxy-tag-ttl 10m
+$ docker pull 127.0.0.1:8080/library/nginx:latest
 `,
 		Flags:  c.Flags(),
 		Action: c.Run,
@@ -51,8 +78,38 @@ func (c *Command) ToCLI() *cli.Command {
 
 // Flags defines the flags related to the current command.
 func (c *Command) Flags() []cli.Flag {
-	flags := []cli.Flag{}
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "registry-dir",
+			Usage:       `serve the OCI distribution API at "/v2/" with the repositories stored as OCI image layouts in the directory`,
+			Sources:     cli.EnvVars("RUA_SERVER_REGISTRY_DIR"),
+			Destination: &c.RegistryDir,
+			Value:       c.RegistryDir,
+		},
+		&cli.StringFlag{
+			Name:        "proxy",
+			Usage:       `serve the OCI distribution API at "/v2/" as a pull-through caching proxy of the upstream registry`,
+			Sources:     cli.EnvVars("RUA_SERVER_PROXY"),
+			Destination: &c.Proxy,
+			Value:       c.Proxy,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-tag-ttl",
+			Usage:       "duration to serve the tag lookups from the cache before revalidating with the upstream registry",
+			Sources:     cli.EnvVars("RUA_SERVER_PROXY_TAG_TTL"),
+			Destination: &c.ProxyTagTTL,
+			Value:       c.ProxyTagTTL,
+		},
+		&cli.StringFlag{
+			Name:        "proxy-cache-dir",
+			Usage:       "directory to cache the manifests and blobs pulled from the upstream registry, defaults to the workspace cache",
+			Sources:     cli.EnvVars("RUA_SERVER_PROXY_CACHE_DIR"),
+			Destination: &c.ProxyCacheDir,
+			Value:       c.ProxyCacheDir,
+		},
+	}
 	flags = append(flags, c.ServerOptions.Flags()...)
+	flags = append(flags, c.Remote.Flags()...)
 	return flags
 }
 
@@ -69,6 +126,13 @@ func (c *Command) Run(ctx context.Context, cmd *cli.Command) error {
 	router.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "OK")
 	})
+	spec, err := c.newSpec(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if spec != nil {
+		router.Any("/v2/*path", gin.WrapH(distributionserver.NewHandler(spec)))
+	}
 
 	// Start the HTTP server
 	srv := &http.Server{
@@ -103,3 +167,45 @@ func (c *Command) Run(ctx context.Context, cmd *cli.Command) error {
 	xlog.C(ctx).Info("Server stopped")
 	return nil
 }
+
+// newSpec returns the distribution spec to serve at "/v2/", or nil if neither
+// the registry directory nor the proxy is set.
+func (c *Command) newSpec(ctx context.Context, cmd *cli.Command) (distribution.Spec, error) {
+	if c.RegistryDir != "" && c.Proxy != "" {
+		return nil, errdefs.Newf(errdefs.ErrInvalidParameter, "--registry-dir and --proxy are mutually exclusive")
+	}
+	if c.RegistryDir != "" {
+		spec, err := ocilayout.New(c.RegistryDir)
+		if err != nil {
+			return nil, err
+		}
+		xlog.C(ctx).Infof("Serving OCI distribution API with repositories in %s", c.RegistryDir)
+		return spec, nil
+	}
+	if c.Proxy == "" {
+		return nil, nil //nolint:nilnil // no spec to serve
+	}
+
+	target, err := name.NewRegistry(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.Remote.NewClient(cmd.Writer)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := client.NewRegistry(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	cacheDir := c.ProxyCacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(appinfo.GetWorkspace().CacheDir(), "proxy", target.Hostname())
+	}
+	cache, err := ocilayout.New(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	xlog.C(ctx).Infof("Serving OCI distribution API as proxy of %s with cache in %s", target.Hostname(), cacheDir)
+	return proxy.New(upstream, cache, proxy.WithTagTTL(c.ProxyTagTTL)), nil
+}
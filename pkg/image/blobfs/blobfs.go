@@ -0,0 +1,233 @@
+// Package blobfs provides the adapter turning the blob layers into filesystems,
+// which spools the uncompressed layer tarballs into the temporary files and
+// indexes them with [tarfs].
+package blobfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xfs"
+	"github.com/wuxler/ruasec/pkg/util/xfs/tarfs"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+var (
+	_ ocispec.BlobLayer = (*Layer)(nil)
+	_ ocispec.FSLayer   = (*Layer)(nil)
+	_ xfs.Getter        = (*Layer)(nil)
+	_ CacheHolder       = (*Layer)(nil)
+)
+
+// ErrCacheClosed is returned when using the cache already closed.
+var ErrCacheClosed = errors.New("blobfs cache is closed")
+
+// NewCache returns a cache holding the spooled layer tarballs in a new temporary
+// directory under cacheDir, default to [os.TempDir] when cacheDir is empty.
+//
+// The returned cache is referenced once by its owner, and it must be closed when
+// processing is finished.
+func NewCache(cacheDir string) *Cache {
+	return &Cache{
+		temper:  xos.NewTemper(cacheDir, "blobfs-*"),
+		refs:    1,
+		entries: map[digest.Digest]*entry{},
+	}
+}
+
+// Cache holds the filesystems of the blob layers, which are spooled and indexed
+// only once for the layers with the same DiffID.
+//
+// The cache is owned by the image and shared by the layers returned by it. The
+// cache is reference-counted, the holders using the filesystems after the image
+// is closed, like the squashed filesystem built over the layers, should call
+// [Cache.Acquire] to hold a reference. The spooled files are removed when the
+// last reference is released, after which [ErrCacheClosed] is returned.
+type Cache struct {
+	mu      sync.Mutex
+	temper  xos.Temper
+	refs    int
+	closed  bool
+	entries map[digest.Digest]*entry
+	files   []*os.File
+}
+
+// entry is the filesystem of the layers with the same DiffID, which is loaded
+// once succeeded. The failed loading is not remembered and retried by the next
+// caller with its own context.
+type entry struct {
+	mu   sync.Mutex
+	fsys fs.FS
+}
+
+// CacheHolder is implemented by the layers sharing the cache of the image.
+type CacheHolder interface {
+	// AcquireCache holds a new reference of the shared cache, see [Cache.Acquire].
+	AcquireCache() (io.Closer, error)
+}
+
+// Acquire holds a new reference of the cache, which keeps the spooled files
+// available until the returned closer is closed, even if the owner has closed
+// the cache.
+func (c *Cache) Acquire() (io.Closer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refs <= 0 {
+		return nil, ErrCacheClosed
+	}
+	c.refs++
+	return &reference{cache: c}, nil
+}
+
+// Close releases the reference of the owner. It is safe to be called multiple
+// times.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.release()
+}
+
+// release releases a reference, and removes all the spooled files when no
+// reference is held. It must be called with the lock held.
+func (c *Cache) release() error {
+	if c.refs <= 0 {
+		return nil
+	}
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	var errs []error
+	for _, file := range c.files {
+		errs = append(errs, file.Close())
+	}
+	c.entries, c.files = nil, nil
+	errs = append(errs, c.temper.Cleanup())
+	return errors.Join(errs...)
+}
+
+// reference is the reference of the cache held by [Cache.Acquire].
+type reference struct {
+	cache *Cache
+	once  sync.Once
+}
+
+// Close releases the reference. It is safe to be called multiple times.
+func (r *reference) Close() (err error) {
+	r.once.Do(func() {
+		r.cache.mu.Lock()
+		defer r.cache.mu.Unlock()
+		err = r.cache.release()
+	})
+	return err
+}
+
+// GetFS returns the filesystem of the uncompressed layer tarball.
+func (c *Cache) GetFS(ctx context.Context, layer ocispec.Uncompressor) (fs.FS, error) {
+	ent, err := c.entry(layer)
+	if err != nil {
+		return nil, err
+	}
+	ent.mu.Lock()
+	defer ent.mu.Unlock()
+	if ent.fsys != nil {
+		return ent.fsys, nil
+	}
+	fsys, err := c.load(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+	ent.fsys = fsys
+	return fsys, nil
+}
+
+func (c *Cache) entry(layer ocispec.Uncompressor) (*entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refs <= 0 {
+		return nil, ErrCacheClosed
+	}
+	var key digest.Digest
+	if l, ok := layer.(ocispec.Layer); ok {
+		key = l.Metadata().DiffID
+	}
+	if key == "" {
+		// unable to share the layer without DiffID
+		return &entry{}, nil
+	}
+	ent, ok := c.entries[key]
+	if !ok {
+		ent = &entry{}
+		c.entries[key] = ent
+	}
+	return ent, nil
+}
+
+func (c *Cache) load(ctx context.Context, layer ocispec.Uncompressor) (fs.FS, error) {
+	rc, err := layer.Uncompressed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	file, err := c.createTemp()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(file, rc); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return tarfs.New(ctx, file)
+}
+
+func (c *Cache) createTemp() (*os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refs <= 0 {
+		return nil, ErrCacheClosed
+	}
+	file, err := c.temper.CreateTemp("layer-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	c.files = append(c.files, file)
+	return file, nil
+}
+
+// NewLayer returns the layer with the filesystem adapted from the blob by cache.
+func NewLayer(layer ocispec.BlobLayer, cache *Cache) *Layer {
+	return &Layer{BlobLayer: layer, cache: cache}
+}
+
+// Layer is the blob layer implementing [xfs.Getter] as well.
+type Layer struct {
+	ocispec.BlobLayer
+	cache *Cache
+}
+
+// GetFS returns the filesystem of the uncompressed layer tarball.
+// Implements the [xfs.Getter] interface.
+func (layer *Layer) GetFS(ctx context.Context) (fs.FS, error) {
+	return layer.cache.GetFS(ctx, layer.BlobLayer)
+}
+
+// AcquireCache holds a new reference of the cache shared by the layer.
+// Implements the [CacheHolder] interface.
+func (layer *Layer) AcquireCache() (io.Closer, error) {
+	return layer.cache.Acquire()
+}
@@ -0,0 +1,176 @@
+package blobfs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+)
+
+// countedLayer is the blob layer counting the uncompressed streams opened.
+type countedLayer struct {
+	data   []byte
+	diffID digest.Digest
+	opened atomic.Int32
+	err    error
+}
+
+func newCountedLayer(t *testing.T, diffID digest.Digest) *countedLayer {
+	t.Helper()
+	data := buildTar(t, tarEntry{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "hello.txt", Mode: 0o644}, content: "hello"})
+	return &countedLayer{data: data, diffID: diffID}
+}
+
+func (l *countedLayer) Metadata() ocispec.LayerMetadata {
+	return ocispec.LayerMetadata{DiffID: l.diffID}
+}
+
+func (l *countedLayer) Uncompressed(_ context.Context) (io.ReadCloser, error) {
+	l.opened.Add(1)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return io.NopCloser(bytes.NewReader(l.data)), nil
+}
+
+func spooledFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestCache_GetFS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache := blobfs.NewCache(dir)
+	defer cache.Close()
+
+	diffID := digest.FromString("layer")
+	first := newCountedLayer(t, diffID)
+	second := newCountedLayer(t, diffID)
+	var wg sync.WaitGroup
+	for _, layer := range []*countedLayer{first, second, first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fsys, err := cache.GetFS(ctx, layer)
+			assert.NoError(t, err)
+			content, err := fs.ReadFile(fsys, "hello.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(content))
+		}()
+	}
+	wg.Wait()
+	// spooled once for the layers with the same DiffID
+	assert.Equal(t, int32(1), first.opened.Load()+second.opened.Load())
+	assert.Len(t, spooledFiles(t, dir), 1)
+
+	other := newCountedLayer(t, digest.FromString("other"))
+	_, err := cache.GetFS(ctx, other)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), other.opened.Load())
+	assert.Len(t, spooledFiles(t, dir), 2)
+
+	// the layers without DiffID are never shared
+	anonymous := newCountedLayer(t, "")
+	_, err = cache.GetFS(ctx, anonymous)
+	require.NoError(t, err)
+	_, err = cache.GetFS(ctx, anonymous)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), anonymous.opened.Load())
+}
+
+func TestCache_GetFSRetry(t *testing.T) {
+	ctx := context.Background()
+	cache := blobfs.NewCache(t.TempDir())
+	defer cache.Close()
+
+	diffID := digest.FromString("layer")
+	failed := newCountedLayer(t, diffID)
+	failed.err = context.Canceled
+	_, err := cache.GetFS(ctx, failed)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the failure is not remembered for the layers with the same DiffID
+	layer := newCountedLayer(t, diffID)
+	fsys, err := cache.GetFS(ctx, layer)
+	require.NoError(t, err)
+	content, err := fs.ReadFile(fsys, "hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	_, err = cache.GetFS(ctx, failed)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), failed.opened.Load())
+	assert.Equal(t, int32(1), layer.opened.Load())
+}
+
+func TestCache_Close(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache := blobfs.NewCache(dir)
+	layer := newCountedLayer(t, digest.FromString("layer"))
+	_, err := cache.GetFS(ctx, layer)
+	require.NoError(t, err)
+	require.NotEmpty(t, spooledFiles(t, dir))
+
+	require.NoError(t, cache.Close())
+	assert.Empty(t, spooledFiles(t, dir))
+	require.NoError(t, cache.Close())
+
+	_, err = cache.GetFS(ctx, layer)
+	assert.ErrorIs(t, err, blobfs.ErrCacheClosed)
+	assert.Equal(t, int32(1), layer.opened.Load())
+}
+
+func TestCache_Acquire(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache := blobfs.NewCache(dir)
+	layer := newCountedLayer(t, digest.FromString("layer"))
+	_, err := cache.GetFS(ctx, layer)
+	require.NoError(t, err)
+
+	ref, err := cache.Acquire()
+	require.NoError(t, err)
+	// the spooled files are kept for the holder after the owner closes the cache
+	require.NoError(t, cache.Close())
+	require.NoError(t, cache.Close())
+	assert.Len(t, spooledFiles(t, dir), 1)
+	fsys, err := cache.GetFS(ctx, layer)
+	require.NoError(t, err)
+	content, err := fs.ReadFile(fsys, "hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	// the spooled files are removed when the last reference is released
+	require.NoError(t, ref.Close())
+	require.NoError(t, ref.Close())
+	assert.Empty(t, spooledFiles(t, dir))
+	_, err = cache.GetFS(ctx, layer)
+	assert.ErrorIs(t, err, blobfs.ErrCacheClosed)
+	_, err = cache.Acquire()
+	assert.ErrorIs(t, err, blobfs.ErrCacheClosed)
+}
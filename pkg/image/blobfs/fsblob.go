@@ -0,0 +1,181 @@
+package blobfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xcontext"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression/gzip"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+var (
+	_ ocispec.Compressor   = (*FSBlob)(nil)
+	_ ocispec.Uncompressor = (*FSBlob)(nil)
+)
+
+// ErrDiffIDMismatch is returned when the layer tarball reproduced from the
+// filesystem does not match the DiffID of the layer.
+var ErrDiffIDMismatch = errors.New("diffid of the reproduced layer tarball mismatched")
+
+type diffIDMismatch bool
+
+// WithDiffIDMismatchAllowed injects the signal to tell [FSBlob] to accept the
+// layer tarball reproduced with a different DiffID, which changes the image ID
+// when the image is written with the new DiffIDs.
+func WithDiffIDMismatchAllowed(ctx context.Context) context.Context {
+	return xcontext.WithValue(ctx, diffIDMismatch(true))
+}
+
+// IsDiffIDMismatchAllowed checks whether the layer tarball reproduced with a
+// different DiffID is accepted by the context.
+func IsDiffIDMismatchAllowed(ctx context.Context) bool {
+	value, ok := xcontext.GetValue[diffIDMismatch](ctx)
+	return ok && bool(value)
+}
+
+// NewFSBlob returns the blob adapter of the filesystem layer, which reassembles
+// the original layer tarball with [WriteTarSplit] when the layer implements
+// [TarSplitter], or produces the layer tarball with [WriteTar] otherwise.
+func NewFSBlob(layer ocispec.FSLayer) *FSBlob {
+	return &FSBlob{layer: layer}
+}
+
+// FSBlob produces the layer tarball streams of the filesystem layer, and the
+// DiffID is computed on the fly when the stream is read.
+//
+// The stream fails with [ErrDiffIDMismatch] at the end when the DiffID does not
+// match the one of the layer, unless allowed by [WithDiffIDMismatchAllowed].
+type FSBlob struct {
+	layer ocispec.FSLayer
+
+	mu     sync.Mutex
+	diffID digest.Digest
+}
+
+// Uncompressed returns a reader of the layer tarball.
+// The reader must be closed when reading is finished.
+func (b *FSBlob) Uncompressed(ctx context.Context) (io.ReadCloser, error) {
+	fsys, err := b.layer.GetFS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		diffID, err := b.writeTar(ctx, pw, fsys)
+		if err == nil {
+			err = b.setDiffID(ctx, diffID)
+		}
+		pw.CloseWithError(err) //nolint:errcheck // always returns nil
+	}()
+	return pr, nil
+}
+
+// Compressed returns a reader of the layer tarball compressed with gzip.
+// The reader must be closed when reading is finished.
+func (b *FSBlob) Compressed(ctx context.Context) (io.ReadCloser, error) {
+	rc, err := b.Uncompressed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	format, err := compression.GetFormat(gzip.FormatName)
+	if err != nil {
+		xio.CloseAndSkipError(rc)
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	compressor, err := format.Compress(pw)
+	if err != nil {
+		xio.CloseAndSkipError(xio.MultiClosers(pw, pr, rc))
+		return nil, err
+	}
+
+	// goroutine returns err so we can pw.CloseWithError(err)
+	go func() error {
+		defer xio.CloseAndSkipError(rc)
+		if _, err := io.Copy(compressor, rc); err != nil {
+			defer xio.CloseAndSkipError(compressor)
+			return pw.CloseWithError(err)
+		}
+		// close compressor writer to flush it and write trailers
+		if err := compressor.Close(); err != nil {
+			return pw.CloseWithError(err)
+		}
+		return pw.Close()
+	}() //nolint:errcheck // we don't care about the error here
+
+	return pr, nil
+}
+
+// DiffID returns the digest of the layer tarball. The tarball is produced and
+// discarded to compute it if no stream has been read completely.
+func (b *FSBlob) DiffID(ctx context.Context) (digest.Digest, error) {
+	b.mu.Lock()
+	diffID := b.diffID
+	b.mu.Unlock()
+	if diffID != "" {
+		return diffID, nil
+	}
+	fsys, err := b.layer.GetFS(ctx)
+	if err != nil {
+		return "", err
+	}
+	diffID, err = b.writeTar(ctx, io.Discard, fsys)
+	if err != nil {
+		return "", err
+	}
+	if err := b.setDiffID(ctx, diffID); err != nil {
+		return "", err
+	}
+	return diffID, nil
+}
+
+// writeTar reassembles the original layer tarball from the tar-split metadata
+// if present, or produces the layer tarball from the filesystem otherwise.
+func (b *FSBlob) writeTar(ctx context.Context, w io.Writer, fsys fs.FS) (digest.Digest, error) {
+	splitter, ok := b.layer.(TarSplitter)
+	if !ok {
+		return WriteTar(ctx, w, fsys)
+	}
+	rc, err := splitter.TarSplit(ctx)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			xlog.C(ctx).Debugf("tar-split metadata of layer %s not found, reproduce the layer tarball from the filesystem", b.layer.Metadata().DiffID)
+			return WriteTar(ctx, w, fsys)
+		}
+		return "", err
+	}
+	defer xio.CloseAndSkipError(rc)
+	format, err := compression.GetFormat(gzip.FormatName)
+	if err != nil {
+		return "", err
+	}
+	zr, err := format.Uncompress(rc)
+	if err != nil {
+		return "", fmt.Errorf("unable to decompress tar-split metadata: %w", err)
+	}
+	defer xio.CloseAndSkipError(zr)
+	return WriteTarSplit(ctx, w, zr, fsys)
+}
+
+func (b *FSBlob) setDiffID(ctx context.Context, diffID digest.Digest) error {
+	if want := b.layer.Metadata().DiffID; want != "" && want != diffID {
+		if !IsDiffIDMismatchAllowed(ctx) {
+			return fmt.Errorf("%w: %s != %s, the original layer tarball can not be reproduced exactly from the filesystem", ErrDiffIDMismatch, diffID, want)
+		}
+		xlog.C(ctx).Warnf("diffid of the reproduced layer tarball mismatched: %s != %s", diffID, want)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.diffID = diffID
+	return nil
+}
@@ -0,0 +1,174 @@
+package blobfs
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	stdpath "path"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/util/xcontext"
+	"github.com/wuxler/ruasec/pkg/util/xfile"
+	"github.com/wuxler/ruasec/pkg/util/xfs"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+// paxSchilyXattr is the prefix of the PAX records holding the extended attributes.
+const paxSchilyXattr = "SCHILY.xattr."
+
+// WriteTar writes the filesystem to w as a deterministic OCI layer tarball, and
+// returns the DiffID which is the digest of the tarball.
+//
+// The entries are written in lexical order, and the owner, mode, modification
+// time in seconds and extended attributes are preserved while the access and
+// change times, user and group names are dropped. The overlay whiteout character
+// devices are written as the ".wh." prefixed whiteout files, and the files with
+// the same inode are written as hardlinks to the first one.
+func WriteTar(ctx context.Context, w io.Writer, fsys fs.FS) (digest.Digest, error) {
+	digester := digest.Canonical.Digester()
+	tw := tar.NewWriter(io.MultiWriter(w, digester.Hash()))
+	links := map[inodeKey]string{}
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := xcontext.NonBlockingCheck(ctx, path); err != nil {
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tarHeader(fsys, path, info, links)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			return nil
+		}
+		file, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer xio.CloseAndSkipError(file)
+		_, err = io.CopyN(tw, file, hdr.Size)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+func tarHeader(fsys fs.FS, path string, info fs.FileInfo, links map[inodeKey]string) (*tar.Header, error) {
+	if isWhiteoutDevice(info) {
+		return &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     stdpath.Join(stdpath.Dir(path), xfile.WhiteoutPrefix+stdpath.Base(path)),
+			Format:   tar.FormatPAX,
+		}, nil
+	}
+
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := readLink(fsys, path, info)
+		if err != nil {
+			return nil, err
+		}
+		link = target
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create tar header of %s: %w", path, err)
+	}
+	hdr.Name = path
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Format = tar.FormatPAX
+	hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	// the names are looked up from the current host, which are not the content
+	// of the layer
+	if _, isTar := info.Sys().(*tar.Header); !isTar {
+		hdr.Uname, hdr.Gname = "", ""
+	}
+
+	xattrs, err := xfs.Xattrs(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Xattrs = nil //nolint:staticcheck // the deprecated field is copied from the tar header
+	hdr.PAXRecords = nil
+	for key, value := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[paxSchilyXattr+key] = value
+	}
+
+	if hdr.Typeflag == tar.TypeLink {
+		// the hardlink read from the tarball
+		return hdr, nil
+	}
+	if key, ok := hardlinkKey(info); ok && hdr.Typeflag == tar.TypeReg {
+		if target, seen := links[key]; seen {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = target
+			hdr.Size = 0
+		} else {
+			links[key] = path
+		}
+	}
+	return hdr, nil
+}
+
+func readLink(fsys fs.FS, path string, info fs.FileInfo) (string, error) {
+	if hdr, ok := info.Sys().(*tar.Header); ok {
+		return hdr.Linkname, nil
+	}
+	if rlfs, ok := fsys.(xfs.ReadLinkFS); ok {
+		return rlfs.ReadLink(path)
+	}
+	return "", errdefs.Newf(errdefs.ErrUnsupported, "read symbolic link %s from %T", path, fsys)
+}
+
+// isWhiteoutDevice returns true if the file is the overlay whiteout, which is
+// a character device with 0/0 device number.
+func isWhiteoutDevice(info fs.FileInfo) bool {
+	if info.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// hardlinkKey returns the inode key of the file with multiple hardlinks.
+func hardlinkKey(info fs.FileInfo) (inodeKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink <= 1 {
+		return inodeKey{}, false
+	}
+	return inodeKey{dev: uint64(stat.Dev), ino: stat.Ino}, true //nolint:unconvert // the type of Dev varies between platforms
+}
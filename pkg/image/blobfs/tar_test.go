@@ -0,0 +1,189 @@
+package blobfs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers/overlay2"
+	"github.com/wuxler/ruasec/pkg/util/xfs/tarfs"
+)
+
+type tarEntry struct {
+	hdr     *tar.Header
+	content string
+}
+
+// buildTar writes the entries in order as a tarball.
+func buildTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		hdr := *entry.hdr
+		hdr.Size = int64(len(entry.content))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// readTar returns the headers and the contents of the regular files in order.
+func readTar(t *testing.T, data []byte) ([]*tar.Header, map[string]string) {
+	t.Helper()
+	var headers []*tar.Header
+	contents := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		headers = append(headers, hdr)
+		if hdr.Typeflag == tar.TypeReg {
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			contents[hdr.Name] = string(content)
+		}
+	}
+	return headers, contents
+}
+
+func headerNames(headers []*tar.Header) []string {
+	names := make([]string, 0, len(headers))
+	for _, hdr := range headers {
+		names = append(names, hdr.Name)
+	}
+	return names
+}
+
+func TestWriteTar_Deterministic(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	data := buildTar(t,
+		tarEntry{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: "usr/", Mode: 0o755, ModTime: mtime}},
+		tarEntry{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "usr/b", Mode: 0o644, ModTime: mtime, Uid: 1000, Gid: 1000}, content: "bbb"},
+		tarEntry{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "usr/a", Mode: 0o600, ModTime: mtime}, content: "aa"},
+		tarEntry{hdr: &tar.Header{Typeflag: tar.TypeSymlink, Name: "usr/link", Linkname: "a", ModTime: mtime}},
+		tarEntry{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0o755, ModTime: mtime}},
+	)
+	fsys, err := tarfs.New(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	first := &bytes.Buffer{}
+	firstID, err := blobfs.WriteTar(ctx, first, fsys)
+	require.NoError(t, err)
+	second := &bytes.Buffer{}
+	secondID, err := blobfs.WriteTar(ctx, second, fsys)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.Bytes(), second.Bytes())
+	assert.Equal(t, firstID, secondID)
+	assert.Equal(t, digest.FromBytes(first.Bytes()), firstID)
+
+	headers, contents := readTar(t, first.Bytes())
+	assert.Equal(t, []string{"etc/", "usr/", "usr/a", "usr/b", "usr/link"}, headerNames(headers))
+	assert.Equal(t, map[string]string{"usr/a": "aa", "usr/b": "bbb"}, contents)
+	for _, hdr := range headers {
+		assert.True(t, mtime.Truncate(time.Second).Equal(hdr.ModTime), hdr.Name)
+	}
+	assert.Equal(t, 1000, headers[3].Uid)
+	assert.Equal(t, "a", headers[4].Linkname)
+}
+
+func TestWriteTar_Xattrs(t *testing.T) {
+	ctx := context.Background()
+	data := buildTar(t,
+		tarEntry{hdr: &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       "bin/ping",
+			Mode:       0o755,
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{"SCHILY.xattr.security.capability": "\x01\x00\x00\x02"},
+		}, content: "ping"},
+	)
+	fsys, err := tarfs.New(ctx, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	_, err = blobfs.WriteTar(ctx, out, fsys)
+	require.NoError(t, err)
+	headers, _ := readTar(t, out.Bytes())
+	require.Len(t, headers, 2)
+	assert.Equal(t, "bin/ping", headers[1].Name)
+	assert.Equal(t, "\x01\x00\x00\x02", headers[1].PAXRecords["SCHILY.xattr.security.capability"])
+}
+
+func TestWriteTar_Hardlinks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("content"), 0o600))
+	require.NoError(t, os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "b")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c"), []byte("content"), 0o600))
+
+	out := &bytes.Buffer{}
+	_, err := blobfs.WriteTar(ctx, out, os.DirFS(dir))
+	require.NoError(t, err)
+	headers, contents := readTar(t, out.Bytes())
+	require.Equal(t, []string{"a", "b", "c"}, headerNames(headers))
+
+	assert.Equal(t, byte(tar.TypeReg), headers[0].Typeflag)
+	assert.Equal(t, byte(tar.TypeLink), headers[1].Typeflag)
+	assert.Equal(t, "a", headers[1].Linkname)
+	assert.Zero(t, headers[1].Size)
+	// the same content with a different inode is not a hardlink
+	assert.Equal(t, byte(tar.TypeReg), headers[2].Typeflag)
+	assert.Equal(t, map[string]string{"a": "content", "c": "content"}, contents)
+}
+
+func TestWriteTar_OverlayWhiteouts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := syscall.Mknod(filepath.Join(dir, "removed"), syscall.S_IFCHR|0o600, 0); err != nil {
+		t.Skipf("unable to create the whiteout character device: %s", err)
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "opaque"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "opaque", "kept"), []byte("kept"), 0o600))
+	if err := unix.Lsetxattr(filepath.Join(dir, "opaque"), "user.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("unable to set the opaque extended attribute: %s", err)
+	}
+
+	t.Run("diff filesystem", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		_, err := blobfs.WriteTar(ctx, out, overlay2.NewDiffFS(ctx, dir))
+		require.NoError(t, err)
+		headers, contents := readTar(t, out.Bytes())
+		assert.Equal(t, []string{".wh.removed", "opaque/", "opaque/.wh..wh..opq", "opaque/kept"}, headerNames(headers))
+		assert.Equal(t, map[string]string{".wh.removed": "", "opaque/.wh..wh..opq": "", "opaque/kept": "kept"}, contents)
+		// the overlay private extended attributes are not the content
+		assert.NotContains(t, headers[1].PAXRecords, "SCHILY.xattr.user.overlay.opaque")
+	})
+
+	t.Run("raw directory", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		_, err := blobfs.WriteTar(ctx, out, os.DirFS(dir))
+		require.NoError(t, err)
+		// the character device is converted while the opaque directory is
+		// kept as is without the overlay private knowledge
+		headers, contents := readTar(t, out.Bytes())
+		assert.Equal(t, []string{"opaque/", "opaque/kept", ".wh.removed"}, headerNames(headers))
+		assert.Equal(t, byte(tar.TypeReg), headers[2].Typeflag)
+		assert.Equal(t, map[string]string{".wh.removed": "", "opaque/kept": "kept"}, contents)
+	})
+}
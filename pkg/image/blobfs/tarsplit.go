@@ -0,0 +1,123 @@
+package blobfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	stdpath "path"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/wuxler/ruasec/pkg/util/xcontext"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+// TarSplitter is implemented by the filesystem layers keeping the tar-split
+// metadata of the original layer tarball, like the layers of docker and the
+// containers storage, which records the raw tar headers and paddings and refers
+// to the file contents by names.
+//
+// See https://github.com/vbatts/tar-split
+type TarSplitter interface {
+	// TarSplit returns a reader of the gzip compressed tar-split metadata, an
+	// error matched [fs.ErrNotExist] is returned when not found.
+	// The reader must be closed when reading is finished.
+	TarSplit(ctx context.Context) (io.ReadCloser, error)
+}
+
+// tarSplitEntry is the entry of the tar-split metadata, which is a stream of
+// JSON objects.
+type tarSplitEntry struct {
+	Type     tarSplitEntryType `json:"type"`
+	Name     string            `json:"name,omitempty"`
+	NameRaw  []byte            `json:"name_raw,omitempty"`
+	Size     int64             `json:"size,omitempty"`
+	Payload  []byte            `json:"payload"`
+	Position int               `json:"position"`
+}
+
+type tarSplitEntryType int
+
+const (
+	// tarSplitFileType is the entry of the file content, whose payload is the
+	// CRC-64 checksum of the content.
+	tarSplitFileType tarSplitEntryType = 1 + iota
+	// tarSplitSegmentType is the entry of the raw bytes, like tar headers and
+	// paddings.
+	tarSplitSegmentType
+)
+
+func (e *tarSplitEntry) name() string {
+	if len(e.NameRaw) > 0 {
+		return string(e.NameRaw)
+	}
+	return e.Name
+}
+
+var crc64Table = crc64.MakeTable(crc64.ISO)
+
+// WriteTarSplit reassembles the original layer tarball to w from the tar-split
+// metadata and the file contents in fsys, and returns the DiffID which is the
+// digest of the tarball. The metadata must be uncompressed.
+//
+// The reassembled tarball is byte-to-byte identical to the original one as long
+// as the file contents are not changed, which is verified with the checksums
+// recorded in the metadata.
+func WriteTarSplit(ctx context.Context, w io.Writer, metadata io.Reader, fsys fs.FS) (digest.Digest, error) {
+	digester := digest.Canonical.Digester()
+	mw := io.MultiWriter(w, digester.Hash())
+	decoder := json.NewDecoder(metadata)
+	for {
+		var entry tarSplitEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", fmt.Errorf("unable to decode tar-split entry: %w", err)
+		}
+		switch entry.Type {
+		case tarSplitSegmentType:
+			if _, err := mw.Write(entry.Payload); err != nil {
+				return "", err
+			}
+		case tarSplitFileType:
+			if err := xcontext.NonBlockingCheck(ctx, entry.name()); err != nil {
+				return "", err
+			}
+			if entry.Size == 0 {
+				continue
+			}
+			if err := copyTarSplitFile(mw, fsys, &entry); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("unknown tar-split entry type %d at position %d", entry.Type, entry.Position)
+		}
+	}
+	return digester.Digest(), nil
+}
+
+func copyTarSplitFile(w io.Writer, fsys fs.FS, entry *tarSplitEntry) error {
+	name := entry.name()
+	file, err := fsys.Open(strings.TrimPrefix(stdpath.Clean("/"+name), "/"))
+	if err != nil {
+		return fmt.Errorf("unable to open %s recorded in tar-split: %w", name, err)
+	}
+	defer xio.CloseAndSkipError(file)
+
+	hash := crc64.New(crc64Table)
+	n, err := io.CopyN(io.MultiWriter(w, hash), file, entry.Size)
+	if err != nil {
+		return fmt.Errorf("unable to copy %s recorded in tar-split (%d/%d bytes): %w", name, n, entry.Size, err)
+	}
+	if len(entry.Payload) > 0 && !bytes.Equal(hash.Sum(nil), entry.Payload) {
+		return fmt.Errorf("%w: checksum of %s recorded in tar-split mismatched", ErrDiffIDMismatch, name)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package blobfs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression/gzip"
+)
+
+// buildTarSplit writes the entries as a tarball in the way not reproducible by
+// WriteTar, and returns the tarball and its tar-split metadata.
+func buildTarSplit(t *testing.T, entries ...tarEntry) ([]byte, []byte) {
+	t.Helper()
+	data := &bytes.Buffer{}
+	metadata := &bytes.Buffer{}
+	encoder := json.NewEncoder(metadata)
+	position := 0
+	encode := func(v map[string]any) {
+		v["position"] = position
+		position++
+		require.NoError(t, encoder.Encode(v))
+	}
+
+	tw := tar.NewWriter(data)
+	offset := 0
+	for _, entry := range entries {
+		hdr := *entry.hdr
+		hdr.Size = int64(len(entry.content))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		encode(map[string]any{"type": 2, "payload": data.Bytes()[offset:]})
+		offset = data.Len()
+
+		_, err := tw.Write([]byte(entry.content))
+		require.NoError(t, err)
+		var checksum []byte
+		if hdr.Size > 0 {
+			checksum = binary.BigEndian.AppendUint64(nil, crc64.Checksum([]byte(entry.content), crc64.MakeTable(crc64.ISO)))
+		}
+		encode(map[string]any{"type": 1, "name": hdr.Name, "size": hdr.Size, "payload": checksum})
+		offset = data.Len()
+	}
+	require.NoError(t, tw.Close())
+	// the trailing garbage is kept in the original tarball
+	data.WriteString("trailing")
+	encode(map[string]any{"type": 2, "payload": data.Bytes()[offset:]})
+	return data.Bytes(), metadata.Bytes()
+}
+
+func TestWriteTarSplit(t *testing.T) {
+	ctx := context.Background()
+	entries := []tarEntry{
+		{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: "./usr/", Mode: 0o755, Uname: "root", Format: tar.FormatGNU}},
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "./usr/b", Mode: 0o644, Uname: "root", Format: tar.FormatGNU}, content: "bbb"},
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "./usr/a", Mode: 0o644, Uname: "root", Format: tar.FormatGNU}, content: "aa"},
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "./empty", Mode: 0o644, Format: tar.FormatGNU}},
+	}
+	data, metadata := buildTarSplit(t, entries...)
+	fsys := fstest.MapFS{
+		"usr":     &fstest.MapFile{Mode: 0o755 | fs.ModeDir},
+		"usr/a":   &fstest.MapFile{Data: []byte("aa")},
+		"usr/b":   &fstest.MapFile{Data: []byte("bbb")},
+		"empty":   &fstest.MapFile{},
+		"ignored": &fstest.MapFile{Data: []byte("not recorded")},
+	}
+
+	t.Run("reassembled", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		diffID, err := blobfs.WriteTarSplit(ctx, out, bytes.NewReader(metadata), fsys)
+		require.NoError(t, err)
+		assert.Equal(t, data, out.Bytes())
+		assert.Equal(t, digest.FromBytes(data), diffID)
+	})
+
+	t.Run("content changed", func(t *testing.T) {
+		changed := fstest.MapFS{}
+		for name, file := range fsys {
+			changed[name] = file
+		}
+		changed["usr/a"] = &fstest.MapFile{Data: []byte("zz")}
+		_, err := blobfs.WriteTarSplit(ctx, io.Discard, bytes.NewReader(metadata), changed)
+		assert.ErrorIs(t, err, blobfs.ErrDiffIDMismatch)
+	})
+
+	t.Run("file missing", func(t *testing.T) {
+		missing := fstest.MapFS{"usr/b": fsys["usr/b"]}
+		_, err := blobfs.WriteTarSplit(ctx, io.Discard, bytes.NewReader(metadata), missing)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
+
+// tarSplitLayer is a filesystem layer with the tar-split metadata.
+type tarSplitLayer struct {
+	fsys     fs.FS
+	metadata []byte
+	diffID   digest.Digest
+}
+
+func (l *tarSplitLayer) Metadata() ocispec.LayerMetadata {
+	return ocispec.LayerMetadata{DiffID: l.diffID}
+}
+
+func (l *tarSplitLayer) GetFS(_ context.Context) (fs.FS, error) {
+	return l.fsys, nil
+}
+
+func (l *tarSplitLayer) TarSplit(_ context.Context) (io.ReadCloser, error) {
+	if l.metadata == nil {
+		return nil, fs.ErrNotExist
+	}
+	format, err := compression.GetFormat(gzip.FormatName)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	w, err := format.Compress(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(l.metadata); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(buf), nil
+}
+
+func TestFSBlob_DiffID(t *testing.T) {
+	data, metadata := buildTarSplit(t,
+		tarEntry{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "./a", Mode: 0o644, Uname: "root", Format: tar.FormatGNU}, content: "aa"},
+	)
+	fsys := fstest.MapFS{"a": &fstest.MapFile{Data: []byte("aa"), Mode: 0o644}}
+
+	t.Run("reassembled from tar-split", func(t *testing.T) {
+		ctx := context.Background()
+		blob := blobfs.NewFSBlob(&tarSplitLayer{fsys: fsys, metadata: metadata, diffID: digest.FromBytes(data)})
+		rc, err := blob.Uncompressed(ctx)
+		require.NoError(t, err)
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, data, got)
+
+		diffID, err := blob.DiffID(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, digest.FromBytes(data), diffID)
+	})
+
+	t.Run("mismatched without tar-split", func(t *testing.T) {
+		ctx := context.Background()
+		blob := blobfs.NewFSBlob(&tarSplitLayer{fsys: fsys, diffID: digest.FromBytes(data)})
+		rc, err := blob.Uncompressed(ctx)
+		require.NoError(t, err)
+		defer rc.Close()
+		_, err = io.ReadAll(rc)
+		require.ErrorIs(t, err, blobfs.ErrDiffIDMismatch)
+
+		_, err = blob.DiffID(ctx)
+		require.ErrorIs(t, err, blobfs.ErrDiffIDMismatch)
+	})
+
+	t.Run("mismatch allowed", func(t *testing.T) {
+		ctx := blobfs.WithDiffIDMismatchAllowed(context.Background())
+		blob := blobfs.NewFSBlob(&tarSplitLayer{fsys: fsys, diffID: digest.FromBytes(data)})
+		want := &bytes.Buffer{}
+		wantID, err := blobfs.WriteTar(ctx, want, fsys)
+		require.NoError(t, err)
+
+		rc, err := blob.Uncompressed(ctx)
+		require.NoError(t, err)
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		assert.Equal(t, want.Bytes(), got)
+
+		diffID, err := blob.DiffID(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, wantID, diffID)
+		assert.NotEqual(t, digest.FromBytes(data), diffID)
+	})
+}
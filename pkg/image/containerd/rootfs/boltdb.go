@@ -0,0 +1,73 @@
+package rootfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"go.etcd.io/bbolt"
+	bolterrors "go.etcd.io/bbolt/errors"
+
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+const (
+	boltOpenTimeout = time.Second
+	boltFileMode    = 0o400
+)
+
+// viewBoltDB opens the bolt database file in read-only mode and calls fn within
+// a read-only transaction.
+//
+// NOTE: The running containerd daemon holds the exclusive file lock of the
+// database, so the database file is copied to the cacheDir and opened there when
+// the shared lock can not be obtained in time.
+func viewBoltDB(ctx context.Context, path string, cacheDir string, fn func(tx *bbolt.Tx) error) error {
+	db, err := openBoltDB(path)
+	if errors.Is(err, bolterrors.ErrTimeout) {
+		xlog.C(ctx).Debugf("bolt database %s is locked, copy it to %s and retry", path, cacheDir)
+		copied, cerr := copyToTemp(path, cacheDir)
+		if cerr != nil {
+			return errors.Join(err, cerr)
+		}
+		defer os.Remove(copied) //nolint:errcheck // ignore error
+		db, err = openBoltDB(copied)
+	}
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(db)
+
+	return db.View(fn)
+}
+
+func openBoltDB(path string) (*bbolt.DB, error) {
+	return bbolt.Open(path, boltFileMode, &bbolt.Options{
+		ReadOnly: true,
+		Timeout:  boltOpenTimeout,
+	})
+}
+
+func copyToTemp(path string, cacheDir string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer xio.CloseAndSkipError(src)
+
+	dst, err := xos.NewTemper(cacheDir).CreateTemp("containerd-bolt-*.db")
+	if err != nil {
+		return "", err
+	}
+	defer xio.CloseAndSkipError(dst)
+
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
@@ -0,0 +1,71 @@
+package rootfs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	// DefaultRoot is the default directory the containerd data is stored in.
+	DefaultRoot = "/var/lib/containerd"
+	// DefaultSnapshotter is the default snapshotter used by containerd.
+	DefaultSnapshotter = "overlayfs"
+	// DefaultNamespace is the default namespace used by containerd and nerdctl.
+	DefaultNamespace = "default"
+
+	metadataPluginDir    = "io.containerd.metadata.v1.bolt"
+	metadataDBFile       = "meta.db"
+	contentPluginDir     = "io.containerd.content.v1.content"
+	snapshotterPluginDir = "io.containerd.snapshotter.v1."
+	snapshotterDBFile    = "metadata.db"
+	snapshotsDir         = "snapshots"
+)
+
+// Config is the configuration for the containerd rootfs storage.
+type Config struct {
+	// Root is the containerd root directory, default to "/var/lib/containerd".
+	Root string
+	// Namespace is the containerd namespace to lookup images. All namespaces are
+	// looked up when empty, with "default" namespace first.
+	Namespace string
+	// Snapshotter is the name of the snapshotter unpacking the image layers,
+	// only "overlayfs" is supported now.
+	Snapshotter string
+	// CacheDir is the directory to hold the copied bolt database files when they
+	// are locked by the running containerd daemon.
+	CacheDir string
+}
+
+// DefaultConfig returns the default configuration for the containerd rootfs storage.
+func DefaultConfig() Config {
+	return Config{
+		Root:        DefaultRoot,
+		Snapshotter: DefaultSnapshotter,
+		CacheDir:    os.TempDir(),
+	}
+}
+
+// MetadataDBFile returns the path to {Root}/io.containerd.metadata.v1.bolt/meta.db.
+func (c Config) MetadataDBFile() string {
+	return filepath.Join(c.Root, metadataPluginDir, metadataDBFile)
+}
+
+// ContentDir returns the path to {Root}/io.containerd.content.v1.content.
+func (c Config) ContentDir() string {
+	return filepath.Join(c.Root, contentPluginDir)
+}
+
+// SnapshotterDir returns the path to {Root}/io.containerd.snapshotter.v1.{Snapshotter}.
+func (c Config) SnapshotterDir() string {
+	return filepath.Join(c.Root, snapshotterPluginDir+c.Snapshotter)
+}
+
+// SnapshotterDBFile returns the path to {Root}/io.containerd.snapshotter.v1.{Snapshotter}/metadata.db.
+func (c Config) SnapshotterDBFile() string {
+	return filepath.Join(c.SnapshotterDir(), snapshotterDBFile)
+}
+
+// SnapshotFSDir returns the path to {Root}/io.containerd.snapshotter.v1.{Snapshotter}/snapshots/{id}/fs.
+func (c Config) SnapshotFSDir(id string) string {
+	return filepath.Join(c.SnapshotterDir(), snapshotsDir, id, "fs")
+}
@@ -0,0 +1,180 @@
+package rootfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers/overlay2"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+var (
+	_ ocispec.ImageCloser = (*containerdImage)(nil)
+	_ ocispec.BlobLayer   = (*containerdLayer)(nil)
+	_ ocispec.FSLayer     = (*containerdLayer)(nil)
+)
+
+type containerdImage struct {
+	storage    *Storage
+	namespace  string
+	manifest   manifest.ImageManifest
+	descriptor imgspecv1.Descriptor
+	metadata   ocispec.ImageMetadata
+
+	// lazy initialized and cached properties
+	configFileContent []byte
+	layers            []*containerdLayer
+}
+
+// Metadata returns the metadata of the image.
+func (img *containerdImage) Metadata() ocispec.ImageMetadata {
+	return img.metadata
+}
+
+// ConfigFile returns the image config file bytes.
+func (img *containerdImage) ConfigFile(ctx context.Context) ([]byte, error) {
+	if img.configFileContent != nil {
+		return img.configFileContent, nil
+	}
+
+	rc, err := img.storage.Fetch(ctx, img.manifest.Config())
+	if err != nil {
+		return nil, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	img.configFileContent = content
+	return img.configFileContent, nil
+}
+
+// Layers returns a list of layer objects contained in the current image in order.
+// The list order is from the oldest/base layer to the most-recent/top layer.
+func (img *containerdImage) Layers(ctx context.Context) ([]ocispec.Layer, error) {
+	if img.layers != nil {
+		return toLayers(img.layers), nil
+	}
+
+	configFile, err := img.ConfigFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := &imgspecv1.Image{}
+	if err := json.Unmarshal(configFile, config); err != nil {
+		return nil, err
+	}
+
+	metadatas, err := image.LayerMetadatas(ctx, config, img.manifest.Layers())
+	if err != nil {
+		return nil, err
+	}
+	var parent *containerdLayer
+	layers := make([]*containerdLayer, len(metadatas))
+	for i, desc := range manifest.NonEmptyLayers(img.manifest.Layers()...) {
+		current := &containerdLayer{
+			storage:    img.storage,
+			namespace:  img.namespace,
+			metadata:   metadatas[i],
+			descriptor: desc.Descriptor,
+		}
+		// NOTE: avoid the typed nil pointer of the parent layer
+		if parent != nil {
+			current.metadata.Parent = parent
+		}
+		parent = current
+		layers[i] = current
+	}
+
+	img.layers = layers
+	return toLayers(img.layers), nil
+}
+
+// Close releases any resources associated with the image.
+func (img *containerdImage) Close() error {
+	return nil
+}
+
+// Descriptor returns the descriptor for the resource.
+func (img *containerdImage) Descriptor() imgspecv1.Descriptor {
+	return img.descriptor
+}
+
+// containerdLayer is both a blob layer backed by the content store and a
+// filesystem layer backed by the snapshot directory.
+//
+// NOTE: The content blobs may be garbage collected after unpacking when the
+// "discard_unpacked_layers" is enabled, and the snapshots are not exist when
+// the image is pulled without unpacking.
+type containerdLayer struct {
+	storage    *Storage
+	namespace  string
+	metadata   ocispec.LayerMetadata
+	descriptor imgspecv1.Descriptor
+}
+
+// Metadata returns the metadata of the layer.
+func (layer *containerdLayer) Metadata() ocispec.LayerMetadata {
+	return layer.metadata
+}
+
+// Descriptor returns the descriptor for the resource.
+func (layer *containerdLayer) Descriptor() imgspecv1.Descriptor {
+	return layer.descriptor
+}
+
+// Compressed returns a reader that compressed what is read.
+// The reader must be closed when reading is finished.
+func (layer *containerdLayer) Compressed(_ context.Context) (io.ReadCloser, error) {
+	rc, err := layer.storage.contentFS.OpenBlob(layer.descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return image.CompressBlob(rc, layer.descriptor.MediaType)
+}
+
+// Uncompressed returns a reader that uncompresses what is read.
+// The reader must be closed when reading is finished.
+func (layer *containerdLayer) Uncompressed(_ context.Context) (io.ReadCloser, error) {
+	rc, err := layer.storage.contentFS.OpenBlob(layer.descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return image.UncompressBlob(rc)
+}
+
+// GetFS returns the filesystem of the layer diff in the snapshot directory.
+func (layer *containerdLayer) GetFS(ctx context.Context) (fs.FS, error) {
+	dir, err := layer.storage.snapshotDir(layer.namespace, layer.metadata.ChainID.String())
+	if err != nil {
+		return nil, err
+	}
+	exists, err := xos.Exists(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: snapshot directory %s", errdefs.ErrNotFound, dir)
+	}
+	return overlay2.NewDiffFS(ctx, dir), nil
+}
+
+func toLayers(layers []*containerdLayer) []ocispec.Layer {
+	result := make([]ocispec.Layer, len(layers))
+	for i, layer := range layers {
+		result[i] = layer
+	}
+	return result
+}
@@ -0,0 +1,243 @@
+package rootfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.etcd.io/bbolt"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// Buckets and keys of the containerd metadata database, the schema looks like:
+//
+//	└──v1                                        - Schema version bucket
+//	   ╘══*namespace*
+//	      ├──images
+//	      │  ╘══*image name*
+//	      │     ├──createdat : <binary time>     - Created at
+//	      │     ├──updatedat : <binary time>     - Updated at
+//	      │     ├──target
+//	      │     │  ├──digest : <digest>          - Descriptor digest
+//	      │     │  ├──mediatype : <string>       - Descriptor media type
+//	      │     │  └──size : <varint>            - Descriptor size
+//	      │     └──labels
+//	      │        ╘══*key* : <string>           - Label value
+//	      └──snapshots
+//	         ╘══*snapshotter*
+//	            ╘══*snapshot key*
+//	               └──name : <string>            - Snapshot key in the snapshotter
+//
+// More to see: https://github.com/containerd/containerd/blob/main/core/metadata/buckets.go
+var (
+	bucketKeyVersion         = []byte("v1")
+	bucketKeyObjectImages    = []byte("images")
+	bucketKeyObjectSnapshots = []byte("snapshots")
+	bucketKeyObjectLabels    = []byte("labels")
+	bucketKeyTarget          = []byte("target")
+	bucketKeyDigest          = []byte("digest")
+	bucketKeyMediaType       = []byte("mediatype")
+	bucketKeySize            = []byte("size")
+	bucketKeyCreatedAt       = []byte("createdat")
+	bucketKeyUpdatedAt       = []byte("updatedat")
+	bucketKeyName            = []byte("name")
+)
+
+// imageRecord is the image object stored in the containerd metadata database.
+type imageRecord struct {
+	Namespace string
+	Name      string
+	Target    imgspecv1.Descriptor
+	Labels    map[string]string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// loadMetadataDB reads all the image records and the snapshot keys of the
+// snapshotter from the containerd metadata database file.
+func loadMetadataDB(ctx context.Context, path string, snapshotter string, cacheDir string) (*metadataDB, error) {
+	db := &metadataDB{
+		images:    make(map[string][]imageRecord),
+		snapshots: make(map[string]map[string]string),
+	}
+	err := viewBoltDB(ctx, path, cacheDir, func(tx *bbolt.Tx) error {
+		root := tx.Bucket(bucketKeyVersion)
+		if root == nil {
+			return errdefs.Newf(errdefs.ErrNotFound, "schema version bucket %q not found in %s", bucketKeyVersion, path)
+		}
+		return root.ForEach(func(k, v []byte) error {
+			// skip the non-bucket keys like "version"
+			if v != nil {
+				return nil
+			}
+			namespace := string(k)
+			nsbkt := root.Bucket(k)
+			db.namespaces = append(db.namespaces, namespace)
+			if err := db.loadImages(ctx, namespace, nsbkt.Bucket(bucketKeyObjectImages)); err != nil {
+				return fmt.Errorf("unable to load images of namespace %q: %w", namespace, err)
+			}
+			if sbkt := nsbkt.Bucket(bucketKeyObjectSnapshots); sbkt != nil {
+				db.loadSnapshots(namespace, sbkt.Bucket([]byte(snapshotter)))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+type metadataDB struct {
+	namespaces []string
+	images     map[string][]imageRecord     // namespace -> images
+	snapshots  map[string]map[string]string // namespace -> snapshot key -> snapshotter key
+}
+
+func (db *metadataDB) loadImages(ctx context.Context, namespace string, bkt *bbolt.Bucket) error {
+	if bkt == nil {
+		return nil
+	}
+	return bkt.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		ibkt := bkt.Bucket(k)
+		record := imageRecord{
+			Namespace: namespace,
+			Name:      string(k),
+		}
+		if v := ibkt.Get(bucketKeyCreatedAt); v != nil {
+			if err := record.CreatedAt.UnmarshalBinary(v); err != nil {
+				xlog.C(ctx).Warnf("skip, unable to parse created time of image %s: %s", record.Name, err)
+			}
+		}
+		if v := ibkt.Get(bucketKeyUpdatedAt); v != nil {
+			if err := record.UpdatedAt.UnmarshalBinary(v); err != nil {
+				xlog.C(ctx).Warnf("skip, unable to parse updated time of image %s: %s", record.Name, err)
+			}
+		}
+		if lbkt := ibkt.Bucket(bucketKeyObjectLabels); lbkt != nil {
+			record.Labels = make(map[string]string)
+			_ = lbkt.ForEach(func(k, v []byte) error { //nolint:errcheck // never return error
+				record.Labels[string(k)] = string(v)
+				return nil
+			})
+		}
+		tbkt := ibkt.Bucket(bucketKeyTarget)
+		if tbkt == nil {
+			xlog.C(ctx).Warnf("skip, no target found for image %s in namespace %s", record.Name, namespace)
+			return nil
+		}
+		dgst, err := digest.Parse(string(tbkt.Get(bucketKeyDigest)))
+		if err != nil {
+			xlog.C(ctx).Warnf("skip, invalid target digest of image %s in namespace %s: %s", record.Name, namespace, err)
+			return nil
+		}
+		size, _ := binary.Varint(tbkt.Get(bucketKeySize))
+		record.Target = imgspecv1.Descriptor{
+			MediaType: string(tbkt.Get(bucketKeyMediaType)),
+			Digest:    dgst,
+			Size:      size,
+		}
+		db.images[namespace] = append(db.images[namespace], record)
+		return nil
+	})
+}
+
+func (db *metadataDB) loadSnapshots(namespace string, bkt *bbolt.Bucket) {
+	if bkt == nil {
+		return
+	}
+	keys := make(map[string]string)
+	_ = bkt.ForEach(func(k, v []byte) error { //nolint:errcheck // never return error
+		if v != nil {
+			return nil
+		}
+		if name := bkt.Bucket(k).Get(bucketKeyName); len(name) > 0 {
+			keys[string(k)] = string(name)
+		}
+		return nil
+	})
+	db.snapshots[namespace] = keys
+}
+
+// Namespaces returns the namespaces found in the database, "default" namespace
+// is always the first one if exists.
+func (db *metadataDB) Namespaces() []string {
+	namespaces := slices.Clone(db.namespaces)
+	slices.SortStableFunc(namespaces, func(a, b string) int {
+		switch {
+		case a == b:
+			return 0
+		case a == DefaultNamespace:
+			return -1
+		case b == DefaultNamespace:
+			return 1
+		}
+		return 0
+	})
+	return namespaces
+}
+
+// LookupImage returns the image record matched with the ref in the namespace.
+// The ref can be the full image name, the familiar image name or the digest of
+// the image target.
+func (db *metadataDB) LookupImage(namespace string, ref string) (imageRecord, bool) {
+	records := db.images[namespace]
+	for _, record := range records {
+		if record.Name == ref {
+			return record, true
+		}
+	}
+	if dgst, err := digest.Parse(ref); err == nil {
+		for _, record := range records {
+			if record.Target.Digest == dgst {
+				return record, true
+			}
+		}
+		return imageRecord{}, false
+	}
+	named, err := ocispecname.NewReference(ref)
+	if err != nil {
+		return imageRecord{}, false
+	}
+	for _, record := range records {
+		parsed, err := ocispecname.NewReference(record.Name)
+		if err != nil {
+			continue
+		}
+		if parsed.String() == named.String() {
+			return record, true
+		}
+	}
+	return imageRecord{}, false
+}
+
+// ImagesWithTarget returns all image records in the namespace pointing to the target.
+func (db *metadataDB) ImagesWithTarget(namespace string, target digest.Digest) []imageRecord {
+	var found []imageRecord
+	for _, record := range db.images[namespace] {
+		if record.Target.Digest == target {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+// SnapshotKey returns the key of the snapshot in the snapshotter.
+func (db *metadataDB) SnapshotKey(namespace string, key string) (string, bool) {
+	keys, ok := db.snapshots[namespace]
+	if !ok {
+		return "", false
+	}
+	name, ok := keys[key]
+	return name, ok
+}
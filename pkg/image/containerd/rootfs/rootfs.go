@@ -0,0 +1,232 @@
+// Package rootfs provides a containerd-rootfs storage implementation, which reads
+// the images from the containerd root directory directly without the daemon.
+package rootfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/samber/lo"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/oci/layout"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	_ "github.com/wuxler/ruasec/pkg/ocispec/manifest/all"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+var (
+	_ image.Storage            = (*Storage)(nil)
+	_ manifest.ManifestFetcher = (*Storage)(nil)
+)
+
+func init() {
+	ocispecname.RegisterScheme(image.StorageTypeContainerdFS)
+}
+
+// NewStorage returns a new containerd rootfs storage with the root directory.
+func NewStorage(ctx context.Context, root string) (*Storage, error) {
+	config := DefaultConfig()
+	config.Root = root
+	return NewStorageWithConfig(ctx, config)
+}
+
+// NewStorageWithConfig returns a new containerd rootfs storage with the given config.
+func NewStorageWithConfig(ctx context.Context, config Config) (*Storage, error) {
+	if config.Root == "" {
+		config.Root = DefaultRoot
+	}
+	if config.Snapshotter == "" {
+		config.Snapshotter = DefaultSnapshotter
+	}
+	if config.Snapshotter != DefaultSnapshotter {
+		return nil, errdefs.Newf(errdefs.ErrUnsupported, "unsupported containerd snapshotter %q", config.Snapshotter)
+	}
+
+	metadata, err := loadMetadataDB(ctx, config.MetadataDBFile(), config.Snapshotter, config.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load containerd metadata database: %w", err)
+	}
+	if config.Namespace != "" && !lo.Contains(metadata.namespaces, config.Namespace) {
+		return nil, fmt.Errorf("%w: containerd namespace %q", errdefs.ErrNotFound, config.Namespace)
+	}
+
+	s := &Storage{
+		config:    config,
+		metadata:  metadata,
+		contentFS: layout.NewLayoutFS(os.DirFS(config.ContentDir())),
+	}
+
+	// the snapshots are not exist when the images are pulled without unpacking,
+	// so the database file is optional
+	dbfile := config.SnapshotterDBFile()
+	if exists, err := xos.Exists(dbfile); err != nil {
+		return nil, err
+	} else if exists {
+		snapshots, err := loadSnapshotDB(ctx, dbfile, config.CacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load containerd %s snapshotter database: %w", config.Snapshotter, err)
+		}
+		s.snapshots = snapshots
+	} else {
+		xlog.C(ctx).Debugf("skip, containerd %s snapshotter database %s not found", config.Snapshotter, dbfile)
+	}
+	return s, nil
+}
+
+// Storage is a image storage implementation for containerd root directory.
+type Storage struct {
+	config    Config
+	metadata  *metadataDB
+	snapshots *snapshotDB
+	contentFS *layout.LayoutFS
+}
+
+// Type returns the unique identity type of the provider.
+func (s *Storage) Type() string {
+	return image.StorageTypeContainerdFS
+}
+
+// GetImage returns the image specified by ref.
+//
+// NOTE: The image must be closed when processing is finished.
+func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageOption) (ocispec.ImageCloser, error) {
+	if strings.HasPrefix(ref, s.Type()) {
+		ref = strings.TrimPrefix(ref, s.Type()+"://")
+	}
+	options := image.MakeImageOptions(opts...)
+
+	record, ok := s.lookupImage(ctx, ref)
+	if !ok {
+		return nil, fmt.Errorf("%w: lookup image with %s", errdefs.ErrNotFound, ref)
+	}
+	desc := record.Target
+
+	rc, err := s.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	mf, _, err := manifest.ParseCASReader(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	// select the manifest and descriptor of the target image
+	selectedManifest, selectedDesc, err := manifest.SelectImageManifest(
+		ctx, s, mf, desc, options.DescriptorMatchers()...)
+	if err != nil {
+		return nil, err
+	}
+	if mt := selectedManifest.MediaType(); ocispec.IsDockerSchema1Manifest(mt) {
+		return nil, errdefs.Newf(errdefs.ErrUnsupported, "docker scheme1 manifest %q is unsupported", mt)
+	}
+
+	// create the image metadata
+	metadata := ocispec.ImageMetadata{
+		ID:       selectedManifest.Config().Digest,
+		Digest:   selectedDesc.Digest,
+		Name:     ref,
+		Platform: selectedDesc.Platform,
+	}
+	if _, isIndexManifest := mf.(ocispec.IndexManifest); isIndexManifest {
+		metadata.IndexDigest = desc.Digest
+	}
+
+	// check if layers of the image is compressed and set image compressed/uncompressed size
+	isCompressed := false
+	layers := selectedManifest.Layers()
+	for i := range layers {
+		if !layers[i].Empty && ocispec.IsCompressedBlob(layers[i].MediaType) {
+			isCompressed = true
+		}
+	}
+	metadata.IsCompressed = isCompressed
+	size := manifest.ImageSize(selectedManifest)
+	if isCompressed {
+		metadata.CompressedSize = size
+	} else {
+		metadata.UncompressedSize = size
+	}
+
+	// collect all the names of the image in the namespace
+	for _, alias := range s.metadata.ImagesWithTarget(record.Namespace, desc.Digest) {
+		named, err := ocispecname.NewReference(alias.Name)
+		if err != nil {
+			continue
+		}
+		if tagged, ok := ocispecname.IsTagged(named); ok {
+			metadata.RepoTags = append(metadata.RepoTags, tagged.String())
+		}
+		metadata.RepoDigests = append(metadata.RepoDigests,
+			ocispecname.MustWithDigest(named.Repository(), desc.Digest).String())
+	}
+	// the tags of the same repository share one repo digest
+	metadata.RepoDigests = lo.Uniq(metadata.RepoDigests)
+	options.ApplyMetadata(&metadata)
+
+	img := &containerdImage{
+		storage:    s,
+		namespace:  record.Namespace,
+		manifest:   selectedManifest,
+		descriptor: selectedDesc,
+		metadata:   metadata,
+	}
+	return img, nil
+}
+
+// Fetch fetches the content for the given descriptor from the content store.
+func (s *Storage) Fetch(_ context.Context, desc imgspecv1.Descriptor) (cas.ReadCloser, error) {
+	rc, err := s.contentFS.OpenBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return cas.NewReadCloser(rc, desc), nil
+}
+
+// Close closes the storage and releases resources.
+func (s *Storage) Close() error {
+	return nil
+}
+
+// lookupImage returns the image record in the configured namespace, or the first
+// one matched in all namespaces when the namespace is not configured.
+func (s *Storage) lookupImage(ctx context.Context, ref string) (imageRecord, bool) {
+	namespaces := []string{s.config.Namespace}
+	if s.config.Namespace == "" {
+		namespaces = s.metadata.Namespaces()
+	}
+	for _, namespace := range namespaces {
+		if record, ok := s.metadata.LookupImage(namespace, ref); ok {
+			xlog.C(ctx).Debugf("found image %s as %s in containerd namespace %q", ref, record.Name, namespace)
+			return record, true
+		}
+	}
+	return imageRecord{}, false
+}
+
+// snapshotDir returns the snapshot directory of the layer with the chain id.
+func (s *Storage) snapshotDir(namespace string, chainID string) (string, error) {
+	if s.snapshots == nil {
+		return "", fmt.Errorf("%w: containerd %s snapshotter database", errdefs.ErrNotFound, s.config.Snapshotter)
+	}
+	key, ok := s.metadata.SnapshotKey(namespace, chainID)
+	if !ok {
+		return "", fmt.Errorf("%w: snapshot %s in containerd namespace %q", errdefs.ErrNotFound, chainID, namespace)
+	}
+	id, ok := s.snapshots.LookupID(key)
+	if !ok {
+		return "", fmt.Errorf("%w: snapshot %s in containerd %s snapshotter", errdefs.ErrNotFound, key, s.config.Snapshotter)
+	}
+	return s.config.SnapshotFSDir(id), nil
+}
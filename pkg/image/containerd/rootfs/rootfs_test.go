@@ -0,0 +1,304 @@
+package rootfs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image/containerd/rootfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+)
+
+// rootFixture is the containerd root directory with the metadata database, the
+// content store and the overlayfs snapshotter. The image is tagged "v1" and
+// "latest" in "default" namespace, and only "v1" in "k8s.io" namespace, where
+// the only one "other" image is.
+type rootFixture struct {
+	root           string
+	manifestDigest digest.Digest
+	otherDigest    digest.Digest
+	diffIDs        []digest.Digest
+}
+
+func writeBlob(t *testing.T, root string, content []byte) digest.Digest {
+	t.Helper()
+	dgst := digest.FromBytes(content)
+	path := filepath.Join(root, "io.containerd.content.v1.content", "blobs", dgst.Algorithm().String(), dgst.Encoded())
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+	return dgst
+}
+
+func writeJSONBlob(t *testing.T, root string, v any) (digest.Digest, int64) {
+	t.Helper()
+	content, err := json.Marshal(v)
+	require.NoError(t, err)
+	return writeBlob(t, root, content), int64(len(content))
+}
+
+func buildTar(t *testing.T, name string, content string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content))}))
+	_, err := tw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// writeImage writes the config, the layers and the manifest of the image into
+// the content store, and returns the manifest descriptor.
+func writeImage(t *testing.T, root string, layers [][]byte, histories []string) (imgspecv1.Descriptor, []digest.Digest) {
+	t.Helper()
+	diffIDs := []digest.Digest{}
+	descriptors := []imgspecv1.Descriptor{}
+	config := imgspecv1.Image{
+		Platform: imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   imgspecv1.RootFS{Type: "layers"},
+	}
+	for i, layer := range layers {
+		dgst := writeBlob(t, root, layer)
+		diffIDs = append(diffIDs, dgst)
+		descriptors = append(descriptors, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Digest: dgst, Size: int64(len(layer))})
+		config.History = append(config.History, imgspecv1.History{CreatedBy: histories[i]})
+	}
+	config.RootFS.DiffIDs = diffIDs
+	configDigest, configSize := writeJSONBlob(t, root, config)
+	manifestDigest, manifestSize := writeJSONBlob(t, root, imgspecv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Digest: configDigest, Size: configSize},
+		Layers:    descriptors,
+	})
+	return imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: manifestDigest, Size: manifestSize}, diffIDs
+}
+
+func updateBoltDB(t *testing.T, path string, fn func(tx *bbolt.Tx) error) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	db, err := bbolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Update(fn))
+}
+
+func createBuckets(t *testing.T, tx *bbolt.Tx, keys ...string) *bbolt.Bucket {
+	t.Helper()
+	bkt, err := tx.CreateBucketIfNotExists([]byte(keys[0]))
+	require.NoError(t, err)
+	for _, key := range keys[1:] {
+		bkt, err = bkt.CreateBucketIfNotExists([]byte(key))
+		require.NoError(t, err)
+	}
+	return bkt
+}
+
+func putImage(t *testing.T, tx *bbolt.Tx, namespace string, name string, target imgspecv1.Descriptor) {
+	t.Helper()
+	ibkt := createBuckets(t, tx, "v1", namespace, "images", name)
+	createdAt, err := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, ibkt.Put([]byte("createdat"), createdAt))
+	lbkt, err := ibkt.CreateBucketIfNotExists([]byte("labels"))
+	require.NoError(t, err)
+	require.NoError(t, lbkt.Put([]byte("io.cri-containerd.image"), []byte("managed")))
+	tbkt, err := ibkt.CreateBucketIfNotExists([]byte("target"))
+	require.NoError(t, err)
+	require.NoError(t, tbkt.Put([]byte("digest"), []byte(target.Digest)))
+	require.NoError(t, tbkt.Put([]byte("mediatype"), []byte(target.MediaType)))
+	require.NoError(t, tbkt.Put([]byte("size"), binary.AppendVarint(nil, target.Size)))
+}
+
+func newRootFixture(t *testing.T) rootFixture {
+	t.Helper()
+	root := t.TempDir()
+	manifest, diffIDs := writeImage(t, root,
+		[][]byte{buildTar(t, "layer", "base"), buildTar(t, "layer", "top")},
+		[]string{"ADD rootfs", "RUN make"})
+	other, _ := writeImage(t, root, [][]byte{buildTar(t, "layer", "other")}, []string{"ADD other"})
+	chainIDs := identity.ChainIDs([]digest.Digest{diffIDs[0], diffIDs[1]})
+
+	metadataDB := filepath.Join(root, "io.containerd.metadata.v1.bolt", "meta.db")
+	updateBoltDB(t, metadataDB, func(tx *bbolt.Tx) error {
+		putImage(t, tx, "k8s.io", "registry.example.com/app:v1", manifest)
+		putImage(t, tx, "k8s.io", "registry.example.com/other:v2", other)
+		putImage(t, tx, "default", "registry.example.com/app:v1", manifest)
+		putImage(t, tx, "default", "registry.example.com/app:latest", manifest)
+		// the image without target is skipped
+		createBuckets(t, tx, "v1", "default", "images", "registry.example.com/broken:v1")
+		// only the base layer is unpacked into the snapshotter
+		sbkt := createBuckets(t, tx, "v1", "default", "snapshots", "overlayfs", chainIDs[0].String())
+		return sbkt.Put([]byte("name"), []byte("default/1/"+chainIDs[0].String()))
+	})
+
+	snapshotDB := filepath.Join(root, "io.containerd.snapshotter.v1.overlayfs", "metadata.db")
+	updateBoltDB(t, snapshotDB, func(tx *bbolt.Tx) error {
+		bkt := createBuckets(t, tx, "v1", "snapshots", "default/1/"+chainIDs[0].String())
+		return bkt.Put([]byte("id"), binary.AppendUvarint(nil, 7))
+	})
+	fsDir := filepath.Join(root, "io.containerd.snapshotter.v1.overlayfs", "snapshots", "7", "fs")
+	require.NoError(t, os.MkdirAll(fsDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(fsDir, "layer"), []byte("base snapshot"), 0o644))
+
+	return rootFixture{root: root, manifestDigest: manifest.Digest, otherDigest: other.Digest, diffIDs: diffIDs}
+}
+
+func TestStorage_GetImage(t *testing.T) {
+	ctx := context.Background()
+	fixture := newRootFixture(t)
+
+	testcases := []struct {
+		name        string
+		namespace   string
+		ref         string
+		wantDigest  digest.Digest
+		wantTags    []string
+		wantDigests []string
+	}{
+		{
+			name:        "default namespace first",
+			ref:         "registry.example.com/app:v1",
+			wantDigest:  fixture.manifestDigest,
+			wantTags:    []string{"registry.example.com/app:v1", "registry.example.com/app:latest"},
+			wantDigests: []string{"registry.example.com/app@" + fixture.manifestDigest.String()},
+		},
+		{
+			name:        "configured namespace",
+			namespace:   "k8s.io",
+			ref:         "containerd-rootfs://registry.example.com/app:v1",
+			wantDigest:  fixture.manifestDigest,
+			wantTags:    []string{"registry.example.com/app:v1"},
+			wantDigests: []string{"registry.example.com/app@" + fixture.manifestDigest.String()},
+		},
+		{
+			name:        "other namespace",
+			ref:         "registry.example.com/other:v2",
+			wantDigest:  fixture.otherDigest,
+			wantTags:    []string{"registry.example.com/other:v2"},
+			wantDigests: []string{"registry.example.com/other@" + fixture.otherDigest.String()},
+		},
+		{
+			name:        "target digest",
+			ref:         fixture.otherDigest.String(),
+			wantDigest:  fixture.otherDigest,
+			wantTags:    []string{"registry.example.com/other:v2"},
+			wantDigests: []string{"registry.example.com/other@" + fixture.otherDigest.String()},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := rootfs.DefaultConfig()
+			config.Root = fixture.root
+			config.Namespace = tc.namespace
+			storage, err := rootfs.NewStorageWithConfig(ctx, config)
+			require.NoError(t, err)
+			defer storage.Close()
+
+			img, err := storage.GetImage(ctx, tc.ref)
+			require.NoError(t, err)
+			defer img.Close()
+
+			metadata := img.Metadata()
+			assert.Equal(t, tc.wantDigest, metadata.Digest)
+			assert.ElementsMatch(t, tc.wantTags, metadata.RepoTags)
+			assert.Equal(t, tc.wantDigests, metadata.RepoDigests)
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		config := rootfs.DefaultConfig()
+		config.Root = fixture.root
+		config.Namespace = rootfs.DefaultNamespace
+		storage, err := rootfs.NewStorageWithConfig(ctx, config)
+		require.NoError(t, err)
+		defer storage.Close()
+
+		for _, ref := range []string{"registry.example.com/other:v2", "registry.example.com/broken:v1"} {
+			_, err = storage.GetImage(ctx, ref)
+			assert.ErrorIs(t, err, errdefs.ErrNotFound, ref)
+		}
+	})
+
+	t.Run("unknown namespace", func(t *testing.T) {
+		config := rootfs.DefaultConfig()
+		config.Root = fixture.root
+		config.Namespace = "unknown"
+		_, err := rootfs.NewStorageWithConfig(ctx, config)
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+	})
+}
+
+func TestStorage_Layers(t *testing.T) {
+	ctx := context.Background()
+	fixture := newRootFixture(t)
+	storage, err := rootfs.NewStorage(ctx, fixture.root)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	img, err := storage.GetImage(ctx, "registry.example.com/app:latest")
+	require.NoError(t, err)
+	defer img.Close()
+
+	layers, err := img.Layers(ctx)
+	require.NoError(t, err)
+	require.Len(t, layers, 2)
+	chainIDs := identity.ChainIDs([]digest.Digest{fixture.diffIDs[0], fixture.diffIDs[1]})
+	for i, createdBy := range []string{"ADD rootfs", "RUN make"} {
+		metadata := layers[i].Metadata()
+		assert.Equal(t, fixture.diffIDs[i], metadata.DiffID)
+		assert.Equal(t, chainIDs[i], metadata.ChainID)
+		require.NotNil(t, metadata.History)
+		assert.Equal(t, createdBy, metadata.History.CreatedBy)
+	}
+	assert.Nil(t, layers[0].Metadata().Parent)
+	assert.Equal(t, layers[0], layers[1].Metadata().Parent)
+
+	t.Run("snapshot", func(t *testing.T) {
+		fsLayer, ok := layers[0].(ocispec.FSLayer)
+		require.True(t, ok)
+		fsys, err := fsLayer.GetFS(ctx)
+		require.NoError(t, err)
+		content, err := fs.ReadFile(fsys, "layer")
+		require.NoError(t, err)
+		assert.Equal(t, "base snapshot", string(content))
+
+		// the top layer is not unpacked
+		fsLayer, ok = layers[1].(ocispec.FSLayer)
+		require.True(t, ok)
+		_, err = fsLayer.GetFS(ctx)
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+	})
+
+	t.Run("content", func(t *testing.T) {
+		blobLayer, ok := layers[1].(ocispec.BlobLayer)
+		require.True(t, ok)
+		rc, err := blobLayer.Uncompressed(ctx)
+		require.NoError(t, err)
+		defer rc.Close()
+		tr := tar.NewReader(rc)
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "layer", hdr.Name)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		assert.Equal(t, "top", string(content))
+	})
+}
@@ -0,0 +1,70 @@
+package rootfs
+
+import (
+	"context"
+	"encoding/binary"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+)
+
+// Buckets and keys of the snapshotter metadata database, the schema looks like:
+//
+//	└──v1                                        - Schema version bucket
+//	   ├──snapshots
+//	   │  ╘══*snapshot key*
+//	   │     ├──id : <uvarint>                   - Snapshot id used as the directory name
+//	   │     ├──kind : <byte>                    - Snapshot kind
+//	   │     └──parent : <string>                - Parent snapshot key
+//	   └──parents
+//
+// More to see: https://github.com/containerd/containerd/blob/main/core/snapshots/storage/bolt.go
+var (
+	bucketKeyStorageVersion = []byte("v1")
+	bucketKeySnapshot       = []byte("snapshots")
+	bucketKeyID             = []byte("id")
+)
+
+// loadSnapshotDB reads all the snapshot ids from the snapshotter metadata database file.
+func loadSnapshotDB(ctx context.Context, path string, cacheDir string) (*snapshotDB, error) {
+	db := &snapshotDB{
+		ids: make(map[string]string),
+	}
+	err := viewBoltDB(ctx, path, cacheDir, func(tx *bbolt.Tx) error {
+		root := tx.Bucket(bucketKeyStorageVersion)
+		if root == nil {
+			return errdefs.Newf(errdefs.ErrNotFound, "schema version bucket %q not found in %s", bucketKeyStorageVersion, path)
+		}
+		bkt := root.Bucket(bucketKeySnapshot)
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			id, n := binary.Uvarint(bkt.Bucket(k).Get(bucketKeyID))
+			if n <= 0 {
+				return nil
+			}
+			db.ids[string(k)] = strconv.FormatUint(id, 10)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+type snapshotDB struct {
+	ids map[string]string // snapshot key -> snapshot id
+}
+
+// LookupID returns the id of the snapshot with the key in the snapshotter.
+func (db *snapshotDB) LookupID(key string) (string, bool) {
+	id, ok := db.ids[key]
+	return id, ok
+}
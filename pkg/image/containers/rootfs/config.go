@@ -0,0 +1,95 @@
+package rootfs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	// DefaultRoot is the default graph root directory of the containers storage
+	// used by root podman, buildah and CRI-O.
+	DefaultRoot = "/var/lib/containers/storage"
+	// DefaultDriver is the default storage driver of the containers storage.
+	DefaultDriver = "overlay"
+
+	imagesFileName = "images.json"
+	layersFileName = "layers.json"
+	manifestKey    = "manifest"
+	diffDirName    = "diff"
+
+	tarSplitFileSuffix = ".tar-split.gz"
+)
+
+// DefaultRootlessRoot returns the default graph root directory of the containers
+// storage used by the rootless podman, which is "$XDG_DATA_HOME/containers/storage"
+// and falls back to "$HOME/.local/share/containers/storage".
+func DefaultRootlessRoot() string {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "containers", "storage")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "share", "containers", "storage")
+}
+
+// Config is the configuration for the containers storage.
+type Config struct {
+	// Root is the graph root directory of the containers storage, default to
+	// "/var/lib/containers/storage" for root user and [DefaultRootlessRoot] for
+	// others.
+	Root string
+	// Driver is the name of the storage driver, only "overlay" is supported now.
+	Driver string
+}
+
+// DefaultConfig returns the default configuration for the containers storage.
+func DefaultConfig() Config {
+	root := DefaultRoot
+	if os.Geteuid() != 0 {
+		if rootless := DefaultRootlessRoot(); rootless != "" {
+			root = rootless
+		}
+	}
+	return Config{
+		Root:   root,
+		Driver: DefaultDriver,
+	}
+}
+
+// ImagesDir returns the path to {Root}/{Driver}-images.
+func (c Config) ImagesDir() string {
+	return filepath.Join(c.Root, c.Driver+"-images")
+}
+
+// ImagesJSONFile returns the path to {Root}/{Driver}-images/images.json.
+func (c Config) ImagesJSONFile() string {
+	return filepath.Join(c.ImagesDir(), imagesFileName)
+}
+
+// ImageBigDataFile returns the path to {Root}/{Driver}-images/{id}/{key}, the
+// key is encoded the same as the containers storage does.
+func (c Config) ImageBigDataFile(id string, key string) string {
+	return filepath.Join(c.ImagesDir(), id, bigDataFileName(key))
+}
+
+// LayersDir returns the path to {Root}/{Driver}-layers.
+func (c Config) LayersDir() string {
+	return filepath.Join(c.Root, c.Driver+"-layers")
+}
+
+// LayersJSONFile returns the path to {Root}/{Driver}-layers/layers.json.
+func (c Config) LayersJSONFile() string {
+	return filepath.Join(c.LayersDir(), layersFileName)
+}
+
+// LayerTarSplitFile returns the path to {Root}/{Driver}-layers/{id}.tar-split.gz.
+func (c Config) LayerTarSplitFile(id string) string {
+	return filepath.Join(c.LayersDir(), id+tarSplitFileSuffix)
+}
+
+// LayerDiffDir returns the path to {Root}/{Driver}/{id}/diff.
+func (c Config) LayerDiffDir(id string) string {
+	return filepath.Join(c.Root, c.Driver, id, diffDirName)
+}
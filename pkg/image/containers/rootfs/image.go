@@ -0,0 +1,121 @@
+package rootfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers/overlay2"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+var (
+	_ ocispec.ImageCloser  = (*containersImage)(nil)
+	_ ocispec.FSLayer      = (*containersLayer)(nil)
+	_ ocispec.Compressor   = (*containersLayer)(nil)
+	_ ocispec.Uncompressor = (*containersLayer)(nil)
+	_ blobfs.TarSplitter   = (*containersLayer)(nil)
+)
+
+// NewImage returns the image speicified by the name ref.
+func NewImage(ctx context.Context, root string, ref string, opts ...image.ImageOption) (ocispec.ImageCloser, error) {
+	storage, err := NewStorage(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	return storage.GetImage(ctx, ref, opts...)
+}
+
+type containersImage struct {
+	metadata ocispec.ImageMetadata
+	layers   []*containersLayer
+
+	// cached values
+
+	configFileContent []byte
+}
+
+// Metadata returns the metadata of the image.
+func (img *containersImage) Metadata() ocispec.ImageMetadata {
+	return img.metadata
+}
+
+// ConfigFile returns the image config file bytes.
+func (img *containersImage) ConfigFile(_ context.Context) ([]byte, error) {
+	return img.configFileContent, nil
+}
+
+// Layers returns a list of layer objects contained in the current image in order.
+// The list order is from the oldest/base layer to the most-recent/top layer.
+func (img *containersImage) Layers(_ context.Context) ([]ocispec.Layer, error) {
+	layers := make([]ocispec.Layer, len(img.layers))
+	for i, layer := range img.layers {
+		layers[i] = layer
+	}
+	return layers, nil
+}
+
+// Close do nothing here
+func (img *containersImage) Close() error {
+	return nil
+}
+
+type containersLayer struct {
+	id       string
+	metadata ocispec.LayerMetadata
+	diffDir  string
+	tarSplit string
+	blob     *blobfs.FSBlob
+}
+
+// Metadata returns the metadata of the layer.
+func (l *containersLayer) Metadata() ocispec.LayerMetadata {
+	return l.metadata
+}
+
+// GetFS returns the filesystem of the layer diff directory, the overlay whiteouts
+// are transferred to the ".wh." prefixed whiteout files.
+func (l *containersLayer) GetFS(ctx context.Context) (fs.FS, error) {
+	exists, err := xos.Exists(l.diffDir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: diff directory %s of layer %s", errdefs.ErrNotFound, l.diffDir, l.id)
+	}
+	return overlay2.NewDiffFS(ctx, l.diffDir), nil
+}
+
+// TarSplit returns a reader of the tar-split metadata recorded by the containers
+// storage when the layer is applied, which reassembles the original layer tarball.
+// Implements the [blobfs.TarSplitter] interface.
+func (l *containersLayer) TarSplit(_ context.Context) (io.ReadCloser, error) {
+	return os.Open(l.tarSplit)
+}
+
+// Compressed returns a reader of the layer tarball reassembled or reproduced from
+// the filesystem and compressed with gzip.
+// The reader must be closed when reading is finished.
+func (l *containersLayer) Compressed(ctx context.Context) (io.ReadCloser, error) {
+	return l.fsBlob().Compressed(ctx)
+}
+
+// Uncompressed returns a reader of the layer tarball reassembled or reproduced from
+// the filesystem.
+// The reader must be closed when reading is finished.
+func (l *containersLayer) Uncompressed(ctx context.Context) (io.ReadCloser, error) {
+	return l.fsBlob().Uncompressed(ctx)
+}
+
+func (l *containersLayer) fsBlob() *blobfs.FSBlob {
+	if l.blob == nil {
+		l.blob = blobfs.NewFSBlob(l)
+	}
+	return l.blob
+}
@@ -0,0 +1,207 @@
+// Package rootfs provides a containers-storage implementation, which reads the
+// images from the graph root directory of the containers storage used by podman,
+// buildah and CRI-O directly.
+package rootfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	_ "github.com/wuxler/ruasec/pkg/ocispec/manifest/all"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+var _ image.Storage = (*Storage)(nil)
+
+func init() {
+	ocispecname.RegisterScheme(image.StorageTypeContainersFS)
+}
+
+// NewStorage returns a new containers storage with the graph root directory.
+func NewStorage(ctx context.Context, root string) (*Storage, error) {
+	config := DefaultConfig()
+	config.Root = root
+	return NewStorageWithConfig(ctx, config)
+}
+
+// NewStorageWithConfig returns a new containers storage with the given config.
+func NewStorageWithConfig(ctx context.Context, config Config) (*Storage, error) {
+	if config.Root == "" {
+		config.Root = DefaultConfig().Root
+	}
+	if config.Driver == "" {
+		config.Driver = DefaultDriver
+	}
+	if config.Driver != DefaultDriver {
+		return nil, errdefs.Newf(errdefs.ErrUnsupported, "unsupported containers storage driver %q", config.Driver)
+	}
+
+	db, err := loadStoreDB(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{config: config, db: db}, nil
+}
+
+// Storage is a image storage implementation for the containers storage, which is
+// the "/var/lib/containers/storage" for root and "~/.local/share/containers/storage"
+// for rootless by default.
+//
+// More to see: https://github.com/containers/storage
+type Storage struct {
+	config Config
+	db     *storeDB
+}
+
+// Type returns the unique identity type of the provider.
+func (s *Storage) Type() string {
+	return image.StorageTypeContainersFS
+}
+
+// GetImage returns the image specified by ref, which can be the full or short
+// image id, the image manifest digest or the image name.
+//
+// NOTE: The image must be closed when processing is finished.
+func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageOption) (ocispec.ImageCloser, error) {
+	if strings.HasPrefix(ref, s.Type()) {
+		ref = strings.TrimPrefix(ref, s.Type()+"://")
+	}
+	record, err := s.db.LookupImage(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := ocispec.ImageMetadata{
+		Name:   ref,
+		ID:     digest.NewDigestFromEncoded(digest.SHA256, record.ID),
+		Digest: record.Digest,
+	}
+
+	// load image metadata with aliased names and digests
+	for _, r := range s.db.ReferencesByImageID(record.ID) {
+		if _, ok := ocispecname.IsTagged(r); ok {
+			metadata.RepoTags = append(metadata.RepoTags, r.String())
+		}
+		if _, ok := ocispecname.IsDigested(r); ok {
+			metadata.RepoDigests = append(metadata.RepoDigests, r.String())
+			continue
+		}
+		for _, dgst := range record.Digests {
+			if named, err := ocispecname.WithDigest(r.Repository(), dgst); err == nil {
+				metadata.RepoDigests = append(metadata.RepoDigests, named.String())
+			}
+		}
+	}
+	metadata.RepoDigests = slices.Compact(slices.Sorted(slices.Values(metadata.RepoDigests)))
+
+	// load image config file
+	configBytes, err := s.readImageConfig(record)
+	if err != nil {
+		return nil, err
+	}
+	config := &imgspecv1.Image{}
+	if err := json.Unmarshal(configBytes, config); err != nil {
+		return nil, err
+	}
+	diffids := config.RootFS.DiffIDs
+	chainids := identity.ChainIDs(slices.Clone(diffids))
+
+	// load layers from the base layer to the top layer
+	chain, err := s.db.LayerChain(record.TopLayer)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) != len(diffids) {
+		return nil, fmt.Errorf("mismatch length of DiffIDs and layers of image %s: %d != %d", record.ID, len(diffids), len(chain))
+	}
+
+	metadatas := make([]ocispec.LayerMetadata, len(chain))
+	for i, l := range chain {
+		if l.UncompressedDigest != "" && l.UncompressedDigest != diffids[i] {
+			return nil, fmt.Errorf("mismatch DiffID of layer %s: %s != %s", l.ID, l.UncompressedDigest, diffids[i])
+		}
+		metadatas[i] = ocispec.LayerMetadata{
+			DiffID:           diffids[i],
+			ChainID:          chainids[i],
+			UncompressedSize: l.UncompressedSize,
+			CompressedDigest: l.CompressedDigest,
+			CompressedSize:   l.CompressedSize,
+		}
+		metadata.UncompressedSize += l.UncompressedSize
+	}
+	image.SetLayerHistories(ctx, metadatas, config)
+
+	var parent *containersLayer
+	layers := make([]*containersLayer, len(chain))
+	for i, l := range chain {
+		layer := &containersLayer{
+			id:       l.ID,
+			metadata: metadatas[i],
+			diffDir:  s.config.LayerDiffDir(l.ID),
+			tarSplit: s.config.LayerTarSplitFile(l.ID),
+		}
+		if parent != nil {
+			layer.metadata.Parent = parent
+		}
+		parent = layer
+		layers[i] = layer
+	}
+
+	// set image platform from image config
+	p := platforms.Normalize(config.Platform)
+	metadata.Platform = &p
+
+	// create image instance
+	options := image.MakeImageOptions(opts...)
+	options.ApplyMetadata(&metadata)
+	img := &containersImage{
+		metadata:          metadata,
+		layers:            layers,
+		configFileContent: configBytes,
+	}
+	return img, nil
+}
+
+// Close closes the storage and releases resources.
+func (s *Storage) Close() error {
+	return nil
+}
+
+// readImageConfig returns the image config bytes stored as the big data item
+// keyed by the config digest, which is resolved from the image manifest and
+// falls back to the image id.
+func (s *Storage) readImageConfig(record imageRecord) ([]byte, error) {
+	key := digest.NewDigestFromEncoded(digest.SHA256, record.ID).String()
+	if slices.Contains(record.BigDataNames, manifestKey) {
+		content, err := os.ReadFile(s.config.ImageBigDataFile(record.ID, manifestKey))
+		if err != nil {
+			return nil, err
+		}
+		mf, _, err := manifest.ParseBytes(content)
+		if err != nil {
+			return nil, err
+		}
+		imageManifest, ok := mf.(manifest.ImageManifest)
+		if !ok {
+			return nil, errdefs.Newf(errdefs.ErrUnsupported, "image manifest with media type %q", mf.MediaType())
+		}
+		key = imageManifest.Config().Digest.String()
+	}
+	if !slices.Contains(record.BigDataNames, key) {
+		return nil, fmt.Errorf("%w: config %s of image %s", errdefs.ErrNotFound, key, record.ID)
+	}
+	return os.ReadFile(s.config.ImageBigDataFile(record.ID, key))
+}
@@ -0,0 +1,166 @@
+package rootfs_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image/containers/rootfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+)
+
+// storageFixture is the graph root directory of the containers storage with
+// one image of two layers.
+type storageFixture struct {
+	root           string
+	imageID        string
+	manifestDigest digest.Digest
+	diffIDs        []digest.Digest
+}
+
+func writeJSON(t *testing.T, path string, v any) []byte {
+	t.Helper()
+	content, err := json.Marshal(v)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+	return content
+}
+
+func newStorageFixture(t *testing.T) storageFixture {
+	t.Helper()
+	root := t.TempDir()
+	diffIDs := []digest.Digest{digest.FromString("base"), digest.FromString("top")}
+	layerIDs := []string{"base-layer", "top-layer"}
+	for _, id := range layerIDs {
+		path := filepath.Join(root, "overlay", id, "diff", "layer")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(id), 0o644))
+	}
+	writeJSON(t, filepath.Join(root, "overlay-layers", "layers.json"), []map[string]any{
+		{"id": layerIDs[0], "diff-digest": diffIDs[0], "diff-size": 100},
+		{"id": layerIDs[1], "parent": layerIDs[0], "diff-digest": diffIDs[1], "diff-size": 200},
+	})
+
+	config := imgspecv1.Image{
+		Platform: imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   imgspecv1.RootFS{Type: "layers", DiffIDs: diffIDs},
+		History: []imgspecv1.History{
+			{CreatedBy: "ADD rootfs"},
+			{CreatedBy: "ENV A=B", EmptyLayer: true},
+			{CreatedBy: "RUN make"},
+		},
+	}
+	configContent, err := json.Marshal(config)
+	require.NoError(t, err)
+	configDigest := digest.FromBytes(configContent)
+	imageID := configDigest.Encoded()
+	imageDir := filepath.Join(root, "overlay-images", imageID)
+	require.NoError(t, os.MkdirAll(imageDir, 0o755))
+	// the big data key out of [.0-9a-z] is encoded with base64 and prefixed with "="
+	configFile := "=" + base64.StdEncoding.EncodeToString([]byte(configDigest.String()))
+	require.NoError(t, os.WriteFile(filepath.Join(imageDir, configFile), configContent, 0o644))
+
+	manifestContent := writeJSON(t, filepath.Join(imageDir, "manifest"), imgspecv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Digest: configDigest, Size: int64(len(configContent))},
+		Layers: []imgspecv1.Descriptor{
+			{MediaType: imgspecv1.MediaTypeImageLayerGzip, Digest: digest.FromString("base.gz"), Size: 10},
+			{MediaType: imgspecv1.MediaTypeImageLayerGzip, Digest: digest.FromString("top.gz"), Size: 20},
+		},
+	})
+	manifestDigest := digest.FromBytes(manifestContent)
+	writeJSON(t, filepath.Join(root, "overlay-images", "images.json"), []map[string]any{{
+		"id":             imageID,
+		"digest":         manifestDigest,
+		"digests":        []digest.Digest{manifestDigest},
+		"names":          []string{"registry.example.com/app:v1", "registry.example.com/app:latest"},
+		"layer":          layerIDs[1],
+		"big-data-names": []string{"manifest", configDigest.String()},
+	}})
+
+	return storageFixture{root: root, imageID: imageID, manifestDigest: manifestDigest, diffIDs: diffIDs}
+}
+
+func TestStorage_GetImage(t *testing.T) {
+	ctx := context.Background()
+	fixture := newStorageFixture(t)
+	storage, err := rootfs.NewStorage(ctx, fixture.root)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	refs := []string{
+		"registry.example.com/app:v1",
+		"containers-storage://registry.example.com/app:latest",
+		fixture.imageID,
+		fixture.imageID[:12],
+		"sha256:" + fixture.imageID,
+		fixture.manifestDigest.String(),
+	}
+	for _, ref := range refs {
+		t.Run(ref, func(t *testing.T) {
+			img, err := storage.GetImage(ctx, ref)
+			require.NoError(t, err)
+			defer img.Close()
+
+			metadata := img.Metadata()
+			assert.Equal(t, "sha256:"+fixture.imageID, metadata.ID.String())
+			assert.Equal(t, fixture.manifestDigest, metadata.Digest)
+			assert.ElementsMatch(t, []string{"registry.example.com/app:v1", "registry.example.com/app:latest"}, metadata.RepoTags)
+			assert.Equal(t, []string{"registry.example.com/app@" + fixture.manifestDigest.String()}, metadata.RepoDigests)
+			assert.Equal(t, int64(300), metadata.UncompressedSize)
+			require.NotNil(t, metadata.Platform)
+			assert.Equal(t, "amd64", metadata.Platform.Architecture)
+		})
+	}
+
+	t.Run("layers", func(t *testing.T) {
+		img, err := storage.GetImage(ctx, "registry.example.com/app:v1")
+		require.NoError(t, err)
+		defer img.Close()
+
+		configFile, err := img.ConfigFile(ctx)
+		require.NoError(t, err)
+		assert.Contains(t, string(configFile), "RUN make")
+
+		layers, err := img.Layers(ctx)
+		require.NoError(t, err)
+		require.Len(t, layers, 2)
+		chainIDs := identity.ChainIDs([]digest.Digest{fixture.diffIDs[0], fixture.diffIDs[1]})
+		for i, createdBy := range []string{"ADD rootfs", "RUN make"} {
+			metadata := layers[i].Metadata()
+			assert.Equal(t, fixture.diffIDs[i], metadata.DiffID)
+			assert.Equal(t, chainIDs[i], metadata.ChainID)
+			require.NotNil(t, metadata.History)
+			assert.Equal(t, createdBy, metadata.History.CreatedBy)
+		}
+		assert.Nil(t, layers[0].Metadata().Parent)
+		assert.Equal(t, layers[0], layers[1].Metadata().Parent)
+
+		fsLayer, ok := layers[1].(ocispec.FSLayer)
+		require.True(t, ok)
+		fsys, err := fsLayer.GetFS(ctx)
+		require.NoError(t, err)
+		content, err := fs.ReadFile(fsys, "layer")
+		require.NoError(t, err)
+		assert.Equal(t, "top-layer", string(content))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := storage.GetImage(ctx, "registry.example.com/missing:v1")
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+	})
+}
@@ -0,0 +1,179 @@
+package rootfs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/go-digest/digestset"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// imageRecord is the image object stored in the "images.json" file.
+//
+// More to see: https://github.com/containers/storage/blob/main/images.go
+type imageRecord struct {
+	ID           string          `json:"id"`
+	Digest       digest.Digest   `json:"digest,omitempty"`
+	Digests      []digest.Digest `json:"digests,omitempty"`
+	Names        []string        `json:"names,omitempty"`
+	TopLayer     string          `json:"layer,omitempty"`
+	BigDataNames []string        `json:"big-data-names,omitempty"`
+	Created      time.Time       `json:"created,omitempty"`
+}
+
+// layerRecord is the layer object stored in the "layers.json" file.
+//
+// More to see: https://github.com/containers/storage/blob/main/layers.go
+type layerRecord struct {
+	ID                 string        `json:"id"`
+	Parent             string        `json:"parent,omitempty"`
+	CompressedDigest   digest.Digest `json:"compressed-diff-digest,omitempty"`
+	CompressedSize     int64         `json:"compressed-size,omitempty"`
+	UncompressedDigest digest.Digest `json:"diff-digest,omitempty"`
+	UncompressedSize   int64         `json:"diff-size,omitempty"`
+}
+
+// bigDataFileName returns the file name of the image big data item with the key.
+// The key is used as-is when consists of [.0-9a-z] only, or is encoded with
+// base64 and prefixed with "=".
+func bigDataFileName(key string) string {
+	for _, ch := range key {
+		if ch != '.' && (ch < '0' || ch > '9') && (ch < 'a' || ch > 'z') {
+			return "=" + base64.StdEncoding.EncodeToString([]byte(key))
+		}
+	}
+	return key
+}
+
+// loadStoreDB reads the images and layers databases of the containers storage.
+func loadStoreDB(ctx context.Context, config Config) (*storeDB, error) {
+	var images []imageRecord
+	if err := readJSONFile(config.ImagesJSONFile(), &images); err != nil {
+		return nil, fmt.Errorf("unable to load images database: %w", err)
+	}
+	var layers []layerRecord
+	if err := readJSONFile(config.LayersJSONFile(), &layers); err != nil {
+		return nil, fmt.Errorf("unable to load layers database: %w", err)
+	}
+
+	db := &storeDB{
+		images:   images,
+		layers:   make(map[string]layerRecord, len(layers)),
+		refsByID: make(map[string][]name.Reference),
+		idset:    digestset.NewSet(),
+	}
+	for _, layer := range layers {
+		db.layers[layer.ID] = layer
+	}
+	for _, img := range images {
+		id := digest.NewDigestFromEncoded(digest.SHA256, img.ID)
+		if err := id.Validate(); err != nil {
+			xlog.C(ctx).Warnf("skip, invalid image id %q: %s", img.ID, err)
+			continue
+		}
+		if err := db.idset.Add(id); err != nil {
+			return nil, err
+		}
+		for _, n := range img.Names {
+			ref, err := name.NewReference(n)
+			if err != nil {
+				xlog.C(ctx).Warnf("skip, unable to parse name %q of image %s: %s", n, img.ID, err)
+				continue
+			}
+			db.refsByID[img.ID] = append(db.refsByID[img.ID], ref)
+		}
+	}
+	return db, nil
+}
+
+type storeDB struct {
+	images []imageRecord
+	layers map[string]layerRecord // layer id -> layer
+
+	// Maps by: image id => name.Reference
+	refsByID map[string][]name.Reference
+	// all image id set
+	idset *digestset.Set
+}
+
+// ReferencesByImageID returns all references of the image with the id.
+func (db *storeDB) ReferencesByImageID(id string) []name.Reference {
+	return slices.Clone(db.refsByID[id])
+}
+
+// LookupImage returns the image record for the given string, which can be the
+// full or short image id, the image manifest digest or the image name.
+func (db *storeDB) LookupImage(s string) (imageRecord, error) {
+	id := strings.TrimPrefix(s, string(digest.SHA256)+":")
+	if found, err := db.idset.Lookup(id); err == nil {
+		id = found.Encoded()
+	}
+	if img, ok := db.imageByID(id); ok {
+		return img, nil
+	}
+
+	if dgst, err := digest.Parse(s); err == nil {
+		for _, img := range db.images {
+			if img.Digest == dgst || slices.Contains(img.Digests, dgst) {
+				return img, nil
+			}
+		}
+		return imageRecord{}, fmt.Errorf("%w: image with digest %s", errdefs.ErrNotFound, s)
+	}
+
+	ref, err := name.NewReference(s)
+	if err != nil {
+		return imageRecord{}, fmt.Errorf("invalid reference %s", s)
+	}
+	for imageID, refs := range db.refsByID {
+		for _, r := range refs {
+			if r.String() == ref.String() {
+				img, _ := db.imageByID(imageID)
+				return img, nil
+			}
+		}
+	}
+	return imageRecord{}, fmt.Errorf("%w: no such image %q", errdefs.ErrNotFound, ref.String())
+}
+
+// LayerChain returns the layers from the base layer to the top layer.
+func (db *storeDB) LayerChain(top string) ([]layerRecord, error) {
+	var chain []layerRecord
+	for id := top; id != ""; {
+		layer, ok := db.layers[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: layer %s", errdefs.ErrNotFound, id)
+		}
+		chain = append(chain, layer)
+		id = layer.Parent
+	}
+	slices.Reverse(chain)
+	return chain, nil
+}
+
+func (db *storeDB) imageByID(id string) (imageRecord, bool) {
+	for _, img := range db.images {
+		if img.ID == id {
+			return img, true
+		}
+	}
+	return imageRecord{}, false
+}
+
+func readJSONFile(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
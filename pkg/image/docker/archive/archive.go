@@ -4,7 +4,6 @@
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -19,8 +18,9 @@
 
 	"github.com/wuxler/ruasec/pkg/errdefs"
 	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/image/oci/layout"
 	"github.com/wuxler/ruasec/pkg/ocispec"
-	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
 	_ "github.com/wuxler/ruasec/pkg/ocispec/manifest/all"
 	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
 	"github.com/wuxler/ruasec/pkg/util/xfs/tarfs"
@@ -91,6 +91,7 @@ func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageO
 	if strings.HasPrefix(ref, s.Type()) {
 		ref = strings.TrimPrefix(ref, s.Type()+"://")
 	}
+	options := image.MakeImageOptions(opts...)
 	id, ok := s.manifestDB.LookupImageID(ref)
 	if !ok {
 		return nil, fmt.Errorf("%w: lookup image id with %s", errdefs.ErrNotFound, ref)
@@ -129,6 +130,7 @@ func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageO
 		metadata:        metadata,
 		configFileBytes: configFileBytes,
 		configFile:      configFile,
+		cache:           blobfs.NewCache(options.CacheDir),
 	}
 	return img, nil
 }
@@ -250,12 +252,15 @@ func (db *manifestDB) LookupImageID(ref string) (digest.Digest, bool) {
 
 // NewArchiveFS extends [fs.FS] for docker archive.
 func NewArchiveFS(fsys fs.FS) *ArchiveFS {
-	return &ArchiveFS{FS: fsys}
+	return &ArchiveFS{LayoutFS: layout.NewLayoutFS(fsys)}
 }
 
 // ArchiveFS extends [fs.FS] for docker archive.
+//
+// NOTE: The embedded [layout.LayoutFS] provides the "index.json" and "oci-layout"
+// accessors, which are only available when [ArchiveFS.IsOCILayoutSupport] is true.
 type ArchiveFS struct {
-	fs.FS
+	*layout.LayoutFS
 }
 
 // IsOCILayoutSupport checks if the docker archive is an OCI layout format.
@@ -288,29 +293,3 @@ func (fsys *ArchiveFS) Manifest() ([]Manifest, error) {
 	}
 	return manifests, nil
 }
-
-// IndexJSON reads the "index.json" file from the archive.
-func (fsys *ArchiveFS) IndexJSON() (ocispec.IndexManifest, error) {
-	content, err := fs.ReadFile(fsys, imgspecv1.ImageIndexFile)
-	if err != nil {
-		return nil, err
-	}
-	mf, _, err := manifest.ParseBytes(content)
-	if err != nil {
-		return nil, err
-	}
-	index, ok := mf.(ocispec.IndexManifest)
-	if !ok {
-		return nil, errors.New("index.json is not an index manifest")
-	}
-	return index, nil
-}
-
-// OCILayout reads and returns the "oci-layout" file from the archive.
-func (fsys *ArchiveFS) OCILayout() (*imgspecv1.ImageLayout, error) {
-	var layout imgspecv1.ImageLayout
-	if err := readJSONFile(fsys, imgspecv1.ImageLayoutFile, &layout); err != nil {
-		return nil, err
-	}
-	return &layout, nil
-}
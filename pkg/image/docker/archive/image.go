@@ -12,16 +12,25 @@
 	"github.com/opencontainers/image-spec/identity"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
 	"github.com/wuxler/ruasec/pkg/ocispec"
 	"github.com/wuxler/ruasec/pkg/util/xio"
 	"github.com/wuxler/ruasec/pkg/util/xio/compression"
 )
 
+var (
+	_ ocispec.ImageCloser = (*archiveImage)(nil)
+	_ ocispec.BlobLayer   = (*archiveLayer)(nil)
+	_ ocispec.FSLayer     = (*archiveLayer)(nil)
+	_ blobfs.CacheHolder  = (*archiveLayer)(nil)
+)
+
 type archiveImage struct {
 	archiveFS       fs.FS
 	manifest        Manifest
 	metadata        ocispec.ImageMetadata
 	configFileBytes []byte
+	cache           *blobfs.Cache
 
 	// lazy load fields
 	configFile *imgspecv1.Image
@@ -58,8 +67,13 @@ func (img *archiveImage) Layers(ctx context.Context) ([]ocispec.Layer, error) {
 	return result, nil
 }
 
+// Close releases any resources associated with the image, and removes the
+// spooled layer tarballs.
 func (img *archiveImage) Close() error {
-	return nil
+	if img.cache == nil {
+		return nil
+	}
+	return img.cache.Close()
 }
 
 func (img *archiveImage) loadLayers(ctx context.Context) ([]*archiveLayer, error) {
@@ -116,6 +130,7 @@ func (img *archiveImage) loadLayers(ctx context.Context) ([]*archiveLayer, error
 		}
 		layers[i] = &archiveLayer{
 			archiveFS:  img.archiveFS,
+			cache:      img.cache,
 			path:       layerPath,
 			metadata:   metadata,
 			descriptor: descriptor,
@@ -143,6 +158,7 @@ func (img *archiveImage) parseConfigFile(ctx context.Context) (*imgspecv1.Image,
 
 type archiveLayer struct {
 	archiveFS  fs.FS
+	cache      *blobfs.Cache
 	path       string
 	metadata   ocispec.LayerMetadata
 	descriptor imgspecv1.Descriptor
@@ -199,3 +215,17 @@ func (layer *archiveLayer) Compressed(_ context.Context) (io.ReadCloser, error)
 func (layer *archiveLayer) Uncompressed(ctx context.Context) (io.ReadCloser, error) {
 	return layer.archiveFS.Open(layer.path)
 }
+
+// GetFS returns the filesystem of the uncompressed layer tarball, which is
+// spooled into the temporary file.
+// Implements the [xfs.Getter] interface.
+func (layer *archiveLayer) GetFS(ctx context.Context) (fs.FS, error) {
+	return layer.cache.GetFS(ctx, layer)
+}
+
+// AcquireCache holds a new reference of the cache shared with the image, which
+// keeps the spooled layer tarballs available after the image is closed.
+// Implements the [blobfs.CacheHolder] interface.
+func (layer *archiveLayer) AcquireCache() (io.Closer, error) {
+	return layer.cache.Acquire()
+}
@@ -0,0 +1,109 @@
+package rootfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// v2Metadata is the metadata of the compressed layer blob pulled from the
+// registry, stored in the "v2metadata-by-diffid" distribution database.
+type v2Metadata struct {
+	Digest           digest.Digest `json:"Digest"`
+	SourceRepository string        `json:"SourceRepository"`
+	HMAC             string        `json:"HMAC"`
+}
+
+func newDistributionDB(root pathspec.DriverRoot) *distributionDB {
+	return &distributionDB{
+		DriverRoot: root,
+	}
+}
+
+// distributionDB reads the docker distribution database directory, which maps
+// the layer diffid to the compressed blob digests and the source repositories.
+type distributionDB struct {
+	DriverRoot pathspec.DriverRoot
+}
+
+// GetV2Metadata returns the v2 metadata of the layer with the diffid. The entries
+// can not be mapped back to the diffid by the "diffid-by-digest" database are
+// skipped, and nil is returned when the layer is not pulled from any registry.
+func (db *distributionDB) GetV2Metadata(ctx context.Context, diffid digest.Digest) ([]v2Metadata, error) {
+	content, err := os.ReadFile(db.DriverRoot.DistributionV2MetadataByDiffIDFile(diffid))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var metadatas []v2Metadata
+	if err := json.Unmarshal(content, &metadatas); err != nil {
+		return nil, err
+	}
+	valid := []v2Metadata{}
+	for _, metadata := range metadatas {
+		mapped, err := db.GetDiffID(metadata.Digest)
+		if err != nil {
+			xlog.C(ctx).Warnf("skip, unable to read diffid of layer blob %s: %s", metadata.Digest, err)
+			continue
+		}
+		if mapped != "" && mapped != diffid {
+			xlog.C(ctx).Warnf("skip, mismatch diffid of layer blob %s: %s != %s", metadata.Digest, mapped, diffid)
+			continue
+		}
+		// normalize the source repository the same as the image names
+		if repo, err := name.NewRepository(metadata.SourceRepository); err == nil {
+			metadata.SourceRepository = repo.String()
+		}
+		valid = append(valid, metadata)
+	}
+	return valid, nil
+}
+
+// GetDiffID returns the diffid of the compressed layer blob with the digest, or
+// empty when it is not found.
+func (db *distributionDB) GetDiffID(dgst digest.Digest) (digest.Digest, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(db.DriverRoot.DistributionDiffIDByDigestFile(dgst))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return digest.Parse(strings.TrimSpace(string(content)))
+}
+
+// selectV2Metadata returns the compressed blob digest pulled from the preferred
+// repositories first, and all the source repositories of the digest.
+func selectV2Metadata(metadatas []v2Metadata, preferred []string) (digest.Digest, []string) {
+	if len(metadatas) == 0 {
+		return "", nil
+	}
+	selected := metadatas[0].Digest
+	for _, metadata := range metadatas {
+		if slices.Contains(preferred, metadata.SourceRepository) {
+			selected = metadata.Digest
+			break
+		}
+	}
+	repos := []string{}
+	for _, metadata := range metadatas {
+		if metadata.Digest == selected && !slices.Contains(repos, metadata.SourceRepository) {
+			repos = append(repos, metadata.SourceRepository)
+		}
+	}
+	return selected, repos
+}
@@ -0,0 +1,131 @@
+package rootfs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+)
+
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+}
+
+func TestDistributionDB(t *testing.T) {
+	ctx := context.Background()
+	root := pathspec.DataRoot(t.TempDir()).DriverRoot("overlay2")
+	db := newDistributionDB(root)
+
+	diffid := digest.FromString("layer")
+	otherDiffID := digest.FromString("other layer")
+	pulled := digest.FromString("layer.tar.gz")
+	mismatched := digest.FromString("other.tar.gz")
+	unmapped := digest.FromString("layer.tar.zst")
+	writeFile(t, root.DistributionDiffIDByDigestFile(pulled), []byte(diffid.String()+"\n"))
+	writeFile(t, root.DistributionDiffIDByDigestFile(mismatched), []byte(otherDiffID.String()))
+
+	content, err := json.Marshal([]v2Metadata{
+		{Digest: pulled, SourceRepository: "docker.io/library/app"},
+		{Digest: mismatched, SourceRepository: "docker.io/library/other"},
+		{Digest: pulled, SourceRepository: "registry.example.com/team/app"},
+		{Digest: unmapped, SourceRepository: "registry.example.com/team/zstd"},
+	})
+	require.NoError(t, err)
+	writeFile(t, root.DistributionV2MetadataByDiffIDFile(diffid), content)
+
+	t.Run("v2metadata by diffid", func(t *testing.T) {
+		metadatas, err := db.GetV2Metadata(ctx, diffid)
+		require.NoError(t, err)
+		// the source repositories are normalized the same as the image names
+		assert.Equal(t, []v2Metadata{
+			{Digest: pulled, SourceRepository: "registry-1.docker.io/library/app"},
+			{Digest: pulled, SourceRepository: "registry.example.com/team/app"},
+			{Digest: unmapped, SourceRepository: "registry.example.com/team/zstd"},
+		}, metadatas)
+	})
+
+	t.Run("v2metadata of local layer", func(t *testing.T) {
+		metadatas, err := db.GetV2Metadata(ctx, otherDiffID)
+		require.NoError(t, err)
+		assert.Nil(t, metadatas)
+	})
+
+	t.Run("invalid v2metadata", func(t *testing.T) {
+		invalid := digest.FromString("invalid")
+		writeFile(t, root.DistributionV2MetadataByDiffIDFile(invalid), []byte("[{"))
+		_, err := db.GetV2Metadata(ctx, invalid)
+		assert.Error(t, err)
+	})
+
+	t.Run("diffid by digest", func(t *testing.T) {
+		got, err := db.GetDiffID(pulled)
+		require.NoError(t, err)
+		assert.Equal(t, diffid, got)
+
+		got, err = db.GetDiffID(unmapped)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+
+		_, err = db.GetDiffID("sha256:invalid")
+		assert.Error(t, err)
+	})
+}
+
+func TestSelectV2Metadata(t *testing.T) {
+	gzipped := digest.FromString("layer.tar.gz")
+	zstd := digest.FromString("layer.tar.zst")
+	metadatas := []v2Metadata{
+		{Digest: gzipped, SourceRepository: "docker.io/library/app"},
+		{Digest: zstd, SourceRepository: "registry.example.com/team/app"},
+		{Digest: gzipped, SourceRepository: "registry.example.com/team/mirror"},
+		{Digest: gzipped, SourceRepository: "docker.io/library/app"},
+	}
+
+	testcases := []struct {
+		name      string
+		metadatas []v2Metadata
+		preferred []string
+		wantDgst  digest.Digest
+		wantRepos []string
+	}{
+		{
+			name: "not pulled",
+		},
+		{
+			name:      "first one without preferred",
+			metadatas: metadatas,
+			wantDgst:  gzipped,
+			wantRepos: []string{"docker.io/library/app", "registry.example.com/team/mirror"},
+		},
+		{
+			name:      "preferred repository",
+			metadatas: metadatas,
+			preferred: []string{"registry.example.com/team/app"},
+			wantDgst:  zstd,
+			wantRepos: []string{"registry.example.com/team/app"},
+		},
+		{
+			name:      "preferred repository not found",
+			metadatas: metadatas,
+			preferred: []string{"registry.example.com/team/missing"},
+			wantDgst:  gzipped,
+			wantRepos: []string{"docker.io/library/app", "registry.example.com/team/mirror"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dgst, repos := selectV2Metadata(tc.metadatas, tc.preferred)
+			assert.Equal(t, tc.wantDgst, dgst)
+			assert.Equal(t, tc.wantRepos, repos)
+		})
+	}
+}
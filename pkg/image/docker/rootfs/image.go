@@ -4,18 +4,27 @@
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
 
 	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
 	"github.com/wuxler/ruasec/pkg/ocispec"
 	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers"
 	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
 )
 
-var _ ocispec.ImageCloser = (*rootfsImage)(nil)
+var (
+	_ ocispec.ImageCloser  = (*rootfsImage)(nil)
+	_ ocispec.FSLayer      = (*rootfsLayer)(nil)
+	_ ocispec.Compressor   = (*rootfsLayer)(nil)
+	_ ocispec.Uncompressor = (*rootfsLayer)(nil)
+	_ blobfs.TarSplitter   = (*rootfsLayer)(nil)
+)
 
 // NewImage returns the image speicified by the name ref.
 func NewImage(ctx context.Context, root string, ref string, opts ...image.ImageOption) (ocispec.ImageCloser, error) {
@@ -72,7 +81,13 @@ type rootfsLayer struct {
 	size    int64
 	history *imgspecv1.History
 
+	compressedDigest   digest.Digest
+	sourceRepositories []string
+
+	tarSplitFile string
+
 	driver drivers.Driver
+	blob   *blobfs.FSBlob
 }
 
 // SetHistory sets the history of the layer.
@@ -80,15 +95,27 @@ func (l *rootfsLayer) SetHistory(history *imgspecv1.History) {
 	l.history = history
 }
 
+// SetDistribution sets the compressed blob digest and the source repositories of
+// the layer pulled from the registry.
+func (l *rootfsLayer) SetDistribution(dgst digest.Digest, repos []string) {
+	l.compressedDigest = dgst
+	l.sourceRepositories = repos
+}
+
 // Metadata returns the metadata of the layer.
 func (l *rootfsLayer) Metadata() ocispec.LayerMetadata {
-	return ocispec.LayerMetadata{
-		DiffID:           l.diffid,
-		ChainID:          l.chainid,
-		UncompressedSize: l.size,
-		Parent:           l.parent,
-		History:          l.history,
+	metadata := ocispec.LayerMetadata{
+		DiffID:             l.diffid,
+		ChainID:            l.chainid,
+		UncompressedSize:   l.size,
+		CompressedDigest:   l.compressedDigest,
+		SourceRepositories: l.sourceRepositories,
+		History:            l.history,
+	}
+	if l.parent != nil {
+		metadata.Parent = l.parent
 	}
+	return metadata
 }
 
 // GetFS returns a filesystem.
@@ -96,6 +123,17 @@ func (l *rootfsLayer) GetFS(ctx context.Context) (fs.FS, error) {
 	if l.driver == nil {
 		return nil, errors.New("storage driver is nil")
 	}
+	if differ, ok := l.driver.(drivers.ParentDiffer); ok {
+		parent := ""
+		if l.parent != nil {
+			parent = l.parent.cacheid
+		}
+		getter, err := differ.DiffWithParent(l.cacheid, parent)
+		if err != nil {
+			return nil, err
+		}
+		return getter.GetFS(ctx)
+	}
 	differ, ok := l.driver.(drivers.Differ)
 	if !ok {
 		return nil, fmt.Errorf("storage driver does not implement DifferDriver interface with type %T", l.driver)
@@ -106,3 +144,31 @@ func (l *rootfsLayer) GetFS(ctx context.Context) (fs.FS, error) {
 	}
 	return getter.GetFS(ctx)
 }
+
+// TarSplit returns a reader of the tar-split metadata recorded by docker when the
+// layer is extracted, which reassembles the original layer tarball.
+// Implements the [blobfs.TarSplitter] interface.
+func (l *rootfsLayer) TarSplit(_ context.Context) (io.ReadCloser, error) {
+	return os.Open(l.tarSplitFile)
+}
+
+// Compressed returns a reader of the layer tarball reassembled or reproduced from
+// the filesystem and compressed with gzip.
+// The reader must be closed when reading is finished.
+func (l *rootfsLayer) Compressed(ctx context.Context) (io.ReadCloser, error) {
+	return l.fsBlob().Compressed(ctx)
+}
+
+// Uncompressed returns a reader of the layer tarball reassembled or reproduced from
+// the filesystem.
+// The reader must be closed when reading is finished.
+func (l *rootfsLayer) Uncompressed(ctx context.Context) (io.ReadCloser, error) {
+	return l.fsBlob().Uncompressed(ctx)
+}
+
+func (l *rootfsLayer) fsBlob() *blobfs.FSBlob {
+	if l.blob == nil {
+		l.blob = blobfs.NewFSBlob(l)
+	}
+	return l.blob
+}
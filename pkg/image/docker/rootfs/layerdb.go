@@ -114,6 +114,8 @@ func (db *layerDB) loadLayer(driver drivers.Driver, chainid digest.Digest) (*roo
 		cacheid: cacheid,
 		size:    size,
 		driver:  driver,
+
+		tarSplitFile: db.DriverRoot.LayerMetadataTarSplitFile(chainid),
 	}
 	if parent != "" {
 		parentLayer, err := db.loadLayer(driver, parent)
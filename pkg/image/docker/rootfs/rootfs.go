@@ -29,11 +29,11 @@ func init() {
 
 // NewStorage returns a new storage for the given root directory.
 func NewStorage(ctx context.Context, root string) (*Storage, error) {
-	driverType := dockerdrivers.DetectType(ctx, root)
+	driverType, driverConfig := dockerdrivers.DetectDriver(ctx, root)
 	if driverType == "" {
 		return nil, errors.New("unable to detect storage type")
 	}
-	driver, err := dockerdrivers.New(ctx, root, driverType, dockerdrivers.DriverConfig{})
+	driver, err := dockerdrivers.New(ctx, root, driverType, driverConfig)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create storage: %w", err)
 	}
@@ -45,6 +45,7 @@ func NewStorage(ctx context.Context, root string) (*Storage, error) {
 		imagedb: newImageDB(driverRoot),
 		layerdb: newLayerDB(driverRoot),
 		namedb:  newNameDB(driverRoot),
+		distdb:  newDistributionDB(driverRoot),
 	}
 	return storage, nil
 }
@@ -56,6 +57,7 @@ type Storage struct {
 	imagedb *imageDB
 	layerdb *layerDB
 	namedb  *nameDB
+	distdb  *distributionDB
 }
 
 // Type returns the unique identity type of the provider.
@@ -80,20 +82,23 @@ func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageO
 		ID:   imageid,
 	}
 
-	// load image metadata with aliased names and tags
+	// load image metadata with aliased names and tags, the manifest digest is
+	// taken from the first digested name as docker does not store it elsewhere
 	refs := s.namedb.ReferencesByImageID(imageid)
+	repos := []string{}
 	for _, r := range refs {
 		if _, ok := ocispecname.IsTagged(r); ok {
 			metadata.RepoTags = append(metadata.RepoTags, r.String())
 		}
-		if _, ok := ocispecname.IsDigested(r); ok {
+		if digested, ok := ocispecname.IsDigested(r); ok {
 			metadata.RepoDigests = append(metadata.RepoDigests, r.String())
+			if metadata.Digest == "" {
+				metadata.Digest = digested.Digest()
+			}
 		}
+		repos = append(repos, r.Repository().String())
 	}
 
-	// TODO: load image metadata with Digest from distribution database
-	// directory, like "{DriverRoot}/distribution/"
-
 	// load image config file
 	configBytes, err := s.imagedb.ReadImageConfig(imageid)
 	if err != nil {
@@ -128,6 +133,15 @@ func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageO
 			layer.SetHistory(&histories[i])
 		}
 
+		// load the compressed blob digest and source repositories from the
+		// distribution database, which only exist for the layers pulled
+		v2metadatas, err := s.distdb.GetV2Metadata(ctx, layer.diffid)
+		if err != nil {
+			xlog.C(ctx).Warnf("skip, unable to load distribution metadata of layer %s: %s", layer.diffid, err)
+		} else {
+			layer.SetDistribution(selectV2Metadata(v2metadatas, repos))
+		}
+
 		layers[i] = layer
 	}
 
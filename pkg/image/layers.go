@@ -0,0 +1,129 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/opencontainers/image-spec/identity"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// LayerMetadatas returns the metadata of the layers described by the DiffIDs of
+// the image config and the non-empty layer descriptors of the manifest, ordered
+// from the oldest/base layer to the most-recent/top layer. The parents are left
+// to be linked by the caller.
+func LayerMetadatas(ctx context.Context, config *imgspecv1.Image, descriptors []manifest.LayerDescriptor) ([]ocispec.LayerMetadata, error) {
+	diffids := config.RootFS.DiffIDs
+	if len(diffids) == 0 {
+		return nil, errdefs.Newf(errdefs.ErrUnsupported, "no DiffIDs found in image config")
+	}
+	descriptors = manifest.NonEmptyLayers(descriptors...)
+	if len(diffids) != len(descriptors) {
+		return nil, fmt.Errorf("mismatch length of DiffIDs and Descriptors: %d != %d", len(diffids), len(descriptors))
+	}
+	// it will change the value of inputs in function [identity.ChainIDs], so we need to clone it
+	chainids := identity.ChainIDs(slices.Clone(diffids))
+
+	metadatas := make([]ocispec.LayerMetadata, len(diffids))
+	for i, diffid := range diffids {
+		desc := descriptors[i]
+		metadata := ocispec.LayerMetadata{
+			DiffID:       diffid,
+			ChainID:      chainids[i],
+			IsCompressed: ocispec.IsCompressedBlob(desc.MediaType),
+		}
+		if metadata.IsCompressed {
+			metadata.CompressedDigest = desc.Digest
+			metadata.CompressedSize = desc.Size
+		} else {
+			metadata.UncompressedSize = desc.Size
+		}
+		metadatas[i] = metadata
+	}
+	SetLayerHistories(ctx, metadatas, config)
+	return metadatas, nil
+}
+
+// SetLayerHistories sets the non-empty histories of the image config to the
+// layers in order, which is skipped with a warning when the number of the
+// histories mismatches the layers.
+func SetLayerHistories(ctx context.Context, metadatas []ocispec.LayerMetadata, config *imgspecv1.Image) {
+	histories := []imgspecv1.History{}
+	for _, history := range config.History {
+		if !history.EmptyLayer {
+			histories = append(histories, history)
+		}
+	}
+	if len(metadatas) != len(histories) {
+		xlog.C(ctx).Warnf("skip, mismatch length of layers and non-empty hisotries: %d != %d", len(metadatas), len(histories))
+		return
+	}
+	for i := range metadatas {
+		metadatas[i].History = &histories[i]
+	}
+}
+
+// CompressBlob returns a reader of the layer blob compressed in the format of
+// the media type, or the blob itself when it is compressed already. The blob is
+// closed with the reader returned.
+func CompressBlob(rc io.ReadCloser, mediaType string) (io.ReadCloser, error) {
+	if ocispec.IsCompressedBlob(mediaType) {
+		return rc, nil
+	}
+
+	format, err := ocispec.CompressionFormatFromMediaType(mediaType)
+	if err != nil {
+		xio.CloseAndSkipError(rc)
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	compressor, err := format.Compress(pw)
+	if err != nil {
+		xio.CloseAndSkipError(xio.MultiClosers(pw, pr, rc))
+		return nil, err
+	}
+
+	// goroutine returns err so we can pw.CloseWithError(err)
+	go func() error {
+		defer xio.CloseAndSkipError(rc)
+		if _, err := io.Copy(compressor, rc); err != nil {
+			defer xio.CloseAndSkipError(compressor)
+			return pw.CloseWithError(err)
+		}
+		// close compressor writer to flush it and write trailers
+		if err := compressor.Close(); err != nil {
+			return pw.CloseWithError(err)
+		}
+		return pw.Close()
+	}() //nolint:errcheck // we don't care about the error here
+
+	return pr, nil
+}
+
+// UncompressBlob returns a reader of the layer blob uncompressed in the format
+// detected. The blob is closed with the reader returned.
+func UncompressBlob(rc io.ReadCloser) (io.ReadCloser, error) {
+	format, reader, err := compression.DetectReader(rc)
+	if err != nil {
+		xio.CloseAndSkipError(rc)
+		return nil, err
+	}
+
+	uncompressor, err := format.Uncompress(reader)
+	if err != nil {
+		xio.CloseAndSkipError(rc)
+		return nil, err
+	}
+
+	return xio.WrapReader(uncompressor, xio.MultiClosers(uncompressor, rc).Close), nil
+}
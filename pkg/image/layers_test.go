@@ -0,0 +1,123 @@
+package image_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+)
+
+func TestLayerMetadatas(t *testing.T) {
+	ctx := context.Background()
+	diffids := []digest.Digest{digest.FromString("base"), digest.FromString("top")}
+	config := &imgspecv1.Image{
+		RootFS: imgspecv1.RootFS{Type: "layers", DiffIDs: diffids},
+		History: []imgspecv1.History{
+			{CreatedBy: "ADD rootfs"},
+			{CreatedBy: "ENV A=B", EmptyLayer: true},
+			{CreatedBy: "RUN make"},
+		},
+	}
+	descriptors := []manifest.LayerDescriptor{
+		{Descriptor: imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayerGzip, Digest: digest.FromString("gzip"), Size: 10}},
+		{Descriptor: imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer}, Empty: true},
+		{Descriptor: imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Digest: diffids[1], Size: 20}},
+	}
+
+	metadatas, err := image.LayerMetadatas(ctx, config, descriptors)
+	require.NoError(t, err)
+	require.Len(t, metadatas, 2)
+	chainids := identity.ChainIDs([]digest.Digest{diffids[0], diffids[1]})
+
+	assert.Equal(t, diffids[0], metadatas[0].DiffID)
+	assert.Equal(t, chainids[0], metadatas[0].ChainID)
+	assert.True(t, metadatas[0].IsCompressed)
+	assert.Equal(t, digest.FromString("gzip"), metadatas[0].CompressedDigest)
+	assert.Equal(t, int64(10), metadatas[0].CompressedSize)
+	assert.Equal(t, "ADD rootfs", metadatas[0].History.CreatedBy)
+
+	assert.Equal(t, chainids[1], metadatas[1].ChainID)
+	assert.False(t, metadatas[1].IsCompressed)
+	assert.Empty(t, metadatas[1].CompressedDigest)
+	assert.Equal(t, int64(20), metadatas[1].UncompressedSize)
+	assert.Equal(t, "RUN make", metadatas[1].History.CreatedBy)
+	assert.Nil(t, metadatas[1].Parent)
+	// the DiffIDs of the config are not changed by computing the ChainIDs
+	assert.Equal(t, digest.FromString("top"), config.RootFS.DiffIDs[1])
+
+	t.Run("mismatched histories", func(t *testing.T) {
+		config := &imgspecv1.Image{RootFS: config.RootFS, History: config.History[:1]}
+		metadatas, err := image.LayerMetadatas(ctx, config, descriptors)
+		require.NoError(t, err)
+		for _, metadata := range metadatas {
+			assert.Nil(t, metadata.History)
+		}
+	})
+
+	t.Run("mismatched descriptors", func(t *testing.T) {
+		_, err := image.LayerMetadatas(ctx, config, descriptors[:1])
+		assert.ErrorContains(t, err, "mismatch length of DiffIDs and Descriptors")
+	})
+
+	t.Run("no DiffIDs", func(t *testing.T) {
+		_, err := image.LayerMetadatas(ctx, &imgspecv1.Image{}, descriptors)
+		assert.ErrorIs(t, err, errdefs.ErrUnsupported)
+	})
+}
+
+func TestCompressBlob(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644, Size: 4}))
+	_, err := tw.Write([]byte("file"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	content := buf.Bytes()
+
+	compressed := &bytes.Buffer{}
+	gw := gzip.NewWriter(compressed)
+	_, err = gw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	testcases := []struct {
+		name      string
+		blob      []byte
+		mediaType string
+	}{
+		{name: "uncompressed", blob: content, mediaType: imgspecv1.MediaTypeImageLayer},
+		{name: "compressed", blob: compressed.Bytes(), mediaType: imgspecv1.MediaTypeImageLayerGzip},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rc, err := image.CompressBlob(io.NopCloser(bytes.NewReader(tc.blob)), tc.mediaType)
+			require.NoError(t, err)
+			got, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			assert.Equal(t, tc.blob, got)
+
+			rc, err = image.UncompressBlob(io.NopCloser(bytes.NewReader(tc.blob)))
+			require.NoError(t, err)
+			got, err = io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			assert.Equal(t, content, got)
+		})
+	}
+
+	_, err = image.CompressBlob(io.NopCloser(bytes.NewReader(content)), "application/unknown")
+	assert.Error(t, err)
+}
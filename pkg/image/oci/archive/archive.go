@@ -0,0 +1,149 @@
+// Package archive provides an oci-archive storage implementation.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/oci/layout"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xfs/tarfs"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	_ "github.com/wuxler/ruasec/pkg/util/xio/compression/builtin"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression/tar"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+var _ image.Storage = (*Storage)(nil)
+
+func init() {
+	ocispecname.RegisterScheme(image.StorageTypeOCIArchive)
+}
+
+// NewStorageFromFile creates a new Storage from an OCI layout tarball file with
+// the default config.
+func NewStorageFromFile(ctx context.Context, path string) (*Storage, error) {
+	return NewStorageFromFileWithConfig(ctx, path, DefaultConfig())
+}
+
+// NewStorageFromFileWithConfig creates a new Storage from an OCI layout tarball
+// file with the given config. The tarball file may be compressed, in which case
+// it is uncompressed into the cache directory first.
+func NewStorageFromFileWithConfig(ctx context.Context, path string, config Config) (*Storage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	format, _, err := compression.DetectReader(file)
+	if err != nil {
+		xio.CloseAndSkipError(file)
+		return nil, fmt.Errorf("unable to detect compression format of %s: %w", path, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		xio.CloseAndSkipError(file)
+		return nil, err
+	}
+
+	s := &Storage{}
+	if format.Name() != tar.FormatName {
+		xlog.C(ctx).Debugf("oci archive %s is compressed as %s, uncompressing ...", path, format.Name())
+		uncompressed, err := uncompressToTemp(ctx, file, format, config.CacheDir)
+		xio.CloseAndSkipError(file)
+		if err != nil {
+			return nil, err
+		}
+		file = uncompressed
+		s.tempFile = uncompressed.Name()
+	}
+	s.file = file
+
+	fsys, err := tarfs.New(ctx, file)
+	if err != nil {
+		xio.CloseAndSkipError(s)
+		return nil, err
+	}
+	s.layout, err = layout.NewStorage(ctx, fsys)
+	if err != nil {
+		xio.CloseAndSkipError(s)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Storage is a image storage implementation for OCI image layout tarball, which
+// is produced by tools like "buildah push", "skopeo copy" and "kaniko".
+//
+// More to see: https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+type Storage struct {
+	layout   *layout.Storage
+	file     *os.File
+	tempFile string
+}
+
+// Type returns the unique identity type of the provider.
+func (s *Storage) Type() string {
+	return image.StorageTypeOCIArchive
+}
+
+// GetImage returns the image specified by ref. The ref resolution rules are the
+// same as [layout.Storage.GetImage].
+//
+// NOTE: The image must be closed when processing is finished.
+func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageOption) (ocispec.ImageCloser, error) {
+	if strings.HasPrefix(ref, s.Type()) {
+		ref = strings.TrimPrefix(ref, s.Type()+"://")
+	}
+	return s.layout.GetImage(ctx, ref, opts...)
+}
+
+// Close closes the tarball file and removes the uncompressed temporary file if
+// exists.
+func (s *Storage) Close() error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
+	}
+	if s.tempFile != "" {
+		if err := os.Remove(s.tempFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.tempFile = ""
+	}
+	return nil
+}
+
+func uncompressToTemp(ctx context.Context, r io.Reader, format compression.Format, cacheDir string) (*os.File, error) {
+	uncompressor, err := format.Uncompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer xio.CloseAndSkipError(uncompressor)
+
+	file, err := xos.NewTemper(cacheDir).CreateTemp("oci-archive-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if _, err := io.Copy(file, uncompressor); err != nil {
+		xio.CloseAndSkipError(file)
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		xio.CloseAndSkipError(file)
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	xlog.C(ctx).Debugf("uncompressed oci archive to %s, elapsed %s", file.Name(), time.Since(start).Round(time.Millisecond))
+	return file, nil
+}
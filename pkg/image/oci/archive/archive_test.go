@@ -0,0 +1,150 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/image/oci/archive"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression/gzip"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression/zstd"
+)
+
+// buildLayoutTar builds the OCI layout tarball of one image tagged with ref, and
+// returns the manifest digest.
+func buildLayoutTar(t *testing.T, ref string) ([]byte, digest.Digest) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	writeFile := func(name string, content []byte) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write(content)
+		require.NoError(t, err)
+	}
+	writeJSON := func(v any) imgspecv1.Descriptor {
+		content, err := json.Marshal(v)
+		require.NoError(t, err)
+		dgst := digest.FromBytes(content)
+		writeFile("blobs/sha256/"+dgst.Encoded(), content)
+		return imgspecv1.Descriptor{Digest: dgst, Size: int64(len(content))}
+	}
+
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "blobs/", Mode: 0o755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "blobs/sha256/", Mode: 0o755}))
+	layer := []byte("layer")
+	layerDigest := digest.FromBytes(layer)
+	writeFile("blobs/sha256/"+layerDigest.Encoded(), layer)
+	config := writeJSON(imgspecv1.Image{
+		Platform: imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+		RootFS:   imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{layerDigest}},
+	})
+	config.MediaType = imgspecv1.MediaTypeImageConfig
+	manifest := writeJSON(imgspecv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    config,
+		Layers: []imgspecv1.Descriptor{
+			{MediaType: imgspecv1.MediaTypeImageLayer, Digest: layerDigest, Size: int64(len(layer))},
+		},
+	})
+	manifest.MediaType = imgspecv1.MediaTypeImageManifest
+	manifest.Annotations = map[string]string{imgspecv1.AnnotationRefName: ref}
+
+	content, err := json.Marshal(imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion})
+	require.NoError(t, err)
+	writeFile(imgspecv1.ImageLayoutFile, content)
+	content, err = json.Marshal(imgspecv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{manifest},
+	})
+	require.NoError(t, err)
+	writeFile(imgspecv1.ImageIndexFile, content)
+	require.NoError(t, tw.Close())
+	return buf.Bytes(), manifest.Digest
+}
+
+func compress(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+	if name == "" {
+		return data
+	}
+	buf := &bytes.Buffer{}
+	w, err := compression.MustGetFormat(name).Compress(buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestNewStorageFromFile(t *testing.T) {
+	ctx := context.Background()
+	ref := "registry.example.com/app:v1"
+	data, manifestDigest := buildLayoutTar(t, ref)
+
+	testcases := []struct {
+		name     string
+		format   string
+		wantTemp bool
+	}{
+		{name: "plain"},
+		{name: "gzip", format: gzip.FormatName, wantTemp: true},
+		{name: "zstd", format: zstd.FormatName, wantTemp: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "archive.tar")
+			require.NoError(t, os.WriteFile(path, compress(t, data, tc.format), 0o644))
+			cacheDir := t.TempDir()
+
+			storage, err := archive.NewStorageFromFileWithConfig(ctx, path, archive.Config{CacheDir: cacheDir})
+			require.NoError(t, err)
+			entries, err := os.ReadDir(cacheDir)
+			require.NoError(t, err)
+			if tc.wantTemp {
+				assert.Len(t, entries, 1)
+			} else {
+				assert.Empty(t, entries)
+			}
+
+			for _, r := range []string{ref, "oci-archive://" + ref, manifestDigest.String()} {
+				img, err := storage.GetImage(ctx, r)
+				require.NoError(t, err, r)
+				assert.Equal(t, manifestDigest, img.Metadata().Digest, r)
+				require.NoError(t, img.Close())
+			}
+
+			// the uncompressed temporary file is removed on close
+			require.NoError(t, storage.Close())
+			require.NoError(t, storage.Close())
+			entries, err = os.ReadDir(cacheDir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+
+	t.Run("invalid layout", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "archive.tar.gz")
+		buf := &bytes.Buffer{}
+		require.NoError(t, tar.NewWriter(buf).Close())
+		require.NoError(t, os.WriteFile(path, compress(t, buf.Bytes(), gzip.FormatName), 0o644))
+		cacheDir := t.TempDir()
+		_, err := archive.NewStorageFromFileWithConfig(ctx, path, archive.Config{CacheDir: cacheDir})
+		assert.Error(t, err)
+		entries, err := os.ReadDir(cacheDir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
@@ -0,0 +1,19 @@
+package archive
+
+import (
+	"os"
+)
+
+// Config is the configuration for the oci-archive storage.
+type Config struct {
+	// CacheDir is the directory to hold the uncompressed tarball when the archive
+	// file is compressed.
+	CacheDir string
+}
+
+// DefaultConfig returns the default configuration for the oci-archive storage.
+func DefaultConfig() Config {
+	return Config{
+		CacheDir: os.TempDir(),
+	}
+}
@@ -0,0 +1,176 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+var (
+	_ ocispec.ImageCloser = (*layoutImage)(nil)
+	_ ocispec.BlobLayer   = (*layoutLayer)(nil)
+	_ ocispec.FSLayer     = (*layoutLayer)(nil)
+	_ blobfs.CacheHolder  = (*layoutLayer)(nil)
+)
+
+type layoutImage struct {
+	layoutFS   *LayoutFS
+	manifest   manifest.ImageManifest
+	descriptor imgspecv1.Descriptor
+	metadata   ocispec.ImageMetadata
+	cache      *blobfs.Cache
+
+	// lazy initialized and cached properties
+	configFileContent []byte
+	layers            []*layoutLayer
+}
+
+// Metadata returns the metadata of the image.
+func (img *layoutImage) Metadata() ocispec.ImageMetadata {
+	return img.metadata
+}
+
+// ConfigFile returns the image config file bytes.
+func (img *layoutImage) ConfigFile(_ context.Context) ([]byte, error) {
+	if img.configFileContent != nil {
+		return img.configFileContent, nil
+	}
+
+	desc := img.manifest.Config()
+	rc, err := img.layoutFS.OpenBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	content, err := io.ReadAll(cas.NewReadCloser(rc, desc))
+	if err != nil {
+		return nil, err
+	}
+	img.configFileContent = content
+	return img.configFileContent, nil
+}
+
+// Layers returns a list of layer objects contained in the current image in order.
+// The list order is from the oldest/base layer to the most-recent/top layer.
+func (img *layoutImage) Layers(ctx context.Context) ([]ocispec.Layer, error) {
+	if img.layers != nil {
+		return toLayers(img.layers), nil
+	}
+
+	configFile, err := img.ConfigFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := &imgspecv1.Image{}
+	if err := json.Unmarshal(configFile, config); err != nil {
+		return nil, err
+	}
+
+	metadatas, err := image.LayerMetadatas(ctx, config, img.manifest.Layers())
+	if err != nil {
+		return nil, err
+	}
+	var parent *layoutLayer
+	layers := make([]*layoutLayer, len(metadatas))
+	for i, desc := range manifest.NonEmptyLayers(img.manifest.Layers()...) {
+		current := &layoutLayer{
+			layoutFS:   img.layoutFS,
+			cache:      img.cache,
+			metadata:   metadatas[i],
+			descriptor: desc.Descriptor,
+		}
+		// NOTE: avoid the typed nil pointer of the parent layer
+		if parent != nil {
+			current.metadata.Parent = parent
+		}
+		parent = current
+		layers[i] = current
+	}
+
+	img.layers = layers
+	return toLayers(img.layers), nil
+}
+
+// Close releases any resources associated with the image, and removes the
+// spooled layer tarballs.
+func (img *layoutImage) Close() error {
+	if img.cache == nil {
+		return nil
+	}
+	return img.cache.Close()
+}
+
+// Descriptor returns the descriptor for the resource.
+func (img *layoutImage) Descriptor() imgspecv1.Descriptor {
+	return img.descriptor
+}
+
+type layoutLayer struct {
+	layoutFS   *LayoutFS
+	cache      *blobfs.Cache
+	metadata   ocispec.LayerMetadata
+	descriptor imgspecv1.Descriptor
+}
+
+// Metadata returns the metadata of the layer.
+func (layer *layoutLayer) Metadata() ocispec.LayerMetadata {
+	return layer.metadata
+}
+
+// Descriptor returns the descriptor for the resource.
+func (layer *layoutLayer) Descriptor() imgspecv1.Descriptor {
+	return layer.descriptor
+}
+
+// Compressed returns a reader that compressed what is read.
+// The reader must be closed when reading is finished.
+func (layer *layoutLayer) Compressed(_ context.Context) (io.ReadCloser, error) {
+	rc, err := layer.layoutFS.OpenBlob(layer.descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return image.CompressBlob(rc, layer.descriptor.MediaType)
+}
+
+// Uncompressed returns a reader that uncompresses what is read.
+// The reader must be closed when reading is finished.
+func (layer *layoutLayer) Uncompressed(_ context.Context) (io.ReadCloser, error) {
+	rc, err := layer.layoutFS.OpenBlob(layer.descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return image.UncompressBlob(rc)
+}
+
+// GetFS returns the filesystem of the uncompressed layer tarball, which is
+// spooled into the temporary file.
+// Implements the [xfs.Getter] interface.
+func (layer *layoutLayer) GetFS(ctx context.Context) (fs.FS, error) {
+	return layer.cache.GetFS(ctx, layer)
+}
+
+// AcquireCache holds a new reference of the cache shared with the image, which
+// keeps the spooled layer tarballs available after the image is closed.
+// Implements the [blobfs.CacheHolder] interface.
+func (layer *layoutLayer) AcquireCache() (io.Closer, error) {
+	return layer.cache.Acquire()
+}
+
+func toLayers(layers []*layoutLayer) []ocispec.Layer {
+	result := make([]ocispec.Layer, len(layers))
+	for i, layer := range layers {
+		result[i] = layer
+	}
+	return result
+}
@@ -0,0 +1,354 @@
+// Package layout provides an oci-layout storage implementation.
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	_ "github.com/wuxler/ruasec/pkg/ocispec/manifest/all"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+const (
+	// AnnotationContainerdImageName is the annotation key set by containerd (and
+	// docker since v25) with the full image name on the descriptors in "index.json".
+	AnnotationContainerdImageName = "io.containerd.image.name"
+)
+
+var (
+	_ image.Storage            = (*Storage)(nil)
+	_ manifest.ManifestFetcher = (*Storage)(nil)
+)
+
+func init() {
+	ocispecname.RegisterScheme(image.StorageTypeOCILayout)
+}
+
+// NewStorageFromDir creates a new Storage from an OCI image layout directory.
+func NewStorageFromDir(ctx context.Context, dir string) (*Storage, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errdefs.Newf(errdefs.ErrInvalidParameter, "oci layout path %s is not a directory", dir)
+	}
+	return NewStorage(ctx, os.DirFS(dir))
+}
+
+// NewStorage returns a new image storage with the given OCI image layout filesystem.
+func NewStorage(ctx context.Context, fsys fs.FS) (*Storage, error) {
+	layoutFS := NewLayoutFS(fsys)
+	layout, err := layoutFS.OCILayout()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q file: %w", imgspecv1.ImageLayoutFile, err)
+	}
+	if layout.Version != imgspecv1.ImageLayoutVersion {
+		xlog.C(ctx).Warnf("unexpected oci layout version %q, expected %q", layout.Version, imgspecv1.ImageLayoutVersion)
+	}
+	index, err := layoutFS.IndexJSON()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q file: %w", imgspecv1.ImageIndexFile, err)
+	}
+	return &Storage{
+		layoutFS: layoutFS,
+		index:    index,
+	}, nil
+}
+
+// Storage is a image storage implementation for OCI image layout.
+//
+// More to see: https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+type Storage struct {
+	layoutFS *LayoutFS
+	index    ocispec.IndexManifest
+}
+
+// Type returns the unique identity type of the provider.
+func (s *Storage) Type() string {
+	return image.StorageTypeOCILayout
+}
+
+// GetImage returns the image specified by ref. The ref is matched against the
+// "org.opencontainers.image.ref.name" annotation of the descriptors in "index.json",
+// and can also be the digest of the manifest descriptor.
+//
+// NOTE: The image must be closed when processing is finished.
+func (s *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageOption) (ocispec.ImageCloser, error) {
+	if strings.HasPrefix(ref, s.Type()) {
+		ref = strings.TrimPrefix(ref, s.Type()+"://")
+	}
+	options := image.MakeImageOptions(opts...)
+
+	desc, err := s.resolve(ref, options)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := s.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	mf, _, err := manifest.ParseCASReader(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	// select the manifest and descriptor of the target image
+	selectedManifest, selectedDesc, err := manifest.SelectImageManifest(
+		ctx, s, mf, desc, options.DescriptorMatchers()...)
+	if err != nil {
+		return nil, err
+	}
+	if mt := selectedManifest.MediaType(); ocispec.IsDockerSchema1Manifest(mt) {
+		return nil, errdefs.Newf(errdefs.ErrUnsupported, "docker scheme1 manifest %q is unsupported", mt)
+	}
+	if selectedDesc.Platform == nil {
+		selectedDesc.Platform = desc.Platform
+	}
+
+	// create the image metadata
+	metadata := ocispec.ImageMetadata{
+		ID:       selectedManifest.Config().Digest,
+		Digest:   selectedDesc.Digest,
+		Name:     ref,
+		Platform: selectedDesc.Platform,
+	}
+	if _, isIndexManifest := mf.(ocispec.IndexManifest); isIndexManifest {
+		metadata.IndexDigest = desc.Digest
+	}
+
+	// check if layers of the image is compressed and set image compressed/uncompressed size
+	isCompressed := false
+	layers := selectedManifest.Layers()
+	for i := range layers {
+		if !layers[i].Empty && ocispec.IsCompressedBlob(layers[i].MediaType) {
+			isCompressed = true
+		}
+	}
+	metadata.IsCompressed = isCompressed
+	size := manifest.ImageSize(selectedManifest)
+	if isCompressed {
+		metadata.CompressedSize = size
+	} else {
+		metadata.UncompressedSize = size
+	}
+
+	if named, ok := imageName(desc); ok {
+		metadata.RepoDigests = append(metadata.RepoDigests,
+			ocispecname.MustWithDigest(named.Repository(), metadata.Digest).String())
+		if tagged, ok := ocispecname.IsTagged(named); ok {
+			metadata.RepoTags = append(metadata.RepoTags,
+				ocispecname.MustWithTag(named.Repository(), tagged.Tag()).String())
+		}
+	}
+	options.ApplyMetadata(&metadata)
+
+	img := &layoutImage{
+		layoutFS:   s.layoutFS,
+		manifest:   selectedManifest,
+		descriptor: selectedDesc,
+		metadata:   metadata,
+		cache:      blobfs.NewCache(options.CacheDir),
+	}
+	return img, nil
+}
+
+// Fetch fetches the content for the given descriptor from the blobs directory.
+func (s *Storage) Fetch(_ context.Context, desc imgspecv1.Descriptor) (cas.ReadCloser, error) {
+	rc, err := s.layoutFS.OpenBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return cas.NewReadCloser(rc, desc), nil
+}
+
+// Close closes the storage and releases resources.
+func (s *Storage) Close() error {
+	return nil
+}
+
+// resolve returns the descriptor in "index.json" which matches the ref.
+func (s *Storage) resolve(ref string, options *image.ImageOptions) (imgspecv1.Descriptor, error) {
+	var zero imgspecv1.Descriptor
+	descriptors := s.index.Manifests()
+	if len(descriptors) == 0 {
+		return zero, fmt.Errorf("%w: no manifests found in %s", errdefs.ErrNotFound, imgspecv1.ImageIndexFile)
+	}
+
+	var candidates []imgspecv1.Descriptor
+	switch {
+	case ref == "":
+		// the ref can be omitted only when the layout holds a single image
+		candidates = descriptors
+	default:
+		candidates = matchDescriptors(ref, descriptors)
+	}
+	if len(candidates) == 0 {
+		return zero, fmt.Errorf("%w: lookup image with %s", errdefs.ErrNotFound, ref)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	// multiple candidates found, which means the platform specific manifests are
+	// referenced directly by "index.json"
+	for _, matcher := range options.DescriptorMatchers() {
+		if desc, found := matcher(candidates...); found {
+			return desc, nil
+		}
+	}
+	return zero, errdefs.Newf(errdefs.ErrInvalidParameter,
+		"ambiguous image reference %q, %d manifests matched", ref, len(candidates))
+}
+
+// matchDescriptors returns the descriptors matched with the ref by the annotations
+// or the digest.
+func matchDescriptors(ref string, descriptors []imgspecv1.Descriptor) []imgspecv1.Descriptor {
+	var normalized string
+	if named, err := ocispecname.NewReference(ref); err == nil {
+		normalized = named.String()
+	}
+	dgst, err := digest.Parse(ref)
+	if err != nil {
+		dgst = ""
+	}
+
+	var matched []imgspecv1.Descriptor
+	for _, desc := range descriptors {
+		if dgst != "" && desc.Digest == dgst {
+			matched = append(matched, desc)
+			continue
+		}
+		for _, key := range []string{imgspecv1.AnnotationRefName, AnnotationContainerdImageName} {
+			value, ok := desc.Annotations[key]
+			if !ok || value == "" {
+				continue
+			}
+			if value == ref {
+				matched = append(matched, desc)
+				break
+			}
+			if normalized == "" {
+				continue
+			}
+			if named, err := ocispecname.NewReference(value); err == nil && named.String() == normalized {
+				matched = append(matched, desc)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// imageName returns the full image name annotated on the descriptor if exists.
+func imageName(desc imgspecv1.Descriptor) (ocispecname.Reference, bool) {
+	for _, key := range []string{AnnotationContainerdImageName, imgspecv1.AnnotationRefName} {
+		value, ok := desc.Annotations[key]
+		if !ok || value == "" {
+			continue
+		}
+		// NOTE: "org.opencontainers.image.ref.name" may be a tag only like "latest"
+		// which is not an image name at all.
+		if !strings.ContainsAny(value, ":/@") {
+			continue
+		}
+		named, err := ocispecname.NewReference(value)
+		if err != nil {
+			continue
+		}
+		return named, true
+	}
+	return nil, false
+}
+
+// NewLayoutFS extends [fs.FS] for OCI image layout.
+func NewLayoutFS(fsys fs.FS) *LayoutFS {
+	return &LayoutFS{FS: fsys}
+}
+
+// LayoutFS extends [fs.FS] for OCI image layout.
+type LayoutFS struct {
+	fs.FS
+}
+
+// IndexJSON reads the "index.json" file from the layout.
+func (fsys *LayoutFS) IndexJSON() (ocispec.IndexManifest, error) {
+	content, err := fs.ReadFile(fsys, imgspecv1.ImageIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	mf, _, err := manifest.ParseBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := mf.(ocispec.IndexManifest)
+	if !ok {
+		return nil, errors.New("index.json is not an index manifest")
+	}
+	return index, nil
+}
+
+// OCILayout reads and returns the "oci-layout" file from the layout.
+func (fsys *LayoutFS) OCILayout() (*imgspecv1.ImageLayout, error) {
+	content, err := fs.ReadFile(fsys, imgspecv1.ImageLayoutFile)
+	if err != nil {
+		return nil, err
+	}
+	var layout imgspecv1.ImageLayout
+	if err := json.Unmarshal(content, &layout); err != nil {
+		return nil, err
+	}
+	return &layout, nil
+}
+
+// OpenBlob opens the blob file with the digest in the layout.
+func (fsys *LayoutFS) OpenBlob(dgst digest.Digest) (fs.File, error) {
+	p, err := BlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fsys.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: blob %s", errdefs.ErrNotFound, dgst)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// StatBlob returns the [fs.FileInfo] of the blob file with the digest in the layout.
+func (fsys *LayoutFS) StatBlob(dgst digest.Digest) (fs.FileInfo, error) {
+	p, err := BlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(fsys, p)
+}
+
+// BlobPath returns the path to the blob file relative to the layout root as
+// "blobs/<alg>/<encoded>".
+func BlobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", fmt.Errorf("%w: %w", errdefs.ErrInvalidParameter, err)
+	}
+	return imgspecv1.ImageBlobsDir + "/" + dgst.Algorithm().String() + "/" + dgst.Encoded(), nil
+}
@@ -0,0 +1,186 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image"
+)
+
+var (
+	amd64 = &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 = &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}
+)
+
+func newDescriptor(name string, platform *imgspecv1.Platform, annotations map[string]string) imgspecv1.Descriptor {
+	return imgspecv1.Descriptor{
+		MediaType:   imgspecv1.MediaTypeImageManifest,
+		Digest:      digest.FromString(name),
+		Size:        int64(len(name)),
+		Platform:    platform,
+		Annotations: annotations,
+	}
+}
+
+// newMapStorage returns the storage of the in-memory layout with only the
+// "oci-layout" and "index.json" files, which is enough to resolve the refs.
+func newMapStorage(t *testing.T, descriptors ...imgspecv1.Descriptor) *Storage {
+	t.Helper()
+	layout, err := json.Marshal(imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion})
+	require.NoError(t, err)
+	index, err := json.Marshal(imgspecv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: descriptors,
+	})
+	require.NoError(t, err)
+	storage, err := NewStorage(context.Background(), fstest.MapFS{
+		imgspecv1.ImageLayoutFile: &fstest.MapFile{Data: layout},
+		imgspecv1.ImageIndexFile:  &fstest.MapFile{Data: index},
+	})
+	require.NoError(t, err)
+	return storage
+}
+
+func TestStorage_resolve(t *testing.T) {
+	app := newDescriptor("app", amd64, map[string]string{
+		imgspecv1.AnnotationRefName: "registry.example.com/app:v1",
+	})
+	nginx := newDescriptor("nginx", amd64, map[string]string{
+		imgspecv1.AnnotationRefName:   "latest",
+		AnnotationContainerdImageName: "docker.io/library/nginx:latest",
+	})
+	multiAMD64 := newDescriptor("multi-amd64", amd64, map[string]string{
+		imgspecv1.AnnotationRefName: "registry.example.com/multi:v1",
+	})
+	multiARM64 := newDescriptor("multi-arm64", arm64, map[string]string{
+		imgspecv1.AnnotationRefName: "registry.example.com/multi:v1",
+	})
+	ambiguous := []imgspecv1.Descriptor{
+		newDescriptor("ambiguous-1", nil, map[string]string{imgspecv1.AnnotationRefName: "registry.example.com/ambiguous:v1"}),
+		newDescriptor("ambiguous-2", nil, map[string]string{imgspecv1.AnnotationRefName: "registry.example.com/ambiguous:v1"}),
+	}
+	storage := newMapStorage(t, append([]imgspecv1.Descriptor{app, nginx, multiAMD64, multiARM64}, ambiguous...)...)
+
+	testcases := []struct {
+		name    string
+		ref     string
+		opts    []image.ImageOption
+		want    imgspecv1.Descriptor
+		wantErr error
+	}{
+		{name: "ref name", ref: "registry.example.com/app:v1", want: app},
+		{name: "digest", ref: app.Digest.String(), want: app},
+		{name: "containerd image name", ref: "docker.io/library/nginx:latest", want: nginx},
+		{name: "normalized containerd image name", ref: "nginx", want: nginx},
+		{name: "bare tag ref name", ref: "latest", want: nginx},
+		{name: "platform", ref: "registry.example.com/multi:v1", opts: []image.ImageOption{image.WithPlatform(arm64)}, want: multiARM64},
+		{name: "instance digest", ref: "registry.example.com/multi:v1", opts: []image.ImageOption{image.WithInstanceDigest(multiAMD64.Digest)}, want: multiAMD64},
+		{name: "ambiguous", ref: "registry.example.com/ambiguous:v1", wantErr: errdefs.ErrInvalidParameter},
+		{name: "not found", ref: "registry.example.com/missing:v1", wantErr: errdefs.ErrNotFound},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := storage.resolve(tc.ref, image.MakeImageOptions(tc.opts...))
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("single image without ref", func(t *testing.T) {
+		got, err := newMapStorage(t, app).resolve("", image.MakeImageOptions())
+		require.NoError(t, err)
+		assert.Equal(t, app, got)
+	})
+
+	t.Run("empty index", func(t *testing.T) {
+		_, err := newMapStorage(t).resolve("", image.MakeImageOptions())
+		assert.ErrorIs(t, err, errdefs.ErrNotFound)
+	})
+}
+
+func TestMatchDescriptors(t *testing.T) {
+	tagged := newDescriptor("tagged", nil, map[string]string{imgspecv1.AnnotationRefName: "v1"})
+	named := newDescriptor("named", nil, map[string]string{imgspecv1.AnnotationRefName: "registry.example.com/app:v1"})
+	containerd := newDescriptor("containerd", nil, map[string]string{AnnotationContainerdImageName: "registry.example.com/app:v1"})
+	descriptors := []imgspecv1.Descriptor{tagged, named, containerd}
+
+	testcases := []struct {
+		name string
+		ref  string
+		want []imgspecv1.Descriptor
+	}{
+		{name: "both annotations", ref: "registry.example.com/app:v1", want: []imgspecv1.Descriptor{named, containerd}},
+		{name: "bare tag", ref: "v1", want: []imgspecv1.Descriptor{tagged}},
+		{name: "digest", ref: containerd.Digest.String(), want: []imgspecv1.Descriptor{containerd}},
+		{name: "not matched", ref: "registry.example.com/app:v2"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, matchDescriptors(tc.ref, descriptors))
+		})
+	}
+}
+
+func TestImageName(t *testing.T) {
+	testcases := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			name:        "ref name",
+			annotations: map[string]string{imgspecv1.AnnotationRefName: "registry.example.com/app:v1"},
+			want:        "registry.example.com/app:v1",
+		},
+		{
+			name: "containerd image name first",
+			annotations: map[string]string{
+				imgspecv1.AnnotationRefName:   "registry.example.com/app:v1",
+				AnnotationContainerdImageName: "registry.example.com/other:v2",
+			},
+			want: "registry.example.com/other:v2",
+		},
+		{
+			name: "bare tag ref name",
+			annotations: map[string]string{
+				imgspecv1.AnnotationRefName: "latest",
+			},
+		},
+		{
+			name: "fallback to ref name",
+			annotations: map[string]string{
+				imgspecv1.AnnotationRefName:   "registry.example.com/app:v1",
+				AnnotationContainerdImageName: "Invalid/Name",
+			},
+			want: "registry.example.com/app:v1",
+		},
+		{
+			name: "no annotations",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			named, ok := imageName(newDescriptor(tc.name, nil, tc.annotations))
+			if tc.want == "" {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tc.want, named.String())
+		})
+	}
+}
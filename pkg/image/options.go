@@ -37,11 +37,20 @@ func WithMetadataApplier(applier func(*ocispec.ImageMetadata)) ImageOption {
 	}
 }
 
+// WithCacheDir sets the directory to hold the temporary files of the image, like
+// the uncompressed layer tarballs, default to [os.TempDir].
+func WithCacheDir(dir string) ImageOption {
+	return func(o *ImageOptions) {
+		o.CacheDir = dir
+	}
+}
+
 // ImageOptions is the structure of the optional parameters.
 type ImageOptions struct {
 	Platform         *imgspecv1.Platform
 	InstanceDigest   digest.Digest
 	MetadataAppliers []func(*ocispec.ImageMetadata)
+	CacheDir         string
 }
 
 // ApplyMetadata applies the metadata appliers to the image metadata.
@@ -12,6 +12,7 @@
 
 	"github.com/wuxler/ruasec/pkg/errdefs"
 	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
 	"github.com/wuxler/ruasec/pkg/ocispec"
 	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
 	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
@@ -33,6 +34,7 @@ type remoteImage struct {
 	manifest   manifest.ImageManifest
 	descriptor imgspecv1.Descriptor
 	metadata   ocispec.ImageMetadata
+	cache      *blobfs.Cache
 
 	// lazy initialized and cached properties
 	configFileContent []byte
@@ -109,12 +111,14 @@ func (img *remoteImage) Layers(ctx context.Context) ([]ocispec.Layer, error) {
 			IsCompressed: ocispec.IsCompressedBlob(desc.MediaType),
 		}
 		if metadata.IsCompressed {
+			metadata.CompressedDigest = desc.Digest
 			metadata.CompressedSize = desc.Size
 		} else {
 			metadata.UncompressedSize = desc.Size
 		}
 		current := &remoteLayer{
 			client:     img.client,
+			cache:      img.cache,
 			metadata:   metadata,
 			descriptor: desc.Descriptor,
 		}
@@ -140,9 +144,13 @@ func (img *remoteImage) Layers(ctx context.Context) ([]ocispec.Layer, error) {
 	return toLayers(img.layers), nil
 }
 
-// Close releases any resources associated with the image.
+// Close releases any resources associated with the image, and removes the
+// spooled layer tarballs.
 func (img *remoteImage) Close() error {
-	return nil
+	if img.cache == nil {
+		return nil
+	}
+	return img.cache.Close()
 }
 
 // Descriptor returns the descriptor for the resource.
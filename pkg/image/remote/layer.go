@@ -3,19 +3,26 @@
 import (
 	"context"
 	"io"
+	"io/fs"
 
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
 	"github.com/wuxler/ruasec/pkg/ocispec"
 	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
 	"github.com/wuxler/ruasec/pkg/util/xio"
 	"github.com/wuxler/ruasec/pkg/util/xio/compression"
 )
 
-var _ ocispec.BlobLayer = (*remoteLayer)(nil)
+var (
+	_ ocispec.BlobLayer  = (*remoteLayer)(nil)
+	_ ocispec.FSLayer    = (*remoteLayer)(nil)
+	_ blobfs.CacheHolder = (*remoteLayer)(nil)
+)
 
 type remoteLayer struct {
 	client     *remote.Repository
+	cache      *blobfs.Cache
 	metadata   ocispec.LayerMetadata
 	descriptor imgspecv1.Descriptor
 }
@@ -99,6 +106,20 @@ func (layer *remoteLayer) Uncompressed(ctx context.Context) (io.ReadCloser, erro
 	return xio.WrapReader(uncompressor, xio.MultiClosers(uncompressor, rc).Close), nil
 }
 
+// GetFS returns the filesystem of the uncompressed layer tarball, which is
+// spooled into the temporary file.
+// Implements the [xfs.Getter] interface.
+func (layer *remoteLayer) GetFS(ctx context.Context) (fs.FS, error) {
+	return layer.cache.GetFS(ctx, layer)
+}
+
+// AcquireCache holds a new reference of the cache shared with the image, which
+// keeps the spooled layer tarballs available after the image is closed.
+// Implements the [blobfs.CacheHolder] interface.
+func (layer *remoteLayer) AcquireCache() (io.Closer, error) {
+	return layer.cache.Acquire()
+}
+
 func toLayers(layers []*remoteLayer) []ocispec.Layer {
 	result := make([]ocispec.Layer, len(layers))
 	for i, layer := range layers {
@@ -3,17 +3,23 @@
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/puzpuzpuz/xsync/v3"
 
 	"github.com/wuxler/ruasec/pkg/errdefs"
 	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
 	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/registries"
 	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
 	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
 	_ "github.com/wuxler/ruasec/pkg/ocispec/manifest/all"
 	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
 	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/xlog"
 )
 
 var _ image.Storage = (*Storage)(nil)
@@ -24,10 +30,29 @@ func init() {
 	ocispecname.RegisterScheme("https")
 }
 
+// DefaultConfig returns the default config of remote type storage.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+// Config is the config of remote type storage.
+type Config struct {
+	// Registries configures the mirrors, the location rewrites and the blocked
+	// registries to pull images from. Images are pulled from the registry of
+	// the reference directly if not set.
+	Registries *registries.Config
+}
+
 // NewStorage returns a remote type storage.
 func NewStorage(client *remote.Client) *Storage {
+	return NewStorageWithConfig(client, DefaultConfig())
+}
+
+// NewStorageWithConfig returns a remote type storage with the config.
+func NewStorageWithConfig(client *remote.Client, config Config) *Storage {
 	return &Storage{
 		client:     client,
+		config:     config,
 		registries: xsync.NewMapOf[string, *remote.Registry](),
 	}
 }
@@ -35,6 +60,7 @@ func NewStorage(client *remote.Client) *Storage {
 // Storage is a wrapper for remote registry implements Storage interface.
 type Storage struct {
 	client     *remote.Client
+	config     Config
 	registries *xsync.MapOf[string, *remote.Registry]
 }
 
@@ -47,37 +73,16 @@ func (p *Storage) Type() string {
 func (p *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageOption) (ocispec.ImageCloser, error) {
 	options := image.MakeImageOptions(opts...)
 
-	parsedRef, err := ocispecname.NewReference(ref)
+	// fetch the manifest of the reference
+	parsedRef, repo, rc, err := p.resolve(ctx, ref)
 	if err != nil {
 		return nil, err
 	}
-
-	domain := parsedRef.Repository().Domain()
-	client, ok := p.registries.Load(domain.Hostname())
-	if !ok {
-		client, err := p.client.NewRegistry(ctx, domain)
-		if err != nil {
-			return nil, err
-		}
-		p.registries.Store(domain.Hostname(), client)
-	}
-
-	// get repository client for the reference
-	repo := client.Repository(parsedRef.Repository().Path())
+	defer xio.CloseAndSkipError(rc)
 	img := &remoteImage{
 		client: repo,
 		name:   parsedRef,
 	}
-	tagOrDigest, err := ocispecname.Identify(parsedRef)
-	if err != nil {
-		return nil, err
-	}
-	// fetch the manifest of the reference
-	rc, err := repo.Manifests().FetchTagOrDigest(ctx, tagOrDigest)
-	if err != nil {
-		return nil, err
-	}
-	defer xio.CloseAndSkipError(rc)
 
 	mf, desc, err := manifest.ParseCASReader(rc)
 	if err != nil {
@@ -135,9 +140,110 @@ func (p *Storage) GetImage(ctx context.Context, ref string, opts ...image.ImageO
 	options.ApplyMetadata(&metadata)
 
 	img.metadata = metadata
+	img.cache = blobfs.NewCache(options.CacheDir)
 	return img, nil
 }
 
+// resolve resolves the reference which may be a short name to the candidates,
+// and fetches the manifest from the candidates in order. The candidate which the
+// manifest is fetched from is returned with the repository.
+func (p *Storage) resolve(ctx context.Context, ref string) (ocispecname.Reference, *remote.Repository, cas.ReadCloser, error) {
+	candidates, err := p.candidates(ref)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var errs []error
+	for _, candidate := range candidates {
+		repo, rc, err := p.fetchManifest(ctx, candidate.Reference)
+		if err == nil {
+			if registries.IsShortName(ref) && p.config.Registries != nil &&
+				(candidate.Alias || len(p.config.Registries.UnqualifiedSearchRegistries) > 0) {
+				xlog.C(ctx).Infof("Resolved short name %q to %q (alias: %t)", ref, candidate.Reference, candidate.Alias)
+			}
+			return candidate.Reference, repo, rc, nil
+		}
+		if len(candidates) == 1 {
+			return nil, nil, nil, err
+		}
+		xlog.C(ctx).Debugf("unable to resolve short name %q to %q: %s", ref, candidate.Reference, err)
+		errs = append(errs, fmt.Errorf("resolve short name %q to %s: %w", ref, candidate.Reference, err))
+	}
+	return nil, nil, nil, errors.Join(errs...)
+}
+
+// candidates returns the references which the ref may be resolved to in order.
+func (p *Storage) candidates(ref string) ([]registries.ShortNameCandidate, error) {
+	if p.config.Registries != nil {
+		return p.config.Registries.ResolveShortName(ref)
+	}
+	parsed, err := ocispecname.NewReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	return []registries.ShortNameCandidate{{Reference: parsed}}, nil
+}
+
+// fetchManifest fetches the manifest of the reference from the sources in order,
+// and returns the repository which the manifest is fetched from.
+func (p *Storage) fetchManifest(ctx context.Context, ref ocispecname.Reference) (*remote.Repository, cas.ReadCloser, error) {
+	tagOrDigest, err := ocispecname.Identify(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources, err := p.pullSources(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	var errs []error
+	for _, source := range sources {
+		repo, err := p.repository(ctx, source)
+		if err == nil {
+			var rc cas.ReadCloser
+			rc, err = repo.Manifests().FetchTagOrDigest(ctx, tagOrDigest)
+			if err == nil {
+				return repo, rc, nil
+			}
+		}
+		if len(sources) == 1 {
+			return nil, nil, err
+		}
+		xlog.C(ctx).Debugf("unable to pull %s from %s: %s", ref, source, err)
+		errs = append(errs, fmt.Errorf("pull from %s: %w", source, err))
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// pullSources returns the sources to pull the image of the reference from.
+func (p *Storage) pullSources(ref ocispecname.Reference) ([]registries.PullSource, error) {
+	if p.config.Registries != nil {
+		if reg, ok := p.config.Registries.FindRegistry(ref); ok {
+			return reg.PullSources(ref)
+		}
+	}
+	return []registries.PullSource{{Reference: ref}}, nil
+}
+
+// repository returns the repository client of the source.
+func (p *Storage) repository(ctx context.Context, source registries.PullSource) (*remote.Repository, error) {
+	domain := source.Reference.Repository().Domain()
+	if source.Endpoint.Location != "" && !source.Endpoint.Insecure && domain.Scheme() == "" {
+		// never downgrade to plain HTTP for the endpoints configured as secure,
+		// and detect the scheme for the others.
+		domain = domain.WithScheme("https")
+	}
+	key := domain.Scheme() + "://" + domain.Hostname()
+	client, ok := p.registries.Load(key)
+	if !ok {
+		var err error
+		client, err = p.client.NewRegistry(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		p.registries.Store(key, client)
+	}
+	return client.Repository(source.Reference.Repository().Path()), nil
+}
+
 // Close closes the storage and releases resources.
 func (p *Storage) Close() error {
 	return nil
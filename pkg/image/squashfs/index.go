@@ -0,0 +1,259 @@
+package squashfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	stdpath "path"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xcontext"
+	"github.com/wuxler/ruasec/pkg/util/xfile"
+	"github.com/wuxler/ruasec/pkg/util/xfs"
+)
+
+// Change is the kind of the change made by a layer to a file.
+type Change string
+
+const (
+	// ChangeAdded means the file is added by the layer, which does not exist in
+	// the lower layers or is deleted by them.
+	ChangeAdded Change = "added"
+	// ChangeModified means the file exists in the lower layers and is replaced
+	// by the layer.
+	ChangeModified Change = "modified"
+	// ChangeDeleted means the file is deleted by the whiteout, the opaque whiteout
+	// or the replaced parent directory of the layer.
+	ChangeDeleted Change = "deleted"
+)
+
+// LayerRef describes the layer a file version comes from.
+type LayerRef struct {
+	// Index is the index of the layer in the image, starting with 0 as the base layer.
+	Index int `json:"index" yaml:"index"`
+
+	// DiffID is the uncompressed digest of the layer.
+	DiffID digest.Digest `json:"diff_id,omitempty" yaml:"diff_id,omitempty"`
+
+	// ChainID is the chain id of the layer stack from the base layer to the layer.
+	ChainID digest.Digest `json:"chain_id,omitempty" yaml:"chain_id,omitempty"`
+
+	// CreatedBy is the command which creates the layer, like the Dockerfile
+	// instruction. It may be empty when the history is not found.
+	CreatedBy string `json:"created_by,omitempty" yaml:"created_by,omitempty"`
+}
+
+// FileRecord is the provenance of a file in the image.
+type FileRecord struct {
+	// Path is the cleaned path of the file without the leading "/".
+	Path string `json:"path" yaml:"path"`
+
+	// Change is the last change made to the file.
+	Change Change `json:"change" yaml:"change"`
+
+	// Layer is the layer which last added, modified or deleted the file.
+	Layer LayerRef `json:"layer" yaml:"layer"`
+
+	// Shadowed lists the lower layers holding the earlier versions of the file
+	// which are shadowed, ordered from the most-recent to the oldest.
+	Shadowed []LayerRef `json:"shadowed,omitempty" yaml:"shadowed,omitempty"`
+}
+
+// Deleted returns true if the file does not exist in the squashed image.
+func (r FileRecord) Deleted() bool {
+	return r.Change == ChangeDeleted
+}
+
+// Index is the per-file layer provenance index of the image, which maps each
+// path to the layer that last added, modified or deleted it.
+type Index struct {
+	records map[string]*FileRecord
+	// children maps the directory to the paths of its direct children recorded,
+	// so that deleting a subtree only visits the records under it
+	children map[string][]string
+}
+
+// NewIndex builds the file provenance index of the image.
+//
+// NOTE: All the layers are walked through. The filesystems of the layers are
+// got from the image, so the uncompressed tarballs spooled by the image are kept
+// until the image is closed.
+func NewIndex(ctx context.Context, img ocispec.Image, opts ...Option) (*Index, error) {
+	layers, err := img.Layers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewIndexFromLayers(ctx, layers, opts...)
+}
+
+// NewIndexFromLayers builds the file provenance index of the layers, which are
+// ordered from the oldest/base layer to the most-recent/top layer.
+//
+// The blob layers without filesystems are spooled into a temporary cache, which
+// is removed once the index is built.
+func NewIndexFromLayers(ctx context.Context, layers []ocispec.Layer, opts ...Option) (_ *Index, err error) {
+	options := MakeOptions(opts...)
+	cache := blobfs.NewCache(options.CacheDir)
+	defer func() {
+		err = errors.Join(err, cache.Close())
+	}()
+	idx := &Index{records: map[string]*FileRecord{}, children: map[string][]string{}}
+	for i, layer := range layers {
+		if err := idx.apply(ctx, newLayerRef(i, layer), &layerFS{layer: layer, cache: cache}); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// Lookup returns the record of the named file, including the deleted one.
+func (idx *Index) Lookup(name string) (FileRecord, bool) {
+	record, ok := idx.records[cleanPath(name)]
+	if !ok {
+		return FileRecord{}, false
+	}
+	return record.clone(), true
+}
+
+// Records returns all the records sorted by path, including the deleted ones.
+func (idx *Index) Records() []FileRecord {
+	records := make([]FileRecord, 0, len(idx.records))
+	for _, record := range idx.records {
+		records = append(records, record.clone())
+	}
+	slices.SortFunc(records, func(a, b FileRecord) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return records
+}
+
+// apply applies the changes of the layer to the index. The whiteouts of a layer
+// only remove the files of the lower layers, so they are applied before the
+// files added in the same layer.
+func (idx *Index) apply(ctx context.Context, ref LayerRef, lfs *layerFS) error {
+	fsys, err := lfs.FS(ctx)
+	if err != nil {
+		return err
+	}
+
+	var opaques, whiteouts []string
+	changes := map[string]bool{}
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := xcontext.NonBlockingCheck(ctx, path); err != nil {
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+		dir, base := stdpath.Split(path)
+		dir = cleanPath(dir)
+		switch {
+		case base == xfile.OpaqueWhiteout:
+			opaques = append(opaques, dir)
+		case strings.HasPrefix(base, xfile.WhiteoutPrefix):
+			whiteouts = append(whiteouts, stdpath.Join(dir, strings.TrimPrefix(base, xfile.WhiteoutPrefix)))
+		default:
+			changes[path] = d.IsDir()
+		}
+		return nil
+	})
+	if err != nil {
+		return xfs.NewPathError("index", ref.DiffID.String(), err)
+	}
+
+	for _, dir := range opaques {
+		idx.deleteChildren(dir, ref)
+	}
+	for _, name := range whiteouts {
+		idx.delete(name, ref)
+		idx.deleteChildren(name, ref)
+	}
+	// parents are walked before their children, the replaced directory must be
+	// deleted before the files added under the same path
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		idx.update(path, changes[path], ref)
+	}
+	return nil
+}
+
+func (idx *Index) update(name string, isDir bool, ref LayerRef) {
+	record, ok := idx.records[name]
+	if !ok {
+		idx.records[name] = &FileRecord{Path: name, Change: ChangeAdded, Layer: ref}
+		dir := stdpath.Dir(name)
+		idx.children[dir] = append(idx.children[dir], name)
+		return
+	}
+	if record.Deleted() {
+		record.Change = ChangeAdded
+	} else {
+		if !isDir {
+			// the files under the directory replaced by a non-directory are removed
+			idx.deleteChildren(name, ref)
+		}
+		record.Shadowed = slices.Insert(record.Shadowed, 0, record.Layer)
+		record.Change = ChangeModified
+	}
+	record.Layer = ref
+}
+
+func (idx *Index) delete(name string, ref LayerRef) {
+	record, ok := idx.records[name]
+	if !ok || record.Deleted() {
+		return
+	}
+	record.Shadowed = slices.Insert(record.Shadowed, 0, record.Layer)
+	record.Change = ChangeDeleted
+	record.Layer = ref
+}
+
+// deleteChildren deletes all the records under the directory recursively. The
+// deleted children are still walked since their descendants may be added again
+// without them by the upper layers.
+func (idx *Index) deleteChildren(dir string, ref LayerRef) {
+	for _, name := range idx.children[dir] {
+		idx.delete(name, ref)
+		idx.deleteChildren(name, ref)
+	}
+}
+
+func (r *FileRecord) clone() FileRecord {
+	cloned := *r
+	cloned.Shadowed = slices.Clone(r.Shadowed)
+	return cloned
+}
+
+func newLayerRef(index int, layer ocispec.Layer) LayerRef {
+	metadata := layer.Metadata()
+	ref := LayerRef{
+		Index:   index,
+		DiffID:  metadata.DiffID,
+		ChainID: metadata.ChainID,
+	}
+	if metadata.History != nil {
+		ref.CreatedBy = metadata.History.CreatedBy
+	}
+	return ref
+}
+
+// cleanPath returns the cleaned relative path of the name.
+func cleanPath(name string) string {
+	name = strings.TrimPrefix(stdpath.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
@@ -0,0 +1,197 @@
+package squashfs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/image/squashfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+)
+
+// historyLayers builds the tarball layers with the chain ids and the histories,
+// and returns the layer refs expected.
+func historyLayers(t *testing.T, layers [][]string) ([]ocispec.Layer, []squashfs.LayerRef) {
+	t.Helper()
+	built := buildLayers(t, tarLayer, layers)
+	refs := make([]squashfs.LayerRef, 0, len(built))
+	var chainID digest.Digest
+	for i, layer := range built {
+		l := layer.(*fsLayer) //nolint:errcheck // always built as fsLayer
+		if chainID == "" {
+			chainID = l.diffID
+		} else {
+			chainID = digest.FromString(chainID.String() + " " + l.diffID.String())
+		}
+		l.chainID = chainID
+		l.history = &imgspecv1.History{CreatedBy: fmt.Sprintf("RUN step %d", i)}
+		refs = append(refs, squashfs.LayerRef{Index: i, DiffID: l.diffID, ChainID: chainID, CreatedBy: l.history.CreatedBy})
+	}
+	return built, refs
+}
+
+func TestIndex(t *testing.T) {
+	type record struct {
+		change   squashfs.Change
+		layer    int
+		shadowed []int
+	}
+	testcases := []struct {
+		name   string
+		layers [][]string
+		want   map[string]record
+		// absent are the paths never recorded
+		absent []string
+	}{
+		{
+			name:   "added",
+			layers: [][]string{{"a/", "a/x"}, {"b"}},
+			want: map[string]record{
+				"a":   {change: squashfs.ChangeAdded, layer: 0},
+				"a/x": {change: squashfs.ChangeAdded, layer: 0},
+				"b":   {change: squashfs.ChangeAdded, layer: 1},
+			},
+		},
+		{
+			name:   "modified",
+			layers: [][]string{{"x=v0"}, {"x=v1"}, {"y"}, {"x=v3"}},
+			want: map[string]record{
+				"x": {change: squashfs.ChangeModified, layer: 3, shadowed: []int{1, 0}},
+				"y": {change: squashfs.ChangeAdded, layer: 2},
+			},
+		},
+		{
+			name:   "whiteout",
+			layers: [][]string{{"a/", "a/x", "a/y"}, {"a/.wh.x"}},
+			want: map[string]record{
+				"a/x": {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+				"a/y": {change: squashfs.ChangeAdded, layer: 0},
+			},
+			absent: []string{"a/.wh.x"},
+		},
+		{
+			name:   "whiteout then added again",
+			layers: [][]string{{"x=v0"}, {".wh.x"}, {".wh.x"}, {"x=v3"}},
+			want: map[string]record{
+				"x": {change: squashfs.ChangeAdded, layer: 3, shadowed: []int{0}},
+			},
+		},
+		{
+			name:   "whiteout of never added",
+			layers: [][]string{{"a"}, {".wh.b"}},
+			want: map[string]record{
+				"a": {change: squashfs.ChangeAdded, layer: 0},
+			},
+			absent: []string{"b", ".wh.b"},
+		},
+		{
+			name: "opaque whiteout",
+			layers: [][]string{
+				{"a/", "a/x", "a/b/", "a/b/y", "c"},
+				{"a/", "a/.wh..wh..opq", "a/z"},
+			},
+			want: map[string]record{
+				"a":     {change: squashfs.ChangeModified, layer: 1, shadowed: []int{0}},
+				"a/x":   {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+				"a/b":   {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+				"a/b/y": {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+				"a/z":   {change: squashfs.ChangeAdded, layer: 1},
+				"c":     {change: squashfs.ChangeAdded, layer: 0},
+			},
+			absent: []string{"a/.wh..wh..opq"},
+		},
+		{
+			name: "subtree whiteout",
+			layers: [][]string{
+				{"a/", "a/b/", "a/b/c", "ab"},
+				{".wh.a"},
+				{"a/", "a/d"},
+			},
+			want: map[string]record{
+				"a":     {change: squashfs.ChangeAdded, layer: 2, shadowed: []int{0}},
+				"a/b":   {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+				"a/b/c": {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+				"a/d":   {change: squashfs.ChangeAdded, layer: 2},
+				"ab":    {change: squashfs.ChangeAdded, layer: 0},
+			},
+		},
+		{
+			name:   "directory replaced by file",
+			layers: [][]string{{"a/", "a/x"}, {"a=file"}},
+			want: map[string]record{
+				"a":   {change: squashfs.ChangeModified, layer: 1, shadowed: []int{0}},
+				"a/x": {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+			},
+		},
+		{
+			name:   "file replaced by directory",
+			layers: [][]string{{"a=file"}, {"a/", "a/x"}},
+			want: map[string]record{
+				"a":   {change: squashfs.ChangeModified, layer: 1, shadowed: []int{0}},
+				"a/x": {change: squashfs.ChangeAdded, layer: 1},
+			},
+		},
+		{
+			name:   "whiteout applied before added in the same layer",
+			layers: [][]string{{"a/", "a/x=v0"}, {".wh.a", "a/", "a/y"}},
+			want: map[string]record{
+				"a":   {change: squashfs.ChangeAdded, layer: 1, shadowed: []int{0}},
+				"a/x": {change: squashfs.ChangeDeleted, layer: 1, shadowed: []int{0}},
+				"a/y": {change: squashfs.ChangeAdded, layer: 1},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			layers, refs := historyLayers(t, tc.layers)
+			idx, err := squashfs.NewIndexFromLayers(context.Background(), layers, squashfs.WithCacheDir(t.TempDir()))
+			require.NoError(t, err)
+
+			for path, want := range tc.want {
+				got, ok := idx.Lookup(path)
+				require.True(t, ok, path)
+				wantRecord := squashfs.FileRecord{Path: path, Change: want.change, Layer: refs[want.layer]}
+				for _, i := range want.shadowed {
+					wantRecord.Shadowed = append(wantRecord.Shadowed, refs[i])
+				}
+				assert.Equal(t, wantRecord, got, path)
+				assert.Equal(t, want.change == squashfs.ChangeDeleted, got.Deleted(), path)
+			}
+			for _, path := range tc.absent {
+				_, ok := idx.Lookup(path)
+				assert.False(t, ok, path)
+			}
+		})
+	}
+}
+
+func TestIndex_Records(t *testing.T) {
+	layers, refs := historyLayers(t, [][]string{{"b", "a/", "a/x"}, {"a/.wh.x"}})
+	idx, err := squashfs.NewIndexFromLayers(context.Background(), layers, squashfs.WithCacheDir(t.TempDir()))
+	require.NoError(t, err)
+
+	records := idx.Records()
+	paths := []string{}
+	for _, record := range records {
+		paths = append(paths, record.Path)
+	}
+	assert.Equal(t, []string{"a", "a/x", "b"}, paths)
+
+	for _, name := range []string{"a/x", "/a/x", "a/../a/x", "./a//x"} {
+		got, ok := idx.Lookup(name)
+		require.True(t, ok, name)
+		assert.Equal(t, "a/x", got.Path)
+		assert.Equal(t, refs[1], got.Layer)
+	}
+
+	// the records returned are copies
+	got, _ := idx.Lookup("a/x")
+	got.Shadowed[0].CreatedBy = "changed"
+	again, _ := idx.Lookup("a/x")
+	assert.Equal(t, refs[0], again.Shadowed[0])
+}
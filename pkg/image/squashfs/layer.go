@@ -0,0 +1,49 @@
+package squashfs
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// layerFS loads the filesystem of the layer lazily. The [ocispec.FSLayer] is
+// preferred, and the [ocispec.BlobLayer] is spooled into the cache with [blobfs].
+type layerFS struct {
+	layer ocispec.Layer
+	cache *blobfs.Cache
+
+	once sync.Once
+	fsys fs.FS
+	err  error
+}
+
+func (l *layerFS) FS(ctx context.Context) (fs.FS, error) {
+	l.once.Do(func() {
+		l.fsys, l.err = l.load(ctx)
+	})
+	return l.fsys, l.err
+}
+
+func (l *layerFS) load(ctx context.Context) (fs.FS, error) {
+	if getter, ok := l.layer.(ocispec.FSLayer); ok {
+		fsys, err := getter.GetFS(ctx)
+		if err == nil {
+			return fsys, nil
+		}
+		if _, isBlob := l.layer.(ocispec.Uncompressor); !isBlob {
+			return nil, err
+		}
+		xlog.C(ctx).Debugf("unable to get filesystem of layer %s, fallback to the blob: %s", l.layer.Metadata().DiffID, err)
+	}
+
+	uncompressor, ok := l.layer.(ocispec.Uncompressor)
+	if !ok {
+		return nil, errdefs.Newf(errdefs.ErrUnsupported, "layer %s with type %T", l.layer.Metadata().DiffID, l.layer)
+	}
+	return l.cache.GetFS(ctx, uncompressor)
+}
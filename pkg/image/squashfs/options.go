@@ -0,0 +1,30 @@
+package squashfs
+
+import "os"
+
+// Option is the optional parameter setting method.
+type Option func(*Options)
+
+// WithCacheDir sets the directory to hold the uncompressed tarballs of the blob
+// layers, default to [os.TempDir].
+func WithCacheDir(dir string) Option {
+	return func(o *Options) {
+		o.CacheDir = dir
+	}
+}
+
+// Options is the structure of the optional parameters.
+type Options struct {
+	CacheDir string
+}
+
+// MakeOptions returns the options with all optional parameters applied.
+func MakeOptions(opts ...Option) *Options {
+	options := &Options{
+		CacheDir: os.TempDir(),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
@@ -0,0 +1,356 @@
+// Package squashfs provides a read-only filesystem of the image, which squashes
+// all the layers with the whiteouts applied as the container rootfs.
+package squashfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	stdpath "path"
+	"slices"
+	"strings"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xfile"
+	"github.com/wuxler/ruasec/pkg/util/xfs"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+var (
+	_ fs.StatFS      = (*FS)(nil)
+	_ fs.ReadDirFS   = (*FS)(nil)
+	_ fs.ReadFileFS  = (*FS)(nil)
+	_ io.Closer      = (*FS)(nil)
+	_ fs.ReadDirFile = (*dirFile)(nil)
+)
+
+// New returns the squashed filesystem of the image.
+//
+// NOTE: The filesystem must be closed when processing is finished.
+func New(ctx context.Context, img ocispec.Image, opts ...Option) (*FS, error) {
+	layers, err := img.Layers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromLayers(ctx, layers, opts...), nil
+}
+
+// NewFromLayers returns the squashed filesystem of the layers, which are ordered
+// from the oldest/base layer to the most-recent/top layer.
+//
+// NOTE: The filesystem must be closed when processing is finished.
+func NewFromLayers(ctx context.Context, layers []ocispec.Layer, opts ...Option) *FS {
+	options := MakeOptions(opts...)
+	fsys := &FS{ctx: ctx, cache: blobfs.NewCache(options.CacheDir)}
+	for _, layer := range layers {
+		fsys.layers = append(fsys.layers, &layerFS{layer: layer, cache: fsys.cache})
+		// hold the cache shared by the layers of the image, so the spooled
+		// tarballs are kept until the filesystem is closed
+		if holder, ok := layer.(blobfs.CacheHolder); ok {
+			ref, err := holder.AcquireCache()
+			if err != nil {
+				xlog.C(ctx).Debugf("unable to acquire cache of layer %s: %s", layer.Metadata().DiffID, err)
+				continue
+			}
+			fsys.refs = append(fsys.refs, ref)
+		}
+	}
+	return fsys
+}
+
+// FS is the read-only squashed filesystem of the layers, which applies the
+// ".wh." prefixed whiteout files and the ".wh..wh..opq" opaque whiteout files.
+//
+// Each file is looked up from the top layer to the base layer, and the layer
+// filesystem is only loaded when the lookup reaches it.
+//
+// More to see: https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+type FS struct {
+	ctx    context.Context
+	cache  *blobfs.Cache
+	refs   []io.Closer
+	layers []*layerFS
+}
+
+// Open opens the named file.
+// Implements the [fs.FS] interface.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	found, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	top, err := fsys.layers[found[0]].FS(fsys.ctx)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(top, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dirFile{fsys: fsys, name: name, info: info}, nil
+	}
+	return top.Open(name)
+}
+
+// Stat returns a [fs.FileInfo] describing the file.
+// Implements the [fs.StatFS] interface.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	found, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	top, err := fsys.layers[found[0]].FS(fsys.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(top, name)
+}
+
+// ReadFile reads the named file and returns its contents.
+// Implements the [fs.ReadFileFS] interface.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck // ignore error
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, xfs.NewPathError("readfile", name, xfs.ErrIsDir)
+	}
+	return io.ReadAll(file)
+}
+
+// ReadDir reads the named directory and returns a list of directory entries
+// merged from all the layers, sorted by filename.
+// Implements the [fs.ReadDirFS] interface.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	found, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	entries := []fs.DirEntry{}
+	for _, i := range found {
+		layer, err := fsys.layers[i].FS(fsys.ctx)
+		if err != nil {
+			return nil, err
+		}
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			return nil, err
+		}
+		// whiteouts only hide the files of the lower layers
+		hidden := []string{}
+		for _, entry := range layerEntries {
+			entryName := entry.Name()
+			switch {
+			case entryName == xfile.OpaqueWhiteout:
+				continue
+			case strings.HasPrefix(entryName, xfile.WhiteoutPrefix):
+				hidden = append(hidden, strings.TrimPrefix(entryName, xfile.WhiteoutPrefix))
+				continue
+			case seen[entryName]:
+				continue
+			}
+			seen[entryName] = true
+			entries = append(entries, entry)
+		}
+		for _, h := range hidden {
+			seen[h] = true
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// Close closes the filesystem and removes the uncompressed tarballs of the blob
+// layers without filesystems. The references of the caches shared by the image
+// are released, and the tarballs spooled by the image are removed once both the
+// image and the filesystem are closed.
+func (fsys *FS) Close() error {
+	errs := []error{fsys.cache.Close()}
+	for _, ref := range fsys.refs {
+		errs = append(errs, ref.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// lookup returns the indexes of the layers containing the named file, ordered
+// from the top layer to the base layer. The first one is the layer the file is
+// resolved to, and the others are only returned when the file is a directory
+// merged from them.
+func (fsys *FS) lookup(op string, name string) ([]int, error) {
+	if !fs.ValidPath(name) {
+		return nil, xfs.NewPathError(op, name, fs.ErrInvalid)
+	}
+	name = stdpath.Clean(name)
+	if strings.HasPrefix(stdpath.Base(name), xfile.WhiteoutPrefix) {
+		// the whiteouts are applied instead of being the files of the image
+		return nil, xfs.NewPathError(op, name, fs.ErrNotExist)
+	}
+	ancestors := ancestorsOf(name)
+
+	found := []int{}
+	for i := len(fsys.layers) - 1; i >= 0; i-- {
+		layer, err := fsys.layers[i].FS(fsys.ctx)
+		if err != nil {
+			return nil, err
+		}
+		visible, lowerVisible, err := resolve(layer, name, ancestors, len(found) > 0)
+		if err != nil {
+			return nil, err
+		}
+		if visible {
+			found = append(found, i)
+		}
+		if !lowerVisible {
+			break
+		}
+	}
+	if len(found) == 0 {
+		return nil, xfs.NewPathError(op, name, fs.ErrNotExist)
+	}
+	return found, nil
+}
+
+// resolve checks the named file in the layer. It returns whether the file is
+// visible in the layer, and whether the lower layers are still visible for it.
+func resolve(layer fs.FS, name string, ancestors []string, merging bool) (bool, bool, error) {
+	lowerVisible := true
+	for _, ancestor := range ancestors {
+		info, err := stat(layer, ancestor)
+		if err != nil {
+			return false, false, err
+		}
+		switch {
+		case info == nil:
+			// the ancestor is removed by whiteout, the file can not be in this
+			// layer as well
+			whiteout, err := exists(layer, whiteoutOf(ancestor))
+			return false, lowerVisible && !whiteout, err
+		case !info.IsDir():
+			return false, false, nil
+		}
+		opaque, err := exists(layer, stdpath.Join(ancestor, xfile.OpaqueWhiteout))
+		if err != nil {
+			return false, false, err
+		}
+		if opaque {
+			lowerVisible = false
+		}
+	}
+
+	info, err := stat(layer, name)
+	if err != nil {
+		return false, false, err
+	}
+	if info == nil {
+		if name == "." {
+			return false, lowerVisible, nil
+		}
+		whiteout, err := exists(layer, whiteoutOf(name))
+		return false, lowerVisible && !whiteout, err
+	}
+	if !info.IsDir() {
+		// the file in the lower layer is hidden by the directory merged above
+		return !merging, false, nil
+	}
+	opaque, err := exists(layer, stdpath.Join(name, xfile.OpaqueWhiteout))
+	if err != nil {
+		return false, false, err
+	}
+	return true, lowerVisible && !opaque, nil
+}
+
+// stat returns the FileInfo of the named file in the layer, or nil if it does
+// not exist.
+func stat(layer fs.FS, name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(layer, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+func exists(layer fs.FS, name string) (bool, error) {
+	info, err := stat(layer, name)
+	return info != nil, err
+}
+
+// whiteoutOf returns the whiteout file name of the named file.
+func whiteoutOf(name string) string {
+	return stdpath.Join(stdpath.Dir(name), xfile.WhiteoutPrefix+stdpath.Base(name))
+}
+
+// ancestorsOf returns all the ancestor directories of the name, from the root
+// directory "." to the parent one.
+func ancestorsOf(name string) []string {
+	if name == "." {
+		return nil
+	}
+	ancestors := []string{"."}
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		ancestors = append(ancestors, strings.Join(parts[:i], "/"))
+	}
+	return ancestors
+}
+
+// dirFile is the opened directory of the squashed filesystem.
+type dirFile struct {
+	fsys    *FS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+	loaded  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Read(_ []byte) (int, error) {
+	return 0, xfs.NewPathError("read", d.name, xfs.ErrIsDir)
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+	if d.offset >= len(d.entries) {
+		if n <= 0 {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+	last := d.offset + n
+	if n <= 0 || last > len(d.entries) {
+		last = len(d.entries)
+	}
+	entries := slices.Clone(d.entries[d.offset:last])
+	d.offset += len(entries)
+	return entries, nil
+}
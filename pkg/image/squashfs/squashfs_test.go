@@ -0,0 +1,350 @@
+package squashfs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	stdpath "path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/image/squashfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers/overlay2"
+	"github.com/wuxler/ruasec/pkg/util/xfile"
+	"github.com/wuxler/ruasec/pkg/util/xfs/tarfs"
+)
+
+// fsLayer is the layer with the filesystem only.
+type fsLayer struct {
+	fsys    fs.FS
+	diffID  digest.Digest
+	chainID digest.Digest
+	history *imgspecv1.History
+}
+
+func (l *fsLayer) Metadata() ocispec.LayerMetadata {
+	return ocispec.LayerMetadata{DiffID: l.diffID, ChainID: l.chainID, History: l.history}
+}
+
+func (l *fsLayer) GetFS(_ context.Context) (fs.FS, error) {
+	return l.fsys, nil
+}
+
+// blobLayer is the layer with the uncompressed tarball only.
+type blobLayer struct {
+	data   []byte
+	diffID digest.Digest
+}
+
+func (l *blobLayer) Metadata() ocispec.LayerMetadata {
+	return ocispec.LayerMetadata{DiffID: l.diffID}
+}
+
+func (l *blobLayer) Descriptor() imgspecv1.Descriptor {
+	return imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Digest: l.diffID, Size: int64(len(l.data))}
+}
+
+func (l *blobLayer) Compressed(_ context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(l.data)), nil
+}
+
+func (l *blobLayer) Uncompressed(_ context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(l.data)), nil
+}
+
+// layerBuilder builds the layer from the entries in the tarball notation, where
+// the name ending with "/" is a directory, and the file content follows "=" or
+// is the name itself if omitted.
+type layerBuilder func(t *testing.T, entries []string) fs.FS
+
+func parseEntry(entry string) (string, string, bool) {
+	if strings.HasSuffix(entry, "/") {
+		return strings.TrimSuffix(entry, "/"), "", true
+	}
+	name, content, ok := strings.Cut(entry, "=")
+	if !ok {
+		content = name
+	}
+	return name, content, false
+}
+
+// tarLayer builds the in-memory layer from the tarball.
+func tarLayer(t *testing.T, entries []string) fs.FS {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		name, content, isDir := parseEntry(entry)
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content))}
+		if isDir {
+			hdr = &tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755}
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	fsys, err := tarfs.New(context.Background(), bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	return fsys
+}
+
+// overlayLayer builds the overlay diff directory, where the whiteout files are
+// the character devices and the opaque whiteouts are the extended attributes.
+func overlayLayer(t *testing.T, entries []string) fs.FS {
+	t.Helper()
+	dir := t.TempDir()
+	for _, entry := range entries {
+		name, content, isDir := parseEntry(entry)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		base := stdpath.Base(name)
+		switch {
+		case isDir:
+			require.NoError(t, os.MkdirAll(path, 0o755))
+		case base == xfile.OpaqueWhiteout:
+			if err := unix.Lsetxattr(filepath.Dir(path), "user.overlay.opaque", []byte("y"), 0); err != nil {
+				t.Skipf("unable to set the opaque extended attribute: %s", err)
+			}
+		case strings.HasPrefix(base, xfile.WhiteoutPrefix):
+			device := filepath.Join(filepath.Dir(path), strings.TrimPrefix(base, xfile.WhiteoutPrefix))
+			if err := syscall.Mknod(device, syscall.S_IFCHR|0o600, 0); err != nil {
+				t.Skipf("unable to create the whiteout character device: %s", err)
+			}
+		default:
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		}
+	}
+	return overlay2.NewDiffFS(context.Background(), dir)
+}
+
+var layerBuilders = map[string]layerBuilder{
+	"tarfs":    tarLayer,
+	"overlay2": overlayLayer,
+}
+
+func buildLayers(t *testing.T, build layerBuilder, layers [][]string) []ocispec.Layer {
+	t.Helper()
+	result := make([]ocispec.Layer, 0, len(layers))
+	for i, entries := range layers {
+		result = append(result, &fsLayer{fsys: build(t, entries), diffID: digest.FromString(strings.Repeat("layer", i+1))})
+	}
+	return result
+}
+
+func TestFS(t *testing.T) {
+	testcases := []struct {
+		name   string
+		layers [][]string
+		// files are the visible regular files with the contents
+		files map[string]string
+		// dirs are the visible directories with the sorted entry names
+		dirs map[string][]string
+		// missing are the files removed
+		missing []string
+		// tarOnly is true if the layers can not be the overlay diff directories
+		tarOnly bool
+	}{
+		{
+			name: "whiteout file",
+			layers: [][]string{
+				{"a/x", "a/y"},
+				{"a/.wh.x"},
+			},
+			files:   map[string]string{"a/y": "a/y"},
+			dirs:    map[string][]string{".": {"a"}, "a": {"y"}},
+			missing: []string{"a/x", "a/.wh.x"},
+		},
+		{
+			name: "whiteout file added again",
+			layers: [][]string{
+				{"a/x"},
+				{"a/.wh.x"},
+				{"a/x=again"},
+			},
+			files: map[string]string{"a/x": "again"},
+			dirs:  map[string][]string{"a": {"x"}},
+		},
+		{
+			name: "whiteout only hides lower layers",
+			layers: [][]string{
+				{"a/x=lower"},
+				{"a/.wh.x", "a/x=same layer"},
+			},
+			files:   map[string]string{"a/x": "same layer"},
+			dirs:    map[string][]string{"a": {"x"}},
+			tarOnly: true,
+		},
+		{
+			name: "opaque whiteout",
+			layers: [][]string{
+				{"a/x", "a/b/y", "c"},
+				{"a/.wh..wh..opq", "a/z"},
+			},
+			files:   map[string]string{"a/z": "a/z", "c": "c"},
+			dirs:    map[string][]string{".": {"a", "c"}, "a": {"z"}},
+			missing: []string{"a/x", "a/b", "a/b/y", "a/.wh..wh..opq"},
+		},
+		{
+			name: "opaque whiteout of ancestor",
+			layers: [][]string{
+				{"a/b/x"},
+				{"a/.wh..wh..opq", "a/b/y"},
+			},
+			files:   map[string]string{"a/b/y": "a/b/y"},
+			dirs:    map[string][]string{"a": {"b"}, "a/b": {"y"}},
+			missing: []string{"a/b/x"},
+		},
+		{
+			name: "directory replaced by file",
+			layers: [][]string{
+				{"a/x", "a/b/y"},
+				{"a=file"},
+			},
+			files:   map[string]string{"a": "file"},
+			dirs:    map[string][]string{".": {"a"}},
+			missing: []string{"a/x", "a/b", "a/b/y"},
+		},
+		{
+			name: "file replaced by directory",
+			layers: [][]string{
+				{"a=file"},
+				{"a/x"},
+			},
+			files: map[string]string{"a/x": "a/x"},
+			dirs:  map[string][]string{".": {"a"}, "a": {"x"}},
+		},
+		{
+			name: "file replaced by directory then file",
+			layers: [][]string{
+				{"a=first"},
+				{"a/x"},
+				{"a=second"},
+			},
+			files:   map[string]string{"a": "second"},
+			missing: []string{"a/x"},
+		},
+		{
+			name: "subtree whiteout",
+			layers: [][]string{
+				{"a/b/c/d", "a/b/e", "f"},
+				{".wh.a"},
+			},
+			files:   map[string]string{"f": "f"},
+			dirs:    map[string][]string{".": {"f"}},
+			missing: []string{"a", "a/b", "a/b/c", "a/b/c/d", "a/b/e"},
+		},
+		{
+			name: "subtree whiteout then directory added",
+			layers: [][]string{
+				{"a/b/c"},
+				{".wh.a"},
+				{"a/d"},
+			},
+			files:   map[string]string{"a/d": "a/d"},
+			dirs:    map[string][]string{".": {"a"}, "a": {"d"}},
+			missing: []string{"a/b", "a/b/c"},
+		},
+		{
+			name: "merged directories",
+			layers: [][]string{
+				{"a/1", "a/3", "a/5=lower", "a/b/x"},
+				{"a/2", "a/.wh.3", "a/b/y"},
+				{"a/4", "a/5=upper", "a/.wh.b"},
+			},
+			files:   map[string]string{"a/1": "a/1", "a/2": "a/2", "a/4": "a/4", "a/5": "upper"},
+			dirs:    map[string][]string{"a": {"1", "2", "4", "5"}},
+			missing: []string{"a/3", "a/b", "a/b/x", "a/b/y"},
+		},
+	}
+	for backend, build := range layerBuilders {
+		for _, tc := range testcases {
+			t.Run(backend+"/"+tc.name, func(t *testing.T) {
+				if tc.tarOnly && backend != "tarfs" {
+					t.Skip("the whiteout and the file with the same name can not be in the diff directory")
+				}
+				fsys := squashfs.NewFromLayers(context.Background(), buildLayers(t, build, tc.layers),
+					squashfs.WithCacheDir(t.TempDir()))
+				defer fsys.Close()
+
+				expected := []string{}
+				for name, content := range tc.files {
+					expected = append(expected, name)
+					got, err := fs.ReadFile(fsys, name)
+					require.NoError(t, err, name)
+					assert.Equal(t, content, string(got), name)
+				}
+				for name, want := range tc.dirs {
+					info, err := fs.Stat(fsys, name)
+					require.NoError(t, err, name)
+					assert.True(t, info.IsDir(), name)
+					entries, err := fs.ReadDir(fsys, name)
+					require.NoError(t, err, name)
+					got := []string{}
+					for _, entry := range entries {
+						got = append(got, entry.Name())
+					}
+					assert.Equal(t, want, got, name)
+				}
+				for _, name := range tc.missing {
+					_, err := fs.Stat(fsys, name)
+					assert.ErrorIs(t, err, fs.ErrNotExist, name)
+					_, err = fsys.Open(name)
+					assert.ErrorIs(t, err, fs.ErrNotExist, name)
+				}
+				assert.NoError(t, fstest.TestFS(fsys, expected...))
+			})
+		}
+	}
+}
+
+func TestFS_InvalidPath(t *testing.T) {
+	fsys := squashfs.NewFromLayers(context.Background(), buildLayers(t, tarLayer, [][]string{{"a"}}),
+		squashfs.WithCacheDir(t.TempDir()))
+	defer fsys.Close()
+	for _, name := range []string{"/a", "a/", "../a", ""} {
+		_, err := fsys.Stat(name)
+		assert.ErrorIs(t, err, fs.ErrInvalid, name)
+	}
+}
+
+func TestFS_HoldImageCache(t *testing.T) {
+	ctx := context.Background()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "a", Mode: 0o644, Size: 1}))
+	_, err := tw.Write([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	// the cache owned by the image and shared by its layers
+	cacheDir := t.TempDir()
+	cache := blobfs.NewCache(cacheDir)
+	layer := blobfs.NewLayer(&blobLayer{data: buf.Bytes(), diffID: digest.FromBytes(buf.Bytes())}, cache)
+	fsys := squashfs.NewFromLayers(ctx, []ocispec.Layer{layer}, squashfs.WithCacheDir(t.TempDir()))
+
+	// the image is closed before the filesystem is used
+	require.NoError(t, cache.Close())
+	content, err := fsys.ReadFile("a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(content))
+
+	require.NoError(t, fsys.Close())
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
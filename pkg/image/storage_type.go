@@ -9,6 +9,15 @@
 	StorageTypeDockerDaemon = "docker-daemon"
 	// StorageTypeDockerRootfs is the storage type for remote registry images.
 	StorageTypeRemote = "remote"
+	// StorageTypeOCILayout is the storage type for OCI image layout directory images.
+	StorageTypeOCILayout = "oci-layout"
+	// StorageTypeOCIArchive is the storage type for OCI image layout tarball images.
+	StorageTypeOCIArchive = "oci-archive"
+	// StorageTypeContainerdFS is the storage type for containerd rootfs images.
+	StorageTypeContainerdFS = "containerd-rootfs"
+	// StorageTypeContainersFS is the storage type for podman, buildah and CRI-O
+	// containers storage images.
+	StorageTypeContainersFS = "containers-storage"
 )
 
 // AllStorageTypes returns all storage types supported.
@@ -18,5 +27,9 @@ func AllStorageTypes() []string {
 		StorageTypeDockerArchive,
 		StorageTypeDockerDaemon,
 		StorageTypeRemote,
+		StorageTypeOCILayout,
+		StorageTypeOCIArchive,
+		StorageTypeContainerdFS,
+		StorageTypeContainersFS,
 	}
 }
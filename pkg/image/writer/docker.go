@@ -0,0 +1,108 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/image/docker/archive"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+const (
+	// dockerManifestFile is the manifest file name of the docker archive.
+	dockerManifestFile = "manifest.json"
+	// dockerRepositoriesFile is the legacy repositories file name of the docker archive.
+	dockerRepositoriesFile = "repositories"
+	// dockerLayerFile is the layer tarball file name under the layer directory.
+	dockerLayerFile = "layer.tar"
+)
+
+// WriteDockerArchive writes the images as the "docker save" compatible tarball
+// into w, which holds the "manifest.json" and "repositories" files with the
+// "RepoTags" of the images. The layers are always written uncompressed, and the
+// compression option is ignored.
+func WriteDockerArchive(ctx context.Context, w io.Writer, imgs []ocispec.Image, opts ...Option) (err error) {
+	options := MakeOptions(opts...)
+	temper := xos.NewTemper(options.CacheDir, "docker-archive-*")
+	dir, err := temper.Path()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, temper.Cleanup())
+	}()
+
+	manifests := make([]archive.Manifest, 0, len(imgs))
+	repositories := map[string]map[string]string{}
+	for _, img := range imgs {
+		exported, err := exportImage(ctx, img, options, func(ctx context.Context, layer ocispec.Layer) (exportedLayer, error) {
+			written, err := writeLayerBlob(ctx, dir, layer, CompressionNone)
+			if err != nil {
+				return exportedLayer{}, err
+			}
+			path := filepath.Join(dir, written.diffID.Encoded(), dockerLayerFile)
+			return written, commit(written.path, path)
+		})
+		if err != nil {
+			return err
+		}
+
+		configID := digest.FromBytes(exported.config)
+		configFile := configID.Encoded() + ".json"
+		if err := os.WriteFile(filepath.Join(dir, configFile), exported.config, 0o644); err != nil { //nolint:gosec,mnd // default file permission
+			return err
+		}
+		mf := archive.Manifest{
+			Config:   configFile,
+			RepoTags: exported.metadata.RepoTags,
+			Layers:   make([]string, 0, len(exported.layers)),
+		}
+		for _, layer := range exported.layers {
+			mf.Layers = append(mf.Layers, layer.diffID.Encoded()+"/"+dockerLayerFile)
+		}
+		manifests = append(manifests, mf)
+
+		if len(exported.layers) == 0 {
+			continue
+		}
+		top := exported.layers[len(exported.layers)-1].diffID.Encoded()
+		for _, repoTag := range exported.metadata.RepoTags {
+			named, err := ocispecname.NewReference(repoTag)
+			if err != nil {
+				return fmt.Errorf("invalid repo tag %q: %w", repoTag, err)
+			}
+			tagged, ok := ocispecname.IsTagged(named)
+			if !ok {
+				continue
+			}
+			// keep the repository as written in the repo tag, the normalized name
+			// replaces the "docker.io" domain
+			repo := strings.TrimSuffix(repoTag, ":"+tagged.Tag())
+			if repositories[repo] == nil {
+				repositories[repo] = map[string]string{}
+			}
+			repositories[repo][tagged.Tag()] = top
+		}
+	}
+
+	if err := writeJSON(filepath.Join(dir, dockerManifestFile), manifests); err != nil {
+		return err
+	}
+	if len(repositories) > 0 {
+		if err := writeJSON(filepath.Join(dir, dockerRepositoriesFile), repositories); err != nil {
+			return err
+		}
+	}
+	_, err = blobfs.WriteTar(ctx, w, os.DirFS(dir))
+	return err
+}
@@ -0,0 +1,204 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/image/oci/layout"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	_ "github.com/wuxler/ruasec/pkg/util/xio/compression/builtin"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+// WriteOCILayout writes the images into the OCI image layout directory, which is
+// created if not exists. The manifests are appended to the existing "index.json"
+// and annotated with the "RepoTags" of the images.
+//
+// More to see: https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+func WriteOCILayout(ctx context.Context, dir string, imgs []ocispec.Image, opts ...Option) error {
+	options := MakeOptions(opts...)
+	index, err := readIndex(dir)
+	if err != nil {
+		return err
+	}
+	// the spooled blobs are renamed into the layout, so they must be written to
+	// the same filesystem
+	tmpDir := filepath.Join(dir, imgspecv1.ImageBlobsDir)
+	for _, img := range imgs {
+		exported, err := exportImage(ctx, img, options, func(ctx context.Context, layer ocispec.Layer) (exportedLayer, error) {
+			written, err := writeLayerBlob(ctx, tmpDir, layer, options.Compression)
+			if err != nil {
+				return exportedLayer{}, err
+			}
+			return written, commitBlob(dir, written.path, written.descriptor.Digest)
+		})
+		if err != nil {
+			return err
+		}
+		descriptors, err := writeOCIImage(dir, exported)
+		if err != nil {
+			return err
+		}
+		index.Manifests = mergeDescriptors(index.Manifests, descriptors)
+	}
+
+	if err := writeJSON(filepath.Join(dir, imgspecv1.ImageLayoutFile), imgspecv1.ImageLayout{
+		Version: imgspecv1.ImageLayoutVersion,
+	}); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, imgspecv1.ImageIndexFile), index)
+}
+
+// WriteOCIArchive writes the images as the OCI image layout tarball into w.
+func WriteOCIArchive(ctx context.Context, w io.Writer, imgs []ocispec.Image, opts ...Option) (err error) {
+	options := MakeOptions(opts...)
+	temper := xos.NewTemper(options.CacheDir, "oci-archive-*")
+	dir, err := temper.Path()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, temper.Cleanup())
+	}()
+	if err := WriteOCILayout(ctx, dir, imgs, opts...); err != nil {
+		return err
+	}
+	_, err = blobfs.WriteTar(ctx, w, os.DirFS(dir))
+	return err
+}
+
+// writeOCIImage writes the config and manifest blobs of the image, and returns
+// the manifest descriptors to be referenced by "index.json".
+func writeOCIImage(dir string, exported *exportedImage) ([]imgspecv1.Descriptor, error) {
+	configDesc, err := writeBlob(dir, ocispec.MediaTypeImageConfig, exported.config)
+	if err != nil {
+		return nil, err
+	}
+	mf := imgspecv1.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    make([]imgspecv1.Descriptor, 0, len(exported.layers)),
+	}
+	mf.SchemaVersion = 2
+	for _, layer := range exported.layers {
+		mf.Layers = append(mf.Layers, layer.descriptor)
+	}
+	content, err := json.Marshal(mf)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := writeBlob(dir, ocispec.MediaTypeImageManifest, content)
+	if err != nil {
+		return nil, err
+	}
+	desc.Platform = exported.metadata.Platform
+
+	if len(exported.metadata.RepoTags) == 0 {
+		return []imgspecv1.Descriptor{desc}, nil
+	}
+	descriptors := make([]imgspecv1.Descriptor, 0, len(exported.metadata.RepoTags))
+	for _, repoTag := range exported.metadata.RepoTags {
+		named, err := ocispecname.NewReference(repoTag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repo tag %q: %w", repoTag, err)
+		}
+		annotated := desc
+		annotated.Annotations = map[string]string{
+			layout.AnnotationContainerdImageName: repoTag,
+		}
+		if tagged, ok := ocispecname.IsTagged(named); ok {
+			annotated.Annotations[imgspecv1.AnnotationRefName] = tagged.Tag()
+		}
+		descriptors = append(descriptors, annotated)
+	}
+	return descriptors, nil
+}
+
+// mergeDescriptors appends the descriptors to the existing ones. The existing
+// descriptor with the same image name, or the same digest when both are unnamed,
+// is replaced.
+func mergeDescriptors(existing []imgspecv1.Descriptor, descriptors []imgspecv1.Descriptor) []imgspecv1.Descriptor {
+	for _, desc := range descriptors {
+		name := desc.Annotations[layout.AnnotationContainerdImageName]
+		i := slices.IndexFunc(existing, func(d imgspecv1.Descriptor) bool {
+			if name != "" {
+				return d.Annotations[layout.AnnotationContainerdImageName] == name
+			}
+			return d.Digest == desc.Digest && d.Annotations[layout.AnnotationContainerdImageName] == ""
+		})
+		if i < 0 {
+			existing = append(existing, desc)
+		} else {
+			existing[i] = desc
+		}
+	}
+	return existing
+}
+
+// readIndex reads the "index.json" of the layout, and returns an empty index if
+// not exists.
+func readIndex(dir string) (*imgspecv1.Index, error) {
+	index := &imgspecv1.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	index.SchemaVersion = 2
+	content, err := os.ReadFile(filepath.Join(dir, imgspecv1.ImageIndexFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return index, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %q file: %w", imgspecv1.ImageIndexFile, err)
+	}
+	return index, nil
+}
+
+// writeBlob writes the content into the blobs directory of the layout.
+func writeBlob(dir string, mediaType string, content []byte) (imgspecv1.Descriptor, error) {
+	desc := imgspecv1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+	path, _, _, err := spool(filepath.Join(dir, imgspecv1.ImageBlobsDir), func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return desc, err
+	}
+	return desc, commitBlob(dir, path, desc.Digest)
+}
+
+// commitBlob moves the spooled file to the blob path of the layout.
+func commitBlob(dir string, spooled string, dgst digest.Digest) error {
+	p, err := layout.BlobPath(dgst)
+	if err != nil {
+		return err
+	}
+	return commit(spooled, filepath.Join(dir, filepath.FromSlash(p)))
+}
+
+// writeJSON writes the value as JSON into the file.
+func writeJSON(path string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0o644) //nolint:gosec,mnd // default file permission
+}
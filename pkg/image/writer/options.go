@@ -0,0 +1,87 @@
+package writer
+
+import "os"
+
+const (
+	// CompressionNone writes the layer blobs uncompressed.
+	CompressionNone = "none"
+)
+
+// Option is the optional parameter setting method.
+type Option func(*Options)
+
+// WithCompression sets the compression format name of the layer blobs written to
+// the OCI image layout, like "gzip" and "zstd", or [CompressionNone] to write the
+// uncompressed ones. When it is empty, the original compressed blobs are kept
+// and others are compressed with gzip.
+//
+// NOTE: The layers of the docker archive are always uncompressed.
+func WithCompression(name string) Option {
+	return func(o *Options) {
+		o.Compression = name
+	}
+}
+
+// WithCacheDir sets the directory to hold the temporary files when writing the
+// archives, default to [os.TempDir].
+func WithCacheDir(dir string) Option {
+	return func(o *Options) {
+		o.CacheDir = dir
+	}
+}
+
+// WithTags sets the tags of the manifest pushed to the remote repository.
+func WithTags(tags ...string) Option {
+	return func(o *Options) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
+
+// WithChunkSize sets the size threshold of the blobs pushed to the remote
+// repository, the blobs larger than it are uploaded in chunks of the size.
+// When it is not positive, all the blobs are uploaded monolithically.
+func WithChunkSize(size int64) Option {
+	return func(o *Options) {
+		o.ChunkSize = size
+	}
+}
+
+// WithMountFrom appends the full names of the repositories, like
+// "registry.example.com/library/alpine", which the blobs are tried to be mounted
+// from when pushing to the repository in the same registry.
+func WithMountFrom(repos ...string) Option {
+	return func(o *Options) {
+		o.MountFrom = append(o.MountFrom, repos...)
+	}
+}
+
+// WithImageIDChangeAllowed sets whether the layer tarballs reproduced from the
+// filesystems are accepted when their DiffIDs differ from the "rootfs.diff_ids"
+// of the image config. When allowed, the "rootfs.diff_ids" is rewritten with the
+// DiffIDs of the written layers, which changes the image ID.
+func WithImageIDChangeAllowed(allowed bool) Option {
+	return func(o *Options) {
+		o.ImageIDChangeAllowed = allowed
+	}
+}
+
+// Options is the structure of the optional parameters.
+type Options struct {
+	Compression          string
+	CacheDir             string
+	Tags                 []string
+	ChunkSize            int64
+	MountFrom            []string
+	ImageIDChangeAllowed bool
+}
+
+// MakeOptions returns the options with all optional parameters applied.
+func MakeOptions(opts ...Option) *Options {
+	options := &Options{
+		CacheDir: os.TempDir(),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
@@ -0,0 +1,266 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression/tar"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// PushImage pushes the image to the remote repository as an OCI image manifest
+// with the tags, and returns the descriptor of the manifest pushed.
+//
+// The blobs already found in the repository are skipped, and the blobs of the
+// layers pulled from other repositories in the same registry, like the ones
+// recorded by the docker distribution database or [WithMountFrom], are mounted
+// across the repositories. Others are uploaded with the original compressed blobs
+// or the reproduced tarballs compressed with the compression option.
+func PushImage(ctx context.Context, img ocispec.Image, repo *remote.Repository, opts ...Option) (_ imgspecv1.Descriptor, err error) {
+	options := MakeOptions(opts...)
+	temper := xos.NewTemper(options.CacheDir, "push-*")
+	defer func() {
+		err = errors.Join(err, temper.Cleanup())
+	}()
+	dir, err := temper.Path()
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+
+	p := &pusher{repo: repo, options: options, dir: dir}
+	exported, err := exportImage(ctx, img, options, p.pushLayer)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+
+	configDesc := ocispec.NewDescriptorFromBytes(ocispec.MediaTypeImageConfig, exported.config)
+	if err := p.pushBytes(ctx, configDesc, exported.config); err != nil {
+		return imgspecv1.Descriptor{}, fmt.Errorf("unable to push config of image %s: %w", exported.metadata.Name, err)
+	}
+	mf := imgspecv1.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    make([]imgspecv1.Descriptor, 0, len(exported.layers)),
+	}
+	mf.SchemaVersion = 2
+	for _, layer := range exported.layers {
+		mf.Layers = append(mf.Layers, layer.descriptor)
+	}
+	content, err := json.Marshal(mf)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	desc := ocispec.NewDescriptorFromBytes(ocispec.MediaTypeImageManifest, content)
+	r := cas.NewReaderFromBytes(desc.MediaType, content)
+	if err := repo.Registry().PushManifest(ctx, repo.Name().Path(), r, options.Tags...); err != nil {
+		return imgspecv1.Descriptor{}, fmt.Errorf("unable to push manifest of image %s: %w", exported.metadata.Name, err)
+	}
+	desc.Platform = exported.metadata.Platform
+	return desc, nil
+}
+
+// pusher pushes the blobs to the remote repository.
+type pusher struct {
+	repo    *remote.Repository
+	options *Options
+	dir     string
+}
+
+// pushLayer pushes the layer blob, and the reproduced tarball is spooled into
+// the temporary directory to know the digest before uploading.
+func (p *pusher) pushLayer(ctx context.Context, layer ocispec.Layer) (exportedLayer, error) {
+	metadata := layer.Metadata()
+	if p.options.Compression == "" {
+		if written, ok, err := p.pushOriginalLayer(ctx, layer); ok || err != nil {
+			return written, err
+		}
+	}
+
+	written, err := writeLayerBlob(ctx, p.dir, layer, p.options.Compression)
+	if err != nil {
+		return exportedLayer{}, err
+	}
+	defer func() {
+		_ = os.Remove(written.path)
+	}()
+	err = p.pushBlob(ctx, written.descriptor, p.mountFrom(metadata), func(context.Context) (io.ReadCloser, error) {
+		return os.Open(written.path)
+	})
+	if err != nil {
+		return exportedLayer{}, err
+	}
+	written.path = ""
+	return written, nil
+}
+
+// pushOriginalLayer pushes the original compressed blob of the layer, and returns
+// false if it is unknown or missing.
+func (p *pusher) pushOriginalLayer(ctx context.Context, layer ocispec.Layer) (exportedLayer, bool, error) {
+	metadata := layer.Metadata()
+	if blob, ok := layer.(ocispec.BlobLayer); ok && metadata.IsCompressed {
+		desc := blob.Descriptor()
+		mediaType, ok := ocispec.ConvertToOCILayerMediaType(desc.MediaType)
+		if !ok || desc.Digest == "" {
+			return exportedLayer{}, false, nil
+		}
+		desc = imgspecv1.Descriptor{MediaType: mediaType, Digest: desc.Digest, Size: desc.Size}
+		err := p.pushBlob(ctx, desc, p.mountFrom(metadata), blob.Compressed)
+		return exportedLayer{diffID: metadata.DiffID, descriptor: desc}, true, err
+	}
+
+	// the compressed blob which the local layer is pulled from can not be read,
+	// but it may exist in the target repository or be mounted
+	if metadata.CompressedDigest == "" || metadata.DiffID == "" {
+		return exportedLayer{}, false, nil
+	}
+	desc, err := p.statBlob(ctx, metadata.CompressedDigest)
+	if err == nil {
+		xlog.C(ctx).Debugf("skip layer %s, blob %s already exists", metadata.DiffID, desc.Digest)
+	} else if desc, err = p.mountBlob(ctx, metadata.CompressedDigest, p.mountFrom(metadata)); err != nil {
+		return exportedLayer{}, false, nil //nolint:nilerr // fallback to push the reproduced tarball
+	}
+	mediaType, err := p.layerMediaType(ctx, desc)
+	if err != nil {
+		xlog.C(ctx).Debugf("unable to detect media type of blob %s, push the reproduced tarball of layer %s instead: %s",
+			desc.Digest, metadata.DiffID, err)
+		return exportedLayer{}, false, nil
+	}
+	desc = imgspecv1.Descriptor{MediaType: mediaType, Digest: desc.Digest, Size: desc.Size}
+	return exportedLayer{diffID: metadata.DiffID, descriptor: desc}, true, nil
+}
+
+// layerMediaType returns the OCI layer media type of the blob in the repository,
+// which is taken from the media type responded if known, or sniffed from the
+// leading bytes of the blob content otherwise.
+func (p *pusher) layerMediaType(ctx context.Context, desc imgspecv1.Descriptor) (string, error) {
+	if mediaType, ok := ocispec.ConvertToOCILayerMediaType(desc.MediaType); ok {
+		return mediaType, nil
+	}
+	rc, err := p.repo.Blobs().FetchDigest(ctx, desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	defer xio.CloseAndSkipError(rc)
+	format, _, err := compression.DetectReader(rc)
+	if err != nil {
+		return "", err
+	}
+	mediaType := ocispec.MediaTypeImageLayer
+	if format.Name() != tar.FormatName {
+		mediaType = fmt.Sprintf("%s+%s", ocispec.MediaTypeImageLayer, format.Name())
+	}
+	if _, err := ocispec.CompressionFormatFromMediaType(mediaType); err != nil {
+		return "", errdefs.Newf(errdefs.ErrUnsupported, "layer compression format %q", format.Name())
+	}
+	return mediaType, nil
+}
+
+// pushBytes pushes the content if not exists.
+func (p *pusher) pushBytes(ctx context.Context, desc imgspecv1.Descriptor, content []byte) error {
+	return p.pushBlob(ctx, desc, nil, func(context.Context) (io.ReadCloser, error) {
+		return xio.NopReader(bytes.NewReader(content)), nil
+	})
+}
+
+// pushBlob pushes the blob if not exists. The blob is mounted from the candidate
+// repositories first, and uploaded if all failed.
+func (p *pusher) pushBlob(ctx context.Context, desc imgspecv1.Descriptor, mountFrom []string,
+	open func(ctx context.Context) (io.ReadCloser, error)) error {
+	if _, err := p.statBlob(ctx, desc.Digest); err == nil {
+		xlog.C(ctx).Debugf("skip blob %s which already exists", desc.Digest)
+		return nil
+	} else if !errors.Is(err, errdefs.ErrNotFound) {
+		return err
+	}
+	if _, err := p.mountBlob(ctx, desc.Digest, mountFrom); err == nil {
+		return nil
+	}
+
+	if p.options.ChunkSize > 0 && desc.Size > p.options.ChunkSize {
+		return p.pushBlobChunked(ctx, desc, open)
+	}
+	return p.repo.Blobs().Push(ctx, func(ctx context.Context) (cas.ReadCloser, error) {
+		rc, err := open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return cas.NewReadCloser(rc, desc), nil
+	})
+}
+
+func (p *pusher) pushBlobChunked(ctx context.Context, desc imgspecv1.Descriptor,
+	open func(ctx context.Context) (io.ReadCloser, error)) error {
+	rc, err := open(ctx)
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	w, err := p.repo.Registry().PushBlobChunked(ctx, p.repo.Name().Path(), p.options.ChunkSize)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, cas.NewReadCloser(rc, desc)); err == nil {
+		_, err = w.Commit(desc.Digest)
+	}
+	if err != nil {
+		// free the upload session on the registry
+		_ = w.Cancel()
+	}
+	return err
+}
+
+// statBlob returns the descriptor of the blob in the repository.
+func (p *pusher) statBlob(ctx context.Context, dgst digest.Digest) (imgspecv1.Descriptor, error) {
+	return p.repo.Blobs().Stat(ctx, dgst.String())
+}
+
+// mountBlob mounts the blob from the first repository which succeeds.
+func (p *pusher) mountBlob(ctx context.Context, dgst digest.Digest, from []string) (imgspecv1.Descriptor, error) {
+	for _, repo := range from {
+		mounted, err := p.repo.Registry().MountBlob(ctx, p.repo.Name().Path(), repo, dgst)
+		if err != nil {
+			xlog.C(ctx).Debugf("unable to mount blob %s from %s: %s", dgst, repo, err)
+			continue
+		}
+		if mounted {
+			xlog.C(ctx).Debugf("mounted blob %s from %s", dgst, repo)
+			return p.statBlob(ctx, dgst)
+		}
+	}
+	return imgspecv1.Descriptor{}, fmt.Errorf("%w: blob %s to mount", errdefs.ErrNotFound, dgst)
+}
+
+// mountFrom returns the paths of the repositories in the same registry which the
+// blobs of the layer can be mounted from.
+func (p *pusher) mountFrom(metadata ocispec.LayerMetadata) []string {
+	target := p.repo.Name()
+	paths := []string{}
+	for _, name := range append(p.options.MountFrom, metadata.SourceRepositories...) {
+		repo, err := ocispecname.NewRepository(name)
+		if err != nil {
+			continue
+		}
+		if repo.Domain().Hostname() != target.Domain().Hostname() || repo.Path() == target.Path() {
+			continue
+		}
+		paths = append(paths, repo.Path())
+	}
+	return paths
+}
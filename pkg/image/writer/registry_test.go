@@ -0,0 +1,217 @@
+package writer_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/image/writer"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/server"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+func init() {
+	ocispecname.RegisterScheme("http")
+}
+
+// testRegistry is the in-memory registry recording the requests received.
+type testRegistry struct {
+	*remote.Registry
+	host string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+	reg := &testRegistry{}
+	handler := server.NewHandler(memory.New())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reg.mu.Lock()
+		reg.requests = append(reg.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		reg.mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	name, err := ocispecname.NewRegistry(srv.URL)
+	require.NoError(t, err)
+	reg.Registry, err = remote.NewClient().NewRegistry(context.Background(), name)
+	require.NoError(t, err)
+	reg.host = name.Hostname()
+	return reg
+}
+
+// take returns the requests received since the last call matched by the method
+// and the path prefix.
+func (reg *testRegistry) take(method string, prefix string) []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	var matched []string
+	for _, request := range reg.requests {
+		if strings.HasPrefix(request, method+" "+prefix) {
+			matched = append(matched, request)
+		}
+	}
+	reg.requests = nil
+	return matched
+}
+
+func (reg *testRegistry) manifest(t *testing.T, repo string, tagOrDigest string) (imgspecv1.Descriptor, imgspecv1.Manifest) {
+	t.Helper()
+	rc, err := reg.Repository(repo).Manifests().FetchTagOrDigest(context.Background(), tagOrDigest)
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	mf := imgspecv1.Manifest{}
+	require.NoError(t, json.Unmarshal(content, &mf))
+	return rc.Descriptor(), mf
+}
+
+func (reg *testRegistry) blob(t *testing.T, repo string, dgst digest.Digest) []byte {
+	t.Helper()
+	rc, err := reg.GetBlob(context.Background(), repo, dgst)
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return content
+}
+
+func TestPushImage(t *testing.T) {
+	ctx := context.Background()
+	src := newTestImage(t)
+	reg := newTestRegistry(t)
+
+	desc, err := writer.PushImage(ctx, src.open(t), reg.Repository("library/app"),
+		writer.WithCacheDir(t.TempDir()), writer.WithTags("v1", "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, ocispec.MediaTypeImageManifest, desc.MediaType)
+	assert.Len(t, reg.take(http.MethodPost, "/v2/library/app/blobs/uploads/"), 2)
+
+	for _, tag := range []string{"v1", "latest"} {
+		got, mf := reg.manifest(t, "library/app", tag)
+		assert.Equal(t, desc.Digest, got.Digest)
+		assert.Equal(t, src.configDesc.Digest, mf.Config.Digest)
+		assert.Equal(t, []imgspecv1.Descriptor{src.layerDesc}, mf.Layers)
+	}
+
+	t.Run("skip if exists", func(t *testing.T) {
+		pushed, err := writer.PushImage(ctx, src.open(t), reg.Repository("library/app"), writer.WithCacheDir(t.TempDir()))
+		require.NoError(t, err)
+		assert.Equal(t, desc.Digest, pushed.Digest)
+		assert.Empty(t, reg.take(http.MethodPost, "/v2/library/app/blobs/uploads/"))
+	})
+
+	t.Run("mount from", func(t *testing.T) {
+		_, err := writer.PushImage(ctx, src.open(t), reg.Repository("library/mounted"),
+			writer.WithCacheDir(t.TempDir()), writer.WithMountFrom(reg.host+"/library/app"))
+		require.NoError(t, err)
+		uploads := reg.take(http.MethodPost, "/v2/library/mounted/blobs/uploads/")
+		// the layer is mounted while the config is uploaded
+		assert.Equal(t, []string{
+			"POST /v2/library/mounted/blobs/uploads/?mount=" + src.layerDesc.Digest.String() + "&from=library/app",
+			"POST /v2/library/mounted/blobs/uploads/?",
+		}, uploads)
+		_, mf := reg.manifest(t, "library/mounted", desc.Digest.String())
+		assert.Equal(t, []imgspecv1.Descriptor{src.layerDesc}, mf.Layers)
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		chunkSize := int64(16)
+		require.Greater(t, src.layerDesc.Size, chunkSize)
+		_, err := writer.PushImage(ctx, src.open(t), reg.Repository("library/chunked"),
+			writer.WithCacheDir(t.TempDir()), writer.WithChunkSize(chunkSize), writer.WithTags("v1"))
+		require.NoError(t, err)
+		// the layer is uploaded in a chunked session while the small config is not
+		assert.NotEmpty(t, reg.take(http.MethodPatch, "/v2/library/chunked/blobs/uploads/"))
+
+		_, mf := reg.manifest(t, "library/chunked", "v1")
+		require.Len(t, mf.Layers, 1)
+		assert.Equal(t, digest.FromBytes(reg.blob(t, "library/chunked", mf.Layers[0].Digest)), mf.Layers[0].Digest)
+	})
+}
+
+// distributedLayer is the filesystem layer pulled from the registry, whose
+// compressed blob can not be read locally.
+type distributedLayer struct {
+	fsLayer
+	compressed digest.Digest
+	sources    []string
+}
+
+func (l *distributedLayer) Metadata() ocispec.LayerMetadata {
+	metadata := l.fsLayer.Metadata()
+	metadata.CompressedDigest = l.compressed
+	metadata.SourceRepositories = l.sources
+	return metadata
+}
+
+func TestPushImage_DistributedLayer(t *testing.T) {
+	ctx := context.Background()
+	src := newTestImage(t)
+	reg := newTestRegistry(t)
+	_, err := writer.PushImage(ctx, src.open(t), reg.Repository("library/gzip"), writer.WithCacheDir(t.TempDir()))
+	require.NoError(t, err)
+	_, err = writer.PushImage(ctx, src.open(t), reg.Repository("library/plain"),
+		writer.WithCacheDir(t.TempDir()), writer.WithCompression(writer.CompressionNone))
+	require.NoError(t, err)
+
+	config, err := src.open(t).ConfigFile(ctx)
+	require.NoError(t, err)
+	testcases := []struct {
+		name          string
+		compressed    digest.Digest
+		source        string
+		wantMediaType string
+	}{
+		{
+			name:          "gzip blob",
+			compressed:    src.layerDesc.Digest,
+			source:        "library/gzip",
+			wantMediaType: ocispec.MediaTypeImageLayerGzip,
+		},
+		{
+			name:          "uncompressed blob",
+			compressed:    src.diffID,
+			source:        "library/plain",
+			wantMediaType: ocispec.MediaTypeImageLayer,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			img := &fsImage{config: config, layers: []ocispec.Layer{&distributedLayer{
+				// the filesystem is never read since the blob is mounted
+				fsLayer:    fsLayer{fsys: fstest.MapFS{}, diffID: src.diffID},
+				compressed: tc.compressed,
+				sources:    []string{reg.host + "/" + tc.source},
+			}}}
+			target := "library/" + strings.ReplaceAll(tc.name, " ", "-")
+			desc, err := writer.PushImage(ctx, img, reg.Repository(target), writer.WithCacheDir(t.TempDir()))
+			require.NoError(t, err)
+			// mounted from the source repository without uploading
+			assert.Empty(t, reg.take(http.MethodPatch, "/v2/"+target+"/blobs/uploads/"))
+
+			_, mf := reg.manifest(t, target, desc.Digest.String())
+			require.Len(t, mf.Layers, 1)
+			assert.Equal(t, tc.wantMediaType, mf.Layers[0].MediaType)
+			assert.Equal(t, tc.compressed, mf.Layers[0].Digest)
+		})
+	}
+}
@@ -0,0 +1,251 @@
+// Package writer provides the writers saving the images into the local formats,
+// like the docker archive and the OCI image layout, or pushing them to the remote
+// repositories.
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+// exportedImage is the image with all the layer blobs written.
+type exportedImage struct {
+	metadata ocispec.ImageMetadata
+	config   []byte
+	layers   []exportedLayer
+}
+
+// exportedLayer is the layer blob written.
+type exportedLayer struct {
+	diffID     digest.Digest
+	descriptor imgspecv1.Descriptor
+	path       string
+}
+
+// layerWriter writes the layer blob.
+type layerWriter func(ctx context.Context, layer ocispec.Layer) (exportedLayer, error)
+
+// exportImage writes all the layer blobs of the image with the layerWriter, and
+// returns the image config with DiffIDs of the written layers.
+//
+// It fails with [blobfs.ErrDiffIDMismatch] when any written layer has a different
+// DiffID, unless the image ID change is allowed by the options.
+func exportImage(ctx context.Context, img ocispec.Image, options *Options, write layerWriter) (*exportedImage, error) {
+	if options.ImageIDChangeAllowed {
+		ctx = blobfs.WithDiffIDMismatchAllowed(ctx)
+	}
+	metadata := img.Metadata()
+	config, err := img.ConfigFile(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file of image %s: %w", metadata.Name, err)
+	}
+	layers, err := img.Layers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get layers of image %s: %w", metadata.Name, err)
+	}
+	exported := &exportedImage{metadata: metadata}
+	diffIDs := make([]digest.Digest, 0, len(layers))
+	for _, layer := range layers {
+		xlog.C(ctx).Debugf("writing layer %s of image %s", layer.Metadata().DiffID, metadata.Name)
+		written, err := write(ctx, layer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to write layer %s of image %s: %w", layer.Metadata().DiffID, metadata.Name, err)
+		}
+		exported.layers = append(exported.layers, written)
+		diffIDs = append(diffIDs, written.diffID)
+	}
+	exported.config, err = rewriteDiffIDs(ctx, config, diffIDs, options.ImageIDChangeAllowed)
+	if err != nil {
+		return nil, err
+	}
+	return exported, nil
+}
+
+// rewriteDiffIDs replaces the "rootfs.diff_ids" of the image config with the
+// DiffIDs of the written layers when they are different and allowed, which
+// happens when the layer tarballs are reproduced from the filesystems.
+func rewriteDiffIDs(ctx context.Context, config []byte, diffIDs []digest.Digest, allowed bool) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(config, &fields); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal image config: %w", err)
+	}
+	rootfs := imgspecv1.RootFS{}
+	if raw, ok := fields["rootfs"]; ok {
+		if err := json.Unmarshal(raw, &rootfs); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal rootfs of image config: %w", err)
+		}
+	}
+	if slices.Equal(rootfs.DiffIDs, diffIDs) {
+		return config, nil
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: diff_ids of the image config %v != %v of the written layers, which changes the image id",
+			blobfs.ErrDiffIDMismatch, rootfs.DiffIDs, diffIDs)
+	}
+	xlog.C(ctx).Warnf("rewrite diff_ids of the image config since the written layers differ, the image id is changed")
+	rootfs.DiffIDs = diffIDs
+	if rootfs.Type == "" {
+		rootfs.Type = "layers"
+	}
+	raw, err := json.Marshal(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	fields["rootfs"] = raw
+	return json.Marshal(fields)
+}
+
+// writeLayerBlob writes the layer blob into the dir with the compression format
+// name, and returns the descriptor with the temporary file path. When the name
+// is empty, the original compressed blob is copied if possible.
+func writeLayerBlob(ctx context.Context, dir string, layer ocispec.Layer, name string) (exportedLayer, error) {
+	metadata := layer.Metadata()
+	if blob, ok := layer.(ocispec.BlobLayer); ok && name == "" && metadata.IsCompressed {
+		desc := blob.Descriptor()
+		if mediaType, ok := ocispec.ConvertToOCILayerMediaType(desc.MediaType); ok {
+			return copyCompressedBlob(ctx, dir, blob, mediaType)
+		}
+	}
+
+	mediaType := ocispec.MediaTypeImageLayer
+	var format compression.Format
+	switch name {
+	case CompressionNone:
+	case "":
+		name = "gzip"
+		fallthrough
+	default:
+		f, err := compression.GetFormat(name)
+		if err != nil {
+			return exportedLayer{}, err
+		}
+		format = f
+		mediaType = fmt.Sprintf("%s+%s", ocispec.MediaTypeImageLayer, f.Name())
+		if _, err := ocispec.CompressionFormatFromMediaType(mediaType); err != nil {
+			return exportedLayer{}, errdefs.Newf(errdefs.ErrUnsupported, "layer compression format %q", f.Name())
+		}
+	}
+
+	rc, err := uncompressed(ctx, layer)
+	if err != nil {
+		return exportedLayer{}, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	diffDigester := digest.Canonical.Digester()
+	path, dgst, size, err := spool(dir, func(w io.Writer) error {
+		wc := xio.NopWriter(w)
+		if format != nil {
+			compressor, err := format.Compress(w)
+			if err != nil {
+				return err
+			}
+			wc = compressor
+		}
+		if _, err := io.Copy(io.MultiWriter(wc, diffDigester.Hash()), rc); err != nil {
+			return errors.Join(err, wc.Close())
+		}
+		return wc.Close()
+	})
+	if err != nil {
+		return exportedLayer{}, err
+	}
+	return exportedLayer{
+		diffID:     diffDigester.Digest(),
+		descriptor: imgspecv1.Descriptor{MediaType: mediaType, Digest: dgst, Size: size},
+		path:       path,
+	}, nil
+}
+
+func copyCompressedBlob(ctx context.Context, dir string, blob ocispec.BlobLayer, mediaType string) (exportedLayer, error) {
+	rc, err := blob.Compressed(ctx)
+	if err != nil {
+		return exportedLayer{}, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	path, dgst, size, err := spool(dir, func(w io.Writer) error {
+		_, err := io.Copy(w, rc)
+		return err
+	})
+	if err != nil {
+		return exportedLayer{}, err
+	}
+	if want := blob.Descriptor().Digest; want != "" && want != dgst {
+		xlog.C(ctx).Warnf("digest of the copied layer blob mismatched: %s != %s", dgst, want)
+	}
+	return exportedLayer{
+		diffID:     blob.Metadata().DiffID,
+		descriptor: imgspecv1.Descriptor{MediaType: mediaType, Digest: dgst, Size: size},
+		path:       path,
+	}, nil
+}
+
+// uncompressed returns the reader of the uncompressed layer tarball, which is
+// reproduced from the filesystem if the layer is not a blob.
+func uncompressed(ctx context.Context, layer ocispec.Layer) (io.ReadCloser, error) {
+	if uncompressor, ok := layer.(ocispec.Uncompressor); ok {
+		return uncompressor.Uncompressed(ctx)
+	}
+	if fsLayer, ok := layer.(ocispec.FSLayer); ok {
+		return blobfs.NewFSBlob(fsLayer).Uncompressed(ctx)
+	}
+	return nil, errdefs.Newf(errdefs.ErrUnsupported, "layer %s with type %T", layer.Metadata().DiffID, layer)
+}
+
+// spool writes the content to a temporary file under dir, and returns the file
+// path, digest and size of the content.
+func spool(dir string, write func(w io.Writer) error) (string, digest.Digest, int64, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil { //nolint:mnd // default directory permission
+		return "", "", 0, err
+	}
+	file, err := os.CreateTemp(dir, ".spool-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+	digester := digest.Canonical.Digester()
+	counter := xio.NewMeasuredWriter(io.MultiWriter(file, digester.Hash()))
+	err = errors.Join(write(counter), file.Close())
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", "", 0, err
+	}
+	return file.Name(), digester.Digest(), counter.Total(), nil
+}
+
+// commit moves the spooled file to the path, and the spooled file is removed if
+// the path already exists since the content is addressed by the digest.
+func commit(spooled string, path string) error {
+	exists, err := xos.Exists(path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return os.Remove(spooled)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:mnd // default directory permission
+		return err
+	}
+	// the temporary file is created with 0600 permission
+	if err := os.Chmod(spooled, 0o644); err != nil { //nolint:gosec,mnd // default file permission
+		return err
+	}
+	return os.Rename(spooled, path)
+}
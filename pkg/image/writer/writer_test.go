@@ -0,0 +1,329 @@
+package writer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/image"
+	"github.com/wuxler/ruasec/pkg/image/blobfs"
+	dockerarchive "github.com/wuxler/ruasec/pkg/image/docker/archive"
+	ociarchive "github.com/wuxler/ruasec/pkg/image/oci/archive"
+	"github.com/wuxler/ruasec/pkg/image/oci/layout"
+	"github.com/wuxler/ruasec/pkg/image/writer"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression"
+	_ "github.com/wuxler/ruasec/pkg/util/xio/compression/builtin"
+	"github.com/wuxler/ruasec/pkg/util/xio/compression/gzip"
+)
+
+const testImageName = "registry.example.com/library/app:v1"
+
+// testImage is the source image held in an OCI image layout with a gzip
+// compressed layer.
+type testImage struct {
+	dir        string
+	layer      []byte
+	diffID     digest.Digest
+	layerDesc  imgspecv1.Descriptor
+	configDesc imgspecv1.Descriptor
+}
+
+func newTestImage(t *testing.T) *testImage {
+	t.Helper()
+	layer := &bytes.Buffer{}
+	tw := tar.NewWriter(layer)
+	content := "hello"
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "hello.txt", Mode: 0o644, Size: int64(len(content))}))
+	_, err := tw.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	format, err := compression.GetFormat(gzip.FormatName)
+	require.NoError(t, err)
+	compressed := &bytes.Buffer{}
+	zw, err := format.Compress(compressed)
+	require.NoError(t, err)
+	_, err = zw.Write(layer.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	img := &testImage{
+		dir:    t.TempDir(),
+		layer:  layer.Bytes(),
+		diffID: digest.FromBytes(layer.Bytes()),
+	}
+	img.layerDesc = img.writeBlob(t, ocispec.MediaTypeImageLayerGzip, compressed.Bytes())
+	config := imgspecv1.Image{
+		Platform: imgspecv1.Platform{Architecture: "amd64", OS: "linux"},
+		RootFS:   imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{img.diffID}},
+		History:  []imgspecv1.History{{CreatedBy: "COPY hello.txt /"}},
+	}
+	img.configDesc = img.writeBlob(t, ocispec.MediaTypeImageConfig, mustJSON(t, config))
+	mf := imgspecv1.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    img.configDesc,
+		Layers:    []imgspecv1.Descriptor{img.layerDesc},
+	}
+	mf.SchemaVersion = 2
+	mfDesc := img.writeBlob(t, ocispec.MediaTypeImageManifest, mustJSON(t, mf))
+	mfDesc.Annotations = map[string]string{layout.AnnotationContainerdImageName: testImageName}
+	index := imgspecv1.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []imgspecv1.Descriptor{mfDesc}}
+	index.SchemaVersion = 2
+	require.NoError(t, os.WriteFile(filepath.Join(img.dir, imgspecv1.ImageIndexFile), mustJSON(t, index), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(img.dir, imgspecv1.ImageLayoutFile),
+		mustJSON(t, imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion}), 0o600))
+	return img
+}
+
+func (img *testImage) writeBlob(t *testing.T, mediaType string, content []byte) imgspecv1.Descriptor {
+	t.Helper()
+	desc := ocispec.NewDescriptorFromBytes(mediaType, content)
+	path := filepath.Join(img.dir, "blobs", "sha256", desc.Digest.Encoded())
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+	return desc
+}
+
+func (img *testImage) open(t *testing.T) ocispec.Image {
+	t.Helper()
+	ctx := context.Background()
+	storage, err := layout.NewStorageFromDir(ctx, img.dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = storage.Close() })
+	opened, err := storage.GetImage(ctx, testImageName, image.WithCacheDir(t.TempDir()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = opened.Close() })
+	return opened
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	content, err := json.Marshal(v)
+	require.NoError(t, err)
+	return content
+}
+
+// assertImage checks the image read back has the same image ID, repo tags and
+// layer tarballs as the source, and returns the layer descriptors.
+func assertImage(t *testing.T, src *testImage, got ocispec.ImageCloser) []imgspecv1.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	defer got.Close()
+	metadata := got.Metadata()
+	assert.Equal(t, src.configDesc.Digest, metadata.ID)
+	assert.Equal(t, []string{testImageName}, metadata.RepoTags)
+
+	layers, err := got.Layers(ctx)
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	assert.Equal(t, src.diffID, layers[0].Metadata().DiffID)
+	blob, ok := layers[0].(ocispec.BlobLayer)
+	require.True(t, ok)
+	rc, err := blob.Uncompressed(ctx)
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, src.layer, content)
+	return []imgspecv1.Descriptor{blob.Descriptor()}
+}
+
+// readLayoutIndex returns the descriptors in "index.json" and the manifest of
+// the first one from the layout filesystem.
+func readLayoutIndex(t *testing.T, fsys fs.FS) ([]imgspecv1.Descriptor, imgspecv1.Manifest) {
+	t.Helper()
+	index := imgspecv1.Index{}
+	require.NoError(t, json.Unmarshal(mustReadFile(t, fsys, imgspecv1.ImageIndexFile), &index))
+	require.NotEmpty(t, index.Manifests)
+	mf := imgspecv1.Manifest{}
+	require.NoError(t, json.Unmarshal(mustReadFile(t, fsys, "blobs/sha256/"+index.Manifests[0].Digest.Encoded()), &mf))
+	return index.Manifests, mf
+}
+
+func mustReadFile(t *testing.T, fsys fs.FS, name string) []byte {
+	t.Helper()
+	content, err := fs.ReadFile(fsys, name)
+	require.NoError(t, err)
+	return content
+}
+
+func TestWriteDockerArchive_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newTestImage(t)
+	path := filepath.Join(t.TempDir(), "docker.tar")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteDockerArchive(ctx, file, []ocispec.Image{src.open(t)}, writer.WithCacheDir(t.TempDir())))
+	require.NoError(t, file.Close())
+
+	storage, err := dockerarchive.NewStorageFromFile(ctx, path)
+	require.NoError(t, err)
+	defer storage.Close()
+	got, err := storage.GetImage(ctx, testImageName, image.WithCacheDir(t.TempDir()))
+	require.NoError(t, err)
+	descriptors := assertImage(t, src, got)
+	// the layers of the docker archive are always uncompressed
+	assert.Equal(t, src.diffID, descriptors[0].Digest)
+}
+
+func TestWriteOCILayout_RoundTrip(t *testing.T) {
+	testcases := []struct {
+		name             string
+		compression      string
+		wantMediaType    string
+		wantKeptLayer    bool
+		wantUncompressed bool
+	}{
+		{
+			name:          "original compressed layers kept",
+			wantMediaType: ocispec.MediaTypeImageLayerGzip,
+			wantKeptLayer: true,
+		},
+		{
+			name:          "recompressed",
+			compression:   "zstd",
+			wantMediaType: ocispec.MediaTypeImageLayer + "+zstd",
+		},
+		{
+			name:             "uncompressed",
+			compression:      writer.CompressionNone,
+			wantMediaType:    ocispec.MediaTypeImageLayer,
+			wantUncompressed: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			src := newTestImage(t)
+			dir := t.TempDir()
+			require.NoError(t, writer.WriteOCILayout(ctx, dir, []ocispec.Image{src.open(t)},
+				writer.WithCompression(tc.compression), writer.WithCacheDir(t.TempDir())))
+
+			descriptors, mf := readLayoutIndex(t, os.DirFS(dir))
+			require.Len(t, descriptors, 1)
+			assert.Equal(t, "v1", descriptors[0].Annotations[imgspecv1.AnnotationRefName])
+			assert.Equal(t, testImageName, descriptors[0].Annotations[layout.AnnotationContainerdImageName])
+			assert.Equal(t, src.configDesc.Digest, mf.Config.Digest)
+			require.Len(t, mf.Layers, 1)
+			assert.Equal(t, tc.wantMediaType, mf.Layers[0].MediaType)
+			switch {
+			case tc.wantKeptLayer:
+				assert.Equal(t, src.layerDesc, mf.Layers[0])
+			case tc.wantUncompressed:
+				assert.Equal(t, src.diffID, mf.Layers[0].Digest)
+			default:
+				assert.NotEqual(t, src.layerDesc.Digest, mf.Layers[0].Digest)
+			}
+
+			storage, err := layout.NewStorageFromDir(ctx, dir)
+			require.NoError(t, err)
+			defer storage.Close()
+			got, err := storage.GetImage(ctx, testImageName, image.WithCacheDir(t.TempDir()))
+			require.NoError(t, err)
+			assert.Equal(t, mf.Layers, assertImage(t, src, got))
+		})
+	}
+}
+
+func TestWriteOCIArchive_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newTestImage(t)
+	path := filepath.Join(t.TempDir(), "oci.tar")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteOCIArchive(ctx, file, []ocispec.Image{src.open(t)}, writer.WithCacheDir(t.TempDir())))
+	require.NoError(t, file.Close())
+
+	storage, err := ociarchive.NewStorageFromFile(ctx, path)
+	require.NoError(t, err)
+	defer storage.Close()
+	got, err := storage.GetImage(ctx, testImageName, image.WithCacheDir(t.TempDir()))
+	require.NoError(t, err)
+	descriptors := assertImage(t, src, got)
+	assert.Equal(t, src.layerDesc, descriptors[0])
+
+	// resolved by the "org.opencontainers.image.ref.name" annotation
+	got, err = storage.GetImage(ctx, "v1", image.WithCacheDir(t.TempDir()))
+	require.NoError(t, err)
+	assertImage(t, src, got)
+}
+
+// fsImage is an image with the filesystem layers whose DiffIDs recorded in the
+// config can not be reproduced.
+type fsImage struct {
+	config []byte
+	layers []ocispec.Layer
+}
+
+func (img *fsImage) Metadata() ocispec.ImageMetadata {
+	return ocispec.ImageMetadata{Name: "fs", ID: digest.FromBytes(img.config), RepoTags: []string{testImageName}}
+}
+
+func (img *fsImage) ConfigFile(_ context.Context) ([]byte, error) {
+	return img.config, nil
+}
+
+func (img *fsImage) Layers(_ context.Context) ([]ocispec.Layer, error) {
+	return img.layers, nil
+}
+
+type fsLayer struct {
+	fsys   fs.FS
+	diffID digest.Digest
+}
+
+func (l *fsLayer) Metadata() ocispec.LayerMetadata {
+	return ocispec.LayerMetadata{DiffID: l.diffID}
+}
+
+func (l *fsLayer) GetFS(_ context.Context) (fs.FS, error) {
+	return l.fsys, nil
+}
+
+func TestWriteOCILayout_ImageIDChange(t *testing.T) {
+	ctx := context.Background()
+	original := digest.FromString("original layer tarball")
+	config := mustJSON(t, imgspecv1.Image{
+		Platform: imgspecv1.Platform{Architecture: "amd64", OS: "linux"},
+		RootFS:   imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{original}},
+	})
+	img := &fsImage{
+		config: config,
+		layers: []ocispec.Layer{&fsLayer{
+			fsys:   fstest.MapFS{"hello.txt": &fstest.MapFile{Data: []byte("hello"), Mode: 0o644}},
+			diffID: original,
+		}},
+	}
+
+	t.Run("not allowed", func(t *testing.T) {
+		err := writer.WriteOCILayout(ctx, t.TempDir(), []ocispec.Image{img}, writer.WithCacheDir(t.TempDir()))
+		assert.ErrorIs(t, err, blobfs.ErrDiffIDMismatch)
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, writer.WriteOCILayout(ctx, dir, []ocispec.Image{img},
+			writer.WithCacheDir(t.TempDir()), writer.WithImageIDChangeAllowed(true)))
+		_, mf := readLayoutIndex(t, os.DirFS(dir))
+		assert.NotEqual(t, digest.FromBytes(config), mf.Config.Digest)
+
+		written := imgspecv1.Image{}
+		require.NoError(t, json.Unmarshal(mustReadFile(t, os.DirFS(dir), "blobs/sha256/"+mf.Config.Digest.Encoded()), &written))
+		require.Len(t, written.RootFS.DiffIDs, 1)
+		assert.NotEqual(t, original, written.RootFS.DiffIDs[0])
+		assert.Equal(t, "amd64", written.Architecture)
+	})
+}
@@ -41,6 +41,11 @@ type AuthFile struct {
 	isLegacy bool `json:"-"`
 }
 
+// Filename returns the path of the auth file.
+func (f *AuthFile) Filename() string {
+	return f.filename
+}
+
 // Load reads and decodes the auth config file.
 func (f *AuthFile) Load() error {
 	cfgFile, err := os.Open(f.filename)
@@ -66,7 +71,14 @@ func (f *AuthFile) Save(ctx context.Context) error {
 }
 
 // GetCredentialsStore returns a new credentials store from the settings in the
-// configuration file
+// configuration file. The credential helpers set by "credHelpers" and "credsStore"
+// are used when configured, otherwise the credentials are kept in the file.
 func (f *AuthFile) GetCredentialsStore() credentials.Store {
-	return credentials.NewFileStore(f)
+	return credentials.NewDynamicStore(credentials.NewFileStore(f), f.CredentialsStore, f.CredentialHelpers)
+}
+
+// CredentialHelper returns the name of the credential helper used for the host,
+// or empty if the credentials are kept in the file.
+func (f *AuthFile) CredentialHelper(host string) string {
+	return credentials.SelectHelper(host, f.CredentialsStore, f.CredentialHelpers)
 }
@@ -0,0 +1,257 @@
+package authfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/samber/lo"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/authn"
+	"github.com/wuxler/ruasec/pkg/ocispec/authn/credentials"
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/homedir"
+	"github.com/wuxler/ruasec/pkg/util/xdocker"
+)
+
+// EnvRegistryAuthFile is the environment variable of the auth file path shared
+// by the containers tools like podman, buildah and skopeo.
+const EnvRegistryAuthFile = "REGISTRY_AUTH_FILE"
+
+// DefaultPaths returns the auth file paths merged by default, in the order of
+// precedence from high to low:
+//
+//  1. "$REGISTRY_AUTH_FILE" if set.
+//  2. "$XDG_RUNTIME_DIR/containers/auth.json" written by "podman login".
+//  3. "$XDG_CONFIG_HOME/containers/auth.json", falls back to
+//     "~/.config/containers/auth.json".
+//  4. "$DOCKER_CONFIG/config.json", falls back to "~/.docker/config.json"
+//     written by "docker login".
+func DefaultPaths() []string {
+	var paths []string
+	if path := os.Getenv(EnvRegistryAuthFile); path != "" {
+		paths = append(paths, path)
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		paths = append(paths, filepath.Join(runtimeDir, "containers", "auth.json"))
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := homedir.Get(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		paths = append(paths, filepath.Join(configHome, "containers", "auth.json"))
+	}
+	paths = append(paths, xdocker.ConfigFile())
+	return lo.Uniq(paths)
+}
+
+// Source is a credential found in the auth files.
+type Source struct {
+	// Key is the key of the credential in the auth file, like
+	// "registry.example.com" or "registry.example.com/team/repo".
+	Key string
+	// Path is the auth file supplying the credential.
+	Path string
+	// Helper is the credential helper keeping the credential, or empty if the
+	// credential is kept in the auth file in plain text.
+	Helper string
+	// Shadowed is true when the credential is overridden by the same key in
+	// the auth file with higher precedence.
+	Shadowed bool
+	// AuthConfig is the credential.
+	AuthConfig authn.AuthConfig
+}
+
+// Username returns the username of the credential, or "<token>" when an
+// identity token is used.
+func (s Source) Username() string {
+	if s.AuthConfig.IdentityToken != "" {
+		return "<token>"
+	}
+	return s.AuthConfig.Username
+}
+
+// NewChain returns a chain merging the auth files, where the former one takes
+// precedence over the latter ones.
+func NewChain(files ...*AuthFile) *Chain {
+	listings := make([]*listing, 0, len(files))
+	for range files {
+		listings = append(listings, &listing{})
+	}
+	return &Chain{files: files, listings: listings}
+}
+
+// LoadChain loads the auth files of the paths into a chain, where the missing
+// files are skipped.
+func LoadChain(paths ...string) (*Chain, error) {
+	files := make([]*AuthFile, 0, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		file := NewAuthFile(path)
+		if err := file.Load(); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to load auth file: %w", err)
+		}
+		files = append(files, file)
+	}
+	return NewChain(files...), nil
+}
+
+// Chain merges multiple auth files with precedence.
+type Chain struct {
+	files    []*AuthFile
+	listings []*listing
+}
+
+// listing is the servers listed from the credentials store of an auth file.
+type listing struct {
+	mu      sync.Mutex
+	servers map[string]bool
+}
+
+// Files returns the auth files in the order of precedence.
+func (c *Chain) Files() []*AuthFile {
+	return slices.Clone(c.files)
+}
+
+// Lookup returns the credential for the host and the repositories accessed.
+//
+// The auth files are searched in the order of precedence, and the first one
+// with any matched key wins. In an auth file, the keys scoped to the
+// repositories are preferred from the most specific to the least, like
+// "registry.example.com/team/repo" and "registry.example.com/team", and then
+// the key of the host itself like "registry.example.com" or
+// "https://registry.example.com".
+//
+// The servers kept in the credentials store of each auth file are listed once
+// and cached by the chain, so only the keys listed are retrieved, which avoids
+// executing the credential helpers for each key.
+//
+// [authn.ErrNotFound] is returned when no credential matched.
+func (c *Chain) Lookup(ctx context.Context, host string, repositories ...string) (Source, error) {
+	keys := lookupKeys(host, repositories...)
+	var errs []error
+	for i, file := range c.files {
+		store := file.GetCredentialsStore()
+		servers, err := c.listings[i].list(ctx, store)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.Filename(), err))
+			continue
+		}
+		for _, key := range keys {
+			if !servers[key] {
+				continue
+			}
+			authConfig, err := store.Get(ctx, key)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", file.Filename(), err))
+				break
+			}
+			if authConfig != authn.EmptyAuthConfig {
+				return Source{
+					Key:        key,
+					Path:       file.Filename(),
+					Helper:     file.CredentialHelper(key),
+					AuthConfig: authConfig,
+				}, nil
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return Source{}, errors.Join(errs...)
+	}
+	return Source{}, fmt.Errorf("credential for %s: %w", host, authn.ErrNotFound)
+}
+
+// List returns all the credentials in the auth files in the order of
+// precedence, where the keys in each file are sorted.
+func (c *Chain) List(ctx context.Context) ([]Source, error) {
+	var sources []Source
+	seen := map[string]bool{}
+	for _, file := range c.files {
+		all, err := file.GetCredentialsStore().GetAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Filename(), err)
+		}
+		keys := make([]string, 0, len(all))
+		for key := range all {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			sources = append(sources, Source{
+				Key:        key,
+				Path:       file.Filename(),
+				Helper:     file.CredentialHelper(key),
+				Shadowed:   seen[key],
+				AuthConfig: all[key],
+			})
+			seen[key] = true
+		}
+	}
+	return sources, nil
+}
+
+// list returns the servers of the credentials store, which are cached once
+// listed successfully.
+func (l *listing) list(ctx context.Context, store credentials.Store) (map[string]bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.servers != nil {
+		return l.servers, nil
+	}
+	servers, err := credentials.ListServers(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	l.servers = make(map[string]bool, len(servers))
+	for _, server := range servers {
+		l.servers[server] = true
+	}
+	return l.servers, nil
+}
+
+// lookupKeys returns the keys to look up the credential for the host and the
+// repositories, from the most specific to the least.
+func lookupKeys(host string, repositories ...string) []string {
+	hosts := []string{host}
+	if isDockerHub(host) {
+		hosts = []string{name.DockerIOHostname, name.DockerIndexHostname, name.DefaultRegistry}
+	}
+	var keys []string
+	for _, repository := range repositories {
+		parts := strings.Split(repository, "/")
+		for i := len(parts); i > 0; i-- {
+			for _, h := range hosts {
+				keys = append(keys, h+"/"+strings.Join(parts[:i], "/"))
+			}
+		}
+	}
+	if isDockerHub(host) {
+		keys = append(keys, name.DockerIndexServer)
+	}
+	for _, h := range hosts {
+		keys = append(keys, h, "https://"+h, "http://"+h)
+	}
+	return lo.Uniq(keys)
+}
+
+func isDockerHub(host string) bool {
+	switch host {
+	case name.DockerIOHostname, name.DockerIndexHostname, name.DefaultRegistry:
+		return true
+	}
+	return false
+}
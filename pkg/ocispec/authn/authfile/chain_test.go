@@ -0,0 +1,181 @@
+package authfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/authn"
+)
+
+func writeAuthFile(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "auth.json")
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+	return filename
+}
+
+func TestDefaultPaths(t *testing.T) {
+	t.Setenv(EnvRegistryAuthFile, "/custom/auth.json")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	t.Setenv("XDG_CONFIG_HOME", "/home/user/.config")
+	t.Setenv("DOCKER_CONFIG", "/home/user/.docker")
+
+	want := []string{
+		"/custom/auth.json",
+		"/run/user/1000/containers/auth.json",
+		"/home/user/.config/containers/auth.json",
+		"/home/user/.docker/config.json",
+	}
+	assert.Equal(t, want, DefaultPaths())
+
+	t.Setenv(EnvRegistryAuthFile, "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	assert.Equal(t, want[2:], DefaultPaths())
+}
+
+func TestLoadChain(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	existing := writeAuthFile(t, `{"auths": {}}`)
+	chain, err := LoadChain(missing, "", existing)
+	require.NoError(t, err)
+	require.Len(t, chain.Files(), 1)
+	assert.Equal(t, existing, chain.Files()[0].Filename())
+
+	invalid := writeAuthFile(t, `{"auths": [`)
+	_, err = LoadChain(invalid)
+	assert.Error(t, err)
+}
+
+func TestChain_Lookup(t *testing.T) {
+	podman := writeAuthFile(t, `{
+	"auths": {
+		"registry.example.com/team/app": {"auth": "YXBwOmFwcA=="},
+		"registry.example.com/team": {"auth": "dGVhbTp0ZWFt"}
+	}
+}`)
+	docker := writeAuthFile(t, `{
+	"auths": {
+		"registry.example.com": {"auth": "ZG9ja2VyOmRvY2tlcg=="},
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1Yg=="},
+		"http://localhost:5000": {"auth": "bG9jYWw6bG9jYWw="}
+	}
+}`)
+	chain, err := LoadChain(podman, docker)
+	require.NoError(t, err)
+
+	testcases := []struct {
+		name         string
+		host         string
+		repositories []string
+		wantKey      string
+		wantPath     string
+		wantUsername string
+	}{
+		{
+			name:         "repository scoped",
+			host:         "registry.example.com",
+			repositories: []string{"team/app"},
+			wantKey:      "registry.example.com/team/app",
+			wantPath:     podman,
+			wantUsername: "app",
+		},
+		{
+			name:         "namespace scoped",
+			host:         "registry.example.com",
+			repositories: []string{"team/other"},
+			wantKey:      "registry.example.com/team",
+			wantPath:     podman,
+			wantUsername: "team",
+		},
+		{
+			name:         "registry fallback",
+			host:         "registry.example.com",
+			repositories: []string{"another/app"},
+			wantKey:      "registry.example.com",
+			wantPath:     docker,
+			wantUsername: "docker",
+		},
+		{
+			name:         "no repository",
+			host:         "registry.example.com",
+			wantKey:      "registry.example.com",
+			wantPath:     docker,
+			wantUsername: "docker",
+		},
+		{
+			name:         "docker hub",
+			host:         "registry-1.docker.io",
+			repositories: []string{"library/nginx"},
+			wantKey:      "https://index.docker.io/v1/",
+			wantPath:     docker,
+			wantUsername: "hub",
+		},
+		{
+			name:         "key with scheme",
+			host:         "localhost:5000",
+			wantKey:      "http://localhost:5000",
+			wantPath:     docker,
+			wantUsername: "local",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			source, err := chain.Lookup(context.Background(), tc.host, tc.repositories...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantKey, source.Key)
+			assert.Equal(t, tc.wantPath, source.Path)
+			assert.Equal(t, tc.wantUsername, source.Username())
+			assert.Empty(t, source.Helper)
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := chain.Lookup(context.Background(), "unknown.example.com", "team/app")
+		assert.ErrorIs(t, err, authn.ErrNotFound)
+	})
+}
+
+func TestChain_LookupPrecedence(t *testing.T) {
+	// the auth file with higher precedence wins even if the other one has a
+	// more specific key.
+	first := writeAuthFile(t, `{"auths": {"registry.example.com": {"auth": "Zmlyc3Q6Zmlyc3Q="}}}`)
+	second := writeAuthFile(t, `{"auths": {"registry.example.com/team/app": {"auth": "c2Vjb25kOnNlY29uZA=="}}}`)
+	chain, err := LoadChain(first, second)
+	require.NoError(t, err)
+
+	source, err := chain.Lookup(context.Background(), "registry.example.com", "team/app")
+	require.NoError(t, err)
+	assert.Equal(t, first, source.Path)
+	assert.Equal(t, "first", source.Username())
+}
+
+func TestChain_List(t *testing.T) {
+	first := writeAuthFile(t, `{"auths": {"registry.example.com": {"auth": "Zmlyc3Q6Zmlyc3Q="}}}`)
+	second := writeAuthFile(t, `{"auths": {
+		"registry.example.com": {"auth": "c2Vjb25kOnNlY29uZA=="},
+		"other.example.com": {"identitytoken": "token"}
+	}}`)
+	chain, err := LoadChain(first, second)
+	require.NoError(t, err)
+
+	sources, err := chain.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, sources, 3)
+
+	assert.Equal(t, "registry.example.com", sources[0].Key)
+	assert.Equal(t, first, sources[0].Path)
+	assert.False(t, sources[0].Shadowed)
+
+	assert.Equal(t, "other.example.com", sources[1].Key)
+	assert.Equal(t, "<token>", sources[1].Username())
+	assert.False(t, sources[1].Shadowed)
+
+	assert.Equal(t, "registry.example.com", sources[2].Key)
+	assert.Equal(t, second, sources[2].Path)
+	assert.True(t, sources[2].Shadowed)
+}
@@ -0,0 +1,137 @@
+package credentials
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/authn"
+	"github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+var _ Lister = (*dynamicStore)(nil)
+
+// NewDynamicStore creates a new credentials store which selects the store per
+// host like the docker cli does: the credential helper configured for the host
+// in helpers ("credHelpers") is preferred, then the default helper ("credsStore"),
+// and finally the fallback store which keeps the credentials in plain text.
+func NewDynamicStore(fallback Store, defaultHelper string, helpers map[string]string) Store {
+	return &dynamicStore{
+		fallback:      fallback,
+		defaultHelper: defaultHelper,
+		helpers:       helpers,
+	}
+}
+
+// SelectHelper returns the name of the credential helper used for the host, or
+// empty if the credentials should be kept in plain text. The helper configured
+// for the host in helpers is preferred over the default helper.
+func SelectHelper(host string, defaultHelper string, helpers map[string]string) string {
+	if helper, ok := helpers[host]; ok && helper != "" {
+		return helper
+	}
+	if helper, ok := helpers[name.Hostname(host)]; ok && helper != "" {
+		return helper
+	}
+	return defaultHelper
+}
+
+// dynamicStore implements a credentials store dispatching the operations to
+// the credential helpers or the fallback store.
+type dynamicStore struct {
+	fallback      Store
+	defaultHelper string
+	helpers       map[string]string
+}
+
+// Erase removes credentials from the store for a given server.
+func (s *dynamicStore) Erase(ctx context.Context, host string) error {
+	if helper := SelectHelper(host, s.defaultHelper, s.helpers); helper != "" {
+		if err := NewNativeStore(helper).Erase(ctx, host); err != nil {
+			return err
+		}
+	}
+	// always clean up the plain text credentials left by the previous logins
+	return s.fallback.Erase(ctx, host)
+}
+
+// Get retrieves credentials from the store for a given server.
+func (s *dynamicStore) Get(ctx context.Context, host string) (authn.AuthConfig, error) {
+	return s.storeFor(host).Get(ctx, host)
+}
+
+// GetAll retrieves all the credentials from the store. The credentials from
+// the credential helpers override the ones in the fallback store.
+func (s *dynamicStore) GetAll(ctx context.Context) (map[string]authn.AuthConfig, error) {
+	all, err := s.fallback.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all = maps.Clone(all)
+	if all == nil {
+		all = map[string]authn.AuthConfig{}
+	}
+	if s.defaultHelper != "" {
+		creds, err := NewNativeStore(s.defaultHelper).GetAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(all, creds)
+	}
+	for host, helper := range s.helpers {
+		if helper == "" {
+			continue
+		}
+		authConfig, err := NewNativeStore(helper).Get(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		all[host] = authConfig
+	}
+	return all, nil
+}
+
+// List returns the servers of the credentials kept in the store. The servers
+// listed by the fallback store and the default helper are merged with the hosts
+// configured for the helpers, which are not listed but retrieved on demand like
+// the docker cli does.
+func (s *dynamicStore) List(ctx context.Context) ([]string, error) {
+	servers, err := ListServers(ctx, s.fallback)
+	if err != nil {
+		return nil, err
+	}
+	if s.defaultHelper != "" {
+		listed, err := ListServers(ctx, NewNativeStore(s.defaultHelper))
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, listed...)
+	}
+	for host, helper := range s.helpers {
+		if helper != "" {
+			servers = append(servers, host)
+		}
+	}
+	slices.Sort(servers)
+	return slices.Compact(servers), nil
+}
+
+// Store saves credentials in the store.
+func (s *dynamicStore) Store(ctx context.Context, host string, authConfig authn.AuthConfig) error {
+	helper := SelectHelper(host, s.defaultHelper, s.helpers)
+	if helper == "" {
+		return s.fallback.Store(ctx, host, authConfig)
+	}
+	if err := NewNativeStore(helper).Store(ctx, host, authConfig); err != nil {
+		return err
+	}
+	// remove the plain text credentials as they are kept by the helper now
+	return s.fallback.Erase(ctx, host)
+}
+
+func (s *dynamicStore) storeFor(host string) Store {
+	if helper := SelectHelper(host, s.defaultHelper, s.helpers); helper != "" {
+		return NewNativeStore(helper)
+	}
+	return s.fallback
+}
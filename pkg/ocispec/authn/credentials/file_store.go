@@ -2,6 +2,8 @@
 
 import (
 	"context"
+	"maps"
+	"slices"
 
 	"github.com/wuxler/ruasec/pkg/ocispec/authn"
 )
@@ -11,6 +13,8 @@ type fileStoreAdapter interface {
 	Save(ctx context.Context) error
 }
 
+var _ Lister = (*fileStore)(nil)
+
 // NewFileStore creates a new file credentials store.
 func NewFileStore(file fileStoreAdapter) Store {
 	return &fileStore{file: file}
@@ -40,6 +44,15 @@ func (s *fileStore) GetAll(ctx context.Context) (map[string]authn.AuthConfig, er
 	return s.file.GetAll(ctx)
 }
 
+// List returns the servers of the credentials kept in the store.
+func (s *fileStore) List(ctx context.Context) ([]string, error) {
+	all, err := s.file.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(all)), nil
+}
+
 // Store saves credentials in the store.
 func (s *fileStore) Store(ctx context.Context, host string, authConfig authn.AuthConfig) error {
 	if err := s.file.Store(ctx, host, authConfig); err != nil {
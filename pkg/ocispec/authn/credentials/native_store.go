@@ -0,0 +1,160 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/authn"
+)
+
+const (
+	// HelperPrefix is the prefix of the credential helper binaries, which is
+	// followed by the helper name like "docker-credential-pass".
+	HelperPrefix = "docker-credential-"
+
+	// tokenUsername is the username stored by the credential helpers for the
+	// identity token.
+	tokenUsername = "<token>"
+
+	// errCredentialsNotFoundMessage is the message written by the credential
+	// helpers when the credentials are not found.
+	errCredentialsNotFoundMessage = "credentials not found in native keychain"
+)
+
+// helperCredentials is the payload of the credential helper protocol.
+//
+// See https://github.com/docker/docker-credential-helpers
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+var _ Lister = (*nativeStore)(nil)
+
+// NewNativeStore creates a new credentials store which executes the credential
+// helper binary "docker-credential-<helper>" found in PATH, and speaks the
+// credential helper protocol over stdin and stdout.
+func NewNativeStore(helper string) Store {
+	return &nativeStore{program: HelperPrefix + helper}
+}
+
+// nativeStore implements a credentials store using the credential helper to
+// keep the credentials in the native keychain like pass, secretservice and
+// osxkeychain.
+type nativeStore struct {
+	program string
+}
+
+// Erase removes credentials from the store for a given server.
+func (s *nativeStore) Erase(ctx context.Context, host string) error {
+	_, err := s.execute(ctx, "erase", strings.NewReader(host))
+	if errors.Is(err, authn.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Get retrieves credentials from the store for a given server. An empty
+// AuthConfig is returned when not found.
+func (s *nativeStore) Get(ctx context.Context, host string) (authn.AuthConfig, error) {
+	out, err := s.execute(ctx, "get", strings.NewReader(host))
+	if err != nil {
+		if errors.Is(err, authn.ErrNotFound) {
+			return authn.EmptyAuthConfig, nil
+		}
+		return authn.EmptyAuthConfig, err
+	}
+	creds := helperCredentials{}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return authn.EmptyAuthConfig, fmt.Errorf("%s: unable to parse output of get: %w", s.program, err)
+	}
+	if creds.Username == tokenUsername {
+		return authn.AuthConfig{IdentityToken: creds.Secret}, nil
+	}
+	return authn.AuthConfig{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
+
+// GetAll retrieves all the credentials from the store.
+func (s *nativeStore) GetAll(ctx context.Context) (map[string]authn.AuthConfig, error) {
+	servers, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]authn.AuthConfig, len(servers))
+	for _, server := range servers {
+		authConfig, err := s.Get(ctx, server)
+		if err != nil {
+			return nil, err
+		}
+		all[server] = authConfig
+	}
+	return all, nil
+}
+
+// List returns the servers of the credentials kept in the store, which only
+// executes the "list" action of the credential helper.
+func (s *nativeStore) List(ctx context.Context) ([]string, error) {
+	out, err := s.execute(ctx, "list", nil)
+	if err != nil {
+		return nil, err
+	}
+	servers := map[string]string{}
+	if err := json.Unmarshal(out, &servers); err != nil {
+		return nil, fmt.Errorf("%s: unable to parse output of list: %w", s.program, err)
+	}
+	return slices.Sorted(maps.Keys(servers)), nil
+}
+
+// Store saves credentials in the store.
+func (s *nativeStore) Store(ctx context.Context, host string, authConfig authn.AuthConfig) error {
+	creds := helperCredentials{
+		ServerURL: host,
+		Username:  authConfig.Username,
+		Secret:    authConfig.Password,
+	}
+	if authConfig.IdentityToken != "" {
+		creds.Username = tokenUsername
+		creds.Secret = authConfig.IdentityToken
+	}
+	payload, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	_, err = s.execute(ctx, "store", bytes.NewReader(payload))
+	return err
+}
+
+// execute runs the credential helper with the action and the input, and returns
+// the output. [authn.ErrNotFound] is returned when the credentials are not found.
+func (s *nativeStore) execute(ctx context.Context, action string, input io.Reader) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, s.program, action)
+	if input != nil {
+		cmd.Stdin = input
+	}
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	if err := cmd.Run(); err != nil {
+		// helpers write the error message to stdout
+		message := strings.TrimSpace(stdout.String())
+		if message == errCredentialsNotFoundMessage {
+			return nil, fmt.Errorf("%s %s: %w", s.program, action, authn.ErrNotFound)
+		}
+		if message != "" {
+			return nil, fmt.Errorf("%s %s: %s: %w", s.program, action, message, err)
+		}
+		return nil, fmt.Errorf("%s %s: %w", s.program, action, err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,202 @@
+package credentials_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/authn"
+	"github.com/wuxler/ruasec/pkg/ocispec/authn/authfile"
+	"github.com/wuxler/ruasec/pkg/ocispec/authn/credentials"
+)
+
+// fakeHelperScript is a credential helper keeping the payloads as files in the
+// directory of FAKE_HELPER_DIR, named by the hex encoded server URL.
+const fakeHelperScript = `#!/bin/sh
+dir="$FAKE_HELPER_DIR"
+key() { printf '%s' "$1" | od -An -tx1 | tr -d ' \n'; }
+field() { sed -n "s/.*\"$1\":\"\([^\"]*\)\".*/\1/p" "$2"; }
+case "$1" in
+store)
+	input=$(cat)
+	server=$(printf '%s' "$input" | sed -n 's/.*"ServerURL":"\([^"]*\)".*/\1/p')
+	printf '%s' "$input" > "$dir/$(key "$server")"
+	;;
+get|erase)
+	file="$dir/$(key "$(cat)")"
+	if [ ! -f "$file" ]; then
+		echo "credentials not found in native keychain"
+		exit 1
+	fi
+	if [ "$1" = "get" ]; then cat "$file"; else rm -f "$file"; fi
+	;;
+list)
+	sep=""
+	printf '{'
+	for file in "$dir"/*; do
+		[ -f "$file" ] || continue
+		printf '%s"%s":"%s"' "$sep" "$(field ServerURL "$file")" "$(field Username "$file")"
+		sep=","
+	done
+	printf '}'
+	;;
+*)
+	echo "unknown action: $1"
+	exit 1
+	;;
+esac
+`
+
+// setupFakeHelper installs the fake "docker-credential-<helper>" into PATH.
+func setupFakeHelper(t *testing.T, helpers ...string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake credential helper script requires a POSIX shell")
+	}
+	binDir := t.TempDir()
+	for _, helper := range helpers {
+		filename := filepath.Join(binDir, credentials.HelperPrefix+helper)
+		require.NoError(t, os.WriteFile(filename, []byte(fakeHelperScript), 0o700)) //nolint:gosec // executable script
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_HELPER_DIR", t.TempDir())
+}
+
+func TestNativeStore(t *testing.T) {
+	setupFakeHelper(t, "fake")
+	ctx := context.Background()
+	store := credentials.NewNativeStore("fake")
+
+	t.Run("get not found", func(t *testing.T) {
+		got, err := store.Get(ctx, "registry.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, authn.EmptyAuthConfig, got)
+	})
+
+	t.Run("erase not found", func(t *testing.T) {
+		assert.NoError(t, store.Erase(ctx, "registry.example.com"))
+	})
+
+	t.Run("store and get", func(t *testing.T) {
+		basic := authn.AuthConfig{Username: "admin", Password: "hello"}
+		require.NoError(t, store.Store(ctx, "registry.example.com", basic))
+		token := authn.AuthConfig{IdentityToken: "my-token"}
+		require.NoError(t, store.Store(ctx, "https://index.docker.io/v1/", token))
+
+		got, err := store.Get(ctx, "registry.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, basic, got)
+		got, err = store.Get(ctx, "https://index.docker.io/v1/")
+		require.NoError(t, err)
+		assert.Equal(t, token, got)
+
+		all, err := store.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]authn.AuthConfig{
+			"registry.example.com":        basic,
+			"https://index.docker.io/v1/": token,
+		}, all)
+
+		servers, err := credentials.ListServers(ctx, store)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://index.docker.io/v1/", "registry.example.com"}, servers)
+	})
+
+	t.Run("erase", func(t *testing.T) {
+		require.NoError(t, store.Erase(ctx, "registry.example.com"))
+		got, err := store.Get(ctx, "registry.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, authn.EmptyAuthConfig, got)
+	})
+
+	t.Run("helper not found", func(t *testing.T) {
+		_, err := credentials.NewNativeStore("missing").Get(ctx, "registry.example.com")
+		assert.ErrorContains(t, err, credentials.HelperPrefix+"missing")
+	})
+}
+
+func TestDynamicStore(t *testing.T) {
+	setupFakeHelper(t, "fake", "other")
+	ctx := context.Background()
+
+	filename := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+	"auths": {
+		"plain.example.com": {"auth": "YWRtaW46aGVsbG8="},
+		"helper.example.com": {"auth": "b2xkOnNlY3JldA=="}
+	},
+	"credHelpers": {"helper.example.com": "other"}
+}`
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+	authFile := authfile.NewAuthFile(filename)
+	require.NoError(t, authFile.Load())
+
+	assert.Empty(t, authFile.CredentialHelper("plain.example.com"))
+	assert.Equal(t, "other", authFile.CredentialHelper("helper.example.com"))
+	assert.Equal(t, "other", authFile.CredentialHelper("https://helper.example.com/v2/"))
+
+	store := authFile.GetCredentialsStore()
+	authConfig := authn.AuthConfig{Username: "admin", Password: "secret"}
+
+	t.Run("store with helper", func(t *testing.T) {
+		require.NoError(t, store.Store(ctx, "helper.example.com", authConfig))
+		got, err := store.Get(ctx, "helper.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, authConfig, got)
+		// the plain text credentials are removed from the file
+		assert.NotContains(t, readAuths(t, filename), "helper.example.com")
+	})
+
+	t.Run("store without helper", func(t *testing.T) {
+		require.NoError(t, store.Store(ctx, "new.example.com", authConfig))
+		assert.Contains(t, readAuths(t, filename), "new.example.com")
+	})
+
+	t.Run("get all", func(t *testing.T) {
+		all, err := store.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, authConfig, all["helper.example.com"])
+		assert.Contains(t, all, "new.example.com")
+		assert.Contains(t, all, "plain.example.com")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		servers, err := credentials.ListServers(ctx, store)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"helper.example.com", "new.example.com", "plain.example.com"}, servers)
+	})
+
+	t.Run("erase with helper", func(t *testing.T) {
+		require.NoError(t, store.Erase(ctx, "helper.example.com"))
+		got, err := store.Get(ctx, "helper.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, authn.EmptyAuthConfig, got)
+	})
+
+	t.Run("default helper", func(t *testing.T) {
+		authFile.CredentialsStore = "fake"
+		store := authFile.GetCredentialsStore()
+		require.NoError(t, store.Store(ctx, "default.example.com", authConfig))
+		assert.NotContains(t, readAuths(t, filename), "default.example.com")
+		got, err := credentials.NewNativeStore("fake").Get(ctx, "default.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, authConfig, got)
+	})
+}
+
+func readAuths(t *testing.T, filename string) map[string]any {
+	t.Helper()
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	config := struct {
+		Auths map[string]any `json:"auths"`
+	}{}
+	require.NoError(t, json.Unmarshal(content, &config))
+	return config.Auths
+}
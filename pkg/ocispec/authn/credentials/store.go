@@ -2,6 +2,8 @@
 
 import (
 	"context"
+	"maps"
+	"slices"
 
 	"github.com/wuxler/ruasec/pkg/ocispec/authn"
 )
@@ -17,3 +19,24 @@ type Store interface {
 	// Store saves credentials in the store.
 	Store(ctx context.Context, host string, authConfig authn.AuthConfig) error
 }
+
+// Lister is implemented by the stores able to list the servers of the kept
+// credentials without retrieving them, which is much cheaper than GetAll for
+// the credential helpers.
+type Lister interface {
+	// List returns the servers of the credentials kept in the store.
+	List(ctx context.Context) ([]string, error)
+}
+
+// ListServers returns the sorted servers of the credentials kept in the store,
+// where [Lister] is preferred when implemented.
+func ListServers(ctx context.Context, store Store) ([]string, error) {
+	if lister, ok := store.(Lister); ok {
+		return lister.List(ctx)
+	}
+	all, err := store.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(all)), nil
+}
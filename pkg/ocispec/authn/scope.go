@@ -83,6 +83,22 @@ func GetScopes(ctx context.Context) []string {
 	return nil
 }
 
+// GetRepositories returns the names of the repositories in the repository
+// scopes of the context, which tells the repositories the request accesses.
+func GetRepositories(ctx context.Context) []string {
+	var repositories []string
+	for _, scope := range GetScopes(ctx) {
+		rest, ok := strings.CutPrefix(scope, "repository:")
+		if !ok {
+			continue
+		}
+		if i := strings.LastIndex(rest, ":"); i > 0 {
+			repositories = append(repositories, rest[:i])
+		}
+	}
+	return repositories
+}
+
 // CleanScopes merges and sort the actions in ascending order if the scopes have
 // the same resource type and name. The final scopes are sorted in ascending
 // order. In other words, the scopes passed in are de-duplicated and sorted.
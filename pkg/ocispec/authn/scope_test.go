@@ -90,6 +90,23 @@ func TestRepositoryScope(t *testing.T) {
 	}
 }
 
+func TestGetRepositories(t *testing.T) {
+	ctx := context.Background()
+	if got := authn.GetRepositories(ctx); got != nil {
+		t.Errorf("GetRepositories() = %v, want nil", got)
+	}
+
+	ctx = authn.WithScopes(ctx,
+		"repository:team/app:pull",
+		authn.DefaultRegistryCatalogScope,
+		"repository:localhost:5000/base:pull,push",
+	)
+	want := []string{"localhost:5000/base", "team/app"}
+	if got := authn.GetRepositories(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRepositories() = %v, want %v", got, want)
+	}
+}
+
 func TestWithScopes(t *testing.T) {
 	ctx := context.Background()
 
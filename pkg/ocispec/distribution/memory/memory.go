@@ -0,0 +1,445 @@
+// Package memory provides an in-memory implementation of the distribution-spec,
+// which is mainly used in tests.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+var (
+	_ distribution.Spec            = (*Spec)(nil)
+	_ distribution.BlobWriteCloser = (*blobWriter)(nil)
+)
+
+// New returns an empty in-memory registry.
+func New() *Spec {
+	return &Spec{
+		blobs:   map[digest.Digest][]byte{},
+		repos:   map[string]*repository{},
+		uploads: map[string]*blobWriter{},
+	}
+}
+
+// Spec implements [distribution.Spec] in memory. The blobs are shared by all the
+// repositories and linked to the ones pushed or mounted to.
+type Spec struct {
+	mu      sync.RWMutex
+	blobs   map[digest.Digest][]byte
+	repos   map[string]*repository
+	uploads map[string]*blobWriter
+	nextID  int
+}
+
+// repository holds the links of the blobs, the manifests and the tags.
+type repository struct {
+	blobs     map[digest.Digest]struct{}
+	manifests map[digest.Digest]manifestEntry
+	tags      map[string]digest.Digest
+}
+
+// manifestEntry is the manifest content with the media type.
+type manifestEntry struct {
+	mediaType string
+	content   []byte
+}
+
+func (e manifestEntry) descriptor() imgspecv1.Descriptor {
+	return ocispec.NewDescriptorFromBytes(e.mediaType, e.content)
+}
+
+// GetVersion checks the registry accessible and returns the properties of the registry.
+func (s *Spec) GetVersion(_ context.Context) (string, error) {
+	return "registry/2.0", nil
+}
+
+// StatManifest returns the descriptor of the manifest with the given reference.
+func (s *Spec) StatManifest(_ context.Context, repo string, reference string) (imgspecv1.Descriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, err := s.manifest(repo, reference)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return entry.descriptor(), nil
+}
+
+// GetManifest returns the content of the manifest with the given reference.
+func (s *Spec) GetManifest(_ context.Context, repo string, reference string) (cas.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, err := s.manifest(repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	return cas.NewReadCloser(xio.NopReader(bytes.NewReader(entry.content)), entry.descriptor()), nil
+}
+
+// StatBlob returns the descriptor of the blob with the given digest.
+func (s *Spec) StatBlob(_ context.Context, repo string, dgst digest.Digest) (imgspecv1.Descriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	content, err := s.blob(repo, dgst)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{MediaType: ocispec.DefaultMediaType, Digest: dgst, Size: int64(len(content))}, nil
+}
+
+// GetBlob returns the content of the blob with the given digest.
+func (s *Spec) GetBlob(_ context.Context, repo string, dgst digest.Digest) (cas.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	content, err := s.blob(repo, dgst)
+	if err != nil {
+		return nil, err
+	}
+	desc := imgspecv1.Descriptor{MediaType: ocispec.DefaultMediaType, Digest: dgst, Size: int64(len(content))}
+	return cas.NewReadCloser(xio.NopReader(bytes.NewReader(content)), desc), nil
+}
+
+// PushManifest pushes a manifest with the given descriptor and tags.
+func (s *Spec) PushManifest(_ context.Context, repo string, r cas.Reader, tags ...string) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	desc := r.Descriptor()
+	if err := verify(desc, content); err != nil {
+		return err
+	}
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		mediaType = ocispec.DetectMediaType(content)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := manifestEntry{mediaType: mediaType, content: content}
+	dgst := digest.FromBytes(content)
+	repository := s.repository(repo, true)
+	repository.manifests[dgst] = entry
+	for _, tag := range tags {
+		repository.tags[tag] = dgst
+	}
+	return nil
+}
+
+// PushBlob pushes a blob monolithically to the given repository, reading the descriptor
+// and content from "getter".
+func (s *Spec) PushBlob(ctx context.Context, repo string, getter cas.ReadCloserGetter) error {
+	rc, err := getter(ctx)
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(rc)
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if err := verify(rc.Descriptor(), content); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.link(repo, digest.FromBytes(content), content)
+	return nil
+}
+
+// PushBlobChunked starts to push a blob to the given repository.
+func (s *Spec) PushBlobChunked(_ context.Context, repo string, chunkSize int64) (distribution.BlobWriteCloser, error) {
+	if chunkSize <= 0 {
+		chunkSize = distribution.DefaultChunkSize
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	w := &blobWriter{spec: s, repo: repo, id: strconv.Itoa(s.nextID), chunkSize: chunkSize}
+	s.uploads[w.id] = w
+	return w, nil
+}
+
+// PushBlobChunkedResume resumes a previous push of a blob started with PushBlobChunked.
+func (s *Spec) PushBlobChunkedResume(_ context.Context, repo string, chunkSize int64, id string, offset int64) (distribution.BlobWriteCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, ok := s.uploads[id]
+	if !ok || w.repo != repo {
+		return nil, errdefs.Newf(errdefs.ErrNotFound, "blob upload %s in repository %s", id, repo)
+	}
+	if offset >= 0 && offset != w.Size() {
+		return nil, errdefs.Newf(errdefs.ErrInvalidParameter, "resume blob upload %s at offset %d, but %d bytes uploaded", id, offset, w.Size())
+	}
+	if chunkSize > 0 {
+		w.chunkSize = chunkSize
+	}
+	return w, nil
+}
+
+// MountBlob makes a blob with the given digest that's in "from" repository available
+// in "repo" repository. It returns false if the blob is not found in "from".
+func (s *Spec) MountBlob(_ context.Context, repo string, from string, dgst digest.Digest) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, err := s.blob(from, dgst)
+	if err != nil {
+		return false, nil //nolint:nilerr // the caller falls back to upload
+	}
+	s.link(repo, dgst, content)
+	return true, nil
+}
+
+// DeleteManifest deletes the manifest with the given digest, or the tag only
+// when the reference is a tag.
+func (s *Spec) DeleteManifest(_ context.Context, repo string, reference string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repository := s.repository(repo, false)
+	if repository == nil {
+		return errdefs.Newf(errdefs.ErrNotFound, "manifest %s in repository %s", reference, repo)
+	}
+	dgst, err := digest.Parse(reference)
+	if err != nil {
+		if _, ok := repository.tags[reference]; !ok {
+			return errdefs.Newf(errdefs.ErrNotFound, "manifest %s in repository %s", reference, repo)
+		}
+		delete(repository.tags, reference)
+		return nil
+	}
+	if _, ok := repository.manifests[dgst]; !ok {
+		return errdefs.Newf(errdefs.ErrNotFound, "manifest %s in repository %s", reference, repo)
+	}
+	delete(repository.manifests, dgst)
+	for tag, target := range repository.tags {
+		if target == dgst {
+			delete(repository.tags, tag)
+		}
+	}
+	return nil
+}
+
+// DeleteBlob deletes the blob with the given digest in the given repository.
+func (s *Spec) DeleteBlob(_ context.Context, repo string, dgst digest.Digest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.blob(repo, dgst); err != nil {
+		return err
+	}
+	delete(s.repos[repo].blobs, dgst)
+	return nil
+}
+
+// ListRepositories returns an iterator that can be used to iterate
+// over all the repositories in the registry in order.
+func (s *Spec) ListRepositories(opts ...distribution.ListOption) iter.Iterator[string] {
+	return distribution.NewListIterator(func(context.Context) ([]string, error) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		repos := make([]string, 0, len(s.repos))
+		for repo := range s.repos {
+			repos = append(repos, repo)
+		}
+		return repos, nil
+	}, opts...)
+}
+
+// ListTags returns an iterator that can be used to iterate over all
+// the tags in the given repository in order.
+func (s *Spec) ListTags(repo string, opts ...distribution.ListOption) iter.Iterator[string] {
+	return distribution.NewListIterator(func(context.Context) ([]string, error) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		tags := []string{}
+		if repository := s.repository(repo, false); repository != nil {
+			for tag := range repository.tags {
+				tags = append(tags, tag)
+			}
+		}
+		return tags, nil
+	}, opts...)
+}
+
+// ListReferrers returns the descriptors of the manifests that have the given
+// digest as their subject, filtered by the artifact type if specified.
+func (s *Spec) ListReferrers(_ context.Context, repo string, dgst digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	referrers := []imgspecv1.Descriptor{}
+	repository := s.repository(repo, false)
+	if repository == nil {
+		return referrers, nil
+	}
+	for _, entry := range repository.manifests {
+		subject, desc, ok := distribution.ParseReferrer(entry.descriptor(), entry.content)
+		if !ok || subject != dgst {
+			continue
+		}
+		if artifactType != "" && desc.ArtifactType != artifactType {
+			continue
+		}
+		referrers = append(referrers, desc)
+	}
+	slices.SortFunc(referrers, func(a, b imgspecv1.Descriptor) int {
+		return strings.Compare(a.Digest.String(), b.Digest.String())
+	})
+	return referrers, nil
+}
+
+// repository returns the repository by the path, and creates it if not exists
+// when create is true.
+func (s *Spec) repository(repo string, create bool) *repository {
+	r, ok := s.repos[repo]
+	if !ok && create {
+		r = &repository{
+			blobs:     map[digest.Digest]struct{}{},
+			manifests: map[digest.Digest]manifestEntry{},
+			tags:      map[string]digest.Digest{},
+		}
+		s.repos[repo] = r
+	}
+	return r
+}
+
+// manifest returns the manifest by the tag or digest.
+func (s *Spec) manifest(repo string, reference string) (manifestEntry, error) {
+	notFound := errdefs.Newf(errdefs.ErrNotFound, "manifest %s in repository %s", reference, repo)
+	repository := s.repository(repo, false)
+	if repository == nil {
+		return manifestEntry{}, notFound
+	}
+	dgst, err := digest.Parse(reference)
+	if err != nil {
+		tagged, ok := repository.tags[reference]
+		if !ok {
+			return manifestEntry{}, notFound
+		}
+		dgst = tagged
+	}
+	entry, ok := repository.manifests[dgst]
+	if !ok {
+		return manifestEntry{}, notFound
+	}
+	return entry, nil
+}
+
+// blob returns the blob content linked to the repository.
+func (s *Spec) blob(repo string, dgst digest.Digest) ([]byte, error) {
+	repository := s.repository(repo, false)
+	if repository != nil {
+		if _, ok := repository.blobs[dgst]; ok {
+			return s.blobs[dgst], nil
+		}
+	}
+	return nil, errdefs.Newf(errdefs.ErrNotFound, "blob %s in repository %s", dgst, repo)
+}
+
+// link stores the blob content and links it to the repository.
+func (s *Spec) link(repo string, dgst digest.Digest, content []byte) {
+	s.blobs[dgst] = content
+	s.repository(repo, true).blobs[dgst] = struct{}{}
+}
+
+// verify checks the content with the digest and size of the descriptor if set.
+func verify(desc imgspecv1.Descriptor, content []byte) error {
+	if desc.Digest != "" {
+		if got := desc.Digest.Algorithm().FromBytes(content); got != desc.Digest {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "digest mismatch (%s != %s)", got, desc.Digest)
+		}
+	}
+	if desc.Size > 0 && desc.Size != int64(len(content)) {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "size mismatch (%d != %d)", len(content), desc.Size)
+	}
+	return nil
+}
+
+// blobWriter buffers the chunks of the blob upload in memory.
+type blobWriter struct {
+	spec      *Spec
+	repo      string
+	id        string
+	chunkSize int64
+
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+// Write writes more data to the blob.
+func (w *blobWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffer.Write(p)
+}
+
+// Close closes the writer but does not abort, the upload can be resumed.
+func (w *blobWriter) Close() error {
+	return nil
+}
+
+// Size returns the number of bytes written to this blob.
+func (w *blobWriter) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return int64(w.buffer.Len())
+}
+
+// ChunkSize returns the maximum number of bytes to upload at a single time.
+func (w *blobWriter) ChunkSize() int64 {
+	return w.chunkSize
+}
+
+// ID returns the opaque identifier for this writer.
+func (w *blobWriter) ID() string {
+	return w.id
+}
+
+// Commit completes the upload after the content is verified with the digest.
+func (w *blobWriter) Commit(dgst digest.Digest) (imgspecv1.Descriptor, error) {
+	w.mu.Lock()
+	content := bytes.Clone(w.buffer.Bytes())
+	w.mu.Unlock()
+	desc := imgspecv1.Descriptor{MediaType: ocispec.DefaultMediaType, Digest: dgst, Size: int64(len(content))}
+	if err := verify(desc, content); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+
+	w.spec.mu.Lock()
+	defer w.spec.mu.Unlock()
+	if _, ok := w.spec.uploads[w.id]; !ok {
+		return imgspecv1.Descriptor{}, errdefs.Newf(errdefs.ErrNotFound, "blob upload %s in repository %s", w.id, w.repo)
+	}
+	delete(w.spec.uploads, w.id)
+	w.spec.link(w.repo, dgst, content)
+	return desc, nil
+}
+
+// Cancel ends the upload and drops the data written, it is a no-op after commit.
+func (w *blobWriter) Cancel() error {
+	w.spec.mu.Lock()
+	defer w.spec.mu.Unlock()
+	delete(w.spec.uploads, w.id)
+	return nil
+}
+
+// String implements fmt.Stringer.
+func (s *Spec) String() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fmt.Sprintf("memory registry with %d repositories and %d blobs", len(s.repos), len(s.blobs))
+}
@@ -0,0 +1,200 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+)
+
+func pushBlob(t *testing.T, spec *memory.Spec, repo string, content []byte) imgspecv1.Descriptor {
+	t.Helper()
+	desc := ocispec.NewDescriptorFromBytes(ocispec.DefaultMediaType, content)
+	err := spec.PushBlob(context.Background(), repo, getter(desc, content))
+	require.NoError(t, err)
+	return desc
+}
+
+func getter(desc imgspecv1.Descriptor, content []byte) cas.ReadCloserGetter {
+	return func(context.Context) (cas.ReadCloser, error) {
+		return cas.NewReadCloserSkipVerify(io.NopCloser(bytes.NewReader(content)), desc), nil
+	}
+}
+
+func readAll(t *testing.T, rc cas.ReadCloser) []byte {
+	t.Helper()
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return content
+}
+
+func listAll(t *testing.T, it iter.Iterator[string]) [][]string {
+	t.Helper()
+	pages := [][]string{}
+	for {
+		page, err := it.Next(context.Background())
+		if err != nil {
+			require.ErrorIs(t, err, iter.ErrIteratorDone)
+			return pages
+		}
+		pages = append(pages, page)
+	}
+}
+
+func TestSpec_Blob(t *testing.T) {
+	ctx := context.Background()
+	spec := memory.New()
+	desc := pushBlob(t, spec, "library/alpine", []byte("hello"))
+
+	got, err := spec.StatBlob(ctx, "library/alpine", desc.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, desc.Size, got.Size)
+
+	rc, err := spec.GetBlob(ctx, "library/alpine", desc.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), readAll(t, rc))
+
+	_, err = spec.StatBlob(ctx, "library/busybox", desc.Digest)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	mounted, err := spec.MountBlob(ctx, "library/busybox", "library/alpine", desc.Digest)
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	_, err = spec.StatBlob(ctx, "library/busybox", desc.Digest)
+	require.NoError(t, err)
+
+	mounted, err = spec.MountBlob(ctx, "library/busybox", "library/unknown", desc.Digest)
+	require.NoError(t, err)
+	assert.False(t, mounted)
+
+	require.NoError(t, spec.DeleteBlob(ctx, "library/alpine", desc.Digest))
+	_, err = spec.StatBlob(ctx, "library/alpine", desc.Digest)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = spec.StatBlob(ctx, "library/busybox", desc.Digest)
+	require.NoError(t, err)
+
+	content := []byte("bad")
+	bad := imgspecv1.Descriptor{Digest: digest.FromString("other"), Size: int64(len(content))}
+	err = spec.PushBlob(ctx, "library/alpine", getter(bad, content))
+	require.Error(t, err)
+}
+
+func TestSpec_BlobChunked(t *testing.T) {
+	ctx := context.Background()
+	spec := memory.New()
+	content := []byte("hello world")
+	dgst := digest.FromBytes(content)
+
+	w, err := spec.PushBlobChunked(ctx, "library/alpine", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(distribution.DefaultChunkSize), w.ChunkSize())
+	_, err = w.Write(content[:5])
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = spec.PushBlobChunkedResume(ctx, "library/alpine", 0, w.ID(), 1)
+	require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+	w, err = spec.PushBlobChunkedResume(ctx, "library/alpine", 0, w.ID(), 5)
+	require.NoError(t, err)
+	_, err = w.Write(content[5:])
+	require.NoError(t, err)
+
+	_, err = w.Commit(digest.FromString("other"))
+	require.Error(t, err)
+	desc, err := w.Commit(dgst)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), desc.Size)
+
+	rc, err := spec.GetBlob(ctx, "library/alpine", dgst)
+	require.NoError(t, err)
+	assert.Equal(t, content, readAll(t, rc))
+
+	_, err = spec.PushBlobChunkedResume(ctx, "library/alpine", 0, w.ID(), -1)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestSpec_Manifest(t *testing.T) {
+	ctx := context.Background()
+	spec := memory.New()
+	subject := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	subjectDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, subject)
+	require.NoError(t, spec.PushManifest(ctx, "app", cas.NewReaderFromBytes(subjectDesc.MediaType, subject), "v1", "latest"))
+
+	got, err := spec.StatManifest(ctx, "app", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, subjectDesc.Digest, got.Digest)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, got.MediaType)
+
+	rc, err := spec.GetManifest(ctx, "app", subjectDesc.Digest.String())
+	require.NoError(t, err)
+	assert.Equal(t, subject, readAll(t, rc))
+
+	referrer := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"artifactType":"application/vnd.example.sbom",` +
+		`"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + subjectDesc.Digest.String() + `","size":1}}`)
+	referrerDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, referrer)
+	require.NoError(t, spec.PushManifest(ctx, "app", cas.NewReaderFromBytes(referrerDesc.MediaType, referrer)))
+
+	referrers, err := spec.ListReferrers(ctx, "app", subjectDesc.Digest, "")
+	require.NoError(t, err)
+	require.Len(t, referrers, 1)
+	assert.Equal(t, referrerDesc.Digest, referrers[0].Digest)
+	assert.Equal(t, "application/vnd.example.sbom", referrers[0].ArtifactType)
+	referrers, err = spec.ListReferrers(ctx, "app", subjectDesc.Digest, "application/vnd.example.sig")
+	require.NoError(t, err)
+	assert.Empty(t, referrers)
+
+	require.NoError(t, spec.DeleteManifest(ctx, "app", "latest"))
+	_, err = spec.StatManifest(ctx, "app", "latest")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = spec.StatManifest(ctx, "app", "v1")
+	require.NoError(t, err)
+
+	require.NoError(t, spec.DeleteManifest(ctx, "app", subjectDesc.Digest.String()))
+	_, err = spec.StatManifest(ctx, "app", "v1")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.ErrorIs(t, spec.DeleteManifest(ctx, "app", "v1"), errdefs.ErrNotFound)
+}
+
+func TestSpec_List(t *testing.T) {
+	ctx := context.Background()
+	spec := memory.New()
+	content := []byte(`{"schemaVersion":2}`)
+	desc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, content)
+	for _, repo := range []string{"c", "a", "b"} {
+		require.NoError(t, spec.PushManifest(ctx, repo, cas.NewReaderFromBytes(desc.MediaType, content), "v3", "v1", "v2"))
+	}
+
+	testcases := []struct {
+		name string
+		opts []distribution.ListOption
+		want [][]string
+	}{
+		{name: "all", want: [][]string{{"a", "b", "c"}}},
+		{name: "paged", opts: []distribution.ListOption{distribution.WithPageSize(2)}, want: [][]string{{"a", "b"}, {"c"}}},
+		{name: "offset", opts: []distribution.ListOption{distribution.WithOffset("a")}, want: [][]string{{"b", "c"}}},
+		{name: "offset missing", opts: []distribution.ListOption{distribution.WithOffset("bb")}, want: [][]string{{"c"}}},
+		{name: "offset last", opts: []distribution.ListOption{distribution.WithOffset("c")}, want: [][]string{}},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, listAll(t, spec.ListRepositories(tc.opts...)))
+		})
+	}
+
+	assert.Equal(t, [][]string{{"v1", "v2", "v3"}}, listAll(t, spec.ListTags("a")))
+	assert.Equal(t, [][]string{}, listAll(t, spec.ListTags("unknown")))
+}
@@ -0,0 +1,757 @@
+// Package ocilayout provides an implementation of the distribution-spec backed by
+// a directory on disk, in which each repository is stored as an OCI image layout
+// at "<root>/<repository>".
+//
+// The tags are recorded by the "org.opencontainers.image.ref.name" annotation of
+// the descriptors in "index.json", and the manifests pushed by digest are recorded
+// without the annotation, so the layouts can be read by other tools directly.
+//
+// The nested repositories are stored in the layout directories of their parents,
+// so the names with the "blobs", "index.json" or "oci-layout" path components are
+// rejected to not collide with the layout files.
+package ocilayout
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+const (
+	// uploadsDir is the directory in the repository to hold the blob uploads, which
+	// is hidden from the repository names since they never start with a dot.
+	uploadsDir = ".uploads"
+)
+
+var (
+	_ distribution.Spec            = (*Spec)(nil)
+	_ distribution.BlobWriteCloser = (*blobWriter)(nil)
+
+	// repositoryRegexp is the repository name grammar of the distribution-spec.
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+	// uploadIDRegexp matches the identifiers of the blob uploads.
+	uploadIDRegexp = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+	// reservedNames are the files and directories of the OCI image layout, which
+	// can not be the path components of the repository names.
+	reservedNames = []string{imgspecv1.ImageBlobsDir, imgspecv1.ImageIndexFile, imgspecv1.ImageLayoutFile}
+)
+
+// New returns a registry storing the repositories under the root directory,
+// which is created if not exists.
+func New(root string) (*Spec, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil { //nolint:mnd // default directory permission
+		return nil, err
+	}
+	return &Spec{root: root}, nil
+}
+
+// Spec implements [distribution.Spec] with the OCI image layouts on disk.
+type Spec struct {
+	root string
+	// mu guards the "index.json" files, the blobs are written atomically by rename.
+	mu sync.RWMutex
+}
+
+// Root returns the root directory of the registry.
+func (s *Spec) Root() string {
+	return s.root
+}
+
+// GetVersion checks the registry accessible and returns the properties of the registry.
+func (s *Spec) GetVersion(_ context.Context) (string, error) {
+	if _, err := os.Stat(s.root); err != nil {
+		return "", err
+	}
+	return "registry/2.0", nil
+}
+
+// StatManifest returns the descriptor of the manifest with the given reference.
+func (s *Spec) StatManifest(_ context.Context, repo string, reference string) (imgspecv1.Descriptor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resolve(repo, reference)
+}
+
+// GetManifest returns the content of the manifest with the given reference.
+func (s *Spec) GetManifest(_ context.Context, repo string, reference string) (cas.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	desc, err := s.resolve(repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.blobPath(repo, desc.Digest))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errdefs.Newf(errdefs.ErrNotFound, "manifest %s in repository %s", reference, repo)
+		}
+		return nil, err
+	}
+	return cas.NewReadCloser(f, desc), nil
+}
+
+// StatBlob returns the descriptor of the blob with the given digest.
+func (s *Spec) StatBlob(_ context.Context, repo string, dgst digest.Digest) (imgspecv1.Descriptor, error) {
+	if err := validate(repo, dgst); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	fi, err := os.Stat(s.blobPath(repo, dgst))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return imgspecv1.Descriptor{}, errdefs.Newf(errdefs.ErrNotFound, "blob %s in repository %s", dgst, repo)
+		}
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{MediaType: ocispec.DefaultMediaType, Digest: dgst, Size: fi.Size()}, nil
+}
+
+// GetBlob returns the content of the blob with the given digest.
+func (s *Spec) GetBlob(ctx context.Context, repo string, dgst digest.Digest) (cas.ReadCloser, error) {
+	desc, err := s.StatBlob(ctx, repo, dgst)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.blobPath(repo, dgst))
+	if err != nil {
+		return nil, err
+	}
+	return cas.NewReadCloser(f, desc), nil
+}
+
+// PushManifest pushes a manifest with the given descriptor and tags.
+func (s *Spec) PushManifest(_ context.Context, repo string, r cas.Reader, tags ...string) error {
+	if err := validate(repo, ""); err != nil {
+		return err
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	desc := r.Descriptor()
+	if err := verify(desc, content); err != nil {
+		return err
+	}
+	mediaType := desc.MediaType
+	if mediaType == "" || mediaType == ocispec.DefaultMediaType {
+		mediaType = ocispec.DetectMediaType(content)
+	}
+	desc = imgspecv1.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(content), Size: int64(len(content))}
+
+	if err := s.ensureLayout(repo); err != nil {
+		return err
+	}
+	if err := s.writeBlob(repo, desc.Digest, bytes.NewReader(content)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, err := s.readIndex(repo)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		if !slices.ContainsFunc(index.Manifests, func(d imgspecv1.Descriptor) bool { return d.Digest == desc.Digest }) {
+			index.Manifests = append(index.Manifests, desc)
+		}
+		return s.writeIndex(repo, index)
+	}
+	for _, tag := range tags {
+		index.Manifests = untag(index.Manifests, tag)
+		tagged := desc
+		tagged.Annotations = map[string]string{imgspecv1.AnnotationRefName: tag}
+		index.Manifests = append(index.Manifests, tagged)
+	}
+	// the untagged entry is replaced by the tagged ones
+	index.Manifests = slices.DeleteFunc(index.Manifests, func(d imgspecv1.Descriptor) bool {
+		return d.Digest == desc.Digest && tagOf(d) == ""
+	})
+	return s.writeIndex(repo, index)
+}
+
+// PushBlob pushes a blob monolithically to the given repository, reading the descriptor
+// and content from "getter".
+func (s *Spec) PushBlob(ctx context.Context, repo string, getter cas.ReadCloserGetter) error {
+	if err := validate(repo, ""); err != nil {
+		return err
+	}
+	rc, err := getter(ctx)
+	if err != nil {
+		return err
+	}
+	defer xio.CloseAndSkipError(rc)
+	desc := rc.Descriptor()
+	if err := desc.Digest.Validate(); err != nil {
+		return errdefs.NewE(errdefs.ErrInvalidParameter, err)
+	}
+	if err := s.ensureLayout(repo); err != nil {
+		return err
+	}
+	return s.writeBlob(repo, desc.Digest, rc)
+}
+
+// PushBlobChunked starts to push a blob to the given repository.
+func (s *Spec) PushBlobChunked(_ context.Context, repo string, chunkSize int64) (distribution.BlobWriteCloser, error) {
+	if err := validate(repo, ""); err != nil {
+		return nil, err
+	}
+	if chunkSize <= 0 {
+		chunkSize = distribution.DefaultChunkSize
+	}
+	if err := s.ensureLayout(repo); err != nil {
+		return nil, err
+	}
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	w := &blobWriter{spec: s, repo: repo, id: id, chunkSize: chunkSize}
+	if err := os.WriteFile(w.path(), nil, 0o600); err != nil { //nolint:mnd // private temporary file
+		return nil, err
+	}
+	return w, nil
+}
+
+// PushBlobChunkedResume resumes a previous push of a blob started with PushBlobChunked.
+func (s *Spec) PushBlobChunkedResume(_ context.Context, repo string, chunkSize int64, id string, offset int64) (distribution.BlobWriteCloser, error) {
+	if err := validate(repo, ""); err != nil {
+		return nil, err
+	}
+	if !uploadIDRegexp.MatchString(id) {
+		return nil, errdefs.Newf(errdefs.ErrNotFound, "blob upload %s in repository %s", id, repo)
+	}
+	if chunkSize <= 0 {
+		chunkSize = distribution.DefaultChunkSize
+	}
+	w := &blobWriter{spec: s, repo: repo, id: id, chunkSize: chunkSize}
+	fi, err := os.Stat(w.path())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errdefs.Newf(errdefs.ErrNotFound, "blob upload %s in repository %s", id, repo)
+		}
+		return nil, err
+	}
+	if offset >= 0 && offset != fi.Size() {
+		return nil, errdefs.Newf(errdefs.ErrInvalidParameter,
+			"resume blob upload %s at offset %d, but %d bytes uploaded", id, offset, fi.Size())
+	}
+	return w, nil
+}
+
+// MountBlob makes a blob with the given digest that's in "from" repository available
+// in "repo" repository. It returns false if the blob is not found in "from".
+func (s *Spec) MountBlob(_ context.Context, repo string, from string, dgst digest.Digest) (bool, error) {
+	if err := validate(repo, dgst); err != nil {
+		return false, err
+	}
+	if err := validate(from, ""); err != nil {
+		return false, nil //nolint:nilerr // the caller falls back to upload
+	}
+	src, err := os.Open(s.blobPath(from, dgst))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer xio.CloseAndSkipError(src)
+	if err := s.ensureLayout(repo); err != nil {
+		return false, err
+	}
+	// hard link shares the content between the repositories, and falls back to copy
+	// when the filesystem does not support
+	dst := s.blobPath(repo, dgst)
+	if exists, err := xos.Exists(dst); err != nil || exists {
+		return exists, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil { //nolint:mnd // default directory permission
+		return false, err
+	}
+	if err := os.Link(src.Name(), dst); err == nil || errors.Is(err, fs.ErrExist) {
+		return true, nil
+	}
+	if err := s.writeBlob(repo, dgst, src); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteManifest deletes the manifest with the given digest, or the tag only
+// when the reference is a tag.
+func (s *Spec) DeleteManifest(_ context.Context, repo string, reference string) error {
+	if err := validate(repo, ""); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notFound := errdefs.Newf(errdefs.ErrNotFound, "manifest %s in repository %s", reference, repo)
+	index, err := s.readIndex(repo)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return notFound
+		}
+		return err
+	}
+	if dgst, err := digest.Parse(reference); err == nil {
+		if !slices.ContainsFunc(index.Manifests, func(d imgspecv1.Descriptor) bool { return d.Digest == dgst }) {
+			return notFound
+		}
+		index.Manifests = slices.DeleteFunc(index.Manifests, func(d imgspecv1.Descriptor) bool {
+			return d.Digest == dgst
+		})
+		return s.writeIndex(repo, index)
+	}
+	if !slices.ContainsFunc(index.Manifests, func(d imgspecv1.Descriptor) bool { return tagOf(d) == reference }) {
+		return notFound
+	}
+	index.Manifests = untag(index.Manifests, reference)
+	return s.writeIndex(repo, index)
+}
+
+// DeleteBlob deletes the blob with the given digest in the given repository.
+func (s *Spec) DeleteBlob(_ context.Context, repo string, dgst digest.Digest) error {
+	if err := validate(repo, dgst); err != nil {
+		return err
+	}
+	if err := os.Remove(s.blobPath(repo, dgst)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return errdefs.Newf(errdefs.ErrNotFound, "blob %s in repository %s", dgst, repo)
+		}
+		return err
+	}
+	return nil
+}
+
+// ListRepositories returns an iterator that can be used to iterate
+// over all the repositories in the registry in order.
+func (s *Spec) ListRepositories(opts ...distribution.ListOption) iter.Iterator[string] {
+	return distribution.NewListIterator(func(context.Context) ([]string, error) {
+		repos := []string{}
+		err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() || path == s.root {
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			// the blobs directory of the parent repository
+			if d.Name() == imgspecv1.ImageBlobsDir && isLayout(filepath.Dir(path)) {
+				return filepath.SkipDir
+			}
+			if isLayout(path) {
+				rel, err := filepath.Rel(s.root, path)
+				if err != nil {
+					return err
+				}
+				repos = append(repos, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		return repos, err
+	}, opts...)
+}
+
+// ListTags returns an iterator that can be used to iterate over all
+// the tags in the given repository in order.
+func (s *Spec) ListTags(repo string, opts ...distribution.ListOption) iter.Iterator[string] {
+	return distribution.NewListIterator(func(context.Context) ([]string, error) {
+		if err := validate(repo, ""); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		index, err := s.readIndex(repo)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, errdefs.Newf(errdefs.ErrNotFound, "repository %s", repo)
+			}
+			return nil, err
+		}
+		tags := []string{}
+		for _, desc := range index.Manifests {
+			if tag := tagOf(desc); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		return tags, nil
+	}, opts...)
+}
+
+// ListReferrers returns the descriptors of the manifests that have the given
+// digest as their subject, filtered by the artifact type if specified.
+func (s *Spec) ListReferrers(_ context.Context, repo string, dgst digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	if err := validate(repo, dgst); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	referrers := []imgspecv1.Descriptor{}
+	index, err := s.readIndex(repo)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return referrers, nil
+		}
+		return nil, err
+	}
+	seen := map[digest.Digest]bool{}
+	for _, desc := range index.Manifests {
+		if seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
+		content, err := os.ReadFile(s.blobPath(repo, desc.Digest))
+		if err != nil {
+			return nil, err
+		}
+		desc.Annotations = nil
+		subject, referrer, ok := distribution.ParseReferrer(desc, content)
+		if !ok || subject != dgst {
+			continue
+		}
+		if artifactType != "" && referrer.ArtifactType != artifactType {
+			continue
+		}
+		referrers = append(referrers, referrer)
+	}
+	slices.SortFunc(referrers, func(a, b imgspecv1.Descriptor) int {
+		return strings.Compare(a.Digest.String(), b.Digest.String())
+	})
+	return referrers, nil
+}
+
+// resolve returns the descriptor of the manifest in "index.json" by the tag or digest.
+func (s *Spec) resolve(repo string, reference string) (imgspecv1.Descriptor, error) {
+	if err := validate(repo, ""); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	notFound := errdefs.Newf(errdefs.ErrNotFound, "manifest %s in repository %s", reference, repo)
+	index, err := s.readIndex(repo)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return imgspecv1.Descriptor{}, notFound
+		}
+		return imgspecv1.Descriptor{}, err
+	}
+	dgst, err := digest.Parse(reference)
+	if err != nil {
+		dgst = ""
+	}
+	for _, desc := range index.Manifests {
+		if (dgst != "" && desc.Digest == dgst) || (dgst == "" && tagOf(desc) == reference) {
+			return imgspecv1.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}, nil
+		}
+	}
+	return imgspecv1.Descriptor{}, notFound
+}
+
+// dir returns the layout directory of the repository.
+func (s *Spec) dir(repo string) string {
+	return filepath.Join(s.root, filepath.FromSlash(repo))
+}
+
+// blobPath returns the path of the blob as "<root>/<repo>/blobs/<alg>/<encoded>".
+func (s *Spec) blobPath(repo string, dgst digest.Digest) string {
+	return filepath.Join(s.dir(repo), imgspecv1.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// ensureLayout creates the layout of the repository if not exists.
+func (s *Spec) ensureLayout(repo string) error {
+	dir := s.dir(repo)
+	if err := os.MkdirAll(filepath.Join(dir, uploadsDir), 0o755); err != nil { //nolint:mnd // default directory permission
+		return err
+	}
+	layoutFile := filepath.Join(dir, imgspecv1.ImageLayoutFile)
+	if exists, err := xos.Exists(layoutFile); err != nil || exists {
+		return err
+	}
+	return writeJSON(layoutFile, imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion})
+}
+
+// readIndex reads the "index.json" of the repository, and returns [fs.ErrNotExist]
+// if the repository is not found.
+func (s *Spec) readIndex(repo string) (*imgspecv1.Index, error) {
+	dir := s.dir(repo)
+	content, err := os.ReadFile(filepath.Join(dir, imgspecv1.ImageIndexFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && isLayout(dir) {
+			return newIndex(), nil
+		}
+		return nil, err
+	}
+	index := newIndex()
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %q file of repository %s: %w", imgspecv1.ImageIndexFile, repo, err)
+	}
+	return index, nil
+}
+
+// writeIndex replaces the "index.json" of the repository atomically.
+func (s *Spec) writeIndex(repo string, index *imgspecv1.Index) error {
+	return writeJSON(filepath.Join(s.dir(repo), imgspecv1.ImageIndexFile), index)
+}
+
+// writeBlob spools the content into the uploads directory, and moves it to the
+// blob path after verified with the digest.
+func (s *Spec) writeBlob(repo string, dgst digest.Digest, r io.Reader) error {
+	f, err := os.CreateTemp(filepath.Join(s.dir(repo), uploadsDir), ".spool-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck // the spooled file is renamed on success
+	digester := dgst.Algorithm().Digester()
+	_, err = io.Copy(io.MultiWriter(f, digester.Hash()), r)
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+	if got := digester.Digest(); got != dgst {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "digest mismatch (%s != %s)", got, dgst)
+	}
+	return s.commitBlob(repo, dgst, f.Name())
+}
+
+// commitBlob moves the spooled file to the blob path.
+func (s *Spec) commitBlob(repo string, dgst digest.Digest, spooled string) error {
+	path := s.blobPath(repo, dgst)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:mnd // default directory permission
+		return err
+	}
+	if err := os.Chmod(spooled, 0o644); err != nil { //nolint:gosec,mnd // default file permission
+		return err
+	}
+	return os.Rename(spooled, path)
+}
+
+// blobWriter appends the chunks of the blob upload to the file in the uploads
+// directory, which is kept after closed to resume the upload.
+type blobWriter struct {
+	spec      *Spec
+	repo      string
+	id        string
+	chunkSize int64
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Write writes more data to the blob.
+func (w *blobWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		f, err := os.OpenFile(w.path(), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return 0, errdefs.Newf(errdefs.ErrNotFound, "blob upload %s in repository %s", w.id, w.repo)
+			}
+			return 0, err
+		}
+		w.file = f
+	}
+	return w.file.Write(p)
+}
+
+// Close closes the writer but does not abort, the upload can be resumed.
+func (w *blobWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close()
+}
+
+func (w *blobWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Size returns the number of bytes written to this blob.
+func (w *blobWriter) Size() int64 {
+	fi, err := os.Stat(w.path())
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// ChunkSize returns the maximum number of bytes to upload at a single time.
+func (w *blobWriter) ChunkSize() int64 {
+	return w.chunkSize
+}
+
+// ID returns the opaque identifier for this writer.
+func (w *blobWriter) ID() string {
+	return w.id
+}
+
+// Commit completes the upload after the content is verified with the digest.
+func (w *blobWriter) Commit(dgst digest.Digest) (imgspecv1.Descriptor, error) {
+	if err := dgst.Validate(); err != nil {
+		return imgspecv1.Descriptor{}, errdefs.NewE(errdefs.ErrInvalidParameter, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.close(); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	f, err := os.Open(w.path())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return imgspecv1.Descriptor{}, errdefs.Newf(errdefs.ErrNotFound, "blob upload %s in repository %s", w.id, w.repo)
+		}
+		return imgspecv1.Descriptor{}, err
+	}
+	digester := dgst.Algorithm().Digester()
+	size, err := io.Copy(digester.Hash(), f)
+	if err := errors.Join(err, f.Close()); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	if got := digester.Digest(); got != dgst {
+		return imgspecv1.Descriptor{}, errdefs.Newf(errdefs.ErrInvalidParameter, "digest mismatch (%s != %s)", got, dgst)
+	}
+	if err := w.spec.commitBlob(w.repo, dgst, w.path()); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{MediaType: ocispec.DefaultMediaType, Digest: dgst, Size: size}, nil
+}
+
+// Cancel ends the upload and drops the data written, it is a no-op after commit.
+func (w *blobWriter) Cancel() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.close(); err != nil {
+		return err
+	}
+	if err := os.Remove(w.path()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (w *blobWriter) path() string {
+	return filepath.Join(w.spec.dir(w.repo), uploadsDir, w.id)
+}
+
+// validate checks the repository name and the digest if set, which are used to
+// build the paths on disk.
+func validate(repo string, dgst digest.Digest) error {
+	if !repositoryRegexp.MatchString(repo) {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "invalid repository name %q", repo)
+	}
+	for _, part := range strings.Split(repo, "/") {
+		if slices.Contains(reservedNames, part) {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "repository name %q collides with %q of the OCI image layout", repo, part)
+		}
+	}
+	if dgst != "" {
+		if err := dgst.Validate(); err != nil {
+			return errdefs.NewE(errdefs.ErrInvalidParameter, err)
+		}
+	}
+	return nil
+}
+
+// verify checks the content with the digest and size of the descriptor if set.
+func verify(desc imgspecv1.Descriptor, content []byte) error {
+	if desc.Digest != "" {
+		if got := desc.Digest.Algorithm().FromBytes(content); got != desc.Digest {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "digest mismatch (%s != %s)", got, desc.Digest)
+		}
+	}
+	if desc.Size > 0 && desc.Size != int64(len(content)) {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "size mismatch (%d != %d)", len(content), desc.Size)
+	}
+	return nil
+}
+
+// untag removes the descriptor with the tag, and keeps an untagged descriptor if
+// the manifest is not referenced by other tags.
+func untag(descriptors []imgspecv1.Descriptor, tag string) []imgspecv1.Descriptor {
+	i := slices.IndexFunc(descriptors, func(d imgspecv1.Descriptor) bool { return tagOf(d) == tag })
+	if i < 0 {
+		return descriptors
+	}
+	removed := descriptors[i]
+	descriptors = slices.Delete(descriptors, i, i+1)
+	if !slices.ContainsFunc(descriptors, func(d imgspecv1.Descriptor) bool { return d.Digest == removed.Digest }) {
+		removed.Annotations = nil
+		descriptors = append(descriptors, removed)
+	}
+	return descriptors
+}
+
+// tagOf returns the tag annotated on the descriptor in "index.json".
+func tagOf(desc imgspecv1.Descriptor) string {
+	return desc.Annotations[imgspecv1.AnnotationRefName]
+}
+
+func newIndex() *imgspecv1.Index {
+	index := &imgspecv1.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []imgspecv1.Descriptor{}}
+	index.SchemaVersion = 2
+	return index
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16) //nolint:mnd // 128 bits
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isLayout returns true if the directory contains the "oci-layout" file.
+func isLayout(dir string) bool {
+	exists, err := xos.Exists(filepath.Join(dir, imgspecv1.ImageLayoutFile))
+	return err == nil && exists
+}
+
+// writeJSON writes the value as JSON into a temporary file and renames it to the
+// path, so the readers never see a partial file.
+func writeJSON(path string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck // the temporary file is renamed on success
+	_, err = f.Write(content)
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil { //nolint:gosec,mnd // default file permission
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,219 @@
+package ocilayout_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/ocilayout"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+)
+
+func newSpec(t *testing.T) *ocilayout.Spec {
+	t.Helper()
+	spec, err := ocilayout.New(filepath.Join(t.TempDir(), "registry"))
+	require.NoError(t, err)
+	return spec
+}
+
+func getter(desc imgspecv1.Descriptor, content []byte) cas.ReadCloserGetter {
+	return func(context.Context) (cas.ReadCloser, error) {
+		return cas.NewReadCloserSkipVerify(io.NopCloser(bytes.NewReader(content)), desc), nil
+	}
+}
+
+func readAll(t *testing.T, rc cas.ReadCloser) []byte {
+	t.Helper()
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return content
+}
+
+func listAll(t *testing.T, it iter.Iterator[string]) []string {
+	t.Helper()
+	all := []string{}
+	for {
+		page, err := it.Next(context.Background())
+		if err != nil {
+			require.ErrorIs(t, err, iter.ErrIteratorDone)
+			return all
+		}
+		all = append(all, page...)
+	}
+}
+
+func readIndex(t *testing.T, spec *ocilayout.Spec, repo string) imgspecv1.Index {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(spec.Root(), repo, imgspecv1.ImageIndexFile))
+	require.NoError(t, err)
+	var index imgspecv1.Index
+	require.NoError(t, json.Unmarshal(content, &index))
+	return index
+}
+
+func TestSpec_Blob(t *testing.T) {
+	ctx := context.Background()
+	spec := newSpec(t)
+	content := []byte("hello")
+	desc := ocispec.NewDescriptorFromBytes(ocispec.DefaultMediaType, content)
+	require.NoError(t, spec.PushBlob(ctx, "library/alpine", getter(desc, content)))
+	assert.FileExists(t, filepath.Join(spec.Root(), "library/alpine", imgspecv1.ImageLayoutFile))
+	assert.FileExists(t, filepath.Join(spec.Root(), "library/alpine/blobs/sha256", desc.Digest.Encoded()))
+
+	got, err := spec.StatBlob(ctx, "library/alpine", desc.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, desc.Size, got.Size)
+	rc, err := spec.GetBlob(ctx, "library/alpine", desc.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, content, readAll(t, rc))
+
+	mounted, err := spec.MountBlob(ctx, "library/busybox", "library/alpine", desc.Digest)
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	_, err = spec.StatBlob(ctx, "library/busybox", desc.Digest)
+	require.NoError(t, err)
+	mounted, err = spec.MountBlob(ctx, "library/busybox", "library/unknown", digest.FromString("other"))
+	require.NoError(t, err)
+	assert.False(t, mounted)
+
+	require.NoError(t, spec.DeleteBlob(ctx, "library/alpine", desc.Digest))
+	_, err = spec.StatBlob(ctx, "library/alpine", desc.Digest)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.ErrorIs(t, spec.DeleteBlob(ctx, "library/alpine", desc.Digest), errdefs.ErrNotFound)
+
+	bad := imgspecv1.Descriptor{Digest: digest.FromString("other"), Size: desc.Size}
+	require.ErrorIs(t, spec.PushBlob(ctx, "library/alpine", getter(bad, content)), errdefs.ErrInvalidParameter)
+	_, err = spec.StatBlob(ctx, "../escape", desc.Digest)
+	require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+}
+
+func TestSpec_BlobChunked(t *testing.T) {
+	ctx := context.Background()
+	spec := newSpec(t)
+	content := []byte("hello world")
+	dgst := digest.FromBytes(content)
+
+	w, err := spec.PushBlobChunked(ctx, "app", 0)
+	require.NoError(t, err)
+	_, err = w.Write(content[:5])
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, int64(5), w.Size())
+
+	_, err = spec.PushBlobChunkedResume(ctx, "app", 0, w.ID(), 1)
+	require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+	w, err = spec.PushBlobChunkedResume(ctx, "app", 0, w.ID(), 5)
+	require.NoError(t, err)
+	_, err = w.Write(content[5:])
+	require.NoError(t, err)
+
+	_, err = w.Commit(digest.FromString("other"))
+	require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+	desc, err := w.Commit(dgst)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), desc.Size)
+	require.NoError(t, w.Cancel())
+
+	rc, err := spec.GetBlob(ctx, "app", dgst)
+	require.NoError(t, err)
+	assert.Equal(t, content, readAll(t, rc))
+
+	_, err = spec.PushBlobChunkedResume(ctx, "app", 0, w.ID(), -1)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestSpec_Manifest(t *testing.T) {
+	ctx := context.Background()
+	spec := newSpec(t)
+	subject := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	subjectDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, subject)
+	require.NoError(t, spec.PushManifest(ctx, "app", cas.NewReaderFromBytes(subjectDesc.MediaType, subject), "v1", "latest"))
+
+	got, err := spec.StatManifest(ctx, "app", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, subjectDesc, got)
+	rc, err := spec.GetManifest(ctx, "app", subjectDesc.Digest.String())
+	require.NoError(t, err)
+	assert.Equal(t, subject, readAll(t, rc))
+	assert.Len(t, readIndex(t, spec, "app").Manifests, 2)
+
+	referrer := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"config":{"mediaType":"application/vnd.example.sbom","digest":"` + subjectDesc.Digest.String() + `","size":1},` +
+		`"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + subjectDesc.Digest.String() + `","size":1}}`)
+	referrerDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, referrer)
+	require.NoError(t, spec.PushManifest(ctx, "app", cas.NewReaderFromBytes(referrerDesc.MediaType, referrer)))
+	referrers, err := spec.ListReferrers(ctx, "app", subjectDesc.Digest, "application/vnd.example.sbom")
+	require.NoError(t, err)
+	require.Len(t, referrers, 1)
+	assert.Equal(t, referrerDesc.Digest, referrers[0].Digest)
+
+	assert.Equal(t, []string{"latest", "v1"}, listAll(t, spec.ListTags("app")))
+	require.NoError(t, spec.DeleteManifest(ctx, "app", "latest"))
+	require.NoError(t, spec.DeleteManifest(ctx, "app", "v1"))
+	assert.Equal(t, []string{}, listAll(t, spec.ListTags("app")))
+	// the manifest is kept untagged
+	_, err = spec.StatManifest(ctx, "app", subjectDesc.Digest.String())
+	require.NoError(t, err)
+
+	require.NoError(t, spec.DeleteManifest(ctx, "app", subjectDesc.Digest.String()))
+	_, err = spec.StatManifest(ctx, "app", subjectDesc.Digest.String())
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.ErrorIs(t, spec.DeleteManifest(ctx, "app", "v1"), errdefs.ErrNotFound)
+	assert.Len(t, readIndex(t, spec, "app").Manifests, 1)
+}
+
+func TestSpec_ListRepositories(t *testing.T) {
+	ctx := context.Background()
+	spec := newSpec(t)
+	content := []byte(`{"schemaVersion":2}`)
+	for _, repo := range []string{"library/alpine", "app", "library/alpine/edge"} {
+		require.NoError(t, spec.PushManifest(ctx, repo, cas.NewReaderFromBytes(imgspecv1.MediaTypeImageManifest, content), "v1"))
+	}
+	assert.Equal(t, []string{"app", "library/alpine", "library/alpine/edge"}, listAll(t, spec.ListRepositories()))
+	assert.Equal(t, []string{"library/alpine", "library/alpine/edge"},
+		listAll(t, spec.ListRepositories(distribution.WithOffset("app"), distribution.WithPageSize(1))))
+
+	_, err := spec.ListTags("unknown").Next(ctx)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestSpec_ReservedNames(t *testing.T) {
+	ctx := context.Background()
+	spec := newSpec(t)
+	content := []byte("hello")
+	desc := ocispec.NewDescriptorFromBytes(ocispec.DefaultMediaType, content)
+	require.NoError(t, spec.PushBlob(ctx, "app", getter(desc, content)))
+
+	for _, repo := range []string{"app/blobs", "app/index.json", "app/oci-layout", "blobs", "app/blobs/sha256"} {
+		t.Run(repo, func(t *testing.T) {
+			require.ErrorIs(t, spec.PushBlob(ctx, repo, getter(desc, content)), errdefs.ErrInvalidParameter)
+			mf := []byte(`{"schemaVersion":2}`)
+			err := spec.PushManifest(ctx, repo, cas.NewReaderFromBytes(imgspecv1.MediaTypeImageManifest, mf), "v1")
+			require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+			_, err = spec.StatBlob(ctx, repo, desc.Digest)
+			require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+		})
+	}
+	// the layout of the parent repository is kept as is
+	got, err := spec.StatBlob(ctx, "app", desc.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, desc.Digest, got.Digest)
+	assert.Equal(t, []string{"app"}, listAll(t, spec.ListRepositories()))
+
+	// the components only prefixed with the reserved names are allowed
+	require.NoError(t, spec.PushBlob(ctx, "app/blobs-cache", getter(desc, content)))
+}
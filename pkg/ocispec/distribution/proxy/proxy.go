@@ -0,0 +1,349 @@
+// Package proxy provides a pull-through cache of an upstream registry, which is
+// served as a read-only [distribution.Spec].
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+const (
+	// DefaultTagTTL is the default duration to serve the tag lookups from the cache
+	// before revalidated with the upstream.
+	DefaultTagTTL = 5 * time.Minute
+)
+
+var (
+	_ distribution.Spec = (*Spec)(nil)
+
+	// errReadOnly is returned by the write operations.
+	errReadOnly = errdefs.Newf(errdefs.ErrUnsupported, "pull-through cache is read-only")
+)
+
+// Option is used to set the optional parameters of the proxy.
+type Option func(*Options)
+
+// Options is the optional parameters of the proxy.
+type Options struct {
+	// TagTTL is the duration to serve the tag lookups from the cache, and the tags
+	// are always revalidated with the upstream if it is not positive.
+	TagTTL time.Duration
+	// Now returns the current time, which is used to expire the tags.
+	Now func() time.Time
+}
+
+// WithTagTTL sets the duration to serve the tag lookups from the cache.
+func WithTagTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.TagTTL = ttl
+	}
+}
+
+// WithNow sets the function returns the current time.
+func WithNow(now func() time.Time) Option {
+	return func(o *Options) {
+		o.Now = now
+	}
+}
+
+// MakeOptions returns the options with all optional parameters applied.
+func MakeOptions(opts ...Option) *Options {
+	options := &Options{
+		TagTTL: DefaultTagTTL,
+		Now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// New returns a pull-through cache of the upstream registry, and the manifests and
+// blobs pulled are stored in the cache.
+func New(upstream *remote.Registry, cache distribution.Spec, opts ...Option) *Spec {
+	return &Spec{
+		upstream: upstream,
+		cache:    cache,
+		options:  MakeOptions(opts...),
+		tags:     map[string]tagEntry{},
+	}
+}
+
+// Spec implements [distribution.Spec] as a pull-through cache.
+//
+// The manifests and blobs are addressed by digest, so they are served from the
+// cache once pulled. The tags are resolved with the upstream and cached for the
+// TTL, and the cached ones are served even if expired when the upstream is
+// unavailable.
+type Spec struct {
+	upstream *remote.Registry
+	cache    distribution.Spec
+	options  *Options
+
+	mu   sync.Mutex
+	tags map[string]tagEntry
+}
+
+type tagEntry struct {
+	descriptor imgspecv1.Descriptor
+	expires    time.Time
+}
+
+// GetVersion checks the registry accessible and returns the properties of the registry.
+// The cache is checked only, so the cached contents are served when the upstream
+// is unavailable.
+func (s *Spec) GetVersion(ctx context.Context) (string, error) {
+	return s.cache.GetVersion(ctx)
+}
+
+// StatManifest returns the descriptor of the manifest with the given reference.
+func (s *Spec) StatManifest(ctx context.Context, repo string, reference string) (imgspecv1.Descriptor, error) {
+	repo = s.normalize(repo)
+	if _, err := digest.Parse(reference); err == nil {
+		return s.pullManifest(ctx, repo, reference)
+	}
+	return s.resolveTag(ctx, repo, reference)
+}
+
+// GetManifest returns the content of the manifest with the given reference.
+func (s *Spec) GetManifest(ctx context.Context, repo string, reference string) (cas.ReadCloser, error) {
+	desc, err := s.StatManifest(ctx, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	return s.cache.GetManifest(ctx, s.normalize(repo), desc.Digest.String())
+}
+
+// StatBlob returns the descriptor of the blob with the given digest.
+func (s *Spec) StatBlob(ctx context.Context, repo string, dgst digest.Digest) (imgspecv1.Descriptor, error) {
+	repo = s.normalize(repo)
+	desc, err := s.cache.StatBlob(ctx, repo, dgst)
+	if err == nil || !errors.Is(err, errdefs.ErrNotFound) {
+		return desc, err
+	}
+	return s.upstream.StatBlob(ctx, repo, dgst)
+}
+
+// GetBlob returns the content of the blob with the given digest. The blob missed
+// in the cache is streamed from the upstream and stored at the same time.
+func (s *Spec) GetBlob(ctx context.Context, repo string, dgst digest.Digest) (cas.ReadCloser, error) {
+	repo = s.normalize(repo)
+	rc, err := s.cache.GetBlob(ctx, repo, dgst)
+	if err == nil || !errors.Is(err, errdefs.ErrNotFound) {
+		return rc, err
+	}
+	rc, err = s.upstream.GetBlob(ctx, repo, dgst)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := s.cache.PushBlobChunked(ctx, repo, 0)
+	if err != nil {
+		xlog.C(ctx).Warnf("unable to cache blob %s of %s: %s", dgst, repo, err)
+		return rc, nil
+	}
+	return &cachingReader{ReadCloser: rc, ctx: ctx, writer: writer}, nil
+}
+
+// PushManifest is unsupported since the cache is read-only.
+func (s *Spec) PushManifest(_ context.Context, _ string, _ cas.Reader, _ ...string) error {
+	return errReadOnly
+}
+
+// PushBlob is unsupported since the cache is read-only.
+func (s *Spec) PushBlob(_ context.Context, _ string, _ cas.ReadCloserGetter) error {
+	return errReadOnly
+}
+
+// PushBlobChunked is unsupported since the cache is read-only.
+func (s *Spec) PushBlobChunked(_ context.Context, _ string, _ int64) (distribution.BlobWriteCloser, error) {
+	return nil, errReadOnly
+}
+
+// PushBlobChunkedResume is unsupported since the cache is read-only.
+func (s *Spec) PushBlobChunkedResume(_ context.Context, _ string, _ int64, _ string, _ int64) (distribution.BlobWriteCloser, error) {
+	return nil, errReadOnly
+}
+
+// MountBlob is unsupported since the cache is read-only.
+func (s *Spec) MountBlob(_ context.Context, _ string, _ string, _ digest.Digest) (bool, error) {
+	return false, nil
+}
+
+// DeleteManifest is unsupported since the cache is read-only.
+func (s *Spec) DeleteManifest(_ context.Context, _ string, _ string) error {
+	return errReadOnly
+}
+
+// DeleteBlob is unsupported since the cache is read-only.
+func (s *Spec) DeleteBlob(_ context.Context, _ string, _ digest.Digest) error {
+	return errReadOnly
+}
+
+// ListRepositories returns the repositories in the cache, since the catalog is
+// disabled by the most public registries.
+func (s *Spec) ListRepositories(opts ...distribution.ListOption) iter.Iterator[string] {
+	return s.cache.ListRepositories(opts...)
+}
+
+// ListTags returns an iterator over the tags of the upstream repository.
+func (s *Spec) ListTags(repo string, opts ...distribution.ListOption) iter.Iterator[string] {
+	return s.upstream.ListTags(s.normalize(repo), opts...)
+}
+
+// ListReferrers returns the referrers of the upstream repository.
+func (s *Spec) ListReferrers(ctx context.Context, repo string, dgst digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	return s.upstream.ListReferrers(ctx, s.normalize(repo), dgst, artifactType)
+}
+
+// resolveTag returns the descriptor of the tag cached within the TTL, or resolved
+// with the upstream.
+func (s *Spec) resolveTag(ctx context.Context, repo string, tag string) (imgspecv1.Descriptor, error) {
+	key := repo + ":" + tag
+	s.mu.Lock()
+	entry, ok := s.tags[key]
+	s.mu.Unlock()
+	if ok && s.options.Now().Before(entry.expires) {
+		return entry.descriptor, nil
+	}
+
+	desc, err := s.upstream.StatManifest(ctx, repo, tag)
+	if err != nil {
+		if errors.Is(err, errdefs.ErrNotFound) {
+			s.mu.Lock()
+			delete(s.tags, key)
+			s.mu.Unlock()
+			return imgspecv1.Descriptor{}, err
+		}
+		cached, cerr := s.cache.StatManifest(ctx, repo, tag)
+		if cerr != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+		xlog.C(ctx).Warnf("unable to resolve %s from upstream, serve the cached %s: %s", key, cached.Digest, err)
+		return cached, nil
+	}
+	desc, err = s.pullManifest(ctx, repo, desc.Digest.String(), tag)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+
+	s.mu.Lock()
+	s.tags[key] = tagEntry{descriptor: desc, expires: s.options.Now().Add(s.options.TagTTL)}
+	s.mu.Unlock()
+	return desc, nil
+}
+
+// pullManifest stores the manifest into the cache if missed, and tags it in the
+// cache which is used when the upstream is unavailable.
+func (s *Spec) pullManifest(ctx context.Context, repo string, dgst string, tags ...string) (imgspecv1.Descriptor, error) {
+	cached, err := s.cache.StatManifest(ctx, repo, dgst)
+	var rc cas.ReadCloser
+	switch {
+	case err == nil:
+		if len(tags) == 0 {
+			return cached, nil
+		}
+		if tagged, err := s.cache.StatManifest(ctx, repo, tags[0]); err == nil && tagged.Digest == cached.Digest {
+			return cached, nil
+		}
+		// retag the cached manifest
+		rc, err = s.cache.GetManifest(ctx, repo, dgst)
+		if err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+	case errors.Is(err, errdefs.ErrNotFound):
+		rc, err = s.upstream.GetManifest(ctx, repo, dgst)
+		if err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+	default:
+		return imgspecv1.Descriptor{}, err
+	}
+	defer xio.CloseAndSkipError(rc)
+
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	desc := imgspecv1.Descriptor{
+		MediaType: rc.Descriptor().MediaType,
+		Digest:    digest.Digest(dgst),
+		Size:      int64(len(content)),
+	}
+	if err := s.cache.PushManifest(ctx, repo, cas.NewReader(bytes.NewReader(content), desc), tags...); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// normalize returns the repository path in the upstream registry, such as
+// "library/alpine" for "alpine" in Docker Hub.
+func (s *Spec) normalize(repo string) string {
+	named, err := ocispecname.NewRepository(s.upstream.Name().Hostname() + "/" + repo)
+	if err != nil {
+		return repo
+	}
+	return named.Path()
+}
+
+// cachingReader writes the content read into the cache, and commits it after
+// verified at EOF.
+type cachingReader struct {
+	cas.ReadCloser
+	ctx    context.Context
+	writer distribution.BlobWriteCloser
+}
+
+// Read reads the content from the upstream and writes it into the cache.
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.writer == nil {
+		return n, err
+	}
+	if n > 0 {
+		if _, werr := r.writer.Write(p[:n]); werr != nil {
+			xlog.C(r.ctx).Warnf("unable to cache blob %s: %s", r.Descriptor().Digest, werr)
+			r.cancel()
+			return n, err
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		// the content has been verified by the reader at EOF
+		if _, cerr := r.writer.Commit(r.Descriptor().Digest); cerr != nil {
+			xlog.C(r.ctx).Warnf("unable to cache blob %s: %s", r.Descriptor().Digest, cerr)
+		}
+		r.cancel()
+	}
+	return n, err
+}
+
+// Close closes the upstream reader and drops the partial content in the cache.
+func (r *cachingReader) Close() error {
+	r.cancel()
+	return r.ReadCloser.Close()
+}
+
+func (r *cachingReader) cancel() {
+	if r.writer == nil {
+		return
+	}
+	if err := r.writer.Cancel(); err != nil {
+		xlog.C(r.ctx).Debugf("unable to cancel caching blob %s: %s", r.Descriptor().Digest, err)
+	}
+	r.writer = nil
+}
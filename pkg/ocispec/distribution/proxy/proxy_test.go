@@ -0,0 +1,163 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/proxy"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/server"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+func init() {
+	ocispecname.RegisterScheme("http")
+}
+
+// upstream is a registry counting the requests by method.
+type upstream struct {
+	*memory.Spec
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newUpstream(t *testing.T) *upstream {
+	t.Helper()
+	u := &upstream{Spec: memory.New(), requests: map[string]int{}}
+	handler := server.NewHandler(u.Spec)
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.requests[r.Method]++
+		u.mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+func (u *upstream) count(method string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests[method]
+}
+
+func (u *upstream) registry(t *testing.T) *remote.Registry {
+	t.Helper()
+	name, err := ocispecname.NewRegistry(u.server.URL)
+	require.NoError(t, err)
+	reg, err := remote.NewClient().NewRegistry(context.Background(), name)
+	require.NoError(t, err)
+	return reg
+}
+
+func (u *upstream) push(t *testing.T, repo string, tag string, layer []byte) imgspecv1.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	layerDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageLayer, layer)
+	require.NoError(t, u.PushBlob(ctx, repo, func(context.Context) (cas.ReadCloser, error) {
+		return cas.NewReadCloser(io.NopCloser(bytes.NewReader(layer)), layerDesc), nil
+	}))
+	mf := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"mediaType":"` +
+		layerDesc.MediaType + `","digest":"` + layerDesc.Digest.String() + `","size":` + strconv.FormatInt(layerDesc.Size, 10) + `}]}`)
+	require.NoError(t, u.PushManifest(ctx, repo, cas.NewReaderFromBytes(imgspecv1.MediaTypeImageManifest, mf), tag))
+	return ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, mf)
+}
+
+func TestSpec(t *testing.T) {
+	ctx := context.Background()
+	up := newUpstream(t)
+	layer := []byte("layer content")
+	v1 := up.push(t, "app", "latest", layer)
+
+	now := time.Now()
+	cache := memory.New()
+	spec := proxy.New(up.registry(t), cache, proxy.WithTagTTL(time.Minute), proxy.WithNow(func() time.Time { return now }))
+
+	// the tag is resolved with the upstream and the manifest is cached
+	desc, err := spec.StatManifest(ctx, "app", "latest")
+	require.NoError(t, err)
+	assert.Equal(t, v1, desc)
+	_, err = cache.StatManifest(ctx, "app", v1.Digest.String())
+	require.NoError(t, err)
+	heads, gets := up.count(http.MethodHead), up.count(http.MethodGet)
+
+	// served from the cache within the TTL
+	rc, err := spec.GetManifest(ctx, "app", "latest")
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, heads, up.count(http.MethodHead))
+	assert.Equal(t, gets, up.count(http.MethodGet))
+
+	// the blob is cached after read through
+	layerDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageLayer, layer)
+	for range 2 {
+		rc, err = spec.GetBlob(ctx, "app", layerDesc.Digest)
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, layer, content)
+	}
+	assert.Equal(t, gets+1, up.count(http.MethodGet))
+	_, err = cache.StatBlob(ctx, "app", layerDesc.Digest)
+	require.NoError(t, err)
+
+	// the tag is revalidated after expired
+	v2 := up.push(t, "app", "latest", []byte("new layer"))
+	desc, err = spec.StatManifest(ctx, "app", "latest")
+	require.NoError(t, err)
+	assert.Equal(t, v1.Digest, desc.Digest)
+	now = now.Add(2 * time.Minute)
+	desc, err = spec.StatManifest(ctx, "app", "latest")
+	require.NoError(t, err)
+	assert.Equal(t, v2.Digest, desc.Digest)
+
+	// the cached tag is served when the upstream is unavailable
+	up.server.Close()
+	now = now.Add(2 * time.Minute)
+	desc, err = spec.StatManifest(ctx, "app", "latest")
+	require.NoError(t, err)
+	assert.Equal(t, v2.Digest, desc.Digest)
+
+	require.ErrorIs(t, spec.DeleteManifest(ctx, "app", "latest"), errdefs.ErrUnsupported)
+}
+
+func TestSpec_NotModified(t *testing.T) {
+	up := newUpstream(t)
+	v1 := up.push(t, "app", "latest", []byte("layer content"))
+	srv := httptest.NewServer(server.NewHandler(proxy.New(up.registry(t), memory.New())))
+	t.Cleanup(srv.Close)
+
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL+"/v2/app/manifests/latest", http.NoBody)
+	require.NoError(t, err)
+	request.Header.Set("If-None-Match", `"`+v1.Digest.String()+`"`)
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, `"`+v1.Digest.String()+`"`, resp.Header.Get("ETag"))
+
+	request.Header.Set("If-None-Match", `"sha256:other"`)
+	resp, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, v1.Digest.String(), resp.Header.Get("Docker-Content-Digest"))
+}
@@ -0,0 +1,36 @@
+package distribution
+
+import (
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ParseReferrer parses the manifest content described by desc, and returns the
+// subject digest and the descriptor to be listed in the referrers response with
+// the artifact type and annotations of the manifest. It returns false if the
+// manifest has no subject.
+func ParseReferrer(desc imgspecv1.Descriptor, content []byte) (digest.Digest, imgspecv1.Descriptor, bool) {
+	parsed := struct {
+		ArtifactType string                `json:"artifactType,omitempty"`
+		Config       *imgspecv1.Descriptor `json:"config,omitempty"`
+		Subject      *imgspecv1.Descriptor `json:"subject,omitempty"`
+		Annotations  map[string]string     `json:"annotations,omitempty"`
+	}{}
+	if err := json.Unmarshal(content, &parsed); err != nil || parsed.Subject == nil {
+		return "", imgspecv1.Descriptor{}, false
+	}
+	referrer := imgspecv1.Descriptor{
+		MediaType:    desc.MediaType,
+		Digest:       desc.Digest,
+		Size:         desc.Size,
+		ArtifactType: parsed.ArtifactType,
+		Annotations:  parsed.Annotations,
+	}
+	// the config media type is used as the artifact type of the image manifest
+	if referrer.ArtifactType == "" && parsed.Config != nil {
+		referrer.ArtifactType = parsed.Config.MediaType
+	}
+	return parsed.Subject.Digest, referrer, true
+}
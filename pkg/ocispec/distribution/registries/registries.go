@@ -0,0 +1,437 @@
+// Package registries implements the registries configuration compatible with
+// the "registries.conf" v2 format of containers, which configures the mirrors,
+// the location rewrites and the blocked registries to pull images from, and
+// the resolution of the short names.
+//
+// See https://github.com/containers/image/blob/main/docs/containers-registries.conf.5.md
+package registries
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/homedir"
+)
+
+const (
+	// DefaultConfigFile is the default path of the system-wide registries config file.
+	DefaultConfigFile = "/etc/containers/registries.conf"
+
+	// PullFromMirrorAll allows to pull from the mirror by both digest and tag.
+	PullFromMirrorAll = "all"
+	// PullFromMirrorDigestOnly allows to pull from the mirror by digest only.
+	PullFromMirrorDigestOnly = "digest-only"
+	// PullFromMirrorTagOnly allows to pull from the mirror by tag only.
+	PullFromMirrorTagOnly = "tag-only"
+)
+
+// ConfigFile returns the registries config file of the current user, which is
+// "$XDG_CONFIG_HOME/containers/registries.conf" falls back to
+// "~/.config/containers/registries.conf" for rootless if exists, otherwise
+// "/etc/containers/registries.conf".
+func ConfigFile() string {
+	if os.Geteuid() == 0 {
+		return DefaultConfigFile
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := homedir.Get()
+		if err != nil {
+			return DefaultConfigFile
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	path := filepath.Join(configHome, "containers", "registries.conf")
+	if _, err := os.Stat(path); err != nil {
+		return DefaultConfigFile
+	}
+	return path
+}
+
+// Config is the subset of "registries.conf" v2 related to pull images.
+type Config struct {
+	// Registries are the configurations of registries matched by the prefix.
+	Registries []Registry `toml:"registry,omitempty"`
+	// UnqualifiedSearchRegistries are the registries to resolve the short names
+	// in order, like "myapp:1.2".
+	UnqualifiedSearchRegistries []string `toml:"unqualified-search-registries,omitempty"`
+	// ShortNameMode is the mode to resolve the short names, must be one of
+	// "enforcing", "permissive" and "disabled". Default to "permissive".
+	ShortNameMode string `toml:"short-name-mode,omitempty"`
+	// Aliases maps the short names to the fully-qualified names without tag
+	// or digest, like "myapp" = "registry.example.com/team/myapp".
+	Aliases map[string]string `toml:"aliases,omitempty"`
+}
+
+// Endpoint is the location to pull images from.
+type Endpoint struct {
+	// Location is the address of the endpoint, which may include the namespace
+	// to rewrite the references matched by the prefix of the registry, like
+	// "example.com/mirror/docker.io".
+	Location string `toml:"location,omitempty"`
+	// Insecure allows to access the endpoint over plain HTTP.
+	Insecure bool `toml:"insecure,omitempty"`
+	// PullFromMirror restricts the references pulled from the mirror, must be
+	// one of "all", "digest-only" and "tag-only". Only used by mirrors.
+	PullFromMirror string `toml:"pull-from-mirror,omitempty"`
+}
+
+// Registry is the configuration of the references matched by the prefix.
+type Registry struct {
+	// Prefix matches the references to apply the registry configuration, like
+	// "example.com/foo", or "*.example.com" to match any subdomain. The longest
+	// prefix wins when matched by multiple registries. Default to the location.
+	Prefix string `toml:"prefix,omitempty"`
+	// Endpoint is the primary location of the registry.
+	Endpoint
+	// Blocked forbids to pull images matched by the prefix.
+	Blocked bool `toml:"blocked,omitempty"`
+	// MirrorByDigestOnly only pulls from the mirrors when referenced by digest.
+	MirrorByDigestOnly bool `toml:"mirror-by-digest-only,omitempty"`
+	// Mirrors are the endpoints tried in order before the primary location.
+	Mirrors []Endpoint `toml:"mirror,omitempty"`
+}
+
+// PullSource is the reference to pull the image from.
+type PullSource struct {
+	// Reference is the reference rewritten to the endpoint.
+	Reference ocispecname.Reference
+	// Endpoint is the endpoint which the reference rewritten to.
+	Endpoint Endpoint
+	// Mirror is true when the endpoint is a mirror.
+	Mirror bool
+}
+
+// String returns the reference of the source.
+func (s PullSource) String() string {
+	return s.Reference.String()
+}
+
+// Load loads the registries config file from the path, and merges the drop-in
+// files "*.conf" in the "registries.conf.d" directory next to it by the
+// lexical order. A registry in the drop-in files replaces the one with the
+// same prefix. An empty config is returned when nothing exists.
+func Load(path string) (*Config, error) {
+	config, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dropins, err := filepath.Glob(filepath.Join(filepath.Dir(path), "registries.conf.d", "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dropins)
+	for _, dropin := range dropins {
+		c, err := LoadFile(dropin)
+		if err != nil {
+			return nil, err
+		}
+		config.Merge(c)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid registries config %q: %w", path, err)
+	}
+	return config, nil
+}
+
+// LoadFile loads the registries config from a single file. An empty config is
+// returned when the file does not exist.
+func LoadFile(path string) (*Config, error) {
+	config := &Config{}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := toml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("unable to parse registries config %q: %w", path, err)
+	}
+	return config, nil
+}
+
+// Merge merges the other config into the current one, where the registries
+// with the same prefix and the aliases with the same short name are replaced,
+// and the unqualified search registries and the short name mode are replaced
+// if set.
+func (c *Config) Merge(other *Config) {
+	if len(other.UnqualifiedSearchRegistries) > 0 {
+		c.UnqualifiedSearchRegistries = other.UnqualifiedSearchRegistries
+	}
+	if other.ShortNameMode != "" {
+		c.ShortNameMode = other.ShortNameMode
+	}
+	for short, alias := range other.Aliases {
+		if c.Aliases == nil {
+			c.Aliases = map[string]string{}
+		}
+		c.Aliases[short] = alias
+	}
+	for _, reg := range other.Registries {
+		idx := slices.IndexFunc(c.Registries, func(r Registry) bool {
+			return r.prefix() == reg.prefix()
+		})
+		if idx < 0 {
+			c.Registries = append(c.Registries, reg)
+		} else {
+			c.Registries[idx] = reg
+		}
+	}
+}
+
+// Validate checks whether the config is valid.
+func (c *Config) Validate() error {
+	var errs []error
+	if err := c.validateShortNames(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, reg := range c.Registries {
+		if err := reg.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AddDockerMirrors adds the mirrors of Docker Hub, which are compatible with
+// "registry-mirrors" in the docker daemon config like "https://mirror.gcr.io".
+// A mirror with "http" scheme is insecure.
+func (c *Config) AddDockerMirrors(mirrors ...string) error {
+	if len(mirrors) == 0 {
+		return nil
+	}
+	endpoints := make([]Endpoint, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		scheme, location := ocispecname.SplitScheme(strings.TrimSuffix(mirror, "/"))
+		if location == "" || strings.Contains(location, "/") {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "invalid registry mirror %q", mirror)
+		}
+		endpoints = append(endpoints, Endpoint{Location: location, Insecure: scheme == "http"})
+	}
+	for i := range c.Registries {
+		if c.Registries[i].prefix() == ocispecname.DockerIOHostname {
+			c.Registries[i].Mirrors = append(c.Registries[i].Mirrors, endpoints...)
+			return nil
+		}
+	}
+	c.Registries = append(c.Registries, Registry{
+		Prefix:   ocispecname.DockerIOHostname,
+		Endpoint: Endpoint{Location: ocispecname.DockerIOHostname},
+		Mirrors:  endpoints,
+	})
+	return nil
+}
+
+// AddInsecureRegistries marks the registries as insecure to be accessed over
+// plain HTTP, which are compatible with "insecure-registries" in the docker
+// daemon config like "registry.example.com:5000". The locations and the mirrors
+// at the registries are marked as well. The CIDR entries like "10.0.0.0/8" are
+// not supported and skipped.
+func (c *Config) AddInsecureRegistries(hosts ...string) error {
+	for _, host := range hosts {
+		_, location := ocispecname.SplitScheme(strings.TrimSuffix(host, "/"))
+		if location == "" {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "invalid insecure registry %q", host)
+		}
+		if strings.Contains(location, "/") {
+			continue
+		}
+		location = canonicalHostname(location)
+		found := false
+		for i := range c.Registries {
+			reg := &c.Registries[i]
+			if reg.prefix() == location {
+				found = true
+			}
+			primary := reg.Endpoint
+			if primary.Location == "" {
+				primary.Location = reg.prefix()
+			}
+			if primary.hostname() == location {
+				reg.Insecure = true
+			}
+			for j := range reg.Mirrors {
+				if reg.Mirrors[j].hostname() == location {
+					reg.Mirrors[j].Insecure = true
+				}
+			}
+		}
+		if !found {
+			c.Registries = append(c.Registries, Registry{
+				Prefix:   location,
+				Endpoint: Endpoint{Location: location, Insecure: true},
+			})
+		}
+	}
+	return nil
+}
+
+// FindRegistry returns the registry with the longest prefix matched by the
+// reference, or false if not found.
+func (c *Config) FindRegistry(ref ocispecname.Reference) (*Registry, bool) {
+	name := canonicalName(ref)
+	var found *Registry
+	for i := range c.Registries {
+		reg := &c.Registries[i]
+		if !reg.match(name) {
+			continue
+		}
+		if found == nil || len(reg.prefix()) > len(found.prefix()) {
+			found = reg
+		}
+	}
+	return found, found != nil
+}
+
+// Validate checks whether the registry is valid.
+func (r *Registry) Validate() error {
+	prefix := r.prefix()
+	if prefix == "" {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "registry requires either prefix or location")
+	}
+	if strings.HasPrefix(prefix, "*.") {
+		if r.Location != "" {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "registry with wildcard prefix %q must not set location", prefix)
+		}
+	} else if strings.ContainsAny(prefix, "*") {
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "registry prefix %q must start with \"*.\" to use wildcard", prefix)
+	}
+	for _, mirror := range r.Mirrors {
+		if mirror.Location == "" {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "mirror of registry %q requires location", prefix)
+		}
+		switch mirror.PullFromMirror {
+		case "", PullFromMirrorAll, PullFromMirrorDigestOnly, PullFromMirrorTagOnly:
+		default:
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "invalid pull-from-mirror %q of registry %q", mirror.PullFromMirror, prefix)
+		}
+		if r.MirrorByDigestOnly && mirror.PullFromMirror != "" && mirror.PullFromMirror != PullFromMirrorDigestOnly {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "pull-from-mirror %q conflicts with mirror-by-digest-only of registry %q", mirror.PullFromMirror, prefix)
+		}
+	}
+	return nil
+}
+
+// PullSources returns the sources to pull the image of the reference from in
+// order, which are the mirrors allowed followed by the primary location.
+// [errdefs.ErrForbidden] is returned when the registry is blocked.
+func (r *Registry) PullSources(ref ocispecname.Reference) ([]PullSource, error) {
+	if r.Blocked {
+		return nil, errdefs.Newf(errdefs.ErrForbidden, "registry %q is blocked to pull %s", r.prefix(), ref)
+	}
+	_, digested := ocispecname.IsDigested(ref)
+	sources := []PullSource{}
+	for _, mirror := range r.Mirrors {
+		pullFrom := mirror.PullFromMirror
+		if r.MirrorByDigestOnly {
+			pullFrom = PullFromMirrorDigestOnly
+		}
+		if (pullFrom == PullFromMirrorDigestOnly && !digested) || (pullFrom == PullFromMirrorTagOnly && digested) {
+			continue
+		}
+		source, err := r.rewrite(ref, mirror)
+		if err != nil {
+			return nil, err
+		}
+		source.Mirror = true
+		sources = append(sources, source)
+	}
+	source, err := r.rewrite(ref, r.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, source)
+	return sources, nil
+}
+
+// rewrite replaces the prefix of the reference with the location of the endpoint.
+func (r *Registry) rewrite(ref ocispecname.Reference, endpoint Endpoint) (PullSource, error) {
+	source := PullSource{Reference: ref, Endpoint: endpoint}
+	if endpoint.Location == "" {
+		return source, nil
+	}
+	name := canonicalName(ref)
+	prefix := r.prefix()
+	if strings.HasPrefix(prefix, "*.") {
+		// replace the hostname matched by the wildcard prefix
+		hostname, _, _ := strings.Cut(name, "/")
+		prefix = hostname
+	}
+	rewritten, err := ocispecname.NewReference(endpoint.Location + strings.TrimPrefix(name, canonicalHostname(prefix)))
+	if err != nil {
+		return source, fmt.Errorf("unable to rewrite %s with location %q: %w", ref, endpoint.Location, err)
+	}
+	source.Reference = rewritten
+	return source, nil
+}
+
+func (r *Registry) prefix() string {
+	if r.Prefix != "" {
+		return canonicalHostname(r.Prefix)
+	}
+	return canonicalHostname(r.Location)
+}
+
+// hostname returns the canonical hostname of the endpoint location.
+func (e Endpoint) hostname() string {
+	hostname, _, _ := strings.Cut(canonicalHostname(e.Location), "/")
+	return hostname
+}
+
+// match checks whether the canonical name of the reference is matched by the
+// prefix of the registry.
+func (r *Registry) match(name string) bool {
+	prefix := r.prefix()
+	if wildcard, ok := strings.CutPrefix(prefix, "*"); ok {
+		hostname, _, _ := strings.Cut(name, "/")
+		return strings.HasSuffix(hostname, wildcard)
+	}
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	switch name[len(prefix)] {
+	case '/', ':', '@':
+		return true
+	}
+	return false
+}
+
+// canonicalName returns the name of the reference in the form used by the
+// prefixes, where the Docker Hub registry is named as "docker.io".
+func canonicalName(ref ocispecname.Reference) string {
+	name := canonicalHostname(ref.Repository().Domain().Hostname()) + "/" + ref.Repository().Path()
+	if tagged, ok := ocispecname.IsTagged(ref); ok {
+		name += ":" + tagged.Tag()
+	}
+	if digested, ok := ocispecname.IsDigested(ref); ok {
+		name += "@" + digested.Digest().String()
+	}
+	return name
+}
+
+// canonicalHostname replaces the hostname of Docker Hub at the beginning of
+// the name with "docker.io".
+func canonicalHostname(name string) string {
+	hostname, rest, found := strings.Cut(name, "/")
+	switch hostname {
+	case ocispecname.DefaultRegistry, ocispecname.DockerIndexHostname:
+		hostname = ocispecname.DockerIOHostname
+	}
+	if !found {
+		return hostname
+	}
+	return hostname + "/" + rest
+}
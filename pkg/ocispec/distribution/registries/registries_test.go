@@ -0,0 +1,245 @@
+package registries_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/registries"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+func init() {
+	ocispecname.RegisterScheme("http")
+}
+
+const testConfig = `
+[[registry]]
+prefix = "docker.io"
+location = "docker.io"
+
+[[registry.mirror]]
+location = "mirror.example.com"
+
+[[registry.mirror]]
+location = "digest.example.com/docker"
+pull-from-mirror = "digest-only"
+
+[[registry]]
+prefix = "quay.io"
+location = "quay.io"
+mirror-by-digest-only = true
+
+[[registry.mirror]]
+location = "quay-mirror.example.com"
+insecure = true
+
+[[registry]]
+prefix = "example.com/foo"
+location = "internal.example.com/bar"
+
+[[registry]]
+location = "example.com/foo/blocked"
+blocked = true
+
+[[registry]]
+prefix = "*.example.org"
+`
+
+func writeConfig(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func pullSources(t *testing.T, config *registries.Config, ref string) ([]string, error) {
+	t.Helper()
+	parsed, err := ocispecname.NewReference(ref)
+	require.NoError(t, err)
+	reg, ok := config.FindRegistry(parsed)
+	if !ok {
+		return nil, nil
+	}
+	sources, err := reg.PullSources(parsed)
+	if err != nil {
+		return nil, err
+	}
+	got := []string{}
+	for _, source := range sources {
+		got = append(got, source.String())
+	}
+	return got, nil
+}
+
+func TestRegistry_PullSources(t *testing.T) {
+	config, err := registries.Load(writeConfig(t, t.TempDir(), "registries.conf", testConfig))
+	require.NoError(t, err)
+	dgst := "sha256:" + "a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"
+
+	testcases := []struct {
+		ref     string
+		want    []string
+		wantErr error
+	}{
+		{
+			ref: "nginx",
+			want: []string{
+				"mirror.example.com/library/nginx:latest",
+				"registry-1.docker.io/library/nginx:latest",
+			},
+		},
+		{
+			ref: "docker.io/library/nginx@" + dgst,
+			want: []string{
+				"mirror.example.com/library/nginx@" + dgst,
+				"digest.example.com/docker/library/nginx@" + dgst,
+				"registry-1.docker.io/library/nginx@" + dgst,
+			},
+		},
+		{
+			ref:  "quay.io/app/server:v1",
+			want: []string{"quay.io/app/server:v1"},
+		},
+		{
+			ref: "quay.io/app/server@" + dgst,
+			want: []string{
+				"quay-mirror.example.com/app/server@" + dgst,
+				"quay.io/app/server@" + dgst,
+			},
+		},
+		{
+			ref:  "example.com/foo/app:v1",
+			want: []string{"internal.example.com/bar/app:v1"},
+		},
+		{
+			ref:  "example.com/foo:v1",
+			want: []string{"internal.example.com/bar:v1"},
+		},
+		{
+			ref: "example.com/foobar:v1",
+		},
+		{
+			ref:     "example.com/foo/blocked/app:v1",
+			wantErr: errdefs.ErrForbidden,
+		},
+		{
+			ref:  "registry.example.org/app:v1",
+			want: []string{"registry.example.org/app:v1"},
+		},
+		{
+			ref: "example.org/app:v1",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.ref, func(t *testing.T) {
+			got, err := pullSources(t, config, tc.ref)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "registries.conf", testConfig)
+	writeConfig(t, dir, "registries.conf.d/01-quay.conf", `
+[[registry]]
+prefix = "quay.io"
+location = "quay.example.com"
+`)
+	writeConfig(t, dir, "registries.conf.d/02-ghcr.conf", `
+[[registry]]
+location = "ghcr.io"
+insecure = true
+`)
+	config, err := registries.Load(path)
+	require.NoError(t, err)
+	require.Len(t, config.Registries, 6)
+	assert.Equal(t, registries.Registry{Prefix: "quay.io", Endpoint: registries.Endpoint{Location: "quay.example.com"}}, config.Registries[1])
+	assert.Equal(t, registries.Registry{Endpoint: registries.Endpoint{Location: "ghcr.io", Insecure: true}}, config.Registries[5])
+
+	config, err = registries.Load(filepath.Join(t.TempDir(), "registries.conf"))
+	require.NoError(t, err)
+	assert.Empty(t, config.Registries)
+
+	_, err = registries.Load(writeConfig(t, t.TempDir(), "registries.conf", `
+[[registry]]
+prefix = "*.example.com"
+location = "example.com"
+`))
+	require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+}
+
+func TestConfig_AddDockerMirrors(t *testing.T) {
+	config := &registries.Config{}
+	require.NoError(t, config.AddDockerMirrors("https://mirror.gcr.io", "http://127.0.0.1:5000/"))
+	require.NoError(t, config.AddDockerMirrors("mirror.example.com"))
+	require.Len(t, config.Registries, 1)
+	assert.Equal(t, []registries.Endpoint{
+		{Location: "mirror.gcr.io"},
+		{Location: "127.0.0.1:5000", Insecure: true},
+		{Location: "mirror.example.com"},
+	}, config.Registries[0].Mirrors)
+
+	got, err := pullSources(t, config, "index.docker.io/library/alpine:3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"mirror.gcr.io/library/alpine:3",
+		"127.0.0.1:5000/library/alpine:3",
+		"mirror.example.com/library/alpine:3",
+		"registry-1.docker.io/library/alpine:3",
+	}, got)
+
+	require.ErrorIs(t, config.AddDockerMirrors("https://mirror.gcr.io/path"), errdefs.ErrInvalidParameter)
+}
+
+func TestConfig_AddInsecureRegistries(t *testing.T) {
+	config, err := registries.LoadFile(writeConfig(t, t.TempDir(), "registries.conf", `
+[[registry]]
+prefix = "example.com/foo"
+location = "internal.example.com:5000/bar"
+
+[[registry.mirror]]
+location = "mirror.example.com"
+`))
+	require.NoError(t, err)
+	require.NoError(t, config.AddDockerMirrors("mirror.gcr.io"))
+	require.NoError(t, config.AddInsecureRegistries(
+		"internal.example.com:5000",
+		"http://mirror.example.com/",
+		"mirror.gcr.io",
+		"10.0.0.0/8",
+		"plain.example.com",
+	))
+
+	// the hosts pulled from directly are insecure as well
+	require.Len(t, config.Registries, 6)
+	foo := config.Registries[0]
+	assert.True(t, foo.Insecure)
+	assert.Equal(t, []registries.Endpoint{{Location: "mirror.example.com", Insecure: true}}, foo.Mirrors)
+	dockerHub := config.Registries[1]
+	assert.False(t, dockerHub.Insecure)
+	assert.Equal(t, []registries.Endpoint{{Location: "mirror.gcr.io", Insecure: true}}, dockerHub.Mirrors)
+	assert.Equal(t, registries.Registry{
+		Prefix:   "internal.example.com:5000",
+		Endpoint: registries.Endpoint{Location: "internal.example.com:5000", Insecure: true},
+	}, config.Registries[2])
+	for i, host := range []string{"mirror.example.com", "mirror.gcr.io", "plain.example.com"} {
+		assert.Equal(t, registries.Registry{
+			Prefix:   host,
+			Endpoint: registries.Endpoint{Location: host, Insecure: true},
+		}, config.Registries[3+i])
+	}
+
+	require.ErrorIs(t, config.AddInsecureRegistries(""), errdefs.ErrInvalidParameter)
+}
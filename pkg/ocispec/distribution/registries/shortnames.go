@@ -0,0 +1,177 @@
+package registries
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+const (
+	// ShortNameModeEnforcing resolves the short name to the alias or the only
+	// unqualified search registry, and refuses the ambiguous short names.
+	ShortNameModeEnforcing = "enforcing"
+	// ShortNameModePermissive resolves the short name to the alias, or tries
+	// all the unqualified search registries in order.
+	ShortNameModePermissive = "permissive"
+	// ShortNameModeDisabled ignores the aliases and tries all the unqualified
+	// search registries in order.
+	ShortNameModeDisabled = "disabled"
+)
+
+// ShortNameCandidate is a fully-qualified reference which a short name may be
+// resolved to.
+type ShortNameCandidate struct {
+	// Reference is the fully-qualified reference.
+	Reference ocispecname.Reference
+	// Alias is true when the short name is resolved by the aliases.
+	Alias bool
+}
+
+// String returns the reference of the candidate.
+func (c ShortNameCandidate) String() string {
+	return c.Reference.String()
+}
+
+// IsShortName checks whether the name is a short name without the registry,
+// like "myapp:1.2" or "library/nginx". The first component of the name is the
+// registry when it contains "." or ":", or is "localhost".
+//
+// The registry scheme like "https://" qualifies the name, while the other
+// schemes like the storage type "remote://" are not a part of the name.
+func IsShortName(name string) bool {
+	name, qualified := trimScheme(name)
+	if qualified {
+		return false
+	}
+	first, _, found := strings.Cut(name, "/")
+	if !found {
+		return true
+	}
+	return !strings.ContainsAny(first, ".:") && first != "localhost"
+}
+
+// ResolveShortName returns the candidates which the name may be resolved to in
+// order. The name is parsed as it is when it is not a short name, or neither
+// the alias nor the unqualified search registries are configured, which uses
+// the default registry.
+//
+// [errdefs.ErrInvalidParameter] is returned when the short name is ambiguous
+// in "enforcing" mode, where it matches no alias and multiple unqualified
+// search registries.
+func (c *Config) ResolveShortName(name string) ([]ShortNameCandidate, error) {
+	if !IsShortName(name) {
+		ref, err := ocispecname.NewReference(name)
+		if err != nil {
+			return nil, err
+		}
+		return []ShortNameCandidate{{Reference: ref}}, nil
+	}
+
+	name, _ = trimScheme(name)
+	repo, suffix := splitShortName(name)
+	mode := c.shortNameMode()
+	if alias, ok := c.Aliases[repo]; ok && mode != ShortNameModeDisabled {
+		ref, err := ocispecname.NewReference(alias + suffix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alias %q of short name %q: %w", alias, repo, err)
+		}
+		return []ShortNameCandidate{{Reference: ref, Alias: true}}, nil
+	}
+
+	if len(c.UnqualifiedSearchRegistries) == 0 {
+		ref, err := ocispecname.NewReference(name)
+		if err != nil {
+			return nil, err
+		}
+		return []ShortNameCandidate{{Reference: ref}}, nil
+	}
+
+	candidates := make([]ShortNameCandidate, 0, len(c.UnqualifiedSearchRegistries))
+	for _, registry := range c.UnqualifiedSearchRegistries {
+		ref, err := ocispecname.NewReference(name, ocispecname.WithDefaultRegistry(registry))
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, ShortNameCandidate{Reference: ref})
+	}
+	if mode == ShortNameModeEnforcing && len(candidates) > 1 {
+		return nil, errdefs.Newf(errdefs.ErrInvalidParameter,
+			"short name %q is ambiguous in %s mode, use a fully-qualified name or add an alias, candidates: %s",
+			name, mode, strings.Join(candidateNames(candidates), ", "))
+	}
+	return candidates, nil
+}
+
+func (c *Config) shortNameMode() string {
+	if c.ShortNameMode == "" {
+		return ShortNameModePermissive
+	}
+	return c.ShortNameMode
+}
+
+func (c *Config) validateShortNames() error {
+	switch c.ShortNameMode {
+	case "", ShortNameModeEnforcing, ShortNameModePermissive, ShortNameModeDisabled:
+	default:
+		return errdefs.Newf(errdefs.ErrInvalidParameter, "invalid short-name-mode %q", c.ShortNameMode)
+	}
+	for _, registry := range c.UnqualifiedSearchRegistries {
+		if registry == "" || strings.Contains(registry, "/") {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "invalid unqualified search registry %q", registry)
+		}
+	}
+	for short, alias := range c.Aliases {
+		if !IsShortName(short) {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "alias key %q must be a short name", short)
+		}
+		if _, suffix := splitShortName(short); suffix != "" {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "alias key %q must not contain tag or digest", short)
+		}
+		if IsShortName(alias) {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "alias %q of %q must be a fully-qualified name", alias, short)
+		}
+		if _, suffix := splitShortName(alias); suffix != "" {
+			return errdefs.Newf(errdefs.ErrInvalidParameter, "alias %q of %q must not contain tag or digest", alias, short)
+		}
+	}
+	return nil
+}
+
+// trimScheme trims the scheme of the name, and reports whether the name is
+// qualified by the registry scheme, which is kept.
+func trimScheme(name string) (string, bool) {
+	scheme, remainder := ocispecname.SplitScheme(name)
+	switch scheme {
+	case "":
+		return name, false
+	case "http", "https":
+		return name, true
+	default:
+		return remainder, false
+	}
+}
+
+// splitShortName splits the name into the repository and the suffix of tag or
+// digest, like "myapp" and ":1.2".
+func splitShortName(name string) (string, string) {
+	repo, suffix := name, ""
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo, suffix = repo[:i], repo[i:]
+	}
+	// the tag is after the last ":" following the last "/", which excludes
+	// the port of the registry.
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, suffix = repo[:i], repo[i:]+suffix
+	}
+	return repo, suffix
+}
+
+func candidateNames(candidates []ShortNameCandidate) []string {
+	names := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		names = append(names, candidate.String())
+	}
+	return names
+}
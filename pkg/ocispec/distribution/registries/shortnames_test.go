@@ -0,0 +1,110 @@
+package registries_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/registries"
+)
+
+func TestIsShortName(t *testing.T) {
+	testcases := map[string]bool{
+		"myapp":                           true,
+		"myapp:1.2":                       true,
+		"team/myapp@sha256:abc":           true,
+		"localhost/myapp":                 false,
+		"localhost:5000/myapp":            false,
+		"registry.example.com/myapp:1.2":  false,
+		"http://registry.example.com/app": false,
+		"remote://myapp:1.2":              true,
+		"remote://team/myapp":             true,
+		"remote://localhost:5000/myapp":   false,
+	}
+	for name, want := range testcases {
+		assert.Equal(t, want, registries.IsShortName(name), name)
+	}
+}
+
+func TestConfig_ResolveShortName(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "registries.conf", `
+unqualified-search-registries = ["registry.example.com", "docker.io"]
+
+[aliases]
+"myapp" = "registry.example.com/team/myapp"
+"tools/cli" = "quay.io/tools/cli"
+`)
+	config, err := registries.Load(path)
+	require.NoError(t, err)
+
+	resolve := func(t *testing.T, name string) ([]string, bool) {
+		t.Helper()
+		candidates, err := config.ResolveShortName(name)
+		require.NoError(t, err)
+		got := []string{}
+		alias := false
+		for _, candidate := range candidates {
+			got = append(got, candidate.String())
+			alias = alias || candidate.Alias
+		}
+		return got, alias
+	}
+
+	for _, mode := range []string{"", registries.ShortNameModeEnforcing, registries.ShortNameModePermissive} {
+		config.ShortNameMode = mode
+		got, alias := resolve(t, "myapp:1.2")
+		assert.Equal(t, []string{"registry.example.com/team/myapp:1.2"}, got)
+		assert.True(t, alias)
+		got, alias = resolve(t, "remote://myapp:1.2")
+		assert.Equal(t, []string{"registry.example.com/team/myapp:1.2"}, got)
+		assert.True(t, alias)
+		got, _ = resolve(t, "tools/cli@sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4")
+		assert.Equal(t, []string{"quay.io/tools/cli@sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"}, got)
+		got, alias = resolve(t, "ghcr.io/team/myapp:1.2")
+		assert.Equal(t, []string{"ghcr.io/team/myapp:1.2"}, got)
+		assert.False(t, alias)
+	}
+
+	config.ShortNameMode = registries.ShortNameModePermissive
+	got, alias := resolve(t, "other:1.0")
+	assert.Equal(t, []string{"registry.example.com/other:1.0", "registry-1.docker.io/library/other:1.0"}, got)
+	assert.False(t, alias)
+	got, _ = resolve(t, "remote://other:1.0")
+	assert.Equal(t, []string{"registry.example.com/other:1.0", "registry-1.docker.io/library/other:1.0"}, got)
+
+	config.ShortNameMode = registries.ShortNameModeDisabled
+	got, _ = resolve(t, "myapp")
+	assert.Equal(t, []string{"registry.example.com/myapp:latest", "registry-1.docker.io/library/myapp:latest"}, got)
+
+	config.ShortNameMode = registries.ShortNameModeEnforcing
+	_, err = config.ResolveShortName("other:1.0")
+	require.ErrorIs(t, err, errdefs.ErrInvalidParameter)
+	assert.Contains(t, err.Error(), "registry.example.com/other:1.0")
+
+	config.UnqualifiedSearchRegistries = []string{"registry.example.com"}
+	got, _ = resolve(t, "other:1.0")
+	assert.Equal(t, []string{"registry.example.com/other:1.0"}, got)
+
+	config.UnqualifiedSearchRegistries = nil
+	got, _ = resolve(t, "other:1.0")
+	assert.Equal(t, []string{"registry-1.docker.io/library/other:1.0"}, got)
+}
+
+func TestConfig_ValidateShortNames(t *testing.T) {
+	testcases := []registries.Config{
+		{ShortNameMode: "unknown"},
+		{UnqualifiedSearchRegistries: []string{"example.com/namespace"}},
+		{Aliases: map[string]string{"example.com/myapp": "example.com/team/myapp"}},
+		{Aliases: map[string]string{"myapp:1.2": "example.com/team/myapp"}},
+		{Aliases: map[string]string{"myapp": "team/myapp"}},
+		{Aliases: map[string]string{"myapp": "example.com/team/myapp:1.2"}},
+	}
+	for _, config := range testcases {
+		require.ErrorIs(t, config.Validate(), errdefs.ErrInvalidParameter)
+	}
+	config := registries.Config{Aliases: map[string]string{"myapp": "localhost:5000/team/myapp"}}
+	require.NoError(t, config.Validate())
+}
@@ -5,6 +5,10 @@
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
 
 	"github.com/wuxler/ruasec/pkg/ocispec/authn"
 	"github.com/wuxler/ruasec/pkg/ocispec/authn/authfile"
@@ -15,14 +19,64 @@
 type AuthProvider func(ctx context.Context, host string) authn.AuthConfig
 
 // NewAuthProviderFromAuthFile returns an AuthProvider with the *authfile.AuthFile provided.
+// The credential helpers configured in the auth file are used to retrieve the
+// credentials when present.
 func NewAuthProviderFromAuthFile(authFile *authfile.AuthFile) AuthProvider {
+	return NewAuthProviderFromChain(authfile.NewChain(authFile))
+}
+
+// NewAuthProviderFromChain returns an AuthProvider looking up the credentials in
+// the auth files chain. The repositories accessed by the request are taken from
+// the scopes in the context, so the credentials scoped to the repository like
+// "registry.example.com/team/repo" are preferred over the ones of the registry.
+//
+// The credentials are cached by the host and the repositories, which avoids
+// executing the credential helpers for each request. The concurrent requests of
+// the same key share one lookup, and the lookup errors other than not found are
+// not cached so that the next request retries.
+func NewAuthProviderFromChain(chain *authfile.Chain) AuthProvider {
+	var (
+		mu    sync.Mutex
+		group singleflight.Group
+	)
+	cache := map[string]authn.AuthConfig{}
 	return func(ctx context.Context, host string) authn.AuthConfig {
-		authConfig, err := authFile.Get(ctx, host)
-		if err != nil {
-			xlog.C(ctx).Warnf("failed to get auth config for host %s: %v", host, err)
+		repositories := authn.GetRepositories(ctx)
+		key := strings.Join(append([]string{host}, repositories...), " ")
+
+		mu.Lock()
+		authConfig, ok := cache[key]
+		mu.Unlock()
+		if ok {
+			return authConfig
 		}
-		return authConfig
+		value, _, _ := group.Do(key, func() (interface{}, error) {
+			source, err := chain.Lookup(ctx, host, repositories...)
+			switch {
+			case err == nil:
+				xlog.C(ctx).Debugf("using credential %q for host %s from %s", source.Key, host, source.Path)
+			case !errors.Is(err, authn.ErrNotFound):
+				xlog.C(ctx).Warnf("failed to get auth config for host %s: %v", host, err)
+				return source.AuthConfig, nil
+			}
+			mu.Lock()
+			cache[key] = source.AuthConfig
+			mu.Unlock()
+			return source.AuthConfig, nil
+		})
+		return value.(authn.AuthConfig) //nolint:errcheck // explicitly type assertion
+	}
+}
+
+// NewAuthProviderFromAuthFilePaths returns an AuthProvider merging the auth files
+// of the paths, where the former one takes precedence over the latter ones. The
+// missing files are skipped.
+func NewAuthProviderFromAuthFilePaths(paths ...string) (AuthProvider, error) {
+	chain, err := authfile.LoadChain(paths...)
+	if err != nil {
+		return nil, err
 	}
+	return NewAuthProviderFromChain(chain), nil
 }
 
 // NewAuthProviderFromAuthFilePath returns an AuthProvider with the auth file path provided.
@@ -0,0 +1,119 @@
+package remote_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/authn"
+	"github.com/wuxler/ruasec/pkg/ocispec/authn/credentials"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+)
+
+// countingHelperScript is a credential helper keeping the credential of
+// "registry.example.com" only, which records the actions executed and fails
+// the "get" action while the "fail" file exists in FAKE_HELPER_DIR.
+const countingHelperScript = `#!/bin/sh
+dir="$FAKE_HELPER_DIR"
+echo "$1" >> "$dir/calls"
+case "$1" in
+list)
+	printf '{"registry.example.com":"admin"}'
+	;;
+get)
+	server=$(cat)
+	sleep 0.1
+	if [ -f "$dir/fail" ]; then
+		echo "keychain is locked"
+		exit 1
+	fi
+	if [ "$server" != "registry.example.com" ]; then
+		echo "credentials not found in native keychain"
+		exit 1
+	fi
+	printf '{"ServerURL":"%s","Username":"admin","Secret":"secret"}' "$server"
+	;;
+*)
+	echo "unknown action: $1"
+	exit 1
+	;;
+esac
+`
+
+func TestNewAuthProviderFromAuthFilePaths(t *testing.T) {
+	dir := t.TempDir()
+	podman := filepath.Join(dir, "auth.json")
+	require.NoError(t, os.WriteFile(podman, []byte(`{"auths": {"registry.example.com/team/app": {"auth": "YXBwOmFwcA=="}}}`), 0o600))
+	docker := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(docker, []byte(`{"auths": {"registry.example.com": {"auth": "ZG9ja2VyOmRvY2tlcg=="}}}`), 0o600))
+
+	provider, err := remote.NewAuthProviderFromAuthFilePaths(podman, filepath.Join(dir, "missing.json"), docker)
+	require.NoError(t, err)
+
+	ctx := authn.WithScopes(context.Background(), authn.RepositoryScope("team/app", authn.ActionPull))
+	assert.Equal(t, "app", provider(ctx, "registry.example.com").Username)
+
+	ctx = authn.WithScopes(context.Background(), authn.RepositoryScope("other/app", authn.ActionPull))
+	assert.Equal(t, "docker", provider(ctx, "registry.example.com").Username)
+
+	assert.Equal(t, "docker", provider(context.Background(), "registry.example.com").Username)
+	assert.Equal(t, authn.EmptyAuthConfig, provider(context.Background(), "unknown.example.com"))
+}
+
+func TestNewAuthProviderFromAuthFilePaths_CredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake credential helper script requires a POSIX shell")
+	}
+	binDir := t.TempDir()
+	helper := filepath.Join(binDir, credentials.HelperPrefix+"counting")
+	require.NoError(t, os.WriteFile(helper, []byte(countingHelperScript), 0o700)) //nolint:gosec // executable script
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	helperDir := t.TempDir()
+	t.Setenv("FAKE_HELPER_DIR", helperDir)
+	calls := func() []string {
+		content, err := os.ReadFile(filepath.Join(helperDir, "calls"))
+		require.NoError(t, err)
+		return strings.Fields(string(content))
+	}
+
+	config := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(config, []byte(`{"auths": {}, "credsStore": "counting"}`), 0o600))
+	provider, err := remote.NewAuthProviderFromAuthFilePaths(config)
+	require.NoError(t, err)
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		failFile := filepath.Join(helperDir, "fail")
+		require.NoError(t, os.WriteFile(failFile, nil, 0o600))
+		assert.Equal(t, authn.EmptyAuthConfig, provider(context.Background(), "registry.example.com"))
+		require.NoError(t, os.Remove(failFile))
+		assert.Equal(t, "admin", provider(context.Background(), "registry.example.com").Username)
+		assert.Equal(t, []string{"list", "get", "get"}, calls())
+	})
+
+	t.Run("concurrent lookups are shared", func(t *testing.T) {
+		ctx := authn.WithScopes(context.Background(), authn.RepositoryScope("team/app", authn.ActionPull))
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, "admin", provider(ctx, "registry.example.com").Username)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, "admin", provider(ctx, "registry.example.com").Username)
+		assert.Equal(t, []string{"list", "get", "get", "get"}, calls())
+	})
+
+	t.Run("only listed keys are retrieved", func(t *testing.T) {
+		assert.Equal(t, authn.EmptyAuthConfig, provider(context.Background(), "unknown.example.com"))
+		assert.Equal(t, []string{"list", "get", "get", "get"}, calls())
+	})
+}
@@ -5,7 +5,6 @@
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	stdurl "net/url"
 	"strings"
@@ -30,6 +29,7 @@
 type blobWriter struct {
 	ctx       context.Context
 	spec      *Registry
+	repo      string
 	chunkSize int64
 	location  *stdurl.URL
 
@@ -161,10 +161,60 @@ func (w *blobWriter) flush(buf []byte, commitDigest digest.Digest) error {
 	if commitDigest == "" && len(buf)+len(w.chunk) == 0 {
 		return nil
 	}
+	data := make([]byte, 0, len(w.chunk)+len(buf))
+	data = append(append(data, w.chunk...), buf...)
+
+	// offset holds the size of data already stored in the registry, which is
+	// recovered from the upload status before resuming a failed attempt.
+	var offset int64
+	for attempt := 1; ; attempt++ {
+		resp, err := w.send(data[offset:], w.flushed+offset, commitDigest)
+		if err == nil {
+			break
+		}
+		wait, ok := w.spec.client.RetryPolicy.retryUpload(attempt, resp, err)
+		if !ok {
+			return err
+		}
+		xlog.C(w.ctx).Debugf("resume upload %q in %s (attempt %d/%d): %s",
+			w.location.Redacted(), wait, attempt+1, w.spec.client.RetryPolicy.MaxAttempts, err)
+		if werr := w.spec.client.RetryPolicy.wait(w.ctx, wait); werr != nil {
+			return werr
+		}
+		if commitDigest != "" {
+			// the upload session is gone when the blob is committed but the
+			// response is lost
+			if _, serr := w.spec.StatBlob(w.ctx, w.repo, commitDigest); serr == nil {
+				break
+			}
+		}
+		stored, serr := w.status()
+		if serr != nil {
+			return errors.Join(err, fmt.Errorf("cannot recover upload offset: %w", serr))
+		}
+		if stored < w.flushed || stored > w.flushed+int64(len(data)) {
+			return errors.Join(err, fmt.Errorf("cannot resume upload from offset %d out of chunk [%d, %d]",
+				stored, w.flushed, w.flushed+int64(len(data))))
+		}
+		offset = stored - w.flushed
+		if offset == int64(len(data)) && commitDigest == "" {
+			// the chunk is stored but the response is lost
+			break
+		}
+	}
+	w.flushed += int64(len(data))
+	w.chunk = w.chunk[:0]
+	return nil
+}
+
+// send uploads the data from the start offset, and completes the upload when
+// commitDigest is set. The response is returned along with the error when the
+// registry responds with an unexpected status code.
+func (w *blobWriter) send(data []byte, start int64, commitDigest digest.Digest) (*http.Response, error) {
 	// start a new PATCH request to send the currently outstanding data.
 	method := http.MethodPatch
 	expectCode := http.StatusAccepted
-	url := w.location
+	url := *w.location
 	if commitDigest != "" {
 		// This is the final piece of data, so send it as the final PUT request
 		// (committing the whole blob) which avoids an extra round trip.
@@ -174,31 +224,57 @@ func (w *blobWriter) flush(buf []byte, commitDigest digest.Digest) error {
 		query.Set("digest", commitDigest.String())
 		url.RawQuery = query.Encode()
 	}
-	request, err := http.NewRequestWithContext(w.ctx, method, url.String(), concatBody(w.chunk, buf))
+	// the upload is resumed by the writer instead of retried by the client.
+	ctx := WithoutRetry(w.ctx)
+	request, err := http.NewRequestWithContext(ctx, method, url.String(), bytes.NewReader(data))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	request.ContentLength = int64(len(w.chunk) + len(buf))
-	request.Header.Set("Content-Range", xhttp.RangeString(w.flushed, w.flushed+request.ContentLength))
+	request.ContentLength = int64(len(data))
+	request.Header.Set("Content-Range", xhttp.RangeString(start, start+request.ContentLength))
 	request.Header.Set("Content-Type", ocispec.DefaultMediaType)
 
 	resp, err := w.spec.client.Do(request) //nolint:bodyclose // closed by xio.CloseAndSkipError
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer xio.CloseAndSkipError(resp.Body)
 	if err := xhttp.Success(resp, expectCode); err != nil {
-		return err
+		return resp, err
 	}
 
 	location, err := resp.Location()
 	if err != nil {
-		return xhttp.MakeResponseError(resp, fmt.Errorf("bad Location in response header: %w", err))
+		return nil, xhttp.MakeResponseError(resp, fmt.Errorf("bad Location in response header: %w", err))
 	}
 	w.location = location
-	w.flushed += request.ContentLength
-	w.chunk = w.chunk[:0]
-	return nil
+	return resp, nil
+}
+
+// status returns the size of the upload stored in the registry, and updates
+// the location to resume the upload.
+func (w *blobWriter) status() (int64, error) {
+	request, err := http.NewRequestWithContext(w.ctx, http.MethodGet, w.location.String(), http.NoBody)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := w.spec.client.Do(request) //nolint:bodyclose // closed by xio.CloseAndSkipError
+	if err != nil {
+		return 0, err
+	}
+	defer xio.CloseAndSkipError(resp.Body)
+	if err := xhttp.Success(resp, http.StatusNoContent); err != nil {
+		return 0, err
+	}
+	rangeHeader := resp.Header.Get("Range")
+	start, end, ok := xhttp.ParseRange(rangeHeader)
+	if !ok || start != 0 {
+		return 0, xhttp.MakeResponseError(resp, fmt.Errorf("invalid range %q in response header", rangeHeader))
+	}
+	if location, err := resp.Location(); err == nil {
+		w.location = location
+	}
+	return end, nil
 }
 
 // chunkSizeFromResponse returns the chunk size between server-side defined
@@ -212,19 +288,3 @@ func chunkSizeFromResponse(resp *http.Response, chunkSize int64) int64 {
 	}
 	return chunkSize
 }
-
-func concatBody(b1, b2 []byte) io.Reader {
-	if len(b1)+len(b2) == 0 {
-		return nil
-	}
-	if len(b1) == 0 {
-		return bytes.NewReader(b2)
-	}
-	if len(b2) == 0 {
-		return bytes.NewReader(b1)
-	}
-	return io.MultiReader(
-		bytes.NewReader(b1),
-		bytes.NewReader(b2),
-	)
-}
@@ -17,6 +17,7 @@
 	"github.com/wuxler/ruasec/pkg/util/xcache"
 	"github.com/wuxler/ruasec/pkg/util/xhttp"
 	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/xlog"
 )
 
 var _ xhttp.Client = (*Client)(nil)
@@ -77,6 +78,10 @@ type Client struct {
 
 	// TokenOptions is the options to fetch token for authorization.
 	TokenOptions TokenOptions
+
+	// RetryPolicy is the policy to retry the requests failed with transient errors,
+	// if not set, each request is sent only once.
+	RetryPolicy *RetryPolicy
 }
 
 // Do performs an HTTP request and returns an HTTP response with additinal processes like
@@ -119,6 +124,29 @@ func (c *Client) send(request *http.Request) (*http.Response, error) {
 	ctx := request.Context()
 	request.Header = c.expandHeader(request.Header)
 
+	for attempt := 1; ; attempt++ {
+		resp, err := c.sendOnce(request)
+		wait, ok := c.RetryPolicy.retry(request, attempt, resp, err)
+		if !ok {
+			return resp, err
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			xio.CloseAndSkipError(resp.Body)
+		}
+		xlog.C(ctx).Debugf("retry %s %q in %s (attempt %d/%d): %s",
+			request.Method, request.URL.Redacted(), wait, attempt+1, c.RetryPolicy.MaxAttempts, err)
+		if err := c.RetryPolicy.wait(ctx, wait); err != nil {
+			return nil, err
+		}
+		if err := rewindBody(request); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) sendOnce(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
 	if IsDirectRequest(ctx) {
 		return c.client().Do(request)
 	}
@@ -162,6 +190,9 @@ func (c *Client) send(request *http.Request) (*http.Response, error) {
 	xio.CloseAndLogError(resp.Body)
 
 	// retry request with authorization
+	if err := rewindBody(request); err != nil {
+		return nil, err
+	}
 	return c.client().Do(request)
 }
 
@@ -0,0 +1,382 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/manifest"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/xlog"
+
+	// register all the manifest schemas to parse
+	_ "github.com/wuxler/ruasec/pkg/ocispec/manifest/all"
+)
+
+const (
+	// DefaultCopyConcurrency is the default number of the blobs and manifests
+	// transferred in parallel when copying.
+	DefaultCopyConcurrency = 4
+
+	// annotationDockerReferenceDigest is the annotation of the attestation manifests
+	// in the index built by buildkit, which refers to the digest of the image
+	// manifest attested.
+	annotationDockerReferenceDigest = "vnd.docker.reference.digest"
+)
+
+// CopyOption is used to set the options of [Copy].
+type CopyOption func(*CopyOptions)
+
+// CopyOptions is the options of [Copy].
+type CopyOptions struct {
+	// Tags are the tags of the root manifest pushed to the target repository.
+	Tags []string
+	// Concurrency limits the number of the blobs and manifests transferred in
+	// parallel, default to [DefaultCopyConcurrency] if not positive.
+	Concurrency int
+	// Platforms filters the child manifests of the indexes, all the children are
+	// copied if empty.
+	Platforms []imgspecv1.Platform
+	// Referrers copies the referrers of the manifests like signatures and SBOMs
+	// recursively if true.
+	Referrers bool
+}
+
+// WithCopyTags sets the tags of the root manifest pushed to the target repository.
+func WithCopyTags(tags ...string) CopyOption {
+	return func(o *CopyOptions) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
+
+// WithCopyConcurrency sets the number of the blobs and manifests transferred in parallel.
+func WithCopyConcurrency(n int) CopyOption {
+	return func(o *CopyOptions) {
+		o.Concurrency = n
+	}
+}
+
+// WithCopyPlatforms sets the platforms to filter the child manifests of the indexes.
+func WithCopyPlatforms(ps ...imgspecv1.Platform) CopyOption {
+	return func(o *CopyOptions) {
+		o.Platforms = append(o.Platforms, ps...)
+	}
+}
+
+// WithCopyReferrers sets whether to copy the referrers of the manifests.
+func WithCopyReferrers(referrers bool) CopyOption {
+	return func(o *CopyOptions) {
+		o.Referrers = referrers
+	}
+}
+
+// MakeCopyOptions returns the copy options with all optional parameters applied.
+func MakeCopyOptions(opts ...CopyOption) *CopyOptions {
+	options := &CopyOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultCopyConcurrency
+	}
+	return options
+}
+
+// Copy copies the manifest identified by the tag or digest reference from the
+// src repository to the dst repository, and returns the descriptor of the root
+// manifest copied.
+//
+// All the child manifests of the indexes, the configs and the layers are copied
+// before the manifests referring to them, and the contents which already exist in
+// the dst repository are skipped. The blobs are mounted across the repositories
+// when src and dst are in the same registry.
+//
+// When the platforms are specified, the indexes are rewritten with the matched
+// child manifests only, so the digest of the root manifest may be changed.
+func Copy(ctx context.Context, src *Repository, reference string, dst *Repository, opts ...CopyOption) (imgspecv1.Descriptor, error) {
+	options := MakeCopyOptions(opts...)
+	c := &copier{
+		src:     src,
+		dst:     dst,
+		options: options,
+		limiter: make(chan struct{}, options.Concurrency),
+	}
+	srcName, dstName := src.Name(), dst.Name()
+	c.mount = srcName.Domain().Hostname() == dstName.Domain().Hostname() && srcName.Path() != dstName.Path()
+
+	desc, content, err := c.fetchManifest(ctx, reference)
+	if err != nil {
+		return imgspecv1.Descriptor{}, fmt.Errorf("unable to fetch manifest %s of %s: %w", reference, srcName, err)
+	}
+	desc, err = c.copyManifest(ctx, desc, content, options.Tags)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// copier copies the contents between the repositories.
+type copier struct {
+	src     *Repository
+	dst     *Repository
+	options *CopyOptions
+	mount   bool
+
+	// limiter bounds the transfers in progress
+	limiter chan struct{}
+	// copied records the copy tasks by the digest to copy the shared contents once
+	copied sync.Map
+}
+
+// copyOnce runs the copy of the content identified by the digest only once, and
+// the concurrent callers wait for the same result.
+func (c *copier) copyOnce(dgst digest.Digest, fn func() (imgspecv1.Descriptor, error)) (imgspecv1.Descriptor, error) {
+	task, _ := c.copied.LoadOrStore(dgst, sync.OnceValues(fn))
+	return task.(func() (imgspecv1.Descriptor, error))() //nolint:errcheck // always stored as the type
+}
+
+// transfer runs fn when the concurrency limit allows.
+func (c *copier) transfer(ctx context.Context, fn func() error) error {
+	select {
+	case c.limiter <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.limiter }()
+	return fn()
+}
+
+// copyNode copies the manifest or blob described by desc.
+func (c *copier) copyNode(ctx context.Context, desc imgspecv1.Descriptor) (imgspecv1.Descriptor, error) {
+	if !slices.Contains(manifest.AllSupportedMediaTypes(), desc.MediaType) {
+		return c.copyOnce(desc.Digest, func() (imgspecv1.Descriptor, error) {
+			return desc, c.copyBlob(ctx, desc)
+		})
+	}
+	return c.copyOnce(desc.Digest, func() (imgspecv1.Descriptor, error) {
+		fetched, content, err := c.fetchManifest(ctx, desc.Digest.String())
+		if err != nil {
+			return imgspecv1.Descriptor{}, fmt.Errorf("unable to fetch manifest %s: %w", desc.Digest, err)
+		}
+		copied, err := c.copyManifest(ctx, fetched, content, nil)
+		if err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+		// keep the fields like platform and annotations of the parent index
+		desc.MediaType, desc.Digest, desc.Size = copied.MediaType, copied.Digest, copied.Size
+		return desc, nil
+	})
+}
+
+// copyManifest copies the referenced contents of the manifest in parallel, then
+// pushes the manifest with the tags, and the referrers are copied at last since
+// their subject must exist.
+func (c *copier) copyManifest(ctx context.Context, desc imgspecv1.Descriptor, content []byte, tags []string) (imgspecv1.Descriptor, error) {
+	parsed, _, err := manifest.Parse(desc.MediaType, content)
+	if err != nil {
+		return imgspecv1.Descriptor{}, fmt.Errorf("unable to parse manifest %s: %w", desc.Digest, err)
+	}
+
+	references := parsed.References()
+	index, isIndex := parsed.(ocispec.IndexManifest)
+	if isIndex && len(c.options.Platforms) > 0 {
+		references = c.filterPlatforms(index.Manifests())
+		if len(references) == 0 {
+			return imgspecv1.Descriptor{}, errdefs.Newf(errdefs.ErrNotFound, "no manifest matched the platforms in index %s", desc.Digest)
+		}
+	}
+
+	exists, err := c.existsManifest(ctx, desc)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	if !exists || len(references) != len(parsed.References()) {
+		copied := make([]imgspecv1.Descriptor, len(references))
+		g, gctx := errgroup.WithContext(ctx)
+		for i, ref := range references {
+			g.Go(func() error {
+				result, err := c.copyNode(gctx, ref)
+				copied[i] = result
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+		if isIndex && !slices.EqualFunc(copied, index.Manifests(), equalDescriptor) {
+			if content, err = rewriteIndex(content, copied); err != nil {
+				return imgspecv1.Descriptor{}, fmt.Errorf("unable to rewrite index %s: %w", desc.Digest, err)
+			}
+			rewritten := ocispec.NewDescriptorFromBytes(desc.MediaType, content)
+			xlog.C(ctx).Debugf("rewrite index %s as %s with %d of %d manifests",
+				desc.Digest, rewritten.Digest, len(copied), len(index.Manifests()))
+			if c.options.Referrers {
+				// the referrers of the original index do not refer to the rewritten one
+				xlog.C(ctx).Warnf("skip referrers of index %s which is rewritten as %s", desc.Digest, rewritten.Digest)
+			}
+			return rewritten, c.pushManifest(ctx, rewritten, content, tags)
+		}
+	}
+
+	if exists && len(tags) == 0 {
+		xlog.C(ctx).Debugf("skip manifest %s which already exists", desc.Digest)
+	} else if err := c.pushManifest(ctx, desc, content, tags); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	if err := c.copyReferrers(ctx, desc); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// pushManifest pushes the manifest with the tags.
+func (c *copier) pushManifest(ctx context.Context, desc imgspecv1.Descriptor, content []byte, tags []string) error {
+	err := c.transfer(ctx, func() error {
+		r := cas.NewReaderFromBytes(desc.MediaType, content)
+		return c.dst.Registry().PushManifest(ctx, c.dst.Name().Path(), r, tags...)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to push manifest %s to %s: %w", desc.Digest, c.dst.Name(), err)
+	}
+	xlog.C(ctx).Debugf("pushed manifest %s to %s", desc.Digest, c.dst.Name())
+	return nil
+}
+
+// copyReferrers copies the manifests whose subject is desc.
+func (c *copier) copyReferrers(ctx context.Context, desc imgspecv1.Descriptor) error {
+	if !c.options.Referrers {
+		return nil
+	}
+	referrers, err := c.src.Registry().ListReferrers(ctx, c.src.Name().Path(), desc.Digest, "")
+	if err != nil {
+		// the registry may not support the referrers API or the fallback tag schema
+		xlog.C(ctx).Warnf("unable to list referrers of %s, skip: %s", desc.Digest, err)
+		return nil
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	for _, referrer := range referrers {
+		g.Go(func() error {
+			_, err := c.copyNode(gctx, referrer)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// copyBlob copies the blob if not exists, which is mounted first when possible.
+func (c *copier) copyBlob(ctx context.Context, desc imgspecv1.Descriptor) error {
+	return c.transfer(ctx, func() error {
+		exists, err := c.dst.Blobs().Exists(ctx, desc)
+		if err != nil {
+			return err
+		}
+		if exists {
+			xlog.C(ctx).Debugf("skip blob %s which already exists", desc.Digest)
+			return nil
+		}
+		if c.mount {
+			mounted, err := c.dst.Registry().MountBlob(ctx, c.dst.Name().Path(), c.src.Name().Path(), desc.Digest)
+			if err == nil && mounted {
+				xlog.C(ctx).Debugf("mounted blob %s from %s", desc.Digest, c.src.Name())
+				return nil
+			}
+			xlog.C(ctx).Debugf("unable to mount blob %s from %s, fallback to upload: %v", desc.Digest, c.src.Name(), err)
+		}
+		err = c.dst.Blobs().Push(ctx, func(ctx context.Context) (cas.ReadCloser, error) {
+			return c.src.Blobs().Fetch(ctx, desc)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to copy blob %s to %s: %w", desc.Digest, c.dst.Name(), err)
+		}
+		xlog.C(ctx).Debugf("copied blob %s to %s", desc.Digest, c.dst.Name())
+		return nil
+	})
+}
+
+// fetchManifest returns the descriptor and content of the manifest in the src
+// repository.
+func (c *copier) fetchManifest(ctx context.Context, reference string) (imgspecv1.Descriptor, []byte, error) {
+	var desc imgspecv1.Descriptor
+	var content []byte
+	err := c.transfer(ctx, func() error {
+		rc, err := c.src.Manifests().FetchTagOrDigest(ctx, reference)
+		if err != nil {
+			return err
+		}
+		defer xio.CloseAndSkipError(rc)
+		if content, err = io.ReadAll(rc); err != nil {
+			return err
+		}
+		desc = rc.Descriptor()
+		return nil
+	})
+	if err != nil {
+		return imgspecv1.Descriptor{}, nil, err
+	}
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		mediaType = ocispec.DetectMediaType(content)
+	}
+	return ocispec.NewDescriptorFromBytes(mediaType, content), content, nil
+}
+
+// existsManifest returns true if the manifest exists in the dst repository.
+func (c *copier) existsManifest(ctx context.Context, desc imgspecv1.Descriptor) (bool, error) {
+	var exists bool
+	err := c.transfer(ctx, func() (err error) {
+		exists, err = c.dst.Manifests().Exists(ctx, desc)
+		return err
+	})
+	return exists, err
+}
+
+// filterPlatforms returns the child manifests matched the platforms, and the
+// attestation manifests referring to the matched ones are kept.
+func (c *copier) filterPlatforms(descs []imgspecv1.Descriptor) []imgspecv1.Descriptor {
+	matcher := platforms.Any(c.options.Platforms...)
+	matched := map[digest.Digest]bool{}
+	for _, desc := range descs {
+		if desc.Platform != nil && matcher.Match(*desc.Platform) {
+			matched[desc.Digest] = true
+		}
+	}
+	filtered := []imgspecv1.Descriptor{}
+	for _, desc := range descs {
+		ref, ok := desc.Annotations[annotationDockerReferenceDigest]
+		if matched[desc.Digest] || (ok && matched[digest.Digest(ref)]) {
+			filtered = append(filtered, desc)
+		}
+	}
+	return filtered
+}
+
+// rewriteIndex replaces the "manifests" of the index content and keeps the other
+// fields as they are.
+func rewriteIndex(content []byte, descs []imgspecv1.Descriptor) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(descs)
+	if err != nil {
+		return nil, err
+	}
+	fields["manifests"] = raw
+	return json.Marshal(fields)
+}
+
+// equalDescriptor returns true if the descriptors refer to the same content.
+func equalDescriptor(a, b imgspecv1.Descriptor) bool {
+	return a.MediaType == b.MediaType && a.Digest == b.Digest && a.Size == b.Size
+}
@@ -0,0 +1,316 @@
+package remote_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/server"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+)
+
+// inflightCounter counts the requests in progress across the servers.
+type inflightCounter struct {
+	current atomic.Int32
+	max     atomic.Int32
+}
+
+func (c *inflightCounter) enter() {
+	n := c.current.Add(1)
+	for {
+		peak := c.max.Load()
+		if n <= peak || c.max.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (c *inflightCounter) leave() {
+	c.current.Add(-1)
+}
+
+// copyServer is the in-memory registry recording the requests received.
+type copyServer struct {
+	*memory.Spec
+	registry *remote.Registry
+	inflight *inflightCounter
+	// delay holds each request to make the concurrent ones overlap
+	delay time.Duration
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newCopyServer(t *testing.T, inflight *inflightCounter) *copyServer {
+	t.Helper()
+	s := &copyServer{Spec: memory.New(), inflight: inflight}
+	handler := server.NewHandler(s.Spec)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			s.mu.Lock()
+			s.requests = append(s.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+			s.mu.Unlock()
+		}
+		s.inflight.enter()
+		defer s.inflight.leave()
+		time.Sleep(s.delay)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	name, err := ocispecname.NewRegistry(srv.URL)
+	require.NoError(t, err)
+	s.registry, err = remote.NewClient().NewRegistry(context.Background(), name)
+	require.NoError(t, err)
+	return s
+}
+
+// count returns the number of the requests received with the method, and the
+// path containing the keyword.
+func (s *copyServer) count(method string, keyword string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, request := range s.requests {
+		if strings.HasPrefix(request, method+" ") && strings.Contains(request, keyword) {
+			n++
+		}
+	}
+	return n
+}
+
+// index returns the position of the first request received with the method and
+// the path containing the keyword, or -1 if not found.
+func (s *copyServer) index(method string, keyword string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, request := range s.requests {
+		if strings.HasPrefix(request, method+" ") && strings.Contains(request, keyword) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *copyServer) pushBlob(t *testing.T, repo string, content string) imgspecv1.Descriptor {
+	t.Helper()
+	desc := ocispec.NewDescriptorFromBytes(ocispec.MediaTypeImageLayerGzip, []byte(content))
+	err := s.PushBlob(context.Background(), repo, func(context.Context) (cas.ReadCloser, error) {
+		return cas.NewReadCloser(xio.NopReader(bytes.NewReader([]byte(content))), desc), nil
+	})
+	require.NoError(t, err)
+	return desc
+}
+
+func (s *copyServer) pushManifest(t *testing.T, repo string, mediaType string, v any, tags ...string) imgspecv1.Descriptor {
+	t.Helper()
+	content, err := json.Marshal(v)
+	require.NoError(t, err)
+	desc := ocispec.NewDescriptorFromBytes(mediaType, content)
+	require.NoError(t, s.PushManifest(context.Background(), repo, cas.NewReaderFromBytes(mediaType, content), tags...))
+	return desc
+}
+
+func (s *copyServer) fetchIndex(t *testing.T, repo string, reference string) imgspecv1.Index {
+	t.Helper()
+	rc, err := s.GetManifest(context.Background(), repo, reference)
+	require.NoError(t, err)
+	defer rc.Close()
+	index := imgspecv1.Index{}
+	require.NoError(t, json.NewDecoder(rc).Decode(&index))
+	return index
+}
+
+// multiArch is the multi-arch image index built by buildkit with the attestation
+// manifests, and a signature referring to the amd64 image manifest.
+type multiArch struct {
+	index       imgspecv1.Descriptor
+	amd64       imgspecv1.Descriptor
+	arm64       imgspecv1.Descriptor
+	attestation imgspecv1.Descriptor
+	signature   imgspecv1.Descriptor
+	shared      imgspecv1.Descriptor
+	blobs       []imgspecv1.Descriptor
+}
+
+func pushMultiArch(t *testing.T, s *copyServer, repo string) *multiArch {
+	t.Helper()
+	img := &multiArch{shared: s.pushBlob(t, repo, "shared layer")}
+	newManifest := func(config imgspecv1.Descriptor, layers ...imgspecv1.Descriptor) imgspecv1.Manifest {
+		mf := imgspecv1.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: layers}
+		mf.SchemaVersion = 2
+		return mf
+	}
+	pushImage := func(arch string) imgspecv1.Descriptor {
+		config := s.pushBlob(t, repo, `{"architecture":"`+arch+`","os":"linux"}`)
+		config.MediaType = ocispec.MediaTypeImageConfig
+		layer := s.pushBlob(t, repo, arch+" layer")
+		img.blobs = append(img.blobs, config, layer)
+		desc := s.pushManifest(t, repo, ocispec.MediaTypeImageManifest, newManifest(config, img.shared, layer))
+		desc.Platform = &imgspecv1.Platform{Architecture: arch, OS: "linux"}
+		return desc
+	}
+	img.amd64 = pushImage("amd64")
+	img.arm64 = pushImage("arm64")
+	img.blobs = append(img.blobs, img.shared)
+
+	provenance := s.pushBlob(t, repo, "provenance of arm64")
+	img.blobs = append(img.blobs, provenance)
+	img.attestation = s.pushManifest(t, repo, ocispec.MediaTypeImageManifest, newManifest(provenance))
+	img.attestation.Platform = &imgspecv1.Platform{Architecture: "unknown", OS: "unknown"}
+	img.attestation.Annotations = map[string]string{
+		"vnd.docker.reference.digest": img.arm64.Digest.String(),
+		"vnd.docker.reference.type":   "attestation-manifest",
+	}
+
+	index := imgspecv1.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{img.amd64, img.arm64, img.attestation},
+	}
+	index.SchemaVersion = 2
+	img.index = s.pushManifest(t, repo, ocispec.MediaTypeImageIndex, index, "v1")
+
+	signature := s.pushBlob(t, repo, "signature")
+	empty := s.pushBlob(t, repo, "{}")
+	empty.MediaType = ocispec.MediaTypeEmptyJSON
+	sig := newManifest(empty, signature)
+	sig.ArtifactType = "application/vnd.example.signature"
+	subject := img.amd64
+	subject.Platform = nil
+	sig.Subject = &subject
+	img.signature = s.pushManifest(t, repo, ocispec.MediaTypeImageManifest, sig)
+	return img
+}
+
+// copyRepositories returns the source repository holding the multi-arch image
+// and the empty target repository in another registry.
+func copyRepositories(t *testing.T) (*copyServer, *remote.Repository, *copyServer, *remote.Repository, *multiArch) {
+	t.Helper()
+	inflight := &inflightCounter{}
+	src := newCopyServer(t, inflight)
+	img := pushMultiArch(t, src, "library/src")
+	dst := newCopyServer(t, inflight)
+	return src, src.registry.Repository("library/src"), dst, dst.registry.Repository("library/dst"), img
+}
+
+func TestCopy_Index(t *testing.T) {
+	ctx := context.Background()
+	src, srcRepo, dst, dstRepo, img := copyRepositories(t)
+
+	desc, err := remote.Copy(ctx, srcRepo, "v1", dstRepo, remote.WithCopyTags("v1", "latest"))
+	require.NoError(t, err)
+	assert.Equal(t, img.index.Digest, desc.Digest)
+	assert.Equal(t, ocispec.MediaTypeImageIndex, desc.MediaType)
+
+	for _, tag := range []string{"v1", "latest"} {
+		got, err := dst.StatManifest(ctx, "library/dst", tag)
+		require.NoError(t, err)
+		assert.Equal(t, img.index.Digest, got.Digest)
+	}
+	for _, mf := range []imgspecv1.Descriptor{img.amd64, img.arm64, img.attestation} {
+		_, err := dst.StatManifest(ctx, "library/dst", mf.Digest.String())
+		require.NoError(t, err)
+	}
+	for _, blob := range img.blobs {
+		_, err := dst.StatBlob(ctx, "library/dst", blob.Digest)
+		require.NoError(t, err)
+	}
+	// the referrers are not copied by default
+	_, err = dst.StatManifest(ctx, "library/dst", img.signature.Digest.String())
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	t.Run("shared blobs copied once", func(t *testing.T) {
+		assert.Equal(t, 1, src.count(http.MethodGet, img.shared.Digest.String()))
+		assert.Equal(t, 1, dst.count(http.MethodHead, img.shared.Digest.String()))
+		assert.Equal(t, 1, dst.count(http.MethodPut, "digest="+strings.ReplaceAll(img.shared.Digest.String(), ":", "%3A")))
+	})
+
+	t.Run("existing contents skipped", func(t *testing.T) {
+		_, err := remote.Copy(ctx, srcRepo, img.index.Digest.String(), dstRepo)
+		require.NoError(t, err)
+		assert.Equal(t, 1, src.count(http.MethodGet, img.shared.Digest.String()))
+		assert.Equal(t, 1, dst.count(http.MethodPut, "/v2/library/dst/manifests/"+img.amd64.Digest.String()))
+	})
+}
+
+func TestCopy_Platforms(t *testing.T) {
+	ctx := context.Background()
+	_, srcRepo, dst, dstRepo, img := copyRepositories(t)
+
+	desc, err := remote.Copy(ctx, srcRepo, "v1", dstRepo, remote.WithCopyTags("v1"),
+		remote.WithCopyPlatforms(imgspecv1.Platform{Architecture: "arm64", OS: "linux"}))
+	require.NoError(t, err)
+	// the index is rewritten with the matched manifests only
+	assert.NotEqual(t, img.index.Digest, desc.Digest)
+	got, err := dst.StatManifest(ctx, "library/dst", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, desc.Digest, got.Digest)
+
+	index := dst.fetchIndex(t, "library/dst", "v1")
+	assert.Equal(t, ocispec.MediaTypeImageIndex, index.MediaType)
+	// the attestation manifest of the matched one is kept
+	assert.Equal(t, []imgspecv1.Descriptor{img.arm64, img.attestation}, index.Manifests)
+	_, err = dst.StatManifest(ctx, "library/dst", img.amd64.Digest.String())
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	_, err = dst.StatBlob(ctx, "library/dst", img.shared.Digest)
+	require.NoError(t, err)
+
+	t.Run("no manifest matched", func(t *testing.T) {
+		_, err := remote.Copy(ctx, srcRepo, "v1", dstRepo,
+			remote.WithCopyPlatforms(imgspecv1.Platform{Architecture: "s390x", OS: "linux"}))
+		require.ErrorIs(t, err, errdefs.ErrNotFound)
+	})
+}
+
+func TestCopy_Referrers(t *testing.T) {
+	ctx := context.Background()
+	_, srcRepo, dst, dstRepo, img := copyRepositories(t)
+
+	_, err := remote.Copy(ctx, srcRepo, "v1", dstRepo, remote.WithCopyReferrers(true))
+	require.NoError(t, err)
+	referrers, err := dst.ListReferrers(ctx, "library/dst", img.amd64.Digest, "")
+	require.NoError(t, err)
+	require.Len(t, referrers, 1)
+	assert.Equal(t, img.signature.Digest, referrers[0].Digest)
+
+	// the referrer is pushed after its subject
+	subject := dst.index(http.MethodPut, "/manifests/"+img.amd64.Digest.String())
+	referrer := dst.index(http.MethodPut, "/manifests/"+img.signature.Digest.String())
+	require.NotEqual(t, -1, subject)
+	require.NotEqual(t, -1, referrer)
+	assert.Less(t, subject, referrer)
+}
+
+func TestCopy_Concurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			ctx := context.Background()
+			src, srcRepo, dst, dstRepo, img := copyRepositories(t)
+			src.delay, dst.delay = 10*time.Millisecond, 10*time.Millisecond
+			src.inflight.max.Store(0)
+
+			desc, err := remote.Copy(ctx, srcRepo, "v1", dstRepo, remote.WithCopyConcurrency(concurrency))
+			require.NoError(t, err)
+			assert.Equal(t, img.index.Digest, desc.Digest)
+			assert.Equal(t, int32(concurrency), src.inflight.max.Load())
+		})
+	}
+}
@@ -159,7 +159,7 @@ func IsDirectRequest(ctx context.Context) bool {
 
 // DetectScheme sniffs the protocol of the target registry server is "http" or "https".
 func DetectScheme(ctx context.Context, client xhttp.Client, addr string) (string, error) {
-	ctx = WithDirectRequest(ctx)
+	ctx = WithoutRetry(WithDirectRequest(ctx))
 
 	host, scheme, err := xhttp.ParseHostScheme(addr)
 	if err != nil {
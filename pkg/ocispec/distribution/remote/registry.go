@@ -338,6 +338,7 @@ func (spec *Registry) PushBlobChunked(ctx context.Context, repo string, chunkSiz
 	return &blobWriter{
 		ctx:       ctx,
 		spec:      spec,
+		repo:      repo,
 		chunkSize: chunkSize,
 		chunk:     make([]byte, 0, chunkSize),
 		location:  location,
@@ -404,6 +405,7 @@ func (spec *Registry) PushBlobChunkedResume(ctx context.Context, repo string, ch
 	return &blobWriter{
 		ctx:       ctx,
 		spec:      spec,
+		repo:      repo,
 		chunkSize: chunkSize,
 		size:      offset,
 		flushed:   offset,
@@ -437,9 +439,12 @@ func (spec *Registry) doGetUploadStatusRequest(ctx context.Context, repo string,
 //
 // [distribution-spec]: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#mounting-a-blob-from-another-repository
 func (spec *Registry) MountBlob(ctx context.Context, repo string, from string, dgst digest.Digest) (bool, error) {
-	ctx = authn.AppendScopes(ctx, authn.RepositoryScope(repo, authn.ActionPull, authn.ActionPush))
+	ctx = authn.AppendScopes(ctx,
+		authn.RepositoryScope(repo, authn.ActionPull, authn.ActionPush),
+		authn.RepositoryScope(from, authn.ActionPull),
+	)
 	url := spec.endpoint(fmt.Sprintf("/v2/%s/blobs/uploads/?mount=%s&from=%s", repo, dgst, from))
-	request, err := http.NewRequestWithContext(ctx, http.MethodPut, url, http.NoBody)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, http.NoBody)
 	if err != nil {
 		return false, err
 	}
@@ -502,7 +507,8 @@ func (spec *Registry) completePushAfterPost(ctx context.Context, postRequest *ht
 	defer xio.CloseAndSkipError(rc)
 	expectDesc := rc.Descriptor()
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPut, url, rc)
+	// monolithic upload is not resumable, so never retry it.
+	request, err := http.NewRequestWithContext(WithoutRetry(ctx), http.MethodPut, url, rc)
 	if err != nil {
 		return err
 	}
@@ -616,25 +622,30 @@ func (spec *Registry) GetReferrers(ctx context.Context, repo string, dgst digest
 	}
 	defer xio.CloseAndSkipError(resp.Body)
 
-	err = xhttp.Success(resp, http.StatusNotFound)
-	if err == nil {
+	if resp.StatusCode != http.StatusNotFound {
+		if err := xhttp.Success(resp); err != nil {
+			return nil, err
+		}
 		return spec.extractReferrersDescriptors(resp, artifactType)
 	}
 
 	// fallback to pulling referrers tag schema manifest on 404
 	tag := fmt.Sprintf("%s-%s", dgst.Algorithm(), dgst.Encoded())
 	//nolint:bodyclose // closed by xio.CloseAndSkipError
-	fallbackResp, fallbackErr := spec.doGetManifestRequest(ctx, repo, tag)
-	if fallbackErr != nil {
-		return nil, errors.Join(err, fallbackErr)
+	fallbackResp, err := spec.doGetManifestRequest(ctx, repo, tag)
+	if err != nil {
+		return nil, err
 	}
 	defer xio.CloseAndSkipError(fallbackResp.Body)
 
-	fallbackErr = xhttp.Success(resp)
-	if fallbackErr == nil {
-		return spec.extractReferrersDescriptors(resp, artifactType)
+	if fallbackResp.StatusCode == http.StatusNotFound {
+		// no referrers to the manifest
+		return nil, nil
+	}
+	if err := xhttp.Success(fallbackResp); err != nil {
+		return nil, err
 	}
-	return nil, errors.Join(err, fallbackErr)
+	return spec.extractReferrersDescriptors(fallbackResp, artifactType)
 }
 
 func (spec *Registry) extractReferrersDescriptors(resp *http.Response, artifactType string) ([]imgspecv1.Descriptor, error) {
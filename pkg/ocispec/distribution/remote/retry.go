@@ -0,0 +1,269 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/benbjohnson/clock"
+
+	"github.com/wuxler/ruasec/pkg/util/xcontext"
+)
+
+const (
+	// DefaultRetryMaxAttempts is the default maximum number of attempts to send
+	// a request, including the first one.
+	DefaultRetryMaxAttempts = 5
+	// DefaultRetryMinBackoff is the default backoff before the first retry.
+	DefaultRetryMinBackoff = 500 * time.Millisecond
+	// DefaultRetryMaxBackoff is the default upper bound of the exponential backoff.
+	DefaultRetryMaxBackoff = 30 * time.Second
+	// DefaultRetryMaxWait is the default upper bound of the wait required by the
+	// server with "Retry-After" or "RateLimit-Reset" headers.
+	DefaultRetryMaxWait = 2 * time.Minute
+	// DefaultRetryJitter is the default fraction of the backoff to randomize.
+	DefaultRetryJitter = 0.2
+)
+
+// DefaultRetryPolicy returns the retry policy with default values.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: DefaultRetryMaxAttempts,
+		MinBackoff:  DefaultRetryMinBackoff,
+		MaxBackoff:  DefaultRetryMaxBackoff,
+		MaxWait:     DefaultRetryMaxWait,
+		Jitter:      DefaultRetryJitter,
+	}
+}
+
+// RetryPolicy defines how the client retries the requests failed with transient
+// errors, like "5xx" responses, connection resets and "429 Too Many Requests".
+//
+// Only the idempotent requests are retried on server errors and network errors,
+// while any request with a rewindable body is retried on "429 Too Many Requests"
+// as it is rejected before processed. Chunked blob uploads are never retried by
+// the client, but resumed by the blob writer from the offset stored in the
+// registry instead.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts to send a request, including
+	// the first one. Retry is disabled if less than 2.
+	MaxAttempts int
+
+	// MinBackoff is the backoff before the first retry, which is doubled for
+	// each of the following retries.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the upper bound of the exponential backoff.
+	MaxBackoff time.Duration
+
+	// MaxWait is the upper bound of the wait required by the server with
+	// "Retry-After" or "RateLimit-Reset" headers. The response is returned
+	// without retry when the server requires to wait longer.
+	MaxWait time.Duration
+
+	// Jitter is the fraction in [0, 1] of the backoff to randomize, which avoids
+	// the clients to retry at the same time.
+	Jitter float64
+
+	// Clock is used to wait between the attempts, default to the real clock.
+	Clock clock.Clock
+}
+
+// Backoff returns the duration to wait before the given retry attempt, which
+// starts with 1 for the first retry.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.MinBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if p.Jitter > 0 && backoff > 0 {
+		jitter := time.Duration(float64(backoff) * min(p.Jitter, 1) * rand.Float64()) //nolint:gosec // no need for crypto random
+		backoff -= jitter
+	}
+	return backoff
+}
+
+// retry returns the duration to wait and true if the request should be sent
+// again after the given attempt responded with resp or failed with err.
+func (p *RetryPolicy) retry(request *http.Request, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || !IsRetryableRequest(request.Context()) {
+		return 0, false
+	}
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return 0, false
+	}
+	if err != nil {
+		if !isIdempotent(request.Method) || !isRetryableError(err) {
+			return 0, false
+		}
+		return p.Backoff(attempt), true
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && !isIdempotent(request.Method) {
+		return 0, false
+	}
+	return p.retryResponse(attempt, resp)
+}
+
+// retryUpload returns the duration to wait and true if the chunked upload should
+// be resumed after the given attempt responded with resp or failed with err.
+func (p *RetryPolicy) retryUpload(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if resp != nil {
+		return p.retryResponse(attempt, resp)
+	}
+	if !isRetryableError(err) {
+		return 0, false
+	}
+	return p.Backoff(attempt), true
+}
+
+// retryResponse returns the duration to wait and true if the response is
+// retryable, both for the client and the blob writer.
+func (p *RetryPolicy) retryResponse(attempt int, resp *http.Response) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		wait, ok := retryAfter(resp, p.clock().Now())
+		if !ok {
+			return p.Backoff(attempt), true
+		}
+		if p.MaxWait > 0 && wait > p.MaxWait {
+			return 0, false
+		}
+		return wait, true
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return p.Backoff(attempt), true
+	}
+	return 0, false
+}
+
+// wait blocks for the duration or until the context is done.
+func (p *RetryPolicy) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return xcontext.NonBlockingCheck(ctx)
+	}
+	timer := p.clock().Timer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (p *RetryPolicy) clock() clock.Clock {
+	if p.Clock != nil {
+		return p.Clock
+	}
+	return clock.New()
+}
+
+type noRetry bool
+
+// WithoutRetry injects the signal to tell the http client to send the request
+// only once, regardless of the retry policy.
+func WithoutRetry(ctx context.Context) context.Context {
+	return xcontext.WithValue(ctx, noRetry(true))
+}
+
+// IsRetryableRequest checks whether the request is allowed to be retried by
+// the context of the request.
+func IsRetryableRequest(ctx context.Context) bool {
+	value, ok := xcontext.GetValue[noRetry](ctx)
+	if !ok {
+		return true
+	}
+	return !bool(value)
+}
+
+// retryAfter returns the duration to wait required by the server. "Retry-After"
+// is preferred, and "RateLimit-Reset" is used when the rate limit is exhausted.
+//
+// See https://www.rfc-editor.org/rfc/rfc9110#field.retry-after
+// and https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if value := strings.TrimSpace(resp.Header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	remaining, window, ok := rateLimitValue(resp.Header.Get("RateLimit-Remaining"))
+	if !ok || remaining > 0 {
+		return 0, false
+	}
+	if reset, _, ok := rateLimitValue(resp.Header.Get("RateLimit-Reset")); ok {
+		return time.Duration(reset) * time.Second, true
+	}
+	// the quota is exhausted without reset time, so wait for the whole window
+	// like "0;w=21600" returned by Docker Hub.
+	if window > 0 {
+		return time.Duration(window) * time.Second, true
+	}
+	return 0, false
+}
+
+// rateLimitValue parses the value and the window in seconds of "RateLimit-*"
+// headers, like "100;w=21600".
+func rateLimitValue(value string) (int64, int64, bool) {
+	value, params, _ := strings.Cut(value, ";")
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return 0, 0, false
+	}
+	var window int64
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if key == "w" {
+			window, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return n, window, true
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// rewindBody resets the body of the request to send it again.
+func rewindBody(request *http.Request) error {
+	if request.Body == nil || request.Body == http.NoBody || request.GetBody == nil {
+		return nil
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return err
+	}
+	request.Body = body
+	return nil
+}
@@ -0,0 +1,315 @@
+package remote_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/server"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+func init() {
+	ocispecname.RegisterScheme("http")
+}
+
+// requestRecorder records the time of the requests received by the server.
+type requestRecorder struct {
+	clock clock.Clock
+
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func (r *requestRecorder) record() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.times = append(r.times, r.clock.Now())
+	return len(r.times)
+}
+
+func (r *requestRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.times)
+}
+
+func newRetryClient(clk clock.Clock) *remote.Client {
+	client := remote.NewClient()
+	client.RetryPolicy = &remote.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Second,
+		MaxBackoff:  10 * time.Second,
+		MaxWait:     time.Minute,
+		Clock:       clk,
+	}
+	return client
+}
+
+// do sends the request and advances the mock clock until the response returned.
+func do(t *testing.T, client *remote.Client, mock *clock.Mock, method string, url string) *http.Response {
+	t.Helper()
+	request, err := http.NewRequestWithContext(context.Background(), method, url, http.NoBody)
+	require.NoError(t, err)
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := client.Do(request)
+		assert.NoError(t, err)
+		done <- resp
+	}()
+	for {
+		select {
+		case resp := <-done:
+			require.NotNil(t, resp)
+			t.Cleanup(func() { resp.Body.Close() })
+			return resp
+		case <-time.After(time.Millisecond):
+			mock.Add(100 * time.Millisecond)
+		}
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	testcases := []struct {
+		name       string
+		method     string
+		header     http.Header
+		statuses   []int
+		wantStatus int
+		wantWaits  []time.Duration
+	}{
+		{
+			name:       "retry GET with exponential backoff",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusBadGateway, http.StatusInternalServerError, http.StatusOK},
+			wantStatus: http.StatusOK,
+			wantWaits:  []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "give up after max attempts",
+			method:     http.MethodHead,
+			statuses:   []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			wantStatus: http.StatusBadGateway,
+			wantWaits:  []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "honor Retry-After",
+			method:     http.MethodGet,
+			header:     http.Header{"Retry-After": []string{"5"}},
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			wantStatus: http.StatusOK,
+			wantWaits:  []time.Duration{5 * time.Second},
+		},
+		{
+			name:       "honor RateLimit-Reset",
+			method:     http.MethodGet,
+			header:     http.Header{"Ratelimit-Remaining": []string{"0;w=60"}, "Ratelimit-Reset": []string{"7"}},
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			wantStatus: http.StatusOK,
+			wantWaits:  []time.Duration{7 * time.Second},
+		},
+		{
+			name:       "give up when rate limit window exceeds max wait",
+			method:     http.MethodGet,
+			header:     http.Header{"Ratelimit-Remaining": []string{"0;w=21600"}},
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "retry POST on too many requests",
+			method:     http.MethodPost,
+			statuses:   []int{http.StatusTooManyRequests, http.StatusAccepted},
+			wantStatus: http.StatusAccepted,
+			wantWaits:  []time.Duration{time.Second},
+		},
+		{
+			name:       "never retry POST on server errors",
+			method:     http.MethodPost,
+			statuses:   []int{http.StatusInternalServerError, http.StatusAccepted},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "never retry client errors",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusNotFound, http.StatusOK},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := clock.NewMock()
+			recorder := &requestRecorder{clock: mock}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				n := recorder.record()
+				for key, values := range tc.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(tc.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			resp := do(t, newRetryClient(mock), mock, tc.method, srv.URL+"/v2/")
+			assert.Equal(t, tc.wantStatus, resp.StatusCode)
+			require.Equal(t, len(tc.wantWaits)+1, recorder.count())
+			for i, want := range tc.wantWaits {
+				got := recorder.times[i+1].Sub(recorder.times[i])
+				assert.GreaterOrEqual(t, got, want)
+				assert.Less(t, got, want+time.Second)
+			}
+		})
+	}
+}
+
+func TestClient_RetryDisabled(t *testing.T) {
+	recorder := &requestRecorder{clock: clock.New()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		recorder.record()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := remote.NewClient()
+	for _, ctx := range []context.Context{
+		context.Background(),
+		remote.WithoutRetry(context.Background()),
+	} {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := client.Do(request)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		client = newRetryClient(clock.New())
+	}
+	assert.Equal(t, 2, recorder.count())
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := &remote.RetryPolicy{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2))
+	assert.Equal(t, 4*time.Second, policy.Backoff(3))
+	assert.Equal(t, 5*time.Second, policy.Backoff(4))
+	assert.Equal(t, 5*time.Second, policy.Backoff(100))
+
+	policy.Jitter = 0.5
+	for attempt := 1; attempt < 5; attempt++ {
+		backoff := policy.Backoff(attempt)
+		assert.LessOrEqual(t, backoff, min(time.Second<<(attempt-1), 5*time.Second))
+		assert.GreaterOrEqual(t, backoff, min(time.Second<<(attempt-1), 5*time.Second)/2)
+	}
+}
+
+func TestBlobWriter_Resume(t *testing.T) {
+	handler := server.NewHandler(memory.New())
+	var mu sync.Mutex
+	var patches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		patches++
+		n := patches
+		mu.Unlock()
+		switch n {
+		case 1:
+			// fail before the chunk is stored
+			w.WriteHeader(http.StatusBadGateway)
+		case 3:
+			// fail after the chunk is stored
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusGatewayTimeout)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	name, err := ocispecname.NewRegistry(srv.URL)
+	require.NoError(t, err)
+	client := remote.NewClient()
+	client.RetryPolicy = &remote.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	registry, err := client.NewRegistry(ctx, name)
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	writer, err := registry.PushBlobChunked(ctx, "test", 16)
+	require.NoError(t, err)
+	defer writer.Cancel()
+	for chunk := range slices.Chunk(content, 10) {
+		_, err = writer.Write(chunk)
+		require.NoError(t, err)
+	}
+	dgst := digest.FromBytes(content)
+	desc, err := writer.Commit(dgst)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), desc.Size)
+
+	rc, err := registry.GetBlob(ctx, "test", dgst)
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+	assert.Greater(t, patches, 3)
+}
+
+func TestBlobWriter_CommitResponseLost(t *testing.T) {
+	handler := server.NewHandler(memory.New())
+	var mu sync.Mutex
+	var puts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		puts++
+		mu.Unlock()
+		// fail after the blob is committed
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	name, err := ocispecname.NewRegistry(srv.URL)
+	require.NoError(t, err)
+	client := remote.NewClient()
+	client.RetryPolicy = &remote.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	registry, err := client.NewRegistry(ctx, name)
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	writer, err := registry.PushBlobChunked(ctx, "test", 16)
+	require.NoError(t, err)
+	defer writer.Cancel()
+	_, err = writer.Write(content)
+	require.NoError(t, err)
+	dgst := digest.FromBytes(content)
+	desc, err := writer.Commit(dgst)
+	require.NoError(t, err)
+	assert.Equal(t, dgst, desc.Digest)
+	assert.Equal(t, int64(len(content)), desc.Size)
+	assert.Equal(t, 1, puts)
+
+	stat, err := registry.StatBlob(ctx, "test", dgst)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), stat.Size)
+}
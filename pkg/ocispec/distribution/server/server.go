@@ -0,0 +1,622 @@
+// Package server provides a [http.Handler] serving the OCI distribution API over
+// a [distribution.Spec], so any backend can be exposed as a registry.
+//
+// More to see: https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	"github.com/wuxler/ruasec/pkg/util/xhttp"
+	"github.com/wuxler/ruasec/pkg/util/xio"
+	"github.com/wuxler/ruasec/pkg/xlog"
+)
+
+const (
+	// APIVersion is the value of the "Docker-Distribution-API-Version" header.
+	APIVersion = "registry/2.0"
+
+	// MaxManifestSize is the maximum size of the manifest accepted, which follows
+	// the limit of the most registries.
+	MaxManifestSize = 4 * 1024 * 1024 // 4 MiB
+
+	headerAPIVersion     = "Docker-Distribution-API-Version"
+	headerContentDigest  = "Docker-Content-Digest"
+	headerUploadUUID     = "Docker-Upload-UUID"
+	headerSubject        = "OCI-Subject"
+	headerFiltersApplied = "OCI-Filters-Applied"
+)
+
+// ErrorCode is the error code in the error response body of the distribution API.
+type ErrorCode string
+
+// The error codes defined by the distribution-spec.
+const (
+	ErrorCodeBlobUnknown         ErrorCode = "BLOB_UNKNOWN"
+	ErrorCodeBlobUploadInvalid   ErrorCode = "BLOB_UPLOAD_INVALID"
+	ErrorCodeBlobUploadUnknown   ErrorCode = "BLOB_UPLOAD_UNKNOWN"
+	ErrorCodeDigestInvalid       ErrorCode = "DIGEST_INVALID"
+	ErrorCodeManifestInvalid     ErrorCode = "MANIFEST_INVALID"
+	ErrorCodeManifestUnknown     ErrorCode = "MANIFEST_UNKNOWN"
+	ErrorCodeNameInvalid         ErrorCode = "NAME_INVALID"
+	ErrorCodeNameUnknown         ErrorCode = "NAME_UNKNOWN"
+	ErrorCodeSizeInvalid         ErrorCode = "SIZE_INVALID"
+	ErrorCodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	ErrorCodeUnsupported         ErrorCode = "UNSUPPORTED"
+	ErrorCodePaginationInvalid   ErrorCode = "PAGINATION_NUMBER_INVALID"
+	ErrorCodeRangeNotSatisfiable ErrorCode = "RANGE_NOT_SATISFIABLE"
+	ErrorCodeUnknown             ErrorCode = "UNKNOWN"
+)
+
+const nameExpr = `[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*)*`
+
+var (
+	manifestsRoute = regexp.MustCompile(`^/v2/(` + nameExpr + `)/manifests/([^/]+)$`)
+	tagsRoute      = regexp.MustCompile(`^/v2/(` + nameExpr + `)/tags/list$`)
+	referrersRoute = regexp.MustCompile(`^/v2/(` + nameExpr + `)/referrers/([^/]+)$`)
+	uploadsRoute   = regexp.MustCompile(`^/v2/(` + nameExpr + `)/blobs/uploads/([^/]*)$`)
+	blobsRoute     = regexp.MustCompile(`^/v2/(` + nameExpr + `)/blobs/([^/]+)$`)
+	tagRegexp      = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+)
+
+// NewHandler returns a [http.Handler] serving the distribution API at "/v2/"
+// backed by the distribution Spec.
+func NewHandler(spec distribution.Spec) http.Handler {
+	return &handler{spec: spec}
+}
+
+type handler struct {
+	spec distribution.Spec
+}
+
+// ServeHTTP routes the request to the endpoints of the distribution API.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	xlog.C(r.Context()).Debugf("%s %s", r.Method, r.URL)
+	w.Header().Set(headerAPIVersion, APIVersion)
+
+	path := r.URL.Path
+	if path == "/v2/" || path == "/v2" {
+		h.serveVersion(w, r)
+		return
+	}
+	if path == "/v2/_catalog" {
+		h.serveCatalog(w, r)
+		return
+	}
+	if m := manifestsRoute.FindStringSubmatch(path); m != nil {
+		h.serveManifest(w, r, m[1], m[2])
+		return
+	}
+	if m := tagsRoute.FindStringSubmatch(path); m != nil {
+		h.serveTags(w, r, m[1])
+		return
+	}
+	if m := referrersRoute.FindStringSubmatch(path); m != nil {
+		h.serveReferrers(w, r, m[1], m[2])
+		return
+	}
+	if m := uploadsRoute.FindStringSubmatch(path); m != nil {
+		h.serveUpload(w, r, m[1], m[2])
+		return
+	}
+	if m := blobsRoute.FindStringSubmatch(path); m != nil {
+		h.serveBlob(w, r, m[1], m[2])
+		return
+	}
+	writeError(w, r, http.StatusNotFound, ErrorCodeNameInvalid, fmt.Errorf("invalid endpoint %s", path))
+}
+
+func (h *handler) serveVersion(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+	if _, err := h.spec.GetVersion(r.Context()); err != nil {
+		writeSpecError(w, r, err, ErrorCodeUnknown, ErrorCodeUnknown)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (h *handler) serveCatalog(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	page, next, err := listPage(r, h.spec.ListRepositories)
+	if err != nil {
+		writeSpecError(w, r, err, ErrorCodeUnknown, ErrorCodePaginationInvalid)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, map[string][]string{"repositories": page})
+}
+
+func (h *handler) serveTags(w http.ResponseWriter, r *http.Request, repo string) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	page, next, err := listPage(r, func(opts ...distribution.ListOption) iter.Iterator[string] {
+		return h.spec.ListTags(repo, opts...)
+	})
+	if err != nil {
+		writeSpecError(w, r, err, ErrorCodeNameUnknown, ErrorCodePaginationInvalid)
+		return
+	}
+	setNextLink(w, r, next)
+	writeJSON(w, http.StatusOK, struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{Name: repo, Tags: page})
+}
+
+func (h *handler) serveReferrers(w http.ResponseWriter, r *http.Request, repo string, reference string) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	dgst, err := digest.Parse(reference)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeDigestInvalid, err)
+		return
+	}
+	artifactType := r.URL.Query().Get("artifactType")
+	referrers, err := h.spec.ListReferrers(r.Context(), repo, dgst, artifactType)
+	if err != nil {
+		writeSpecError(w, r, err, ErrorCodeNameUnknown, ErrorCodeDigestInvalid)
+		return
+	}
+	index := imgspecv1.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: referrers,
+	}
+	index.SchemaVersion = 2
+	if artifactType != "" {
+		w.Header().Set(headerFiltersApplied, "artifactType")
+	}
+	w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+	writeJSON(w, http.StatusOK, index)
+}
+
+func (h *handler) serveManifest(w http.ResponseWriter, r *http.Request, repo string, reference string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	if !isReference(reference) {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeManifestInvalid, fmt.Errorf("invalid reference %q", reference))
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		// the content is not opened if the client has it already
+		if r.Method == http.MethodHead || r.Header.Get("If-None-Match") != "" {
+			desc, err := h.spec.StatManifest(ctx, repo, reference)
+			if err != nil {
+				writeSpecError(w, r, err, ErrorCodeManifestUnknown, ErrorCodeManifestInvalid)
+				return
+			}
+			if r.Method == http.MethodHead || isNotModified(r, desc) {
+				writeContentHeaders(w, r, desc)
+				return
+			}
+		}
+		rc, err := h.spec.GetManifest(ctx, repo, reference)
+		if err != nil {
+			writeSpecError(w, r, err, ErrorCodeManifestUnknown, ErrorCodeManifestInvalid)
+			return
+		}
+		defer xio.CloseAndSkipError(rc)
+		if writeContentHeaders(w, r, rc.Descriptor()) {
+			copyBody(ctx, w, rc)
+		}
+	case http.MethodPut:
+		h.putManifest(w, r, repo, reference)
+	case http.MethodDelete:
+		if err := h.spec.DeleteManifest(ctx, repo, reference); err != nil {
+			writeSpecError(w, r, err, ErrorCodeManifestUnknown, ErrorCodeManifestInvalid)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (h *handler) putManifest(w http.ResponseWriter, r *http.Request, repo string, reference string) {
+	content, err := io.ReadAll(io.LimitReader(r.Body, MaxManifestSize+1))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeManifestInvalid, err)
+		return
+	}
+	if len(content) > MaxManifestSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, ErrorCodeSizeInvalid,
+			fmt.Errorf("manifest exceeds the limit of %d bytes", MaxManifestSize))
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType == ocispec.DefaultMediaType {
+		mediaType = ocispec.DetectMediaType(content)
+	}
+	desc := imgspecv1.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(content), Size: int64(len(content))}
+
+	var tags []string
+	if dgst, err := digest.Parse(reference); err == nil {
+		if got := dgst.Algorithm().FromBytes(content); got != dgst {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeDigestInvalid,
+				fmt.Errorf("digest mismatch (%s != %s)", got, dgst))
+			return
+		}
+		desc.Digest = dgst
+		// the tags can be set by the query parameters when pushed by digest
+		for _, tag := range r.URL.Query()["tag"] {
+			if !tagRegexp.MatchString(tag) {
+				writeError(w, r, http.StatusBadRequest, ErrorCodeManifestInvalid, fmt.Errorf("invalid tag %q", tag))
+				return
+			}
+			tags = append(tags, tag)
+		}
+	} else {
+		tags = append(tags, reference)
+	}
+
+	if err := h.spec.PushManifest(r.Context(), repo, cas.NewReader(bytes.NewReader(content), desc), tags...); err != nil {
+		writeSpecError(w, r, err, ErrorCodeNameUnknown, ErrorCodeManifestInvalid)
+		return
+	}
+	if subject, _, ok := distribution.ParseReferrer(desc, content); ok {
+		w.Header().Set(headerSubject, subject.String())
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repo, desc.Digest))
+	w.Header().Set(headerContentDigest, desc.Digest.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *handler) serveBlob(w http.ResponseWriter, r *http.Request, repo string, reference string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodDelete) {
+		return
+	}
+	dgst, err := digest.Parse(reference)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, ErrorCodeDigestInvalid, err)
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		// the content is not opened if the client has it already
+		if r.Method == http.MethodHead || r.Header.Get("If-None-Match") != "" {
+			desc, err := h.spec.StatBlob(ctx, repo, dgst)
+			if err != nil {
+				writeSpecError(w, r, err, ErrorCodeBlobUnknown, ErrorCodeDigestInvalid)
+				return
+			}
+			if r.Method == http.MethodHead || isNotModified(r, desc) {
+				writeContentHeaders(w, r, desc)
+				return
+			}
+		}
+		rc, err := h.spec.GetBlob(ctx, repo, dgst)
+		if err != nil {
+			writeSpecError(w, r, err, ErrorCodeBlobUnknown, ErrorCodeDigestInvalid)
+			return
+		}
+		defer xio.CloseAndSkipError(rc)
+		if writeContentHeaders(w, r, rc.Descriptor()) {
+			copyBody(ctx, w, rc)
+		}
+	case http.MethodDelete:
+		if err := h.spec.DeleteBlob(ctx, repo, dgst); err != nil {
+			writeSpecError(w, r, err, ErrorCodeBlobUnknown, ErrorCodeDigestInvalid)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (h *handler) serveUpload(w http.ResponseWriter, r *http.Request, repo string, id string) {
+	if id == "" {
+		if allowMethods(w, r, http.MethodPost) {
+			h.startUpload(w, r, repo)
+		}
+		return
+	}
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch, http.MethodPut, http.MethodDelete) {
+		return
+	}
+
+	// the offset is checked with the start of "Content-Range" when uploading in chunks
+	offset := int64(-1)
+	if contentRange := r.Header.Get("Content-Range"); contentRange != "" && r.Method == http.MethodPatch {
+		start, _, ok := xhttp.ParseRange(contentRange)
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeBlobUploadInvalid, fmt.Errorf("invalid range %q", contentRange))
+			return
+		}
+		offset = start
+	}
+	writer, err := h.spec.PushBlobChunkedResume(r.Context(), repo, 0, id, offset)
+	if err != nil {
+		if offset >= 0 && errors.Is(err, errdefs.ErrInvalidParameter) {
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, ErrorCodeRangeNotSatisfiable, err)
+			return
+		}
+		writeSpecError(w, r, err, ErrorCodeBlobUploadUnknown, ErrorCodeBlobUploadInvalid)
+		return
+	}
+	defer xio.CloseAndSkipError(writer)
+
+	switch r.Method {
+	case http.MethodGet:
+		setUploadHeaders(w, repo, writer)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		if _, err := io.Copy(writer, r.Body); err != nil {
+			writeSpecError(w, r, err, ErrorCodeBlobUploadUnknown, ErrorCodeBlobUploadInvalid)
+			return
+		}
+		if err := writer.Close(); err != nil {
+			writeSpecError(w, r, err, ErrorCodeBlobUploadUnknown, ErrorCodeBlobUploadInvalid)
+			return
+		}
+		setUploadHeaders(w, repo, writer)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		dgst, err := digest.Parse(r.URL.Query().Get("digest"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeDigestInvalid, err)
+			return
+		}
+		if _, err := io.Copy(writer, r.Body); err != nil {
+			writeSpecError(w, r, err, ErrorCodeBlobUploadUnknown, ErrorCodeBlobUploadInvalid)
+			return
+		}
+		if _, err := writer.Commit(dgst); err != nil {
+			writeSpecError(w, r, err, ErrorCodeBlobUploadUnknown, ErrorCodeDigestInvalid)
+			return
+		}
+		setBlobCreatedHeaders(w, repo, dgst)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if err := writer.Cancel(); err != nil {
+			writeSpecError(w, r, err, ErrorCodeBlobUploadUnknown, ErrorCodeBlobUploadInvalid)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// startUpload mounts the blob from another repository, pushes the blob monolithically
+// with the digest in query, or starts a session to upload in chunks.
+func (h *handler) startUpload(w http.ResponseWriter, r *http.Request, repo string) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	if mount := query.Get("mount"); mount != "" {
+		dgst, err := digest.Parse(mount)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeDigestInvalid, err)
+			return
+		}
+		if from := query.Get("from"); from != "" {
+			mounted, err := h.spec.MountBlob(ctx, repo, from, dgst)
+			if err != nil {
+				writeSpecError(w, r, err, ErrorCodeBlobUnknown, ErrorCodeNameInvalid)
+				return
+			}
+			if mounted {
+				setBlobCreatedHeaders(w, repo, dgst)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		// fallback to start an upload session as the spec specified
+	} else if raw := query.Get("digest"); raw != "" {
+		dgst, err := digest.Parse(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorCodeDigestInvalid, err)
+			return
+		}
+		desc := imgspecv1.Descriptor{MediaType: ocispec.DefaultMediaType, Digest: dgst, Size: r.ContentLength}
+		err = h.spec.PushBlob(ctx, repo, func(context.Context) (cas.ReadCloser, error) {
+			// the content is verified by the spec implementation with the digest
+			return cas.NewReadCloserSkipVerify(r.Body, desc), nil
+		})
+		if err != nil {
+			writeSpecError(w, r, err, ErrorCodeNameUnknown, ErrorCodeDigestInvalid)
+			return
+		}
+		setBlobCreatedHeaders(w, repo, dgst)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	writer, err := h.spec.PushBlobChunked(ctx, repo, 0)
+	if err != nil {
+		writeSpecError(w, r, err, ErrorCodeNameUnknown, ErrorCodeNameInvalid)
+		return
+	}
+	defer xio.CloseAndSkipError(writer)
+	setUploadHeaders(w, repo, writer)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// listPage returns the first page of the list with the "n" and "last" query
+// parameters, and the "last" of the next page if the page is full.
+func listPage(r *http.Request, list func(opts ...distribution.ListOption) iter.Iterator[string]) ([]string, string, error) {
+	query := r.URL.Query()
+	opts := []distribution.ListOption{distribution.WithOffset(query.Get("last"))}
+	n := 0
+	if raw := query.Get("n"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return nil, "", errdefs.Newf(errdefs.ErrInvalidParameter, "invalid pagination number %q", raw)
+		}
+		n = parsed
+		if n == 0 {
+			return []string{}, "", nil
+		}
+		opts = append(opts, distribution.WithPageSize(n))
+	}
+	page, err := list(opts...).Next(r.Context())
+	if err != nil {
+		if errors.Is(err, iter.ErrIteratorDone) {
+			return []string{}, "", nil
+		}
+		return nil, "", err
+	}
+	if n > 0 && len(page) == n {
+		return page, page[len(page)-1], nil
+	}
+	return page, "", nil
+}
+
+// setNextLink sets the "Link" header to the next page with the same "n".
+func setNextLink(w http.ResponseWriter, r *http.Request, last string) {
+	if last == "" {
+		return
+	}
+	query := url.Values{}
+	query.Set("n", r.URL.Query().Get("n"))
+	query.Set("last", last)
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+}
+
+// writeContentHeaders writes the headers of the content with the digest as the
+// "ETag", and returns true if the body should be written. It responds "304 Not
+// Modified" if the "If-None-Match" header matches the digest.
+func writeContentHeaders(w http.ResponseWriter, r *http.Request, desc imgspecv1.Descriptor) bool {
+	w.Header().Set(headerContentDigest, desc.Digest.String())
+	w.Header().Set("ETag", etag(desc.Digest))
+	if isNotModified(r, desc) {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	return r.Method != http.MethodHead
+}
+
+// isNotModified returns true if any of the entity tags in the "If-None-Match"
+// header matches the digest of the content.
+func isNotModified(r *http.Request, desc imgspecv1.Descriptor) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	expected := etag(desc.Digest)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func etag(dgst digest.Digest) string {
+	return `"` + dgst.String() + `"`
+}
+
+func setUploadHeaders(w http.ResponseWriter, repo string, writer distribution.BlobWriteCloser) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, writer.ID()))
+	w.Header().Set("Range", xhttp.RangeString(0, writer.Size()))
+	w.Header().Set(headerUploadUUID, writer.ID())
+	w.Header().Set("Content-Length", "0")
+}
+
+func setBlobCreatedHeaders(w http.ResponseWriter, repo string, dgst digest.Digest) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, dgst))
+	w.Header().Set(headerContentDigest, dgst.String())
+	w.Header().Set("Content-Length", "0")
+}
+
+// allowMethods writes the "405 Method Not Allowed" response if the request method
+// is not allowed, and returns false.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	writeError(w, r, http.StatusMethodNotAllowed, ErrorCodeUnsupported, fmt.Errorf("method %s is not allowed", r.Method))
+	return false
+}
+
+// isReference returns true if the reference is a valid tag or digest.
+func isReference(reference string) bool {
+	if _, err := digest.Parse(reference); err == nil {
+		return true
+	}
+	return tagRegexp.MatchString(reference)
+}
+
+// copyBody writes the content to the response, the error is logged only since the
+// status has been sent.
+func copyBody(ctx context.Context, w io.Writer, r io.Reader) {
+	if _, err := io.Copy(w, r); err != nil {
+		xlog.C(ctx).Warnf("failed to write response body: %s", err)
+	}
+}
+
+// writeSpecError writes the error returned by the spec with the status inferred
+// from the error type, and the code of not found or invalid parameter is specified.
+func writeSpecError(w http.ResponseWriter, r *http.Request, err error, notFound ErrorCode, invalid ErrorCode) {
+	switch {
+	case errors.Is(err, errdefs.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, notFound, err)
+	case errors.Is(err, errdefs.ErrInvalidParameter):
+		writeError(w, r, http.StatusBadRequest, invalid, err)
+	case errors.Is(err, errdefs.ErrUnsupported):
+		writeError(w, r, http.StatusMethodNotAllowed, ErrorCodeUnsupported, err)
+	case errors.Is(err, errdefs.ErrUnauthorized):
+		writeError(w, r, http.StatusUnauthorized, ErrorCodeUnauthorized, err)
+	default:
+		xlog.C(r.Context()).Warnf("%s %s failed: %s", r.Method, r.URL, err)
+		writeError(w, r, http.StatusInternalServerError, ErrorCodeUnknown, err)
+	}
+}
+
+// writeError writes the error response formatted as the distribution-spec.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, err error) {
+	type errorInfo struct {
+		Code    ErrorCode `json:"code"`
+		Message string    `json:"message"`
+	}
+	body := struct {
+		Errors []errorInfo `json:"errors"`
+	}{Errors: []errorInfo{{Code: code, Message: err.Error()}}}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	_, _ = w.Write(content)
+}
@@ -0,0 +1,210 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/ocilayout"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/server"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+func init() {
+	ocispecname.RegisterScheme("http")
+}
+
+func newRemote(t *testing.T, spec distribution.Spec) *remote.Registry {
+	t.Helper()
+	srv := httptest.NewServer(server.NewHandler(spec))
+	t.Cleanup(srv.Close)
+	name, err := ocispecname.NewRegistry(srv.URL)
+	require.NoError(t, err)
+	reg, err := remote.NewClient().NewRegistry(context.Background(), name)
+	require.NoError(t, err)
+	return reg
+}
+
+func getter(content []byte) cas.ReadCloserGetter {
+	desc := ocispec.NewDescriptorFromBytes(ocispec.DefaultMediaType, content)
+	return func(context.Context) (cas.ReadCloser, error) {
+		return cas.NewReadCloser(io.NopCloser(bytes.NewReader(content)), desc), nil
+	}
+}
+
+func readAll(t *testing.T, rc cas.ReadCloser) []byte {
+	t.Helper()
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return content
+}
+
+func listAll(t *testing.T, it iter.Iterator[string]) []string {
+	t.Helper()
+	all := []string{}
+	for {
+		page, err := it.Next(context.Background())
+		if err != nil {
+			require.ErrorIs(t, err, iter.ErrIteratorDone)
+			return all
+		}
+		all = append(all, page...)
+	}
+}
+
+func TestHandler_Remote(t *testing.T) {
+	layoutSpec, err := ocilayout.New(t.TempDir())
+	require.NoError(t, err)
+	backends := map[string]distribution.Spec{
+		"memory":    memory.New(),
+		"ocilayout": layoutSpec,
+	}
+	for name, spec := range backends {
+		t.Run(name, func(t *testing.T) {
+			testRemote(t, newRemote(t, spec))
+		})
+	}
+}
+
+func testRemote(t *testing.T, reg *remote.Registry) {
+	ctx := context.Background()
+	require.NoError(t, reg.Ping(ctx))
+
+	// monolithic and chunked uploads
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	configDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageConfig, config)
+	require.NoError(t, reg.PushBlob(ctx, "library/alpine", getter(config)))
+	layer := bytes.Repeat([]byte("layer"), 1000)
+	layerDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageLayer, layer)
+	w, err := reg.PushBlobChunked(ctx, "library/alpine", 1024)
+	require.NoError(t, err)
+	_, err = w.Write(layer)
+	require.NoError(t, err)
+	_, err = w.Commit(layerDesc.Digest)
+	require.NoError(t, err)
+	rc, err := reg.GetBlob(ctx, "library/alpine", layerDesc.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, layer, readAll(t, rc))
+
+	_, err = reg.StatBlob(ctx, "library/alpine", digest.FromString("unknown"))
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	// manifests with tags
+	mf := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"config":` + descriptorJSON(configDesc) + `,"layers":[` + descriptorJSON(layerDesc) + `]}`)
+	mfDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, mf)
+	require.NoError(t, reg.PushManifest(ctx, "library/alpine", cas.NewReaderFromBytes(mfDesc.MediaType, mf), "3.20", "latest"))
+	got, err := reg.StatManifest(ctx, "library/alpine", "latest")
+	require.NoError(t, err)
+	assert.Equal(t, mfDesc, got)
+	rc, err = reg.GetManifest(ctx, "library/alpine", mfDesc.Digest.String())
+	require.NoError(t, err)
+	assert.Equal(t, mf, readAll(t, rc))
+
+	// cross repository mount
+	mounted, err := reg.MountBlob(ctx, "mirror/alpine", "library/alpine", layerDesc.Digest)
+	require.NoError(t, err)
+	assert.True(t, mounted)
+	_, err = reg.StatBlob(ctx, "mirror/alpine", layerDesc.Digest)
+	require.NoError(t, err)
+
+	// referrers
+	sbom := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"artifactType":"application/spdx+json","config":` + descriptorJSON(configDesc) +
+		`,"layers":[],"subject":` + descriptorJSON(mfDesc) + `}`)
+	sbomDesc := ocispec.NewDescriptorFromBytes(imgspecv1.MediaTypeImageManifest, sbom)
+	require.NoError(t, reg.PushManifest(ctx, "library/alpine", cas.NewReaderFromBytes(sbomDesc.MediaType, sbom)))
+	referrers, err := reg.ListReferrers(ctx, "library/alpine", mfDesc.Digest, "application/spdx+json")
+	require.NoError(t, err)
+	require.Len(t, referrers, 1)
+	assert.Equal(t, sbomDesc.Digest, referrers[0].Digest)
+
+	// list with pagination
+	assert.Equal(t, []string{"3.20", "latest"}, listAll(t, reg.ListTags("library/alpine", distribution.WithPageSize(1))))
+	assert.Equal(t, []string{"library/alpine", "mirror/alpine"}, listAll(t, reg.ListRepositories()))
+
+	// deletions
+	require.NoError(t, reg.DeleteManifest(ctx, "library/alpine", "latest"))
+	assert.Equal(t, []string{"3.20"}, listAll(t, reg.ListTags("library/alpine")))
+	require.NoError(t, reg.DeleteManifest(ctx, "library/alpine", mfDesc.Digest.String()))
+	_, err = reg.StatManifest(ctx, "library/alpine", "3.20")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.NoError(t, reg.DeleteBlob(ctx, "mirror/alpine", layerDesc.Digest))
+	_, err = reg.StatBlob(ctx, "mirror/alpine", layerDesc.Digest)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func descriptorJSON(desc imgspecv1.Descriptor) string {
+	content, _ := json.Marshal(desc)
+	return string(content)
+}
+
+func TestHandler_Errors(t *testing.T) {
+	srv := httptest.NewServer(server.NewHandler(memory.New()))
+	t.Cleanup(srv.Close)
+
+	do := func(method string, path string, header http.Header) *http.Response {
+		t.Helper()
+		request, err := http.NewRequestWithContext(context.Background(), method, srv.URL+path, http.NoBody)
+		require.NoError(t, err)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		resp, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	errorCode := func(resp *http.Response) server.ErrorCode {
+		t.Helper()
+		body := struct {
+			Errors []struct {
+				Code server.ErrorCode `json:"code"`
+			} `json:"errors"`
+		}{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		require.Len(t, body.Errors, 1)
+		return body.Errors[0].Code
+	}
+
+	resp := do(http.MethodGet, "/v2/library/alpine/manifests/latest", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, server.ErrorCodeManifestUnknown, errorCode(resp))
+
+	resp = do(http.MethodGet, "/v2/library/alpine/blobs/sha256:invalid", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, server.ErrorCodeDigestInvalid, errorCode(resp))
+
+	resp = do(http.MethodPost, "/v2/library/alpine/manifests/latest", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, server.ErrorCodeUnsupported, errorCode(resp))
+
+	resp = do(http.MethodPost, "/v2/library/alpine/blobs/uploads/", nil)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	location := resp.Header.Get("Location")
+	assert.Equal(t, "0-0", resp.Header.Get("Range"))
+	resp = do(http.MethodPatch, location, http.Header{"Content-Range": {"10-19"}})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	resp = do(http.MethodDelete, location, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do(http.MethodGet, location, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, server.ErrorCodeBlobUploadUnknown, errorCode(resp))
+}
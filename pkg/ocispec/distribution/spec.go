@@ -3,6 +3,7 @@
 import (
 	"context"
 	"io"
+	"slices"
 
 	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -170,3 +171,39 @@ func MakeListOptions(opts ...ListOption) *ListOptions {
 	}
 	return &options
 }
+
+// NewListIterator returns an iterator over the items loaded on the first call,
+// which are sorted and paged by the list options. It is used by the registries
+// which hold all the names locally.
+func NewListIterator(load func(ctx context.Context) ([]string, error), opts ...ListOption) iter.Iterator[string] {
+	options := MakeListOptions(opts...)
+	var items []string
+	loaded := false
+	return iter.IteratorFunc[string](func(ctx context.Context) ([]string, error) {
+		if !loaded {
+			all, err := load(ctx)
+			if err != nil {
+				return nil, err
+			}
+			slices.Sort(all)
+			if options.Offset != "" {
+				i, found := slices.BinarySearch(all, options.Offset)
+				if found {
+					i++
+				}
+				all = all[i:]
+			}
+			items, loaded = all, true
+		}
+		if len(items) == 0 {
+			return nil, iter.ErrIteratorDone
+		}
+		n := len(items)
+		if options.PageSize > 0 && options.PageSize < n {
+			n = options.PageSize
+		}
+		page := items[:n:n]
+		items = items[n:]
+		return page, nil
+	})
+}
@@ -129,6 +129,13 @@ type LayerMetadata struct {
 	// IsCompressed indicates whether the current size is compressed.
 	IsCompressed bool `json:"is_compressed,omitempty" yaml:"is_compressed,omitempty"`
 
+	// CompressedDigest is the digest of the compressed layer blob. It may be empty when
+	// the layer is stored uncompressed locally and the blob pulled from is unknown.
+	CompressedDigest digest.Digest `json:"compressed_digest,omitempty" yaml:"compressed_digest,omitempty"`
+
+	// SourceRepositories lists the repositories the compressed layer blob is pulled from.
+	SourceRepositories []string `json:"source_repositories,omitempty" yaml:"source_repositories,omitempty"`
+
 	// CompressedSize represents the compressed size of the current layer.
 	CompressedSize int64 `json:"compressed_size,omitempty" yaml:"compressed_size,omitempty"`
 
@@ -249,6 +249,13 @@ func IsCompressedBlob(mt string) bool {
 	}
 )
 
+// ConvertToOCILayerMediaType returns the OCI image layer media type equivalent to
+// the given layer media type, and false if it is not an image layer media type.
+func ConvertToOCILayerMediaType(mediaType string) (string, bool) {
+	converted, ok := ociImageLayerMap[mediaType]
+	return converted, ok
+}
+
 // CompressionFormatFromMediaType returns the compression format from the media type.
 func CompressionFormatFromMediaType(mediaType string) (compression.Format, error) {
 	converted := ociImageLayerMap[mediaType]
@@ -0,0 +1,12 @@
+package registry
+
+import (
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/memory"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+// NewMemory returns an empty [Registry] with the given name which stores all the
+// contents in memory, it is mainly used in tests.
+func NewMemory(name ocispecname.Registry) Registry {
+	return New(name, memory.New())
+}
@@ -0,0 +1,75 @@
+package registry_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/ocispec/cas"
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+	"github.com/wuxler/ruasec/pkg/registry"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	name, err := ocispecname.NewRegistry("registry.example.com")
+	require.NoError(t, err)
+	reg := registry.NewMemory(name)
+	require.NoError(t, reg.Ping(ctx))
+	assert.Equal(t, name, reg.Name())
+
+	repo, err := reg.Repository("library/alpine")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/library/alpine", repo.Name().String())
+	assert.Equal(t, reg, repo.Registry())
+
+	content := []byte(`{"schemaVersion":2}`)
+	target := cas.NewReaderFromBytes(imgspecv1.MediaTypeImageManifest, content)
+	require.NoError(t, repo.Tags().Tag(ctx, target, "latest"))
+
+	desc, err := repo.Manifests().StatTagOrDigest(ctx, "latest")
+	require.NoError(t, err)
+	assert.Equal(t, target.Descriptor().Digest, desc.Digest)
+	exists, err := repo.Manifests().Exists(ctx, desc)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	rc, err := repo.Manifests().FetchTagOrDigest(ctx, desc.Digest.String())
+	require.NoError(t, err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	exists, err = repo.Blobs().Exists(ctx, desc)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, repo.Tags().Untag(ctx, "latest"))
+	_, err = repo.Manifests().StatTagOrDigest(ctx, "latest")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	other, err := reg.Repository("library/busybox")
+	require.NoError(t, err)
+	require.NoError(t, other.Tags().Tag(ctx, cas.NewReaderFromBytes(imgspecv1.MediaTypeImageManifest, content), "v1"))
+
+	it := reg.ListRepositories(distribution.WithPageSize(1))
+	names := []string{}
+	for {
+		page, err := it.Next(ctx)
+		if err != nil {
+			require.ErrorIs(t, err, iter.ErrIteratorDone)
+			break
+		}
+		require.Len(t, page, 1)
+		names = append(names, page[0].Name().Path())
+	}
+	assert.Equal(t, []string{"library/alpine", "library/busybox"}, names)
+}
@@ -0,0 +1,18 @@
+package registry
+
+import (
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution/remote"
+)
+
+// NewRemote returns a [Registry] backed by the remote registry.
+func NewRemote(r *remote.Registry) Registry {
+	return New(r.Name(), r)
+}
+
+// NewRemoteRepository returns a [Repository] backed by the remote repository.
+func NewRemoteRepository(r *remote.Repository) Repository {
+	return &specRepository{
+		registry: &specRegistry{name: r.Registry().Name(), spec: r.Registry()},
+		name:     r.Name(),
+	}
+}
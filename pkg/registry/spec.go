@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"context"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/wuxler/ruasec/pkg/ocispec/distribution"
+	"github.com/wuxler/ruasec/pkg/ocispec/iter"
+	ocispecname "github.com/wuxler/ruasec/pkg/ocispec/name"
+)
+
+var (
+	_ Registry      = (*specRegistry)(nil)
+	_ Repository    = (*specRepository)(nil)
+	_ ManifestStore = (*specManifestStore)(nil)
+	_ BlobStore     = (distribution.BlobStore)(nil)
+	_ TagStore      = (distribution.TagStore)(nil)
+)
+
+// New returns a [Registry] with the given name backed by the distribution Spec,
+// so the registries with different backends can be used in the same way.
+func New(name ocispecname.Registry, spec distribution.Spec) Registry {
+	return &specRegistry{name: name, spec: spec}
+}
+
+type specRegistry struct {
+	name ocispecname.Registry
+	spec distribution.Spec
+}
+
+// Name returns the name of the registry.
+func (r *specRegistry) Name() ocispecname.Registry {
+	return r.name
+}
+
+// Ping checks registry is accessible.
+func (r *specRegistry) Ping(ctx context.Context) error {
+	_, err := r.spec.GetVersion(ctx)
+	return err
+}
+
+// Repository returns the [Repository] by the given path which is the repository name.
+func (r *specRegistry) Repository(path string) (Repository, error) {
+	name, err := ocispecname.WithPath(r.name, path)
+	if err != nil {
+		return nil, err
+	}
+	return &specRepository{registry: r, name: name}, nil
+}
+
+// ListRepositories lists the repositories.
+func (r *specRegistry) ListRepositories(options ...distribution.ListOption) iter.Iterator[Repository] {
+	paths := r.spec.ListRepositories(options...)
+	return iter.IteratorFunc[Repository](func(ctx context.Context) ([]Repository, error) {
+		page, err := paths.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		repos := make([]Repository, 0, len(page))
+		for _, path := range page {
+			repo, err := r.Repository(path)
+			if err != nil {
+				return nil, err
+			}
+			repos = append(repos, repo)
+		}
+		return repos, nil
+	})
+}
+
+type specRepository struct {
+	registry *specRegistry
+	name     ocispecname.Repository
+}
+
+// Name returns the name of the repository.
+func (r *specRepository) Name() ocispecname.Repository {
+	return r.name
+}
+
+// Registry returns the registry of the repository.
+func (r *specRepository) Registry() Registry {
+	return r.registry
+}
+
+// Manifests returns a reference to this repository's manifest storage.
+func (r *specRepository) Manifests() ManifestStore {
+	return &specManifestStore{
+		ManifestStore: distribution.NewManifestStore(r.registry.spec, r.name.Path()),
+		spec:          r.registry.spec,
+		repo:          r.name.Path(),
+	}
+}
+
+// Tags returns a reference to this repository's tag storage.
+func (r *specRepository) Tags() TagStore {
+	return distribution.NewTagStore(r.registry.spec, r.name.Path())
+}
+
+// Blobs returns a reference to this repository's blob storage.
+func (r *specRepository) Blobs() BlobStore {
+	return distribution.NewBlobStore(r.registry.spec, r.name.Path())
+}
+
+// specManifestStore extends the [distribution.ManifestStore] to stat by tag or digest.
+type specManifestStore struct {
+	distribution.ManifestStore
+	spec distribution.Spec
+	repo string
+}
+
+// StatTagOrDigest returns the descriptor for the given tag or digest.
+func (s *specManifestStore) StatTagOrDigest(ctx context.Context, tagOrDigest string) (imgspecv1.Descriptor, error) {
+	return s.spec.StatManifest(ctx, s.repo, tagOrDigest)
+}
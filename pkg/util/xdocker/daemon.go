@@ -0,0 +1,121 @@
+package xdocker
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/wuxler/ruasec/pkg/util/homedir"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+const (
+	// DefaultDaemonConfigFile is the default path of the docker daemon config file.
+	DefaultDaemonConfigFile = "/etc/docker/daemon.json"
+)
+
+// DaemonConfig is the subset of the docker daemon config file "daemon.json"
+// related to the storage and the registries.
+//
+// More to see: https://docs.docker.com/reference/cli/dockerd/#daemon-configuration-file
+type DaemonConfig struct {
+	// DataRoot is the root directory of the persistent docker state.
+	DataRoot string `json:"data-root,omitempty"`
+	// StorageDriver is the storage driver to use.
+	StorageDriver string `json:"storage-driver,omitempty"`
+	// StorageOpts is the storage driver options.
+	StorageOpts []string `json:"storage-opts,omitempty"`
+	// RegistryMirrors are the mirrors of Docker Hub, like "https://mirror.gcr.io".
+	RegistryMirrors []string `json:"registry-mirrors,omitempty"`
+	// InsecureRegistries are the registries allowed to access over plain HTTP.
+	InsecureRegistries []string `json:"insecure-registries,omitempty"`
+
+	// defaultDataRoot is the data root used by the daemon when not configured,
+	// which depends on the config file loaded from.
+	defaultDataRoot string
+}
+
+// GetDataRoot returns the data root configured, or the default one of the
+// daemon owning the config file when not configured.
+func (c *DaemonConfig) GetDataRoot() string {
+	if c.DataRoot != "" {
+		return c.DataRoot
+	}
+	if c.defaultDataRoot != "" {
+		return c.defaultDataRoot
+	}
+	if os.Geteuid() == 0 {
+		return DefaultDataRoot
+	}
+	return RootlessDataRoot()
+}
+
+// DaemonConfigFile returns the docker daemon config file of the current user,
+// which is "/etc/docker/daemon.json" for root user. For rootless, it is
+// "$XDG_CONFIG_HOME/docker/daemon.json" falls back to "~/.config/docker/daemon.json"
+// if exists, otherwise the one of the rootful daemon is returned.
+func DaemonConfigFile() string {
+	return daemonConfigFile(os.Geteuid(), RootlessDaemonConfigFile())
+}
+
+func daemonConfigFile(euid int, rootless string) string {
+	if euid == 0 || rootless == "" {
+		return DefaultDaemonConfigFile
+	}
+	if exists, err := xos.Exists(rootless); err != nil || !exists {
+		return DefaultDaemonConfigFile
+	}
+	return rootless
+}
+
+// DaemonConfigFiles returns the docker daemon config files which may configure
+// the data roots accessible to the current user, the rootless one goes first.
+func DaemonConfigFiles() []string {
+	if rootless := RootlessDaemonConfigFile(); os.Geteuid() != 0 && rootless != "" {
+		return []string{rootless, DefaultDaemonConfigFile}
+	}
+	return []string{DefaultDaemonConfigFile}
+}
+
+// RootlessDaemonConfigFile returns the docker daemon config file of the rootless
+// daemon, which is "$XDG_CONFIG_HOME/docker/daemon.json" and falls back to
+// "~/.config/docker/daemon.json".
+func RootlessDaemonConfigFile() string {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "docker", "daemon.json")
+	}
+	home, err := homedir.Get()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "docker", "daemon.json")
+}
+
+// LoadDaemonConfig loads the docker daemon config file from the path. An empty
+// config is returned when the file does not exist.
+func LoadDaemonConfig(path string) (*DaemonConfig, error) {
+	config := &DaemonConfig{defaultDataRoot: defaultDataRootOf(os.Geteuid(), path)}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// defaultDataRootOf returns the data root used by the daemon owning the config
+// file when not configured, which is the rootless one only for the rootless
+// config file of non-root user.
+func defaultDataRootOf(euid int, path string) string {
+	rootless := RootlessDaemonConfigFile()
+	if euid != 0 && rootless != "" && filepath.Clean(path) == filepath.Clean(rootless) {
+		return RootlessDataRoot()
+	}
+	return DefaultDataRoot
+}
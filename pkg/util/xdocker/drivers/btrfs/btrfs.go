@@ -0,0 +1,21 @@
+// Package btrfs provides the btrfs storage driver, which stores each layer as a btrfs
+// subvolume snapshotted from the parent in the directory
+// {RootDir}/btrfs/subvolumes/{cacheid}.
+package btrfs
+
+import (
+	"context"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers/naivediff"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+)
+
+func init() {
+	drivers.MustRegisterCreator(drivers.TypeBtrfs, drivers.CreatorFunc(New))
+}
+
+// New creates a new btrfs driver
+func New(ctx context.Context, dataRoot pathspec.DataRoot, options []string) (drivers.Driver, error) {
+	return naivediff.NewDriver(dataRoot, drivers.TypeBtrfs, "subvolumes"), nil
+}
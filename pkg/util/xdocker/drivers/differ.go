@@ -7,3 +7,11 @@ type Differ interface {
 	// Diff returns a diff filesystem getter for the target with the given cache id.
 	Diff(cacheid string) (xfs.Getter, error)
 }
+
+// ParentDiffer is a driver that stores the full snapshot of each layer, and the
+// diff of the layer can only be calculated by comparing with its parent.
+type ParentDiffer interface {
+	// DiffWithParent returns a diff filesystem getter for the target with the given
+	// cache id against the parent cache id. The parent is empty for the base layer.
+	DiffWithParent(cacheid string, parent string) (xfs.Getter, error)
+}
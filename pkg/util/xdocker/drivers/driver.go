@@ -3,8 +3,10 @@
 import (
 	"context"
 	"os"
+	"path/filepath"
 
 	"github.com/wuxler/ruasec/pkg/errdefs"
+	"github.com/wuxler/ruasec/pkg/util/xdocker"
 	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
 	"github.com/wuxler/ruasec/pkg/util/xos"
 	"github.com/wuxler/ruasec/pkg/xlog"
@@ -40,7 +42,34 @@ func New(ctx context.Context, path string, typ Type, config DriverConfig) (Drive
 
 // DetectType detects the driver type from the runtime environment.
 func DetectType(ctx context.Context, path string) Type {
-	// TODO: from /etc/docker/daemon.json
+	typ, _ := DetectDriver(ctx, path)
+	return typ
+}
+
+// DetectDriver detects the driver type and config of the docker data root path
+// from the runtime environment, the sources in order of precedence are:
+//  1. the environment variable $DOCKER_DRIVER
+//  2. the "storage-driver" of the docker daemon config file, only when the path
+//     is the data root of it, the rootless and rootful ones are looked up in order
+//  3. the driver directories found in the data root
+//
+// The "storage-opts" of the docker daemon config file are used as the driver
+// options when the detected driver type is the same as the configured one.
+func DetectDriver(ctx context.Context, path string) (Type, DriverConfig) {
+	return detectDriver(ctx, xdocker.DaemonConfigFiles(), path)
+}
+
+func detectDriver(ctx context.Context, daemonConfigFiles []string, path string) (Type, DriverConfig) {
+	config := DriverConfig{}
+	daemonConfig := loadDaemonConfigOfDataRoot(ctx, daemonConfigFiles, path)
+	typ := detectType(ctx, path, daemonConfig)
+	if typ != "" && daemonConfig != nil && daemonConfig.StorageDriver == typ.String() {
+		config.Options = daemonConfig.StorageOpts
+	}
+	return typ, config
+}
+
+func detectType(ctx context.Context, path string, daemonConfig *xdocker.DaemonConfig) Type {
 	// from environment variable
 	typ := Type(os.Getenv("DOCKER_DRIVER"))
 	if typ != "" {
@@ -48,6 +77,16 @@ func DetectType(ctx context.Context, path string) Type {
 			xlog.C(ctx).Debugf("discovered driver from the env $DOCKER_DRIVER: %s", typ)
 			return typ
 		}
+		xlog.C(ctx).Warnf("skip, unsupported driver from the env $DOCKER_DRIVER: %s", typ)
+	}
+	// from docker daemon config file
+	if daemonConfig != nil && daemonConfig.StorageDriver != "" {
+		typ := Type(daemonConfig.StorageDriver)
+		if _, ok := GetCreator(typ); ok {
+			xlog.C(ctx).Debugf("discovered driver from the docker daemon config file: %s", typ)
+			return typ
+		}
+		xlog.C(ctx).Warnf("skip, unsupported driver from the docker daemon config file: %s", typ)
 	}
 	// from docker data root lookup
 	if !xos.IsEmptyDir(path) {
@@ -58,3 +97,21 @@ func DetectType(ctx context.Context, path string) Type {
 	}
 	return ""
 }
+
+// loadDaemonConfigOfDataRoot returns the first docker daemon config loaded from
+// the files whose data root is the path, or nil if not found.
+func loadDaemonConfigOfDataRoot(ctx context.Context, files []string, path string) *xdocker.DaemonConfig {
+	for _, file := range files {
+		config, err := xdocker.LoadDaemonConfig(file)
+		if err != nil {
+			xlog.C(ctx).Warnf("skip, unable to load docker daemon config file %s: %s", file, err)
+			continue
+		}
+		if filepath.Clean(config.GetDataRoot()) != filepath.Clean(path) {
+			xlog.C(ctx).Debugf("skip docker daemon config file %s, the data root %s is not %s", file, config.GetDataRoot(), path)
+			continue
+		}
+		return config
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package drivers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+)
+
+func init() {
+	fake := CreatorFunc(func(_ context.Context, _ pathspec.DataRoot, _ []string) (Driver, error) {
+		return nil, nil
+	})
+	MustRegisterCreator(TypeOverlay2, fake)
+	MustRegisterCreator(TypeVfs, fake)
+}
+
+func TestDetectDriver(t *testing.T) {
+	dataRoot := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dataRoot, "overlay2", "l"), 0o700))
+	otherRoot := t.TempDir()
+
+	testcases := []struct {
+		name        string
+		env         string
+		daemon      string
+		rootful     string
+		wantType    Type
+		wantOptions []string
+	}{
+		{
+			name:     "discovered from data root",
+			wantType: TypeOverlay2,
+		},
+		{
+			name:        "daemon config of the data root",
+			daemon:      `{"data-root": "` + dataRoot + `", "storage-driver": "vfs", "storage-opts": ["size=10G"]}`,
+			wantType:    TypeVfs,
+			wantOptions: []string{"size=10G"},
+		},
+		{
+			name:        "daemon config of the data root with trailing slash",
+			daemon:      `{"data-root": "` + dataRoot + `/", "storage-driver": "vfs", "storage-opts": ["size=10G"]}`,
+			wantType:    TypeVfs,
+			wantOptions: []string{"size=10G"},
+		},
+		{
+			name:     "daemon config of another data root",
+			daemon:   `{"data-root": "` + otherRoot + `", "storage-driver": "vfs", "storage-opts": ["size=10G"]}`,
+			wantType: TypeOverlay2,
+		},
+		{
+			name:        "rootful daemon config of the data root",
+			rootful:     `{"data-root": "` + dataRoot + `", "storage-driver": "vfs", "storage-opts": ["size=10G"]}`,
+			wantType:    TypeVfs,
+			wantOptions: []string{"size=10G"},
+		},
+		{
+			name:        "rootful daemon config after another data root",
+			daemon:      `{"data-root": "` + otherRoot + `", "storage-driver": "overlay2"}`,
+			rootful:     `{"data-root": "` + dataRoot + `", "storage-driver": "vfs", "storage-opts": ["size=10G"]}`,
+			wantType:    TypeVfs,
+			wantOptions: []string{"size=10G"},
+		},
+		{
+			name:        "rootless daemon config goes first",
+			daemon:      `{"data-root": "` + dataRoot + `", "storage-driver": "vfs", "storage-opts": ["size=10G"]}`,
+			rootful:     `{"data-root": "` + dataRoot + `", "storage-driver": "overlay2"}`,
+			wantType:    TypeVfs,
+			wantOptions: []string{"size=10G"},
+		},
+		{
+			name:     "unsupported driver in daemon config",
+			daemon:   `{"data-root": "` + dataRoot + `", "storage-driver": "devicemapper", "storage-opts": ["dm.basesize=10G"]}`,
+			wantType: TypeOverlay2,
+		},
+		{
+			name:     "invalid daemon config",
+			daemon:   `{"data-root": [`,
+			wantType: TypeOverlay2,
+		},
+		{
+			name:     "env takes precedence",
+			env:      "vfs",
+			daemon:   `{"data-root": "` + dataRoot + `", "storage-driver": "overlay2", "storage-opts": ["overlay2.size=10G"]}`,
+			wantType: TypeVfs,
+		},
+		{
+			name:        "unsupported env",
+			env:         "devicemapper",
+			daemon:      `{"data-root": "` + dataRoot + `", "storage-driver": "vfs", "storage-opts": ["size=10G"]}`,
+			wantType:    TypeVfs,
+			wantOptions: []string{"size=10G"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("DOCKER_DRIVER", tc.env)
+			daemonConfigFile := filepath.Join(t.TempDir(), "daemon.json")
+			if tc.daemon != "" {
+				require.NoError(t, os.WriteFile(daemonConfigFile, []byte(tc.daemon), 0o600))
+			}
+			rootfulConfigFile := filepath.Join(t.TempDir(), "daemon.json")
+			if tc.rootful != "" {
+				require.NoError(t, os.WriteFile(rootfulConfigFile, []byte(tc.rootful), 0o600))
+			}
+			typ, config := detectDriver(context.Background(), []string{daemonConfigFile, rootfulConfigFile}, dataRoot)
+			assert.Equal(t, tc.wantType, typ)
+			assert.Equal(t, tc.wantOptions, config.Options)
+		})
+	}
+
+	t.Run("empty data root", func(t *testing.T) {
+		t.Setenv("DOCKER_DRIVER", "")
+		typ, _ := detectDriver(context.Background(), []string{filepath.Join(t.TempDir(), "daemon.json")}, otherRoot)
+		assert.Equal(t, Type(""), typ)
+	})
+}
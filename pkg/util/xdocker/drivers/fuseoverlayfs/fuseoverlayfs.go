@@ -0,0 +1,26 @@
+// Package fuseoverlayfs provides the fuse-overlayfs storage driver used by the
+// rootless docker, which shares the same directory layout as overlay2 in
+// {RootDir}/fuse-overlayfs.
+//
+// The whiteouts are stored as the ".wh." prefixed files when the character
+// devices can not be created, and the opaque directories are marked with the
+// "user.fuseoverlayfs.opaque" extended attribute, both of them are handled by
+// the overlay2 diff filesystem.
+package fuseoverlayfs
+
+import (
+	"context"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers/overlay2"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+)
+
+func init() {
+	drivers.MustRegisterCreator(drivers.TypeFuseOverlayfs, drivers.CreatorFunc(New))
+}
+
+// New creates a new fuse-overlayfs driver
+func New(ctx context.Context, dataRoot pathspec.DataRoot, options []string) (drivers.Driver, error) {
+	return overlay2.NewDriver(dataRoot, drivers.TypeFuseOverlayfs), nil
+}
@@ -0,0 +1,327 @@
+package naivediff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	stdpath "path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/wuxler/ruasec/pkg/util/xcontext"
+	"github.com/wuxler/ruasec/pkg/util/xfile"
+	"github.com/wuxler/ruasec/pkg/util/xfs"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+var (
+	_ fs.StatFS      = (*diffFS)(nil)
+	_ fs.ReadDirFS   = (*diffFS)(nil)
+	_ fs.ReadDirFile = (*entry)(nil)
+	_ xfs.XattrFS    = (*diffFS)(nil)
+	_ xfs.ReadLinkFS = (*diffFS)(nil)
+)
+
+// NewDiffFS returns a [fs.FS] holding only the changes of the snapshot directory
+// dir compared with the parent snapshot directory parentDir, and the removed
+// files are presented as the ".wh." prefixed whiteout files. All files in dir are
+// treated as added when parentDir is empty.
+//
+// The same as the docker "NaiveDiffDriver", a file is changed when its mode,
+// owner, device number, size, modification time or link target differs, and the
+// directories are only included as the ancestors of the changed files unless
+// their mode or owner differs.
+func NewDiffFS(ctx context.Context, dir string, parentDir string) (fs.FS, error) {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, xfs.NewPathError("diff", dir, xfs.ErrIsNotDir)
+	}
+	fsys := &diffFS{
+		base:   dir,
+		real:   os.DirFS(dir),
+		inodes: map[string]*inode{".": {DirEntry: fs.FileInfoToDirEntry(info)}},
+	}
+	if err := fsys.collectChanges(ctx, parentDir); err != nil {
+		return nil, err
+	}
+	if parentDir != "" {
+		if err := fsys.collectDeletions(ctx, parentDir); err != nil {
+			return nil, err
+		}
+	}
+	for _, node := range fsys.inodes {
+		slices.SortFunc(node.childrens, func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+	return fsys, nil
+}
+
+type inode struct {
+	fs.DirEntry
+	childrens []fs.DirEntry
+	whiteout  bool
+}
+
+// diffFS is a file system holding the changed files of the snapshot directory.
+type diffFS struct {
+	base   string
+	real   fs.FS
+	inodes map[string]*inode
+}
+
+func (fsys *diffFS) collectChanges(ctx context.Context, parentDir string) error {
+	return filepath.WalkDir(fsys.base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := xcontext.NonBlockingCheck(ctx, path); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(fsys.base, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if parentDir != "" {
+			parentPath := filepath.Join(parentDir, rel)
+			parentInfo, err := os.Lstat(parentPath)
+			switch {
+			case err == nil:
+				if !isChanged(parentPath, parentInfo, path, info) {
+					return nil
+				}
+			case !isNotExist(err):
+				return err
+			}
+		}
+		return fsys.add(filepath.ToSlash(rel), &inode{DirEntry: fs.FileInfoToDirEntry(info)})
+	})
+}
+
+func (fsys *diffFS) collectDeletions(ctx context.Context, parentDir string) error {
+	return filepath.WalkDir(parentDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := xcontext.NonBlockingCheck(ctx, path); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(parentDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := os.Lstat(filepath.Join(fsys.base, rel))
+		if err == nil {
+			// the children of the replaced directory are removed implicitly
+			if d.IsDir() && !info.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !isNotExist(err) {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		whiteout := stdpath.Join(stdpath.Dir(name), xfile.WhiteoutPrefix+stdpath.Base(name))
+		fi := xfs.NewFakeFileInfo(whiteout).WithMode(0o644) //nolint:mnd // defult permission mode mask
+		if err := fsys.add(whiteout, &inode{DirEntry: fs.FileInfoToDirEntry(fi), whiteout: true}); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+// add adds the node with the name and all its ancestor directories.
+func (fsys *diffFS) add(name string, node *inode) error {
+	if existing, ok := fsys.inodes[name]; ok {
+		// the directory may be added as an ancestor before
+		existing.DirEntry = node.DirEntry
+		return nil
+	}
+	fsys.inodes[name] = node
+	dir := stdpath.Dir(name)
+	if parent, ok := fsys.inodes[dir]; ok {
+		parent.childrens = append(parent.childrens, node)
+		return nil
+	}
+	info, err := os.Lstat(filepath.Join(fsys.base, filepath.FromSlash(dir)))
+	if err != nil {
+		return err
+	}
+	parent := &inode{DirEntry: fs.FileInfoToDirEntry(info)}
+	if err := fsys.add(dir, parent); err != nil {
+		return err
+	}
+	parent.childrens = append(parent.childrens, node)
+	return nil
+}
+
+func (fsys *diffFS) get(op, name string) (*inode, error) {
+	if !fs.ValidPath(name) {
+		return nil, xfs.NewPathError(op, name, fs.ErrInvalid)
+	}
+	node, ok := fsys.inodes[stdpath.Clean(name)]
+	if !ok {
+		return nil, xfs.NewPathError(op, name, fs.ErrNotExist)
+	}
+	return node, nil
+}
+
+// Open opens the named file.
+// Implements the [fs.FS] interface.
+func (fsys *diffFS) Open(name string) (fs.File, error) {
+	node, err := fsys.get("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() || node.whiteout {
+		return &entry{inode: node}, nil
+	}
+	return fsys.real.Open(name)
+}
+
+// ReadDir reads the named directory and returns a list of directory entries sorted by filename.
+// Implements the [fs.ReadDirFS] interface.
+func (fsys *diffFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := fsys.get("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.IsDir() {
+		return nil, xfs.NewPathError("readdir", name, xfs.ErrIsNotDir)
+	}
+	return slices.Clone(node.childrens), nil
+}
+
+// Stat returns a [fs.FileInfo] describing the file.
+// Implements the [fs.StatFS] interface.
+func (fsys *diffFS) Stat(name string) (fs.FileInfo, error) {
+	node, err := fsys.get("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node.Info()
+}
+
+// Xattrs returns the extended attributes of the named file.
+// Implements the [xfs.XattrFS] interface.
+func (fsys *diffFS) Xattrs(name string) (map[string]string, error) {
+	node, err := fsys.get("xattrs", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.whiteout {
+		return nil, nil
+	}
+	return xos.Lxattrs(filepath.Join(fsys.base, filepath.FromSlash(name)))
+}
+
+// ReadLink returns the destination of the named symbolic link.
+// Implements the [xfs.ReadLinkFS] interface.
+func (fsys *diffFS) ReadLink(name string) (string, error) {
+	if _, err := fsys.get("readlink", name); err != nil {
+		return "", err
+	}
+	return os.Readlink(filepath.Join(fsys.base, filepath.FromSlash(name)))
+}
+
+// entry is the opened directory or whiteout file, which has no content.
+type entry struct {
+	*inode
+	readdirOffset int
+	closed        bool
+}
+
+func (ent *entry) Stat() (fs.FileInfo, error) {
+	if ent.closed {
+		return nil, xfs.NewPathError("stat", ent.Name(), fs.ErrClosed)
+	}
+	return ent.inode.Info()
+}
+
+func (ent *entry) Read(_ []byte) (int, error) {
+	if ent.closed {
+		return 0, xfs.NewPathError("read", ent.Name(), fs.ErrClosed)
+	}
+	if ent.IsDir() {
+		return 0, xfs.NewPathError("read", ent.Name(), xfs.ErrIsDir)
+	}
+	return 0, io.EOF
+}
+
+func (ent *entry) Close() error {
+	if ent.closed {
+		return xfs.NewPathError("close", ent.Name(), fs.ErrClosed)
+	}
+	ent.closed = true
+	return nil
+}
+
+func (ent *entry) ReadDir(n int) ([]fs.DirEntry, error) {
+	if ent.closed {
+		return nil, xfs.NewPathError("readdir", ent.Name(), fs.ErrClosed)
+	}
+	if !ent.IsDir() {
+		return nil, xfs.NewPathError("readdir", ent.Name(), xfs.ErrIsNotDir)
+	}
+
+	if ent.readdirOffset >= len(ent.childrens) {
+		if n <= 0 {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+
+	last := ent.readdirOffset + n
+	if n <= 0 || last > len(ent.childrens) {
+		last = len(ent.childrens)
+	}
+
+	entries := slices.Clone(ent.childrens[ent.readdirOffset:last])
+	ent.readdirOffset += len(entries)
+	return entries, nil
+}
+
+// isChanged returns true if the file is changed compared with the old one.
+func isChanged(oldPath string, oldInfo fs.FileInfo, newPath string, newInfo fs.FileInfo) bool {
+	if oldInfo.Mode() != newInfo.Mode() {
+		return true
+	}
+	oldStat, ok1 := oldInfo.Sys().(*syscall.Stat_t)
+	newStat, ok2 := newInfo.Sys().(*syscall.Stat_t)
+	if ok1 && ok2 && (oldStat.Uid != newStat.Uid || oldStat.Gid != newStat.Gid || oldStat.Rdev != newStat.Rdev) {
+		return true
+	}
+	if newInfo.IsDir() {
+		return false
+	}
+	if oldInfo.Size() != newInfo.Size() || !oldInfo.ModTime().Equal(newInfo.ModTime()) {
+		return true
+	}
+	if newInfo.Mode()&fs.ModeSymlink != 0 {
+		oldLink, err1 := os.Readlink(oldPath)
+		newLink, err2 := os.Readlink(newPath)
+		return err1 != nil || err2 != nil || oldLink != newLink
+	}
+	return false
+}
+
+// isNotExist returns true if the error indicates the path or one of its parents
+// does not exist.
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
+}
@@ -0,0 +1,104 @@
+package naivediff
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDiffFS(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	mkfiles(t, parent, map[string]string{
+		"etc/hosts":       "127.0.0.1 localhost",
+		"etc/passwd":      "root:x:0:0",
+		"usr/bin/foo":     "foo",
+		"usr/lib/a/b":     "b",
+		"var/log/removed": "removed",
+		"opt/file":        "file",
+	})
+
+	t.Run("Base", func(t *testing.T) {
+		fsys, err := NewDiffFS(ctx, parent, "")
+		require.NoError(t, err)
+		assert.NoError(t, fstest.TestFS(fsys,
+			"etc/hosts", "etc/passwd", "usr/bin/foo", "usr/lib/a/b", "var/log/removed", "opt/file"))
+	})
+
+	t.Run("Changes", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.CopyFS(dir, os.DirFS(parent)))
+		copyModTimes(t, parent, dir)
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "etc/hosts"), []byte("changed"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "usr/bin/bar"), []byte("bar"), 0o644))
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, "usr/lib/a")))
+		require.NoError(t, os.Remove(filepath.Join(dir, "var/log/removed")))
+		require.NoError(t, os.RemoveAll(filepath.Join(dir, "opt")))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "opt"), []byte("opt"), 0o644))
+
+		fsys, err := NewDiffFS(ctx, dir, parent)
+		require.NoError(t, err)
+		want := []string{
+			"etc/hosts",
+			"opt",
+			"usr/bin/bar",
+			"usr/lib/.wh.a",
+			"var/log/.wh.removed",
+		}
+		assert.NoError(t, fstest.TestFS(fsys, want...))
+
+		var got []string
+		err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				got = append(got, path)
+			}
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		content, err := fs.ReadFile(fsys, "usr/lib/.wh.a")
+		require.NoError(t, err)
+		assert.Empty(t, content)
+	})
+}
+
+func mkfiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func copyModTimes(t *testing.T, src string, dst string) {
+	t.Helper()
+	err := filepath.WalkDir(src, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		mtime := info.ModTime().Truncate(time.Second)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			return err
+		}
+		return os.Chtimes(filepath.Join(dst, rel), mtime, mtime)
+	})
+	require.NoError(t, err)
+}
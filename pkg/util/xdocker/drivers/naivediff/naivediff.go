@@ -0,0 +1,75 @@
+// Package naivediff provides the storage driver base for the snapshot drivers
+// like vfs, btrfs and zfs, which store the full snapshot of each layer in a
+// directory and calculate the layer diff by comparing with the parent one.
+package naivediff
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+	"github.com/wuxler/ruasec/pkg/util/xfs"
+	"github.com/wuxler/ruasec/pkg/util/xos"
+)
+
+var (
+	_ drivers.Driver       = (*Driver)(nil)
+	_ drivers.ParentDiffer = (*Driver)(nil)
+)
+
+// NewDriver returns a new naive diff driver with the type, which stores the layer
+// snapshots in the directory {RootDir}/{Driver}/{subdir}/{cacheid}.
+func NewDriver(dataRoot pathspec.DataRoot, typ drivers.Type, subdir string) *Driver {
+	return &Driver{
+		DriverRoot: dataRoot.DriverRoot(typ.String()),
+		typ:        typ,
+		subdir:     subdir,
+	}
+}
+
+// Driver is a storage driver base calculating the layer diff by comparing the
+// layer snapshot directory with the parent one.
+type Driver struct {
+	pathspec.DriverRoot
+	typ    drivers.Type
+	subdir string
+}
+
+// Type returns the type of the driver
+func (d *Driver) Type() drivers.Type {
+	return d.typ
+}
+
+// SnapshotDir returns the path to the directory {RootDir}/{Driver}/{subdir}/{cacheid}.
+func (d *Driver) SnapshotDir(cacheid string) string {
+	return filepath.Join(d.Path(), d.subdir, cacheid)
+}
+
+// Accessible returns true when the target exists and accessible with the given cache id.
+// If the target does not exist, it returns false with nil error.
+// If the target is not accessible, it returns false with an error.
+func (d *Driver) Accessible(cacheid string) (bool, error) {
+	return xos.Exists(d.SnapshotDir(cacheid))
+}
+
+// GetMetadata returns the metadata of the target with the given cache id.
+func (d *Driver) GetMetadata(cacheid string) (map[string]string, error) {
+	return map[string]string{
+		"Dir": d.SnapshotDir(cacheid),
+	}, nil
+}
+
+// DiffWithParent returns a differ for the target with the given cache id against
+// the parent cache id.
+func (d *Driver) DiffWithParent(cacheid string, parent string) (xfs.Getter, error) {
+	dir := d.SnapshotDir(cacheid)
+	parentDir := ""
+	if parent != "" {
+		parentDir = d.SnapshotDir(parent)
+	}
+	return xfs.GetterFunc(func(ctx context.Context) (fs.FS, error) {
+		return NewDiffFS(ctx, dir, parentDir)
+	}), nil
+}
@@ -2,10 +2,11 @@
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 
@@ -27,8 +28,28 @@
 	committedFileName = "committed"
 )
 
+// opaqueXattrs are the extended attributes marking an overlay directory as an
+// opaque whiteout. The "user." ones are set by the rootless overlay mounted with
+// the "userxattr" option and the fuse-overlayfs, which can not write the
+// "trusted." namespace.
+var opaqueXattrs = []string{
+	"trusted.overlay.opaque",
+	"user.overlay.opaque",
+	"user.fuseoverlayfs.opaque",
+}
+
+// privateXattrPrefixes are the prefixes of the extended attributes used by the
+// overlay internally, which are not the content of the layer.
+var privateXattrPrefixes = []string{
+	"trusted.overlay.",
+	"user.overlay.",
+	"user.fuseoverlayfs.",
+}
+
 var (
-	_ xfs.Getter = (*entity)(nil)
+	_ xfs.Getter     = (*entity)(nil)
+	_ xfs.XattrFS    = (*entityFS)(nil)
+	_ xfs.ReadLinkFS = (*entityFS)(nil)
 )
 
 type entity struct {
@@ -38,7 +59,7 @@ type entity struct {
 
 // pathTo returns the path to {RootDir}/{Driver}/{cacheid}/{elem1}/{elem2}/...
 func (ent *entity) pathTo(elems ...string) string {
-	paths := append([]string{ent.Path(), ent.cacheid}, elems...)
+	paths := append([]string{ent.Driver.Path(), ent.cacheid}, elems...)
 	return filepath.Join(paths...)
 }
 
@@ -59,7 +80,7 @@ func (ent *entity) DiffDir() string {
 
 // LinkFile returns the path to file {RootDir}/{Driver}/{cacheid}/link.
 func (ent *entity) LinkFile() string {
-	return ent.pathTo(linkDir, linkFileName)
+	return ent.pathTo(linkFileName)
 }
 
 // CommittedFile returns the path to file {RootDir}/{Driver}/{cacheid}/committed.
@@ -77,9 +98,10 @@ func (ent *entity) MergedDir() string {
 	return ent.pathTo(mergedDirName)
 }
 
-// LinkDir returns the path to directory {RootDir}/{Driver}/{cacheid}/l.
+// LinkDir returns the path to directory {RootDir}/{Driver}/l holding the short
+// links of all the layers.
 func (ent *entity) LinkDir() string {
-	return ent.pathTo(linkDir)
+	return filepath.Join(ent.Driver.Path(), linkDir)
 }
 
 // IsReadonly returns true if the driver is readonly. The file "committed" exists
@@ -107,16 +129,20 @@ func (ent *entity) GetLowerPaths() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	lowers := strings.Split(string(content), ":")
+	// the base layer has no lower file
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return []string{}, nil
+	}
+	lowers := strings.Split(strings.TrimSpace(string(content)), ":")
 	lowerPaths := []string{}
 	for _, lower := range lowers {
 		// readlink absolute path: /var/lib/docker/overlay2/{s}, "s" here like "l/ZZEVNMYFSIQU3QWPGANYY5H"
-		link, err := os.Readlink(ent.pathTo(lower))
+		link, err := os.Readlink(filepath.Join(ent.Driver.Path(), lower))
 		if err != nil {
 			return nil, err
 		}
 		// link below "l" here like "../{id}/diff/" points to /var/lib/docker/overlay2/{id}/diff/
-		lowerPath := filepath.Clean(ent.pathTo(linkDir, link))
+		lowerPath := filepath.Clean(filepath.Join(ent.LinkDir(), link))
 		lowerPaths = append(lowerPaths, lowerPath)
 	}
 	return lowerPaths, nil
@@ -124,16 +150,26 @@ func (ent *entity) GetLowerPaths() ([]string, error) {
 
 // GetFS returns the filesystem of the target.
 func (ent *entity) GetFS(ctx context.Context) (fs.FS, error) {
-	diffdir := ent.DiffDir()
+	return NewDiffFS(ctx, ent.DiffDir()), nil
+}
+
+// NewDiffFS returns a [fs.FS] of the overlay upper directory diffdir, which
+// transfers the overlay whiteout character devices and opaque directories to the
+// ".wh." prefixed whiteout files.
+func NewDiffFS(ctx context.Context, diffdir string) fs.FS {
 	return &entityFS{
 		ctx:     ctx,
 		base:    diffdir,
 		real:    os.DirFS(diffdir),
 		virtual: afero.NewMemMapFs(),
-	}, nil
+	}
 }
 
 // entityFS implements fs.FS and handle the whiteout file and directory path trans.
+//
+// The whiteout character device "name" is presented as the ".wh.name" file only,
+// and the opaque directory contains a virtual ".wh..wh..opq" file, so that the
+// diff directory looks the same as the layer tarball.
 type entityFS struct {
 	ctx     context.Context
 	base    string
@@ -147,67 +183,215 @@ func (efsys *entityFS) fullpath(name string) string {
 
 // Stat returns the FileInfo for the named file.
 func (efsys *entityFS) Stat(name string) (fs.FileInfo, error) {
-	fullpath := efsys.fullpath(name)
-	fi, err := os.Lstat(fullpath)
-	if err == nil {
+	if !fs.ValidPath(name) {
+		return nil, xfs.NewPathError("stat", name, fs.ErrInvalid)
+	}
+	if fi, ok := efsys.statVirtual(name); ok {
 		return fi, nil
 	}
-	vfi, verr := efsys.virtual.Stat(name)
-	if verr == nil {
-		return vfi, nil
+	fi, err := os.Lstat(efsys.fullpath(name))
+	if err != nil {
+		return nil, err
+	}
+	if isWhiteoutDevice(fi) {
+		return nil, xfs.NewPathError("stat", name, fs.ErrNotExist)
 	}
-	return nil, fmt.Errorf("unable to stat: (real) %w; (virtual) %w", err, verr)
+	return fi, nil
 }
 
-// Open returns a new file for reading the named file.
-func (efsys *entityFS) Open(name string) (fs.File, error) {
-	fi, err := efsys.Stat(name)
+// ReadDir reads the named directory and returns a list of directory entries sorted by filename.
+func (efsys *entityFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, xfs.NewPathError("readdir", name, fs.ErrInvalid)
+	}
+	fullpath := efsys.fullpath(name)
+	entries, err := os.ReadDir(fullpath)
 	if err != nil {
 		return nil, err
 	}
-
-	// check file is a whiteout
-	if fi.Mode()&fs.ModeCharDevice != 0 {
-		if fi.Sys() != nil {
-			if fisys, ok := fi.Sys().(*syscall.Stat_t); ok && fisys.Rdev/256 == 0 && fisys.Rdev%256 == 0 {
-				// if whiteout, rename the file name with whiteout prefix and create a virtual one
-				rename := filepath.Join(filepath.Dir(name), xfile.WhiteoutPrefix+filepath.Base(name))
-				return efsys.virtual.Create(rename)
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeCharDevice != 0 {
+			fi, err := entry.Info()
+			if err == nil && isWhiteoutDevice(fi) {
+				whiteout := xfile.WhiteoutPrefix + entry.Name()
+				result = append(result, fs.FileInfoToDirEntry(newWhiteoutFileInfo(whiteout, fi)))
+				continue
 			}
 		}
+		result = append(result, entry)
 	}
-
-	// check path is an opaque whiteout directory
-	isOpaque, err := efsys.isOpaqueWhiteoutDir(name, fi)
+	isOpaque, err := efsys.isOpaqueWhiteoutDir(fullpath)
 	if err != nil {
 		xlog.C(efsys.ctx).Warnf("unable to check if %s is an opaque whiteout dir: %s", name, err)
 	}
 	if isOpaque {
-		// if the directory is an opaque whiteout and no virtual file exists,
-		// create opaque whiteout file
-		if xos.IsEmptyDir(efsys.fullpath(name)) {
-			if err := efsys.virtual.MkdirAll(name, 0o755); err != nil { //nolint:mnd // no magic number
+		if dirInfo, err := os.Lstat(fullpath); err == nil {
+			result = append(result, fs.FileInfoToDirEntry(newWhiteoutFileInfo(xfile.OpaqueWhiteout, dirInfo)))
+		}
+	}
+	slices.SortFunc(result, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return result, nil
+}
+
+// Open returns a new file for reading the named file.
+func (efsys *entityFS) Open(name string) (fs.File, error) {
+	fi, err := efsys.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := fi.(*xfs.FakeFileInfo); ok {
+		// the whiteout files are virtual and empty
+		if _, err := efsys.virtual.Stat(name); err != nil {
+			if err := efsys.virtual.MkdirAll(filepath.Dir(name), 0o755); err != nil { //nolint:mnd // no magic number
 				return nil, err
 			}
-			if _, err := efsys.virtual.Create(filepath.Join(name, xfile.OpaqueWhiteout)); err != nil {
+			if _, err := efsys.virtual.Create(name); err != nil {
 				return nil, err
 			}
 		}
+		file, err := efsys.virtual.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &whiteoutFile{File: file, info: fi}, nil
+	}
+	file, err := efsys.real.Open(name)
+	if err != nil {
+		return nil, err
 	}
-	if realFile, err := efsys.real.Open(name); err == nil {
-		return realFile, nil
+	if fi.IsDir() {
+		return &entityDir{File: file, fsys: efsys, name: name}, nil
 	}
-	return efsys.virtual.Open(name)
+	return file, nil
 }
 
-func (efsys *entityFS) isOpaqueWhiteoutDir(name string, fi fs.FileInfo) (bool, error) {
-	if !fi.IsDir() {
-		return false, nil
-	}
-	fullpath := efsys.fullpath(name)
-	opaque, err := xos.Lgetxattr(fullpath, "trusted.overlay.opaque")
+// Xattrs returns the extended attributes of the named file, except the ones
+// used by the overlay internally.
+// Implements the [xfs.XattrFS] interface.
+func (efsys *entityFS) Xattrs(name string) (map[string]string, error) {
+	fi, err := efsys.Stat(name)
 	if err != nil {
+		return nil, err
+	}
+	if _, ok := fi.(*xfs.FakeFileInfo); ok {
+		return nil, nil
+	}
+	return xos.Lxattrs(efsys.fullpath(name), privateXattrPrefixes...)
+}
+
+// ReadLink returns the destination of the named symbolic link.
+// Implements the [xfs.ReadLinkFS] interface.
+func (efsys *entityFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", xfs.NewPathError("readlink", name, fs.ErrInvalid)
+	}
+	return os.Readlink(efsys.fullpath(name))
+}
+
+// statVirtual returns the FileInfo of the virtual whiteout file if the name is.
+func (efsys *entityFS) statVirtual(name string) (fs.FileInfo, bool) {
+	base := filepath.Base(name)
+	if !strings.HasPrefix(base, xfile.WhiteoutPrefix) {
+		return nil, false
+	}
+	dir := filepath.Dir(name)
+	if base == xfile.OpaqueWhiteout {
+		fullpath := efsys.fullpath(dir)
+		isOpaque, err := efsys.isOpaqueWhiteoutDir(fullpath)
+		if err != nil || !isOpaque {
+			return nil, false
+		}
+		dirInfo, err := os.Lstat(fullpath)
+		if err != nil {
+			return nil, false
+		}
+		return newWhiteoutFileInfo(base, dirInfo), true
+	}
+	fi, err := os.Lstat(efsys.fullpath(filepath.Join(dir, strings.TrimPrefix(base, xfile.WhiteoutPrefix))))
+	if err != nil || !isWhiteoutDevice(fi) {
+		return nil, false
+	}
+	return newWhiteoutFileInfo(base, fi), true
+}
+
+func (efsys *entityFS) isOpaqueWhiteoutDir(fullpath string) (bool, error) {
+	fi, err := os.Lstat(fullpath)
+	if err != nil || !fi.IsDir() {
 		return false, err
 	}
-	return string(opaque) == "y", nil
+	for _, attr := range opaqueXattrs {
+		opaque, err := xos.Lgetxattr(fullpath, attr)
+		if err != nil {
+			return false, err
+		}
+		if string(opaque) == "y" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// whiteoutFile is the opened virtual whiteout file, which is described by the
+// same FileInfo as [entityFS.Stat] returns.
+type whiteoutFile struct {
+	afero.File
+	info fs.FileInfo
+}
+
+// Stat returns the FileInfo of the whiteout file.
+func (f *whiteoutFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// entityDir is the opened directory of entityFS, which reads the entries with
+// whiteout transferred.
+type entityDir struct {
+	fs.File
+	fsys    *entityFS
+	name    string
+	entries []fs.DirEntry
+	offset  int
+	loaded  bool
+}
+
+// ReadDir reads the contents of the directory.
+func (dir *entityDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !dir.loaded {
+		entries, err := dir.fsys.ReadDir(dir.name)
+		if err != nil {
+			return nil, err
+		}
+		dir.entries = entries
+		dir.loaded = true
+	}
+	if dir.offset >= len(dir.entries) {
+		if n <= 0 {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+	last := dir.offset + n
+	if n <= 0 || last > len(dir.entries) {
+		last = len(dir.entries)
+	}
+	entries := slices.Clone(dir.entries[dir.offset:last])
+	dir.offset += len(entries)
+	return entries, nil
+}
+
+// isWhiteoutDevice returns true if the file is the overlay whiteout character
+// device with 0/0 device number.
+func isWhiteoutDevice(fi fs.FileInfo) bool {
+	if fi.Mode()&fs.ModeCharDevice == 0 || fi.Sys() == nil {
+		return false
+	}
+	fisys, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && fisys.Rdev == 0
+}
+
+func newWhiteoutFileInfo(name string, fi fs.FileInfo) *xfs.FakeFileInfo {
+	return xfs.NewFakeFileInfo(name).WithMode(0o644).WithModTime(fi.ModTime()) //nolint:mnd // defult permission mode mask
 }
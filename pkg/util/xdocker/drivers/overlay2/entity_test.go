@@ -0,0 +1,186 @@
+package overlay2
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+	"github.com/wuxler/ruasec/pkg/util/xfs"
+)
+
+// mkdiff creates the diff directory with the regular files, the whiteout devices
+// and the directories marked as opaque with the extended attribute.
+func mkdiff(t *testing.T, files map[string]string, whiteouts []string, opaques map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	for _, name := range whiteouts {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		if err := syscall.Mknod(path, syscall.S_IFCHR|0o600, 0); err != nil {
+			t.Skipf("unable to create the whiteout character device: %s", err)
+		}
+	}
+	for name, attr := range opaques {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(path, 0o755))
+		if err := unix.Lsetxattr(path, attr, []byte("y"), 0); err != nil {
+			t.Skipf("unable to set the extended attribute %s: %s", attr, err)
+		}
+	}
+	return dir
+}
+
+func readDirNames(t *testing.T, fsys fs.FS, name string) []string {
+	t.Helper()
+	entries, err := fs.ReadDir(fsys, name)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestNewDiffFS_Whiteouts(t *testing.T) {
+	ctx := context.Background()
+	dir := mkdiff(t,
+		map[string]string{"etc/hosts": "hosts", "usr/bin/foo": "foo"},
+		[]string{"etc/removed", "usr/lib"},
+		nil,
+	)
+	fsys := NewDiffFS(ctx, dir)
+	assert.NoError(t, fstest.TestFS(fsys, "etc/hosts", "etc/.wh.removed", "usr/bin/foo", "usr/.wh.lib"))
+
+	testcases := []struct {
+		name    string
+		content string
+		missing bool
+	}{
+		{name: "etc/hosts", content: "hosts"},
+		{name: "etc/.wh.removed"},
+		{name: "usr/.wh.lib"},
+		{name: "etc/removed", missing: true},
+		{name: "usr/lib", missing: true},
+		{name: "etc/.wh.hosts", missing: true},
+		{name: "etc/.wh..wh..opq", missing: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := fs.Stat(fsys, tc.name)
+			if tc.missing {
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, info.Mode().IsRegular())
+			content, err := fs.ReadFile(fsys, tc.name)
+			require.NoError(t, err)
+			assert.Equal(t, tc.content, string(content))
+		})
+	}
+
+	assert.Equal(t, []string{".wh.removed", "hosts"}, readDirNames(t, fsys, "etc"))
+	assert.Equal(t, []string{".wh.lib", "bin"}, readDirNames(t, fsys, "usr"))
+}
+
+func TestNewDiffFS_OpaqueDirs(t *testing.T) {
+	ctx := context.Background()
+	for _, attr := range opaqueXattrs {
+		t.Run(attr, func(t *testing.T) {
+			dir := mkdiff(t, map[string]string{"opaque/kept": "kept", "plain/file": "file"}, nil, map[string]string{"opaque": attr})
+			fsys := NewDiffFS(ctx, dir)
+			assert.NoError(t, fstest.TestFS(fsys, "opaque/.wh..wh..opq", "opaque/kept", "plain/file"))
+
+			assert.Equal(t, []string{".wh..wh..opq", "kept"}, readDirNames(t, fsys, "opaque"))
+			assert.Equal(t, []string{"file"}, readDirNames(t, fsys, "plain"))
+			_, err := fs.Stat(fsys, "plain/.wh..wh..opq")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+
+			content, err := fs.ReadFile(fsys, "opaque/.wh..wh..opq")
+			require.NoError(t, err)
+			assert.Empty(t, content)
+			// the overlay private extended attributes are not the content
+			xattrs, err := xfs.Xattrs(fsys, "opaque")
+			require.NoError(t, err)
+			assert.NotContains(t, xattrs, attr)
+		})
+	}
+}
+
+func TestNewDiffFS_ReadDirPaging(t *testing.T) {
+	ctx := context.Background()
+	dir := mkdiff(t, map[string]string{"a": "a", "c": "c"}, []string{"b"}, nil)
+	fsys := NewDiffFS(ctx, dir)
+
+	file, err := fsys.Open(".")
+	require.NoError(t, err)
+	defer file.Close()
+	rdf, ok := file.(fs.ReadDirFile)
+	require.True(t, ok)
+	names := []string{}
+	for {
+		entries, err := rdf.ReadDir(1)
+		if err != nil {
+			break
+		}
+		require.Len(t, entries, 1)
+		names = append(names, entries[0].Name())
+	}
+	assert.Equal(t, []string{".wh.b", "a", "c"}, names)
+}
+
+func TestEntity_GetLowerPaths(t *testing.T) {
+	root := t.TempDir()
+	driver := NewDriver(pathspec.DataRoot(root), drivers.TypeOverlay2)
+	top := driver.entityTo("top")
+	lowers := []string{"middle", "base"}
+	links := []string{}
+	for _, cacheid := range append([]string{"top"}, lowers...) {
+		ent := driver.entityTo(cacheid)
+		require.NoError(t, os.MkdirAll(ent.DiffDir(), 0o755))
+		require.NoError(t, os.MkdirAll(ent.LinkDir(), 0o755))
+		short := "L" + cacheid
+		require.NoError(t, os.Symlink(filepath.Join("..", cacheid, diffDirName), filepath.Join(driver.Path(), linkDir, short)))
+		links = append(links, filepath.Join(linkDir, short))
+	}
+
+	t.Run("base layer", func(t *testing.T) {
+		paths, err := driver.entityTo("base").GetLowerPaths()
+		require.NoError(t, err)
+		assert.Empty(t, paths)
+	})
+
+	t.Run("lower layers", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(top.LowerFile(), []byte(links[1]+":"+links[2]), 0o644))
+		paths, err := top.GetLowerPaths()
+		require.NoError(t, err)
+		assert.Equal(t, []string{driver.entityTo("middle").DiffDir(), driver.entityTo("base").DiffDir()}, paths)
+
+		metadata, err := driver.GetMetadata("top")
+		require.NoError(t, err)
+		assert.Equal(t, top.DiffDir(), metadata["UpperDir"])
+		assert.Equal(t, "false", metadata["ReadOnly"])
+	})
+
+	t.Run("committed", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(top.CommittedFile(), nil, 0o644))
+		readonly, err := top.IsReadonly()
+		require.NoError(t, err)
+		assert.True(t, readonly)
+	})
+}
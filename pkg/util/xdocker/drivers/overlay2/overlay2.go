@@ -22,19 +22,27 @@ func init() {
 
 // New creates a new overlay2 driver
 func New(ctx context.Context, dataRoot pathspec.DataRoot, options []string) (drivers.Driver, error) {
+	return NewDriver(dataRoot, drivers.TypeOverlay2), nil
+}
+
+// NewDriver returns a new driver with the type, which shares the same directory
+// layout as overlay2, like "fuse-overlayfs".
+func NewDriver(dataRoot pathspec.DataRoot, typ drivers.Type) *Driver {
 	return &Driver{
-		DriverRoot: dataRoot.DriverRoot(drivers.TypeOverlay2.String()),
-	}, nil
+		DriverRoot: dataRoot.DriverRoot(typ.String()),
+		typ:        typ,
+	}
 }
 
 // Driver is a driver for overlay2
 type Driver struct {
 	pathspec.DriverRoot
+	typ drivers.Type
 }
 
 // Type returns the type of the driver
 func (d *Driver) Type() drivers.Type {
-	return drivers.TypeOverlay2
+	return d.typ
 }
 
 // Accessible returns true when the target exists and accessible with the given cache id.
@@ -1,5 +1,9 @@
 package register
 
 import (
-	_ "github.com/wuxler/ruasec/pkg/util/xdocker/drivers/overlay2" // register overlay2
+	_ "github.com/wuxler/ruasec/pkg/util/xdocker/drivers/btrfs"         // register btrfs
+	_ "github.com/wuxler/ruasec/pkg/util/xdocker/drivers/fuseoverlayfs" // register fuse-overlayfs
+	_ "github.com/wuxler/ruasec/pkg/util/xdocker/drivers/overlay2"      // register overlay2
+	_ "github.com/wuxler/ruasec/pkg/util/xdocker/drivers/vfs"           // register vfs
+	_ "github.com/wuxler/ruasec/pkg/util/xdocker/drivers/zfs"           // register zfs
 )
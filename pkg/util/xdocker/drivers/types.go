@@ -96,10 +96,7 @@ func FindSupportedTypes(ctx context.Context, path string) map[Type]bool {
 func LookupPriorType(ctx context.Context, path string) (Type, bool) {
 	found := FindSupportedTypes(ctx, path)
 	for _, prior := range priorityTypes {
-		if prior == TypeVfs {
-			// skip vfs
-			continue
-		}
+		// vfs is the last one in priority, so it is only used when no other found
 		if !found[prior] {
 			continue
 		}
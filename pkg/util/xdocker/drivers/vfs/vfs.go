@@ -0,0 +1,20 @@
+// Package vfs provides the vfs storage driver, which stores the full copy of
+// each layer in the directory {RootDir}/vfs/dir/{cacheid}.
+package vfs
+
+import (
+	"context"
+
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/drivers/naivediff"
+	"github.com/wuxler/ruasec/pkg/util/xdocker/pathspec"
+)
+
+func init() {
+	drivers.MustRegisterCreator(drivers.TypeVfs, drivers.CreatorFunc(New))
+}
+
+// New creates a new vfs driver
+func New(ctx context.Context, dataRoot pathspec.DataRoot, options []string) (drivers.Driver, error) {
+	return naivediff.NewDriver(dataRoot, drivers.TypeVfs, "dir"), nil
+}
@@ -62,6 +62,26 @@ func (d DriverRoot) DistributionDir() string {
 	return filepath.Join(d.ImageRootDir(), "distribution")
 }
 
+// DistributionDiffIDByDigestFile returns the path to {RootDir}/image/{Driver}/distribution/diffid-by-digest/{Algorithm}/{Hex}
+//
+// NOTE:
+//   - input digest is the digest of the compressed layer blob pulled from the registry.
+//   - file MAY not exists
+//   - content of the file is the diffid of the layer
+func (d DriverRoot) DistributionDiffIDByDigestFile(dgst digest.Digest) string {
+	return filepath.Join(d.DistributionDir(), "diffid-by-digest", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// DistributionV2MetadataByDiffIDFile returns the path to {RootDir}/image/{Driver}/distribution/v2metadata-by-diffid/{Algorithm}/{Hex}
+//
+// NOTE:
+//   - input digest is the diffid of the layer.
+//   - file MAY not exists
+//   - content of the file is the JSON list of the compressed layer blob digests and the source repositories
+func (d DriverRoot) DistributionV2MetadataByDiffIDFile(diffid digest.Digest) string {
+	return filepath.Join(d.DistributionDir(), "v2metadata-by-diffid", diffid.Algorithm().String(), diffid.Encoded())
+}
+
 // RepositoryJSONFile returns the path to repository.json file {RootDir}/image/{Driver}/repositories.json,
 // like "/var/lib/docker/image/overlay2/repositories.json"
 func (d DriverRoot) RepositoryJSONFile() string {
@@ -7,6 +7,7 @@
 	"github.com/docker/docker/client"
 
 	"github.com/wuxler/ruasec/pkg/util/homedir"
+	"github.com/wuxler/ruasec/pkg/util/xos"
 )
 
 const (
@@ -29,3 +30,33 @@ func ConfigDir() string {
 func ConfigFile() string {
 	return filepath.Join(ConfigDir(), "config.json")
 }
+
+// RootlessDataRoot returns the directory the rootless docker data is stored in,
+// which is "$XDG_DATA_HOME/docker" and falls back to "~/.local/share/docker".
+func RootlessDataRoot() string {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "docker")
+	}
+	home, err := homedir.Get()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "share", "docker")
+}
+
+// DiscoverDataRoot returns the docker data root of the current user. The rootless
+// data root is returned when running as non-root user and it exists and is not
+// empty, otherwise [DefaultDataRoot] is returned.
+func DiscoverDataRoot() string {
+	return discoverDataRoot(os.Geteuid(), RootlessDataRoot())
+}
+
+func discoverDataRoot(euid int, rootless string) string {
+	if euid == 0 || rootless == "" {
+		return DefaultDataRoot
+	}
+	if exists, err := xos.Exists(rootless); err != nil || !exists || xos.IsEmptyDir(rootless) {
+		return DefaultDataRoot
+	}
+	return rootless
+}
@@ -0,0 +1,90 @@
+package xdocker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverDataRoot(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.Mkdir(empty, 0o700))
+	populated := filepath.Join(dir, "populated")
+	require.NoError(t, os.MkdirAll(filepath.Join(populated, "image", "overlay2"), 0o700))
+
+	testcases := []struct {
+		name     string
+		euid     int
+		rootless string
+		want     string
+	}{
+		{name: "root", euid: 0, rootless: populated, want: DefaultDataRoot},
+		{name: "rootless missing", euid: 1000, rootless: filepath.Join(dir, "missing"), want: DefaultDataRoot},
+		{name: "rootless empty", euid: 1000, rootless: empty, want: DefaultDataRoot},
+		{name: "rootless populated", euid: 1000, rootless: populated, want: populated},
+		{name: "no home", euid: 1000, rootless: "", want: DefaultDataRoot},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, discoverDataRoot(tc.euid, tc.rootless))
+		})
+	}
+}
+
+func TestDaemonConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	rootless := filepath.Join(dir, "docker", "daemon.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(rootless), 0o700))
+	require.NoError(t, os.WriteFile(rootless, []byte(`{}`), 0o600))
+
+	testcases := []struct {
+		name     string
+		euid     int
+		rootless string
+		want     string
+	}{
+		{name: "root", euid: 0, rootless: rootless, want: DefaultDaemonConfigFile},
+		{name: "rootless", euid: 1000, rootless: rootless, want: rootless},
+		{name: "rootless missing", euid: 1000, rootless: filepath.Join(dir, "missing.json"), want: DefaultDaemonConfigFile},
+		{name: "no home", euid: 1000, rootless: "", want: DefaultDaemonConfigFile},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, daemonConfigFile(tc.euid, tc.rootless))
+		})
+	}
+}
+
+func TestDefaultDataRootOf(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(t.TempDir(), "data"))
+
+	testcases := []struct {
+		name string
+		euid int
+		path string
+		want string
+	}{
+		{name: "rootful config of root", euid: 0, path: DefaultDaemonConfigFile, want: DefaultDataRoot},
+		{name: "rootful config of non-root", euid: 1000, path: DefaultDaemonConfigFile, want: DefaultDataRoot},
+		{name: "rootless config", euid: 1000, path: RootlessDaemonConfigFile(), want: RootlessDataRoot()},
+		{name: "rootless config of root", euid: 0, path: RootlessDaemonConfigFile(), want: DefaultDataRoot},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, defaultDataRootOf(tc.euid, tc.path))
+		})
+	}
+
+	t.Run("configured", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "daemon.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"data-root": "/data/docker"}`), 0o600))
+		config, err := LoadDaemonConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, "/data/docker", config.GetDataRoot())
+	})
+}
@@ -23,8 +23,12 @@
 	_ fs.ReadFileFS = (*FS)(nil)
 	_ fs.GlobFS     = (*FS)(nil)
 	_ fs.SubFS      = (*FS)(nil)
+	_ xfs.XattrFS   = (*FS)(nil)
 )
 
+// paxSchilyXattr is the prefix of the PAX records holding the extended attributes.
+const paxSchilyXattr = "SCHILY.xattr."
+
 // Reader defines the minimal reader interface to create a [fs.FS] for the tarball archive.
 type Reader interface {
 	io.ReadSeeker
@@ -99,13 +103,22 @@ type FS struct {
 }
 
 func (fsys *FS) append(name string, node *inode) {
+	if existing, ok := fsys.inodes[name]; ok {
+		// the directory may be created as a fake one before its header is read,
+		// replace the entry in place to keep the childrens
+		existing.DirEntry = node.DirEntry
+		existing.header = node.header
+		existing.offset = node.offset
+		existing.sequence = node.sequence
+		return
+	}
 	fsys.inodes[name] = node
 	dir := stdpath.Dir(name)
 	if parent, ok := fsys.inodes[dir]; ok {
 		parent.childrens = append(parent.childrens, node)
 		return
 	}
-	fakeDirNode := &inode{DirEntry: fs.FileInfoToDirEntry(xfs.NewFakeDirFileInfo(name))}
+	fakeDirNode := &inode{DirEntry: fs.FileInfoToDirEntry(xfs.NewFakeDirFileInfo(dir))}
 	fsys.append(dir, fakeDirNode)
 
 	fakeDirNode.childrens = append(fakeDirNode.childrens, node)
@@ -184,6 +197,29 @@ func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
 	return node.Info()
 }
 
+// Xattrs returns the extended attributes of the named file recorded in the tar header.
+// Implements the [xfs.XattrFS] interface.
+func (fsys *FS) Xattrs(name string) (map[string]string, error) {
+	node, err := fsys.get("xattrs", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.header == nil {
+		return nil, nil
+	}
+	var xattrs map[string]string
+	for key, value := range node.header.PAXRecords {
+		if !strings.HasPrefix(key, paxSchilyXattr) {
+			continue
+		}
+		if xattrs == nil {
+			xattrs = map[string]string{}
+		}
+		xattrs[strings.TrimPrefix(key, paxSchilyXattr)] = value
+	}
+	return xattrs, nil
+}
+
 // Glob returns the names of all files matching pattern.
 // Implements the [fs.GlobFS] interface.
 func (fsys *FS) Glob(pattern string) ([]string, error) {
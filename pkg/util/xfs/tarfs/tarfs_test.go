@@ -2,6 +2,7 @@
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
 	"errors"
 	"io"
@@ -122,6 +123,64 @@ func TestFS(t *testing.T) {
 	})
 }
 
+func TestFS_ImplicitDirs(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	files := []*tar.Header{
+		{Name: "var/lib/foo", Typeflag: tar.TypeReg, Mode: 0o644, Size: 3},
+		{Name: "var/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0o644, Size: 3},
+	}
+	for _, hdr := range files {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte("foo"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	fsys, err := New(context.Background(), bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.NoError(t, fstest.TestFS(fsys, "var/lib/foo", "etc/hosts"))
+
+	entries, err := fs.ReadDir(fsys, ".")
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"var", "etc"}, names)
+}
+
+func TestFS_Xattrs(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:       "foo",
+		Typeflag:   tar.TypeReg,
+		Mode:       0o644,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"},
+	}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bar", Typeflag: tar.TypeReg, Mode: 0o644}))
+	require.NoError(t, tw.Close())
+
+	fsys, err := New(context.Background(), bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	xattrs, err := fsys.Xattrs("foo")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user.foo": "bar"}, xattrs)
+
+	xattrs, err = fsys.Xattrs("bar")
+	require.NoError(t, err)
+	assert.Empty(t, xattrs)
+
+	_, err = fsys.Xattrs("not-exist")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
 func mktar(t *testing.T, name string) {
 	t.Helper()
 	file, err := os.Create(name)
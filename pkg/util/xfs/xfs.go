@@ -18,3 +18,30 @@ type Getter interface {
 func (fn GetterFunc) GetFS(ctx context.Context) (fs.FS, error) {
 	return fn(ctx)
 }
+
+// XattrFS is the file system which can read the extended attributes of files.
+type XattrFS interface {
+	fs.FS
+
+	// Xattrs returns the extended attributes of the named file, and the symlink
+	// is not followed.
+	Xattrs(name string) (map[string]string, error)
+}
+
+// Xattrs returns the extended attributes of the named file if fsys implements
+// [XattrFS], or returns nil otherwise.
+func Xattrs(fsys fs.FS, name string) (map[string]string, error) {
+	xfsys, ok := fsys.(XattrFS)
+	if !ok {
+		return nil, nil
+	}
+	return xfsys.Xattrs(name)
+}
+
+// ReadLinkFS is the file system which can read the destination of the symbolic link.
+type ReadLinkFS interface {
+	fs.FS
+
+	// ReadLink returns the destination of the named symbolic link.
+	ReadLink(name string) (string, error)
+}
@@ -2,6 +2,8 @@
 
 // Forked from https://github.com/moby/moby/blob/v27.1.2/pkg/system/xattrs.go
 
+import "strings"
+
 // XattrError is an error returned by xattr operations.
 type XattrError struct {
 	Op   string
@@ -19,3 +21,36 @@ func (e *XattrError) Timeout() bool {
 	t, ok := e.Err.(interface{ Timeout() bool })
 	return ok && t.Timeout()
 }
+
+// Lxattrs returns all the extended attributes associated with the given path in
+// the file system, except the ones with the name prefixed by any of the skips.
+func Lxattrs(path string, skips ...string) (map[string]string, error) {
+	attrs, err := Llistxattr(path)
+	if err != nil {
+		return nil, err
+	}
+	var xattrs map[string]string
+	for _, attr := range attrs {
+		if hasAnyPrefix(attr, skips...) {
+			continue
+		}
+		value, err := Lgetxattr(path, attr)
+		if err != nil {
+			return nil, err
+		}
+		if xattrs == nil {
+			xattrs = map[string]string{}
+		}
+		xattrs[attr] = string(value)
+	}
+	return xattrs, nil
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
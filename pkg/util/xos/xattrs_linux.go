@@ -6,6 +6,8 @@
 // Forked from https://github.com/moby/moby/blob/v27.1.2/pkg/system/xattrs_linux.go
 
 import (
+	"bytes"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -50,3 +52,39 @@ func Lsetxattr(path string, attr string, data []byte, flags int) error {
 	}
 	return nil
 }
+
+// Llistxattr lists the names of the extended attributes associated with the
+// given path in the file system.
+func Llistxattr(path string) ([]string, error) {
+	sysErr := func(err error) ([]string, error) {
+		return nil, &XattrError{Op: "llistxattr", Path: path, Err: err}
+	}
+
+	dest := make([]byte, 128) //nolint:mnd // 128 is a reasonable size for most xattrs
+	sz, errno := unix.Llistxattr(path, dest)
+
+	for errno == unix.ERANGE {
+		// Buffer too small, use zero-sized buffer to get the actual size
+		sz, errno = unix.Llistxattr(path, []byte{})
+		if errno != nil {
+			return sysErr(errno)
+		}
+		dest = make([]byte, sz)
+		sz, errno = unix.Llistxattr(path, dest)
+	}
+
+	switch {
+	case errno == unix.ENOTSUP:
+		return nil, nil
+	case errno != nil:
+		return sysErr(errno)
+	}
+
+	var attrs []string
+	for _, attr := range bytes.Split(dest[:sz], []byte{0}) {
+		if len(attr) > 0 {
+			attrs = append(attrs, string(attr))
+		}
+	}
+	return attrs, nil
+}
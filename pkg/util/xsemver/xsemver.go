@@ -0,0 +1,262 @@
+// Package xsemver provides helpers to parse the semantic versions and check them
+// with the constraints, which are used to filter the tags like "v1.2.3".
+package xsemver
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version formatted as "[v]MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD]",
+// the missing minor and patch numbers are treated as 0.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease string
+}
+
+// Parse parses the semantic version, and the build metadata is ignored.
+func Parse(s string) (Version, error) {
+	raw := s
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	v := Version{}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.Prerelease = s[:i], s[i+1:]
+		if v.Prerelease == "" {
+			return Version{}, fmt.Errorf("invalid semantic version %q: empty prerelease", raw)
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 { //nolint:mnd // major, minor and patch
+		return Version{}, fmt.Errorf("invalid semantic version %q: too many parts", raw)
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid semantic version %q: %w", raw, err)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+// String returns the version formatted as "MAJOR.MINOR.PATCH[-PRERELEASE]".
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 when v is less than, equal to or greater than o by
+// the semantic versioning precedence.
+func (v Version) Compare(o Version) int {
+	if c := cmp.Compare(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+// comparePrerelease compares the dot separated identifiers, and the version
+// without prerelease has a higher precedence.
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		var c int
+		switch {
+		case aerr == nil && berr == nil:
+			c = cmp.Compare(an, bn)
+		case aerr == nil:
+			// numeric identifiers have lower precedence than alphanumeric ones
+			c = -1
+		case berr == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
+}
+
+// Constraint is a set of the version comparisons like ">= 1.2, < 2.0 || ^3.1".
+// The comparisons separated by commas or spaces must all be satisfied, and any of
+// the groups separated by "||" is satisfied.
+//
+// Supported operators are "=", "!=", ">", ">=", "<", "<=", "~" which allows the
+// patch updates, and "^" which allows the updates not changing the leftmost
+// non-zero number. The version without operator is the same as "=".
+//
+// The prerelease versions only satisfy the groups which contain a comparison with
+// prerelease, so "1.0.0-rc.1" satisfies ">= 1.0.0-rc.0" but not ">= 0.9".
+type Constraint struct {
+	raw    string
+	groups [][]comparison
+}
+
+// comparison compares the version with the operand.
+type comparison struct {
+	op      string
+	operand Version
+	parts   int
+}
+
+// operators are ordered by the length to match the longest prefix first.
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+// ParseConstraint parses the constraint expression.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: s}
+	for _, group := range strings.Split(s, "||") {
+		fields := strings.FieldsFunc(group, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		// join the operator separated from the version like ">= 1.2"
+		comparisons := []comparison{}
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if isOperator(field) && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			comp, err := parseComparison(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+			comparisons = append(comparisons, comp)
+		}
+		if len(comparisons) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q: empty comparisons", s)
+		}
+		c.groups = append(c.groups, comparisons)
+	}
+	return c, nil
+}
+
+func isOperator(s string) bool {
+	for _, op := range operators {
+		if s == op {
+			return true
+		}
+	}
+	return false
+}
+
+func parseComparison(s string) (comparison, error) {
+	op := "="
+	for _, candidate := range operators {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			s = s[len(candidate):]
+			break
+		}
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return comparison{}, err
+	}
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	return comparison{op: op, operand: v, parts: len(strings.Split(core, "."))}, nil
+}
+
+// String returns the raw constraint expression.
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+// Check returns true if the version satisfies the constraint.
+func (c *Constraint) Check(v Version) bool {
+	for _, group := range c.groups {
+		if checkGroup(group, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkGroup(group []comparison, v Version) bool {
+	allowPrerelease := false
+	for _, comp := range group {
+		if !comp.check(v) {
+			return false
+		}
+		if comp.operand.Prerelease != "" {
+			allowPrerelease = true
+		}
+	}
+	return v.Prerelease == "" || allowPrerelease
+}
+
+func (c comparison) check(v Version) bool {
+	n := v.Compare(c.operand)
+	switch c.op {
+	case "!=":
+		return n != 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case "~":
+		// "~1.2.3" and "~1.2" allow the patch updates, "~1" allows the minor updates
+		return n >= 0 && v.Compare(c.upper(min(c.parts, 2))) < 0 //nolint:mnd // bump minor at most
+	case "^":
+		// "^1.2.3" allows the minor updates, "^0.2.3" allows the patch updates and
+		// "^0.0.3" allows nothing
+		switch {
+		case c.operand.Major != 0 || c.parts == 1:
+			return n >= 0 && v.Compare(c.upper(1)) < 0
+		case c.operand.Minor != 0 || c.parts == 2: //nolint:mnd // major and minor
+			return n >= 0 && v.Compare(c.upper(2)) < 0 //nolint:mnd // bump minor
+		default:
+			return n >= 0 && v.Compare(c.upper(3)) < 0 //nolint:mnd // bump patch
+		}
+	default:
+		return n == 0
+	}
+}
+
+// upper returns the exclusive upper bound which bumps the number at the position
+// of 1 for major, 2 for minor and 3 for patch. The prerelease "0" makes it lower
+// than all the prerelease versions of the bound.
+func (c comparison) upper(position int) Version {
+	v := c.operand
+	switch position {
+	case 1:
+		return Version{Major: v.Major + 1, Prerelease: "0"}
+	case 2: //nolint:mnd // minor
+		return Version{Major: v.Major, Minor: v.Minor + 1, Prerelease: "0"}
+	default:
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, Prerelease: "0"}
+	}
+}
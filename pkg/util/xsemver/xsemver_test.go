@@ -0,0 +1,144 @@
+package xsemver_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wuxler/ruasec/pkg/util/xsemver"
+)
+
+func TestParse(t *testing.T) {
+	testcases := map[string]struct {
+		input   string
+		expect  xsemver.Version
+		wantErr bool
+	}{
+		"full version":         {input: "1.2.3", expect: xsemver.Version{Major: 1, Minor: 2, Patch: 3}},
+		"with prefix v":        {input: "v1.2.3", expect: xsemver.Version{Major: 1, Minor: 2, Patch: 3}},
+		"partial version":      {input: "1.2", expect: xsemver.Version{Major: 1, Minor: 2}},
+		"major only":           {input: "7", expect: xsemver.Version{Major: 7}},
+		"prerelease and build": {input: "1.0.0-rc.1+build.5", expect: xsemver.Version{Major: 1, Prerelease: "rc.1"}},
+		"not a version":        {input: "latest", wantErr: true},
+		"too many parts":       {input: "1.2.3.4", wantErr: true},
+		"empty prerelease":     {input: "1.2.3-", wantErr: true},
+		"suffix not separated": {input: "1.2.3alpine", wantErr: true},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			v, err := xsemver.Parse(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, v)
+		})
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// ordered by the precedence defined by semver.org
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := xsemver.Parse(ordered[i])
+			require.NoError(t, err)
+			b, err := xsemver.Parse(ordered[j])
+			require.NoError(t, err)
+			expect := 0
+			if i < j {
+				expect = -1
+			} else if i > j {
+				expect = 1
+			}
+			assert.Equal(t, expect, a.Compare(b), "%s <=> %s", a, b)
+		}
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	testcases := map[string]struct {
+		constraint string
+		matched    []string
+		unmatched  []string
+	}{
+		"range": {
+			constraint: ">= 1.2, < 2.0",
+			matched:    []string{"1.2.0", "v1.9.9"},
+			unmatched:  []string{"1.1.9", "2.0.0", "1.5.0-rc.1"},
+		},
+		"equal without operator": {
+			constraint: "1.2.3",
+			matched:    []string{"1.2.3"},
+			unmatched:  []string{"1.2.4"},
+		},
+		"not equal": {
+			constraint: ">1.0.0,!=1.2.0",
+			matched:    []string{"1.1.0", "1.3.0"},
+			unmatched:  []string{"1.0.0", "1.2.0"},
+		},
+		"tilde": {
+			constraint: "~1.2.3",
+			matched:    []string{"1.2.3", "1.2.9"},
+			unmatched:  []string{"1.2.2", "1.3.0"},
+		},
+		"tilde major": {
+			constraint: "~1",
+			matched:    []string{"1.0.0", "1.9.0"},
+			unmatched:  []string{"2.0.0"},
+		},
+		"caret": {
+			constraint: "^1.2.3",
+			matched:    []string{"1.2.3", "1.9.0"},
+			unmatched:  []string{"1.2.2", "2.0.0"},
+		},
+		"caret zero major": {
+			constraint: "^0.2.3",
+			matched:    []string{"0.2.3", "0.2.9"},
+			unmatched:  []string{"0.3.0"},
+		},
+		"caret zero minor": {
+			constraint: "^0.0.3",
+			matched:    []string{"0.0.3"},
+			unmatched:  []string{"0.0.4"},
+		},
+		"alternatives": {
+			constraint: "~1.2 || >= 3",
+			matched:    []string{"1.2.5", "3.1.0"},
+			unmatched:  []string{"1.3.0", "2.0.0"},
+		},
+		"prerelease allowed": {
+			constraint: ">= 1.0.0-rc.0, < 2",
+			matched:    []string{"1.0.0-rc.1", "1.5.0"},
+			unmatched:  []string{"0.9.0"},
+		},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			c, err := xsemver.ParseConstraint(tc.constraint)
+			require.NoError(t, err)
+			for _, s := range tc.matched {
+				v, err := xsemver.Parse(s)
+				require.NoError(t, err)
+				assert.True(t, c.Check(v), "%s should match %s", s, c)
+			}
+			for _, s := range tc.unmatched {
+				v, err := xsemver.Parse(s)
+				require.NoError(t, err)
+				assert.False(t, c.Check(v), "%s should not match %s", s, c)
+			}
+		})
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	for _, s := range []string{"", ">=", ">= latest", "1.0 ||"} {
+		_, err := xsemver.ParseConstraint(s)
+		assert.Error(t, err, s)
+	}
+}